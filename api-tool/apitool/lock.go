@@ -0,0 +1,23 @@
+package apitool
+
+import (
+	"context"
+	"database/sql"
+)
+
+// RunExclusively run a job while holding a PostgreSQL advisory lock, so a single instance of a micro service runs it at a time.
+// It returns false without running the job when another instance holds the lock
+func RunExclusively(db *sql.DB, lock int64, job func()) (ran bool, err error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", lock).Scan(&ran); err != nil || !ran {
+		return false, err
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lock)
+	job()
+	return true, nil
+}
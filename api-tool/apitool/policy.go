@@ -0,0 +1,134 @@
+package apitool
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// TokenUserIdKey is the key used to store the id of the owner of the access token of a request in the gin context
+const TokenUserIdKey = "token_user_id"
+
+// TokenRolesKey is the key used to store the roles of the owner of the access token of a request in the gin context
+const TokenRolesKey = "token_roles"
+
+// TokenPermissionsKey is the key used to store the permissions granted by the roles of the token owner in the gin context
+const TokenPermissionsKey = "token_permissions"
+
+// ResourceOwnerKey is the key used to store whether the token owner is the owner of the resource of the request in the gin context
+const ResourceOwnerKey = "resource_owner"
+
+// TokenOwner is the owner of an access token as described by the oauth2 service, Roles and Permissions are the role claims of the user
+type TokenOwner struct {
+	UserId      uint        `json:"user_id"`
+	Scopes      []string    `json:"scopes"`
+	Actor       *TokenActor `json:"act"`
+	Roles       []string    `json:"roles"`
+	Permissions []string    `json:"permissions"`
+}
+
+// SetTokenOwner store the id, the scopes, the actor and the role claims of the access token of the request,
+// and whether its owner is the owner of the resource, to be called by the access token validation middleware
+func SetTokenOwner(c *gin.Context, owner TokenOwner, resourceOwner bool) {
+	c.Set(TokenUserIdKey, owner.UserId)
+	SetTokenScopes(c, owner.Scopes)
+	SetTokenActor(c, owner.Actor)
+	c.Set(TokenRolesKey, owner.Roles)
+	c.Set(TokenPermissionsKey, owner.Permissions)
+	c.Set(ResourceOwnerKey, resourceOwner)
+}
+
+// GetTokenUserId return the id of the owner of the access token of the request, or 0 when no token has been validated
+func GetTokenUserId(c *gin.Context) uint {
+	if userId, ok := c.Get(TokenUserIdKey); ok {
+		return userId.(uint)
+	}
+	return 0
+}
+
+// IsResourceOwner check that the token owner is the owner of the resource of the request
+func IsResourceOwner(c *gin.Context) bool {
+	return c.GetBool(ResourceOwnerKey)
+}
+
+// HasRole check that the token owner has a role
+func HasRole(c *gin.Context, role string) bool {
+	return contains(c.GetStringSlice(TokenRolesKey), role)
+}
+
+// HasPermission check that one of the roles of the token owner grants a permission
+func HasPermission(c *gin.Context, permission string) bool {
+	return contains(c.GetStringSlice(TokenPermissionsKey), permission)
+}
+
+// contains check if a value is in a slice
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize abort the request unless the access token satisfies the policy (middleware).
+// A policy is made of rules joined by OR and AND, AND taking precedence, e.g. "owner OR role:support":
+//
+//	owner              the token owner is the owner of the resource
+//	role:<name>        the token owner has the role
+//	permission:<name>  a role of the token owner grants the permission
+//	scope:<name>       the token has been granted the scope
+//
+// It must be placed after the middleware validating the access token, an invalid policy panics when the route is declared
+func Authorize(policy string) gin.HandlerFunc {
+	rules := parsePolicy(policy)
+	return func(c *gin.Context) {
+		for _, allOf := range rules {
+			granted := true
+			for _, rule := range allOf {
+				if !rule(c) {
+					granted = false
+					break
+				}
+			}
+			if granted {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatus(http.StatusForbidden)
+	}
+}
+
+// parsePolicy read a policy as a list of alternatives, each of them being rules that must all be satisfied
+func parsePolicy(policy string) (rules [][]func(c *gin.Context) bool) {
+	for _, alternative := range strings.Split(policy, " OR ") {
+		var allOf []func(c *gin.Context) bool
+		for _, term := range strings.Split(alternative, " AND ") {
+			allOf = append(allOf, parseRule(strings.TrimSpace(term), policy))
+		}
+		rules = append(rules, allOf)
+	}
+	return rules
+}
+
+// parseRule read a rule of a policy
+func parseRule(term string, policy string) func(c *gin.Context) bool {
+	if term == "owner" {
+		return IsResourceOwner
+	}
+	kind := strings.SplitN(term, ":", 2)
+	if len(kind) != 2 || kind[1] == "" {
+		panic("apitool: invalid rule \"" + term + "\" in policy \"" + policy + "\"")
+	}
+	name := kind[1]
+	switch kind[0] {
+	case "role":
+		return func(c *gin.Context) bool { return HasRole(c, name) }
+	case "permission":
+		return func(c *gin.Context) bool { return HasPermission(c, name) }
+	case "scope":
+		return func(c *gin.Context) bool { return HasScopes(c, name) }
+	}
+	panic("apitool: invalid rule \"" + term + "\" in policy \"" + policy + "\"")
+}
@@ -0,0 +1,153 @@
+package apitool
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPageLimit is the amount of items of a page when the request does not set it
+const DefaultPageLimit = 50
+
+// MaxPageLimit is the largest amount of items of a page
+const MaxPageLimit = 200
+
+// Query is the gorm conditions, order and limit of a list request. The conditions are joined with AND in the order they are added
+type Query struct {
+	conditions []string
+	args       []interface{}
+	order      string
+	limit      int
+}
+
+// Where add a condition and its arguments to the query, e.g. q.Where("email LIKE ?", "john%")
+func (q *Query) Where(condition string, args ...interface{}) *Query {
+	q.conditions = append(q.conditions, condition)
+	q.args = append(q.args, args...)
+	return q
+}
+
+// Conditions return the conditions of the query and their arguments, to be given to gorm Where, the query is empty when there is no condition
+func (q *Query) Conditions() (query string, args []interface{}) {
+	if len(q.conditions) == 1 {
+		return q.conditions[0], q.args
+	}
+	for i, condition := range q.conditions {
+		if i > 0 {
+			query += " AND "
+		}
+		query += "(" + condition + ")"
+	}
+	return query, q.args
+}
+
+// Order return the order of the query, to be given to gorm Order
+func (q *Query) Order() string {
+	return q.order
+}
+
+// Limit return the amount of rows to read, to be given to gorm Limit. A paginated query reads one more row than the page to know if there is a next one
+func (q *Query) Limit() int {
+	return q.limit
+}
+
+// Paginate restrict the query to a page, the rows are ordered by the sort column then by ID
+func (q *Query) Paginate(page Page) *Query {
+	direction, comparison := "ASC", ">"
+	if page.Desc {
+		direction, comparison = "DESC", "<"
+	}
+	if page.after != nil {
+		q.Where(fmt.Sprintf("%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?)", page.Column, comparison), page.after.Value, page.after.Value, page.after.Id)
+	}
+	q.order = page.Column + " " + direction + ", id " + direction
+	q.limit = page.Limit + 1
+	return q
+}
+
+// Page is a page of a list sorted by Column, it starts after the item of its cursor
+type Page struct {
+	Limit  int
+	Column string
+	Desc   bool
+	sortBy string
+	after  *pageCursor
+}
+
+// pageCursor is the position of the last item of a page, the value of its sort column and its ID
+type pageCursor struct {
+	SortBy string `json:"s"`
+	Value  string `json:"v"`
+	Id     uint   `json:"id"`
+}
+
+// NewPage check the limit, the cursor and the sort of a list request. sortBy is one of the fields of sortFields, prefixed by - for a descending order,
+// and sortFields map the fields the list can be sorted by to their column. A zero limit is the default limit and an empty sortBy is defaultSort
+func NewPage(limit int, cursor string, sortBy string, defaultSort string, sortFields map[string]string) (Page, *servicehelper.Error) {
+	if limit == 0 {
+		limit = DefaultPageLimit
+	}
+	if limit < 1 || limit > MaxPageLimit {
+		return Page{}, &servicehelper.Error{
+			Detail:  errors.New("invalid page limit"),
+			Message: fmt.Sprintf("The limit must be between 1 and %d", MaxPageLimit),
+			Param:   "limit",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	if sortBy == "" {
+		sortBy = defaultSort
+	}
+	page := Page{Limit: limit, sortBy: sortBy, Desc: strings.HasPrefix(sortBy, "-")}
+	column, ok := sortFields[strings.TrimPrefix(sortBy, "-")]
+	if !ok {
+		fields := make([]string, 0, len(sortFields))
+		for field := range sortFields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		return Page{}, &servicehelper.Error{
+			Detail:  errors.New("invalid sort field"),
+			Message: "The list can only be sorted by " + strings.Join(fields, ", "),
+			Param:   "sort",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	page.Column = column
+	if cursor == "" {
+		return page, nil
+	}
+
+	var after pageCursor
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(decoded, &after)
+	}
+	if err != nil || after.SortBy != sortBy {
+		return Page{}, &servicehelper.Error{
+			Detail:  errors.New("invalid page cursor"),
+			Message: "This cursor is not valid for this list, please start again from the first page",
+			Param:   "cursor",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	page.after = &after
+	return page, nil
+}
+
+// Cursor return the cursor of the page following an item, from the value of its sort column and its ID
+func (p Page) Cursor(value interface{}, id uint) string {
+	after := pageCursor{SortBy: p.sortBy, Id: id}
+	if t, ok := value.(time.Time); ok {
+		after.Value = t.UTC().Format(time.RFC3339Nano)
+	} else {
+		after.Value = fmt.Sprint(value)
+	}
+	encoded, _ := json.Marshal(after)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
@@ -0,0 +1,46 @@
+package apitool
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// TokenScopesKey is the key used to store the scopes granted to the access token of a request in the gin context
+const TokenScopesKey = "token_scopes"
+
+// SetTokenScopes store the scopes granted to the access token of the request, to be called by the access token validation middleware
+func SetTokenScopes(c *gin.Context, scopes []string) {
+	c.Set(TokenScopesKey, scopes)
+}
+
+// HasScopes check that the access token of the request has been granted every scope
+func HasScopes(c *gin.Context, scopes ...string) bool {
+	granted := c.GetStringSlice(TokenScopesKey)
+	for _, scope := range scopes {
+		found := false
+		for _, grantedScope := range granted {
+			if grantedScope == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// RequireScopes abort the request unless the access token has been granted every scope (middleware).
+// It must be placed after the middleware validating the access token
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasScopes(c, scopes...) {
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
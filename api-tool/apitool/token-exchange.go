@@ -0,0 +1,109 @@
+package apitool
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TokenExchangeGrantType is the grant type of the RFC 8693 token exchange grant
+const TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// AccessTokenType is the RFC 8693 identifier of an access token
+const AccessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+
+// TokenActorKey is the key used to store the client acting on behalf of the token owner in the gin context
+const TokenActorKey = "token_actor"
+
+// TokenActor is the RFC 8693 act claim of a delegated access token, Act is the actor the current one acts for
+type TokenActor struct {
+	Sub string      `json:"sub"`
+	Act *TokenActor `json:"act,omitempty"`
+}
+
+// SetTokenActor store the client acting on behalf of the token owner, to be called by the access token validation middleware
+func SetTokenActor(c *gin.Context, actor *TokenActor) {
+	if actor != nil {
+		c.Set(TokenActorKey, actor.Sub)
+	}
+}
+
+// GetTokenActor return the id of the client acting on behalf of the token owner, or an empty string when the owner calls directly
+func GetTokenActor(c *gin.Context) string {
+	return c.GetString(TokenActorKey)
+}
+
+// AllowActors abort the request when the access token is delegated to a client that is not one of clientIds (middleware).
+// The tokens used by their owner directly are let through, it must be placed after the middleware validating the access token
+func AllowActors(clientIds ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if actor := GetTokenActor(c); actor != "" {
+			allowed := false
+			for _, clientId := range clientIds {
+				if actor == clientId {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// BearerToken return the access token sent in the Authorization header of the request
+func BearerToken(c *gin.Context) string {
+	authorization := c.Request.Header.Get("Authorization")
+	if len(authorization) <= 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return ""
+	}
+	return authorization[7:]
+}
+
+// ExchangeToken ask the token endpoint for an access token delegated to the client on behalf of the owner of subjectToken (RFC 8693).
+// The delegated token is restricted to scope, which must be part of the scopes of subjectToken
+func ExchangeToken(tokenUrl string, clientId string, clientSecret string, subjectToken string, scope string) (string, error) {
+	form := url.Values{
+		"grant_type":         {TokenExchangeGrantType},
+		"subject_token":      {subjectToken},
+		"subject_token_type": {AccessTokenType},
+		"scope":              {scope},
+	}
+	req, err := http.NewRequest("POST", tokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(clientId), url.QueryEscape(clientSecret))
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	tokenResponse := struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return "", err
+	}
+	if tokenResponse.Error != "" {
+		return "", errors.New(tokenResponse.Error + ": " + tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.AccessToken == "" {
+		return "", errors.New("no access token in the token exchange response")
+	}
+	return tokenResponse.AccessToken, nil
+}
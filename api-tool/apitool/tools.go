@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"testing"
 	"time"
 )
@@ -158,15 +159,17 @@ func encodeRequestBodyAndLog(t *testing.T, reqBody interface{}) io.Reader {
 	return b
 }
 
-// ExtractQueryParams format arguments for gorm from a map[string]interface{}
+// ExtractQueryParams format arguments for gorm from a map[string]interface{}.
+// The conditions are sorted, so the same map always gives the same query
 func ExtractQueryParams(queryParams map[string]interface{}) (query string, args []interface{}) {
-	for key, value := range queryParams {
-		if query == "" {
-			query += key
-		} else {
-			query += " AND " + key
-		}
-		args = append(args, value)
+	keys := make([]string, 0, len(queryParams))
+	for key := range queryParams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var q Query
+	for _, key := range keys {
+		q.Where(key, queryParams[key])
 	}
-	return
+	return q.Conditions()
 }
@@ -61,12 +61,26 @@ func BuildRequestError(err error) (int, ApiErrors) {
 }
 
 // BuildResponseError apply the right status to the http response and build the error JSON object
+// When the error has causes, each of them is an entry of the response, with the param of the error if it has none
 func BuildResponseError(err *servicehelper.Error) (status int, apiErrors ApiErrors) {
-	apiErrors.Errors = append(apiErrors.Errors, Error{
-		Detail:  err.Detail.Error(),
-		Message: err.Message,
-		Param:   err.Param,
-	})
+	if len(err.Causes) == 0 {
+		apiErrors.Errors = append(apiErrors.Errors, Error{
+			Detail:  err.Detail.Error(),
+			Message: err.Message,
+			Param:   err.Param,
+		})
+	}
+	for _, cause := range err.Causes {
+		param := cause.Param
+		if param == "" {
+			param = err.Param
+		}
+		apiErrors.Errors = append(apiErrors.Errors, Error{
+			Detail:  cause.Detail.Error(),
+			Message: cause.Message,
+			Param:   param,
+		})
+	}
 	return int(err.Code), apiErrors
 }
 
@@ -12,6 +12,8 @@ const (
 	Forbidden            = 403
 	NotFound             = 404
 	AlreadyExist         = 409
+	Locked               = 423
+	TooManyRequests      = 429
 	UnexpectedError      = 500
 	NotImplemented       = 501
 )
@@ -22,4 +24,6 @@ type Error struct {
 	Detail  error
 	Message string
 	Code    Code
+	// Causes are the errors reported instead of this one when several rules failed at once, e.g. every rule a password breaks
+	Causes []Error
 }
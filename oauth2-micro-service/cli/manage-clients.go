@@ -0,0 +1,128 @@
+// Package main is a command line tool to manage the OAuth2 clients directly in the database.
+// It is mostly useful to register the first client allowed to use the admin API.
+//
+// Usage:
+//   manage-clients create -name <name> -redirect-uris <uri,...> -grant-types <type,...> [-scopes <scope,...>] [-public] [-first-party] [-logo-uri <uri>] [-primary-color <#rrggbb>] [-background-color <#rrggbb>] [-logout-uri <uri>] [-public-key-file <pem file>] [-user-id <id>]
+//   manage-clients list
+//   manage-clients get -id <client id>
+//   manage-clients rotate-secret -id <client id> [-previous-secret-expires-in <seconds>] [-expires-in <seconds>]
+//   manage-clients delete -id <client id>
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/repo"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/rest"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/service"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/database/dbconn"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	// Init DB and plan to close it at the end of the programme
+	dbconn.Connect()
+	defer dbconn.DB.Close()
+	dbconn.DB.AutoMigrate(&service.Client{})
+
+	s := service.New(repo.New())
+
+	var result interface{}
+	var err *servicehelper.Error
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	switch os.Args[1] {
+	case "create":
+		name := flags.String("name", "", "display name of the client")
+		redirectUris := flags.String("redirect-uris", "", "comma separated list of redirect uris")
+		grantTypes := flags.String("grant-types", "authorization_code,refresh_token", "comma separated list of grant types")
+		scopes := flags.String("scopes", "", "comma separated list of scopes the client can request")
+		public := flags.Bool("public", false, "register a public client (no secret)")
+		firstParty := flags.Bool("first-party", false, "register a first party client (users are never asked for their consent)")
+		logoUri := flags.String("logo-uri", "", "url of the client logo")
+		primaryColor := flags.String("primary-color", "", "hexadecimal color of the buttons and borders of the login pages")
+		backgroundColor := flags.String("background-color", "", "hexadecimal background color of the login pages")
+		logoutUri := flags.String("logout-uri", "", "url notified when a user signs out")
+		userId := flags.Uint("user-id", 0, "id of the user owning the client")
+		publicKeyFile := flags.String("public-key-file", "", "PEM file of the public keys verifying the JWT assertions of the client")
+		flags.Parse(os.Args[2:])
+		var publicKeys []string
+		if *publicKeyFile != "" {
+			content, readErr := ioutil.ReadFile(*publicKeyFile)
+			if readErr != nil {
+				fmt.Fprintln(os.Stderr, "error:", readErr)
+				os.Exit(1)
+			}
+			publicKeys = []string{string(content)}
+		}
+		result, err = s.AddClient(rest.RequestDTOClient{
+			UserId:          *userId,
+			Name:            *name,
+			RedirectUris:    splitList(*redirectUris),
+			GrantTypes:      splitList(*grantTypes),
+			Scopes:          splitList(*scopes),
+			Public:          *public,
+			FirstParty:      *firstParty,
+			LogoUri:         *logoUri,
+			PrimaryColor:    *primaryColor,
+			BackgroundColor: *backgroundColor,
+			LogoutUri:       *logoutUri,
+			PublicKeys:      publicKeys,
+		})
+	case "list":
+		flags.Parse(os.Args[2:])
+		result, err = s.RetrieveClients()
+	case "get":
+		id := flags.String("id", "", "id of the client")
+		flags.Parse(os.Args[2:])
+		result, err = s.RetrieveClient(*id)
+	case "rotate-secret":
+		id := flags.String("id", "", "id of the client")
+		previousSecretExpiresIn := flags.Int("previous-secret-expires-in", int(service.DefaultSecretOverlap.Seconds()), "seconds during which the previous secret stays valid, 0 revokes it immediately")
+		expiresIn := flags.Int("expires-in", 0, "seconds after which the new secret expires, 0 never expires")
+		flags.Parse(os.Args[2:])
+		reqDTO := rest.RequestDTOSecretRotation{PreviousSecretExpiresIn: previousSecretExpiresIn}
+		if *expiresIn > 0 {
+			reqDTO.ExpiresIn = expiresIn
+		}
+		result, err = s.RotateClientSecret(*id, reqDTO)
+	case "delete":
+		id := flags.String("id", "", "id of the client")
+		flags.Parse(os.Args[2:])
+		err = s.RemoveClient(*id)
+		result = map[string]string{"message": "client has been deleted successfully"}
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err.Detail.Error())
+		os.Exit(1)
+	}
+	output, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(output))
+}
+
+// splitList split a comma separated list and drop empty items
+func splitList(list string) (items []string) {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// usage print the available commands and exit
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: manage-clients <create|list|get|rotate-secret|delete> [flags]")
+	os.Exit(2)
+}
@@ -1,7 +1,14 @@
 package main_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"github.com/RangelReale/osin"
 	"github.com/adriendomoison/apigoboot/api-tool/apitool"
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
@@ -15,48 +22,76 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/go-errors/errors"
+	"github.com/jinzhu/gorm"
 	"io/ioutil"
+	"math/big"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 var publicBaseUrl = config.GAppUrl + "/authentication"
 var privateBaseUrl = config.GAppUrl + "/api/private-v1/authentication"
 var accessToken = ""
+var logoutNotifications = make(chan string, 1)
 
 // initOAuthServer Init OSIN OAuth server
 func initOAuthServer() *osin.Server {
 	serverConfig := osin.NewServerConfig()
 	serverConfig.AllowedAuthorizeTypes = osin.AllowedAuthorizeType{osin.CODE, osin.TOKEN}
 	serverConfig.AllowedAccessTypes = osin.AllowedAccessType{osin.AUTHORIZATION_CODE,
-		osin.REFRESH_TOKEN, osin.PASSWORD, osin.CLIENT_CREDENTIALS, osin.ASSERTION}
+		osin.REFRESH_TOKEN, osin.PASSWORD, osin.CLIENT_CREDENTIALS}
 	serverConfig.AllowGetAccessRequest = true
 	serverConfig.AllowClientSecretInParams = true
+	serverConfig.RedirectUriSeparator = service.RedirectUriSeparator
 	return osin.NewServer(serverConfig, repo.NewStorage(dbconn.DB.DB()))
 }
 
-func requestCode(t *testing.T) string {
+// getCsrfToken open a page with a form in the browser and return the csrf token of the form
+func getCsrfToken(t *testing.T, browser *http.Client, pageUrl string) string {
+	resp, err := browser.Get(pageUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	match := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindSubmatch(body)
+	if match == nil {
+		t.Fatal("No csrf token in the form of " + pageUrl)
+	}
+	return string(match[1])
+}
+
+// requestCode log in the browser of the application, started like the application does so the state is bound to the browser,
+// and return the authorization code and state sent back to the application
+func requestCode(t *testing.T) (string, string, *http.Client) {
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	resp, err := client.Get(publicBaseUrl + "/oauth2/start?client_id=apigoboot&scope=" + url.QueryEscape("user:read profile:read"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	authorizeUrl := resp.Request.URL.String()
+
 	form := url.Values{}
 	form.Add("username", "test00@example.dev")
 	form.Add("password", "password123")
-
-	req, err := http.NewRequest("POST", publicBaseUrl+"/authorize?response_type=code&client_id=apigoboot&client_secret=apigoboot&state=xyz&scope=everything&redirect_uri=http://api.go.boot:4200/authentication/oauth2/code", strings.NewReader(form.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	form.Add("csrf_token", getCsrfToken(t, client, authorizeUrl))
+	resp, err = client.PostForm(authorizeUrl, form)
 	if err != nil {
 		panic(err)
 	}
 	defer resp.Body.Close()
-
 	body, _ := ioutil.ReadAll(resp.Body)
-
 	access := struct {
-		Code string `json:"code"`
+		Code  string `json:"code"`
+		State string `json:"state"`
 	}{}
 	apiErrors := apihelper.ApiErrors{}
 	if json.Unmarshal(body, &access) != nil {
@@ -68,7 +103,7 @@ func requestCode(t *testing.T) string {
 	} else if access.Code == "" {
 		t.Error("Authorization code is empty")
 	}
-	return access.Code
+	return access.Code, access.State, client
 }
 
 func CheckCredentialsMock(c *gin.Context) {
@@ -76,7 +111,55 @@ func CheckCredentialsMock(c *gin.Context) {
 	if err := c.BindJSON(&reqDTO); err != nil {
 		c.JSON(apihelper.BuildRequestError(err))
 	} else {
-		if reqDTO.Username == "test00@example.dev" && reqDTO.Password == "password123" {
+		assertion := struct {
+			Id string `json:"id"`
+		}{}
+		json.Unmarshal(reqDTO.Webauthn, &assertion)
+		if reqDTO.AuthType == "webauthn" {
+			if assertion.Id == "passkey-1" {
+				c.JSON(http.StatusOK, rest.ResponseDTOUserInfo{
+					UserId: 1,
+					Email:  "test00@example.dev",
+				})
+			} else {
+				c.JSON(apihelper.BuildResponseError(&servicehelper.Error{
+					Detail: errors.New("invalid webauthn assertion"),
+					Param:  "webauthn",
+					Code:   servicehelper.Unauthorized,
+				}))
+			}
+		} else if reqDTO.AuthType == "mfa" {
+			if reqDTO.MfaToken != "mfa-token" {
+				c.JSON(apihelper.BuildResponseError(&servicehelper.Error{
+					Detail: errors.New("invalid or expired mfa token"),
+					Param:  "mfa_token",
+					Code:   servicehelper.Unauthorized,
+				}))
+			} else if reqDTO.Code != "123456" {
+				c.JSON(apihelper.BuildResponseError(&servicehelper.Error{
+					Detail: errors.New("incorrect code"),
+					Param:  "code",
+					Code:   servicehelper.Unauthorized,
+				}))
+			} else {
+				c.JSON(http.StatusOK, rest.ResponseDTOUserInfo{
+					UserId: 3,
+					Email:  "mfa00@example.dev",
+				})
+			}
+		} else if reqDTO.Username == "mfa00@example.dev" && reqDTO.Password == "password123" {
+			c.JSON(http.StatusOK, rest.ResponseDTOUserInfo{
+				MfaRequired: true,
+				MfaToken:    "mfa-token",
+			})
+		} else if reqDTO.Username == "unverified00@example.dev" && reqDTO.Password == "password123" {
+			c.JSON(apihelper.BuildResponseError(&servicehelper.Error{
+				Detail:  errors.New("email is not verified"),
+				Message: "Please confirm your email address with the link we sent you before signing in",
+				Param:   "email_verification",
+				Code:    servicehelper.Forbidden,
+			}))
+		} else if reqDTO.Username == "test00@example.dev" && reqDTO.Password == "password123" {
 			c.JSON(http.StatusOK, rest.ResponseDTOUserInfo{
 				UserId: 1,
 				Email:  "test00@example.dev",
@@ -91,6 +174,97 @@ func CheckCredentialsMock(c *gin.Context) {
 	return
 }
 
+// userStatus is the status of the user test00@example.dev
+var userStatus = "active"
+
+func getUserByIdMock(c *gin.Context) {
+	if c.Param("userId") == "1" {
+		c.JSON(http.StatusOK, rest.ResponseDTOUserInfo{
+			UserId:      1,
+			Email:       "test00@example.dev",
+			Status:      userStatus,
+			Roles:       []string{"support"},
+			Permissions: []string{"profiles:read", "users:read", "users:unlock"},
+		})
+	} else if c.Param("userId") == "3" {
+		c.JSON(http.StatusOK, rest.ResponseDTOUserInfo{UserId: 3, Email: "mfa00@example.dev", Status: "active"})
+	} else if c.Param("userId") == "4" {
+		c.JSON(http.StatusOK, rest.ResponseDTOUserInfo{UserId: 4, Email: "suspended00@example.dev", Status: "suspended"})
+	} else {
+		c.JSON(http.StatusNotFound, gin.H{})
+	}
+}
+
+func webauthnLoginOptionsMock(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"challenge": "Y2hhbGxlbmdl", "rpId": "api.go.boot", "allowCredentials": []gin.H{}})
+}
+
+// verificationEmails receive the emails a new verification link is asked for
+var verificationEmails = make(chan string, 1)
+
+func verificationEmailMock(c *gin.Context) {
+	var reqDTO rest.RequestDTOVerificationEmail
+	c.BindJSON(&reqDTO)
+	verificationEmails <- reqDTO.Email
+	c.JSON(http.StatusAccepted, gin.H{"message": "if an account needs to confirm this email, a new link has been sent"})
+}
+
+func passwordResetMock(c *gin.Context) {
+	c.JSON(http.StatusAccepted, gin.H{"message": "if an account uses this email, a link to reset its password has been sent"})
+}
+
+func confirmPasswordResetMock(c *gin.Context) {
+	var reqDTO rest.RequestDTOConfirmPasswordReset
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else if reqDTO.Token != "reset-token" {
+		c.JSON(apihelper.BuildResponseError(&servicehelper.Error{
+			Detail:  errors.New("invalid or expired password reset token"),
+			Message: "This link is not valid anymore, please ask for a new one",
+			Param:   "token",
+			Code:    servicehelper.BadRequest,
+		}))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "password has been reset successfully"})
+	}
+}
+
+// logoutNotificationMock verify the logout token with the published keys, as a client would, and send its subject to logoutNotifications
+func logoutNotificationMock(c *gin.Context) {
+	parts := strings.Split(c.PostForm("logout_token"), ".")
+	var jwks rest.ResponseDTOJwks
+	resp, err := http.Get(publicBaseUrl + "/jwks")
+	if err == nil {
+		json.NewDecoder(resp.Body).Decode(&jwks)
+		resp.Body.Close()
+	}
+	if len(parts) != 3 || len(jwks.Keys) != 1 {
+		logoutNotifications <- "invalid logout token"
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	x, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].X)
+	y, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].Y)
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	var claims struct {
+		Aud    string                     `json:"aud"`
+		Sub    string                     `json:"sub"`
+		Events map[string]json.RawMessage `json:"events"`
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	json.Unmarshal(payload, &claims)
+	if len(signature) != 64 || !ecdsa.Verify(key, hash[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) ||
+		claims.Aud != "apigoboot-sso" || claims.Events["http://schemas.openid.net/event/backchannel-logout"] == nil {
+		logoutNotifications <- "invalid logout token"
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	logoutNotifications <- claims.Sub
+	c.Status(http.StatusOK)
+}
+
 func TestMain(m *testing.M) {
 	// Init Env
 	config.SetToTestingEnv()
@@ -103,6 +277,9 @@ func TestMain(m *testing.M) {
 	router := gin.Default()
 	router.Use(cors.New(apitool.DefaultCORSConfig()))
 
+	// Load the login pages
+	rest.LoadPages(router)
+
 	// Append routes to server
 	oauth2Component := oauth2.New(rest.New(initOAuthServer(), service.New(repo.New())))
 	oauth2Component.AttachPublicAPI(router.Group("/authentication"))
@@ -110,6 +287,12 @@ func TestMain(m *testing.M) {
 
 	// Add mocked other micro-services called by this service
 	router.POST("/api/private-v1/user/check-credentials", CheckCredentialsMock)
+	router.GET("/api/private-v1/user/id/:userId", getUserByIdMock)
+	router.POST("/api/private-v1/user/webauthn/login-options", webauthnLoginOptionsMock)
+	router.POST("/api/private-v1/user/verification-email", verificationEmailMock)
+	router.POST("/api/private-v1/user/password-reset", passwordResetMock)
+	router.POST("/api/private-v1/user/password-reset/confirm", confirmPasswordResetMock)
+	router.POST("/client/logout", logoutNotificationMock)
 
 	// Start server in a routine
 	go router.Run(":" + config.GPort)
@@ -128,20 +311,62 @@ func TestMain(m *testing.M) {
 	dbconn.DB.DropTable(&service.Client{})
 	dbconn.DB.DropTable(&service.Access{})
 	dbconn.DB.DropTable(&service.Refresh{})
+	dbconn.DB.DropTable(&service.Consent{})
+	dbconn.DB.DropTable(&service.DeviceAuthorization{})
+	dbconn.DB.DropTable(&service.UsedAssertion{})
+	dbconn.DB.DropTable(&service.Session{})
 
 	// Stop tests
 	os.Exit(code)
 }
 
 func createClient() {
+	secret, _ := service.HashClientSecret("apigoboot")
+	adminSecret, _ := service.HashClientSecret("apigoboot-admin")
 	dbconn.DB.Create(&service.Client{
 		Id:          "apigoboot",
-		Secret:      "apigoboot",
+		Secret:      secret,
 		RedirectUri: "http://api.go.boot:4200/authentication/oauth2/code",
 		UserId:      1,
+		Name:        "apigoboot",
+		GrantTypes:  "authorization_code refresh_token password",
+		FirstParty:  true,
+		Scope:       "user:read user:write profile:read profile:write staff",
+	})
+	dbconn.DB.Create(&service.Client{
+		Id:          "apigoboot-admin",
+		Secret:      adminSecret,
+		RedirectUri: "http://api.go.boot:4200/authentication/oauth2/code",
+		Name:        "apigoboot admin",
+		GrantTypes:  "client_credentials",
+		Scope:       "admin",
+	})
+	dbconn.DB.Create(&service.Client{
+		Id:          "apigoboot-device",
+		RedirectUri: "http://api.go.boot:4200/authentication/oauth2/code",
+		Name:        "apigoboot device",
+		GrantTypes:  rest.DeviceCodeGrantType,
+		Scope:       "user:read",
+		Public:      true,
 	})
 }
 
+func requestAdminAccessToken(t *testing.T) string {
+	access := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/token?grant_type=client_credentials&scope=admin&client_id=apigoboot-admin&client_secret=apigoboot-admin",
+	}, nil, &access)
+	defer resp.Body.Close()
+
+	if access.AccessToken == "" {
+		t.Error("Admin access token is empty")
+	}
+	return access.AccessToken
+}
+
 func TestPasswordAuthentication(t *testing.T) {
 
 	// init test variable
@@ -177,18 +402,36 @@ func TestPasswordAuthentication(t *testing.T) {
 func TestCodeAuthentication(t *testing.T) {
 
 	// init test variable
-	code := requestCode(t)
+	code, state, browser := requestCode(t)
+
+	// test the code is refused without the state bound to the browser
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    publicBaseUrl + "/oauth2/code?code=" + code + "&state=" + state + "&client_id=apigoboot&client_secret=apigoboot&parse=yes",
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "400", resp.Status)
+	}
+	resp, err := browser.Get(publicBaseUrl + "/oauth2/code?code=" + code + "&state=xyz&client_id=apigoboot&client_secret=apigoboot&parse=yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "400", resp.Status)
+	}
 
 	// call api
+	resp, err = browser.Get(publicBaseUrl + "/oauth2/code?code=" + code + "&state=" + url.QueryEscape(state) + "&client_id=apigoboot&client_secret=apigoboot&parse=yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
 	access := struct {
 		AccessToken string `json:"access_token"`
 	}{}
-	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
-		Method:      "GET",
-		URL:         publicBaseUrl + "/oauth2/code?code=" + code + "&state=xyz&client_id=apigoboot&client_secret=apigoboot&parse=yes",
-		ContentType: "application/x-www-form-urlencoded",
-	}, nil, &access)
-	defer resp.Body.Close()
+	json.NewDecoder(resp.Body).Decode(&access)
 
 	// test response
 	if resp.Status != "200 OK" {
@@ -207,7 +450,7 @@ func TestGetAccessTokenOwnerUserId(t *testing.T) {
 	t.Log("testing with following parameters:")
 	t.Log(accessToken)
 
-	// call api
+	// call api, the roles are not loaded without the staff scope
 	var user rest.ResponseDTOUserInfo
 	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
 		Method:        "GET",
@@ -221,5 +464,1109 @@ func TestGetAccessTokenOwnerUserId(t *testing.T) {
 		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
 	} else if user.UserId != uint(userId) {
 		t.Errorf("Expected %v to be %v, got %v", "user id", userId, user.UserId)
+	} else if len(user.Scopes) != 4 {
+		t.Errorf("Expected %v to be %v, got %v", "scopes", 4, len(user.Scopes))
+	} else if len(user.Roles) != 0 || len(user.Permissions) != 0 {
+		t.Errorf("Expected %v to be %v, got %v", "roles", "empty", user.Roles)
+	}
+
+	// request a token with the staff scope
+	access := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:      "POST",
+		URL:         publicBaseUrl + "/oauth2/password",
+		ContentType: "application/x-www-form-urlencoded",
+	}, map[string]string{
+		"client_id":     "apigoboot",
+		"client_secret": "apigoboot",
+		"method":        "password",
+		"scope":         "user:read staff",
+		"username":      "test00@example.dev",
+		"password":      "password123",
+	}, &access)
+	resp.Body.Close()
+	if access.AccessToken == "" {
+		t.Fatal("Staff access token is empty")
+	}
+
+	// call api, the roles of the user are loaded
+	user = rest.ResponseDTOUserInfo{}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           privateBaseUrl + "/access-token/" + access.AccessToken + "/get-owner",
+		Authorization: "Bearer " + access.AccessToken,
+	}, nil, &user)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if len(user.Roles) != 1 || user.Roles[0] != "support" {
+		t.Errorf("Expected %v to be %v, got %v", "roles", []string{"support"}, user.Roles)
+	} else if len(user.Permissions) != 3 {
+		t.Errorf("Expected %v to be %v, got %v", "permissions", 3, len(user.Permissions))
+	}
+
+	// test the token is refused once its owner is suspended
+	userStatus = "suspended"
+	defer func() { userStatus = "active" }()
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/access-token/" + accessToken + "/get-owner",
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode == 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "an error", resp.Status)
+	}
+}
+
+func TestGrantedApps(t *testing.T) {
+
+	// list apps with a token issued to a client only
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/apps",
+		Authorization: "Bearer " + requestAdminAccessToken(t),
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 403 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "403", resp.Status)
+	}
+
+	// list apps, first party clients are never recorded
+	var apps []rest.ResponseDTOGrantedApp
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/apps",
+		Authorization: "Bearer " + accessToken,
+	}, nil, &apps)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if len(apps) != 0 {
+		t.Errorf("Expected %v to be %v, got %v", "granted apps", 0, len(apps))
+	}
+
+	// revoke an app that was never granted
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "DELETE",
+		URL:           publicBaseUrl + "/apps/apigoboot",
+		Authorization: "Bearer " + accessToken,
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "404", resp.Status)
+	}
+}
+
+func TestDeviceAuthorization(t *testing.T) {
+
+	// start device authorization
+	var deviceAuthorization rest.ResponseDTODeviceAuthorization
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/device_authorization?client_id=apigoboot-device",
+	}, nil, &deviceAuthorization)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if deviceAuthorization.DeviceCode == "" || deviceAuthorization.UserCode == "" {
+		t.Error("Device code or user code is empty")
+	}
+
+	// poll before the user approved the device, then poll too fast
+	for _, expectedError := range []string{"authorization_pending", "slow_down"} {
+		access := struct {
+			AccessToken string `json:"access_token"`
+			Error       string `json:"error"`
+		}{}
+		resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    publicBaseUrl + "/token?grant_type=" + url.QueryEscape(rest.DeviceCodeGrantType) + "&client_id=apigoboot-device&device_code=" + deviceAuthorization.DeviceCode,
+		}, nil, &access)
+		resp.Body.Close()
+		if access.Error != expectedError {
+			t.Errorf("Expected %v to be %v, got %v", "error", expectedError, access.Error)
+		}
+	}
+
+	// poll with another client
+	access := struct {
+		Error string `json:"error"`
+	}{}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/token?grant_type=" + url.QueryEscape(rest.DeviceCodeGrantType) + "&client_id=apigoboot&client_secret=apigoboot&device_code=" + deviceAuthorization.DeviceCode,
+	}, nil, &access)
+	resp.Body.Close()
+	if access.Error != "unauthorized_client" {
+		t.Errorf("Expected %v to be %v, got %v", "error", "unauthorized_client", access.Error)
+	}
+}
+
+// signJwtAssertion build a ES256 JWT assertion issued by a client for a subject
+func signJwtAssertion(t *testing.T, key *ecdsa.PrivateKey, clientId string, subject string, jti string) string {
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss": clientId,
+		"sub": subject,
+		"aud": config.GAppUrl + "/authentication/token",
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+		"jti": jti,
+	})
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 64)
+	copy(signature[32-len(r.Bytes()):32], r.Bytes())
+	copy(signature[64-len(s.Bytes()):], s.Bytes())
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJwtBearerAssertion(t *testing.T) {
+
+	// init test variable
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	publicKey, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	dbconn.DB.Create(&service.Client{
+		Id:          "apigoboot-jwt",
+		RedirectUri: "http://api.go.boot:4200/authentication/oauth2/code",
+		Name:        "apigoboot jwt",
+		GrantTypes:  rest.JwtBearerGrantType,
+		Scope:       "user:read",
+		PublicKeys:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+	})
+	assertion := signJwtAssertion(t, key, "apigoboot-jwt", "1", "assertion-1")
+
+	// exchange assertion, then replay it
+	for _, expectedError := range []string{"", "invalid_grant"} {
+		access := struct {
+			AccessToken string `json:"access_token"`
+			Error       string `json:"error"`
+		}{}
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    publicBaseUrl + "/token?grant_type=" + url.QueryEscape(rest.JwtBearerGrantType) + "&assertion=" + assertion,
+		}, nil, &access)
+		resp.Body.Close()
+		if access.Error != expectedError {
+			t.Errorf("Expected %v to be %v, got %v", "error", expectedError, access.Error)
+		} else if expectedError == "" && access.AccessToken == "" {
+			t.Error("Access token is empty")
+		}
+	}
+
+	// assertion for a suspended user
+	suspended := struct {
+		Error string `json:"error"`
+	}{}
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/token?grant_type=" + url.QueryEscape(rest.JwtBearerGrantType) + "&assertion=" + signJwtAssertion(t, key, "apigoboot-jwt", "4", "assertion-suspended"),
+	}, nil, &suspended)
+	resp.Body.Close()
+	if suspended.Error != "invalid_grant" {
+		t.Errorf("Expected %v to be %v, got %v", "error", "invalid_grant", suspended.Error)
+	}
+
+	// assertion signed by another key
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	access := struct {
+		Error string `json:"error"`
+	}{}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/token?grant_type=" + url.QueryEscape(rest.JwtBearerGrantType) + "&assertion=" + signJwtAssertion(t, otherKey, "apigoboot-jwt", "1", "assertion-2"),
+	}, nil, &access)
+	resp.Body.Close()
+	if access.Error != "invalid_grant" {
+		t.Errorf("Expected %v to be %v, got %v", "error", "invalid_grant", access.Error)
+	}
+}
+
+func TestTokenExchange(t *testing.T) {
+
+	// init test variable
+	secret, _ := service.HashClientSecret("apigoboot-service")
+	dbconn.DB.Create(&service.Client{
+		Id:          "apigoboot-service",
+		Secret:      secret,
+		RedirectUri: "http://api.go.boot:4200/authentication/oauth2/code",
+		Name:        "apigoboot service",
+		GrantTypes:  rest.TokenExchangeGrantType,
+		Scope:       "profile:read",
+	})
+	exchangeUrl := publicBaseUrl + "/token?grant_type=" + url.QueryEscape(rest.TokenExchangeGrantType) +
+		"&client_id=apigoboot-service&client_secret=apigoboot-service&subject_token=" + accessToken +
+		"&subject_token_type=" + url.QueryEscape(rest.AccessTokenType)
+
+	// exchange the token of the user for a token delegated to the service
+	access := struct {
+		AccessToken     string `json:"access_token"`
+		IssuedTokenType string `json:"issued_token_type"`
+		Error           string `json:"error"`
+	}{}
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    exchangeUrl + "&scope=profile:read",
+	}, nil, &access)
+	resp.Body.Close()
+	if access.AccessToken == "" {
+		t.Errorf("Expected %v to be %v, got %v", "error", "", access.Error)
+	} else if access.IssuedTokenType != rest.AccessTokenType {
+		t.Errorf("Expected %v to be %v, got %v", "issued token type", rest.AccessTokenType, access.IssuedTokenType)
+	}
+
+	// delegated token is owned by the user and names the service as actor
+	var user rest.ResponseDTOUserInfo
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/access-token/" + access.AccessToken + "/get-owner",
+	}, nil, &user)
+	resp.Body.Close()
+	if user.UserId != 1 {
+		t.Errorf("Expected %v to be %v, got %v", "user id", 1, user.UserId)
+	} else if len(user.Scopes) != 1 || user.Scopes[0] != "profile:read" {
+		t.Errorf("Expected %v to be %v, got %v", "scopes", "[profile:read]", user.Scopes)
+	} else if user.Actor == nil || user.Actor.Sub != "apigoboot-service" {
+		t.Errorf("Expected %v to be %v, got %v", "actor", "apigoboot-service", user.Actor)
+	}
+
+	// a scope the service is not allowed to request can not be delegated
+	refused := struct {
+		Error string `json:"error"`
+	}{}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    exchangeUrl + "&scope=user:write",
+	}, nil, &refused)
+	resp.Body.Close()
+	if refused.Error != "invalid_scope" {
+		t.Errorf("Expected %v to be %v, got %v", "error", "invalid_scope", refused.Error)
+	}
+}
+
+func TestExpiredAccessTokenIsPurged(t *testing.T) {
+
+	// init test variable
+	dbconn.DB.Create(&service.Access{
+		Model:       gorm.Model{CreatedAt: time.Now().Add(-2 * time.Hour)},
+		Client:      "apigoboot",
+		UserId:      1,
+		AccessToken: "expired-access-token",
+		ExpiresIn:   3600,
+		Scope:       "user:read",
+	})
+
+	// expired token has no owner anymore
+	var user rest.ResponseDTOUserInfo
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/access-token/expired-access-token/get-owner",
+	}, nil, &user)
+	resp.Body.Close()
+	if resp.StatusCode == 200 || user.UserId != 0 {
+		t.Errorf("Expected %v to be %v, got %v", "status", "an error", resp.Status)
+	}
+
+	// expired token is removed by the cleanup
+	report, err := service.New(repo.New()).PurgeExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Accesses < 1 {
+		t.Errorf("Expected %v to be %v, got %v", "purged access tokens", ">= 1", report.Accesses)
+	}
+	if !dbconn.DB.Unscoped().Where("access_token = ?", "expired-access-token").First(&service.Access{}).RecordNotFound() {
+		t.Error("Expired access token is still in database")
+	}
+}
+
+func TestUnknownScopeIsRefused(t *testing.T) {
+
+	// call api
+	access := struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}{}
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/token?grant_type=password&scope=everything&username=test00@example.dev&password=password123&client_id=apigoboot&client_secret=apigoboot",
+	}, nil, &access)
+	defer resp.Body.Close()
+
+	// test response
+	if access.AccessToken != "" {
+		t.Error("Access token was issued with an unknown scope")
+	} else if access.Error != "invalid_scope" {
+		t.Errorf("Expected %v to be %v, got %v", "error", "invalid_scope", access.Error)
+	}
+}
+
+func TestAdminScopeIsRefusedToOtherClients(t *testing.T) {
+
+	// call api
+	access := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/token?grant_type=password&scope=admin&username=test00@example.dev&password=password123&client_id=apigoboot&client_secret=apigoboot",
+	}, nil, &access)
+	defer resp.Body.Close()
+
+	// test response
+	if access.AccessToken != "" {
+		t.Error("Access token with admin scope was issued to a client without the admin scope")
+	}
+}
+
+func TestLegacyClientKeepsItsGrantTypes(t *testing.T) {
+
+	// init test variable
+	secret, _ := service.HashClientSecret("apigoboot-legacy")
+	dbconn.DB.Create(&service.Client{
+		Id:          "apigoboot-legacy",
+		Secret:      secret,
+		RedirectUri: "http://api.go.boot:4200/authentication/oauth2/code",
+		Name:        "apigoboot legacy",
+		Scope:       "user:read",
+	})
+	defer dbconn.DB.Where("id = ?", "apigoboot-legacy").Delete(&service.Client{})
+
+	// call api
+	access := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/token?grant_type=password&scope=user:read&username=test00@example.dev&password=password123&client_id=apigoboot-legacy&client_secret=apigoboot-legacy",
+	}, nil, &access)
+	defer resp.Body.Close()
+
+	// test response
+	if access.AccessToken == "" {
+		t.Error("Access token was refused to a client registered without grant types")
+	}
+}
+
+func TestClientManagement(t *testing.T) {
+
+	// init test variable
+	adminAccessToken := requestAdminAccessToken(t)
+	requestBody := rest.RequestDTOClient{
+		Name:         "my app",
+		RedirectUris: []string{"http://app.go.boot/callback", "http://app.go.boot/other-callback"},
+		GrantTypes:   []string{"authorization_code", "refresh_token"},
+		Scopes:       []string{"user:read", "profile:read"},
+	}
+
+	// create client without admin token
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/admin/clients",
+		Authorization: "Bearer " + accessToken,
+	}, requestBody, &rest.ResponseDTOClient{})
+	resp.Body.Close()
+	if resp.StatusCode != 403 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "403", resp.Status)
+	}
+
+	// create client
+	var client rest.ResponseDTOClient
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/admin/clients",
+		Authorization: "Bearer " + adminAccessToken,
+	}, requestBody, &client)
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "201", resp.Status)
+	} else if client.ClientId == "" || client.ClientSecret == "" {
+		t.Error("Client id or secret is empty")
+	} else if len(client.RedirectUris) != 2 {
+		t.Errorf("Expected %v to be %v, got %v", "redirect uris", 2, len(client.RedirectUris))
+	}
+
+	// retrieve client
+	var retrieved rest.ResponseDTOClient
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/admin/clients/" + client.ClientId,
+		Authorization: "Bearer " + adminAccessToken,
+	}, nil, &retrieved)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if retrieved.ClientSecret != "" {
+		t.Error("Client secret should never be returned once created")
+	}
+
+	// rotate secret
+	var rotated rest.ResponseDTOClient
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/admin/clients/" + client.ClientId + "/secret",
+		Authorization: "Bearer " + adminAccessToken,
+	}, nil, &rotated)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if rotated.ClientSecret == "" || rotated.ClientSecret == client.ClientSecret {
+		t.Error("Client secret was not rotated")
+	} else if rotated.PreviousSecretExpiresAt == nil {
+		t.Error("Previous client secret should stay valid after a rotation")
+	}
+
+	// rotate secret with the previous secret (still valid during overlap) and revoke the current one immediately
+	var revoked rest.ResponseDTOClient
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/client/secret",
+		Authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte(client.ClientId+":"+client.ClientSecret)),
+	}, rest.RequestDTOSecretRotation{PreviousSecretExpiresIn: new(int)}, &revoked)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+
+	// previous secrets are not valid anymore
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/client/secret",
+		Authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte(client.ClientId+":"+rotated.ClientSecret)),
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 401 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "401", resp.Status)
+	}
+
+	// delete client
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "DELETE",
+		URL:           publicBaseUrl + "/admin/clients/" + client.ClientId,
+		Authorization: "Bearer " + adminAccessToken,
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+}
+
+func TestDynamicClientRegistration(t *testing.T) {
+
+	// init test variable
+	config.GDynamicClientRegistration = true
+	defer func() { config.GDynamicClientRegistration = false }()
+	requestBody := rest.RequestDTOClientRegistration{
+		ClientName:              "my public app",
+		RedirectUris:            []string{"http://app.go.boot/callback"},
+		ResponseTypes:           []string{"code"},
+		TokenEndpointAuthMethod: "none",
+	}
+
+	// call api
+	var client rest.ResponseDTOClientRegistration
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/register",
+	}, requestBody, &client)
+	defer resp.Body.Close()
+
+	// test response
+	if resp.StatusCode != 201 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "201", resp.Status)
+	} else if client.ClientId == "" {
+		t.Error("Client id is empty")
+	} else if client.ClientSecret != "" {
+		t.Error("Public client should not have a secret")
+	}
+
+	// test the privileged grant types and scopes can't be registered
+	for _, privileged := range []rest.RequestDTOClientRegistration{
+		{ClientName: "my app", RedirectUris: requestBody.RedirectUris, GrantTypes: []string{"password"}},
+		{ClientName: "my app", RedirectUris: requestBody.RedirectUris, GrantTypes: []string{"client_credentials"}},
+		{ClientName: "my app", RedirectUris: requestBody.RedirectUris, ResponseTypes: []string{"token"}},
+		{ClientName: "my app", RedirectUris: requestBody.RedirectUris, Scope: "user:read staff"},
+		{ClientName: "my app", RedirectUris: requestBody.RedirectUris, Scope: "admin"},
+	} {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    publicBaseUrl + "/register",
+		}, privileged, nil)
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Errorf("Expected %s to be %s, got %s for %+v", "status", "400", resp.Status, privileged)
+		}
+	}
+}
+
+func TestSingleSignOn(t *testing.T) {
+
+	// init test variable
+	secret, _ := service.HashClientSecret("apigoboot-sso")
+	dbconn.DB.Create(&service.Client{
+		Id:          "apigoboot-sso",
+		Secret:      secret,
+		RedirectUri: "http://api.go.boot:4200/authentication/oauth2/code",
+		Name:        "apigoboot sso",
+		GrantTypes:  "authorization_code",
+		FirstParty:  true,
+		Scope:       "user:read",
+		LogoutUri:   config.GAppUrl + "/client/logout",
+	})
+	authorizeUrl := publicBaseUrl + "/authorize?response_type=code&client_id=apigoboot-sso&state=xyz&redirect_uri=http://api.go.boot:4200/authentication/oauth2/code"
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// sign in once
+	form := url.Values{"username": {"test00@example.dev"}, "password": {"password123"}, "csrf_token": {getCsrfToken(t, browser, authorizeUrl)}}
+	resp, err := browser.PostForm(authorizeUrl, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || !strings.Contains(resp.Header.Get("Location"), "code=") {
+		t.Errorf("Expected %v to be %v, got %v", "status", "302 with a code", resp.Status)
+	} else if !strings.Contains(resp.Header.Get("Location"), "state=xyz") {
+		t.Errorf("Expected %v to be %v, got %v", "redirect", "sent back with the state", resp.Header.Get("Location"))
+	}
+
+	// next authorization skip the login form
+	resp, err = browser.Get(authorizeUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || !strings.Contains(resp.Header.Get("Location"), "code=") {
+		t.Errorf("Expected %v to be %v, got %v", "status", "302 with a code", resp.Status)
+	}
+
+	// test a logout is only done through the confirmation form
+	logoutUrl := publicBaseUrl + "/logout"
+	for _, csrfToken := range []string{"", "forged.token"} {
+		resp, err = browser.PostForm(logoutUrl, url.Values{"csrf_token": {csrfToken}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected %s to be %s, got %s", "status", "403", resp.Status)
+		}
+	}
+	resp, err = browser.Get(authorizeUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("Expected %v to be %v, got %v", "status", "302 as the session is still open", resp.Status)
+	}
+
+	// sign out and notify the client with a signed logout token
+	resp, err = browser.PostForm(logoutUrl, url.Values{"csrf_token": {getCsrfToken(t, browser, logoutUrl)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+	select {
+	case sub := <-logoutNotifications:
+		if sub != "1" {
+			t.Errorf("Expected %v to be %v, got %v", "logout notification sub", "1", sub)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Client was not notified of the logout")
+	}
+
+	// the login form is displayed again
+	resp, err = browser.Get(authorizeUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusFound {
+		t.Error("Authorization was granted after the logout")
+	}
+}
+
+func TestAuthorizePageSecurity(t *testing.T) {
+
+	// init test variable
+	authorizeUrl := publicBaseUrl + "/authorize?response_type=code&client_id=apigoboot&state=xyz&scope=user:read&redirect_uri=http://api.go.boot:4200/authentication/oauth2/code"
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// test the login page is served with security headers
+	resp, err := browser.Get(authorizeUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !strings.Contains(resp.Header.Get("Content-Security-Policy"), "frame-ancestors 'none'") {
+		t.Errorf("Expected %v to be %v, got %v", "content security policy", "set", resp.Header.Get("Content-Security-Policy"))
+	} else if resp.Header.Get("X-Frame-Options") != "DENY" {
+		t.Errorf("Expected %v to be %v, got %v", "X-Frame-Options", "DENY", resp.Header.Get("X-Frame-Options"))
+	}
+
+	// test credentials posted without a valid csrf token are refused
+	for _, csrfToken := range []string{"", "forged.token"} {
+		form := url.Values{"username": {"test00@example.dev"}, "password": {"password123"}, "csrf_token": {csrfToken}}
+		resp, err = browser.PostForm(authorizeUrl, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected %s to be %s, got %s", "status", "403", resp.Status)
+		}
+	}
+
+	// test a redirect uri that is not exactly registered is refused without redirecting
+	resp, err = browser.Get(strings.Replace(authorizeUrl, "oauth2/code", "oauth2/code/other", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusFound || !strings.Contains(string(body), "invalid_request") {
+		t.Errorf("Expected %v to be %v, got %v %s", "response", "invalid_request without redirect", resp.Status, body)
+	}
+
+	// test the state is required
+	resp, err = browser.Get(strings.Replace(authorizeUrl, "&state=xyz", "", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !strings.Contains(resp.Header.Get("Location"), "error=invalid_request") {
+		t.Errorf("Expected %v to be %v, got %v", "redirect", "invalid_request error", resp.Header.Get("Location"))
+	}
+}
+
+func TestLoginPageIsLocalizedAndBranded(t *testing.T) {
+
+	// init test variable
+	secret, _ := service.HashClientSecret("apigoboot-branded")
+	dbconn.DB.Create(&service.Client{
+		Id:              "apigoboot-branded",
+		Secret:          secret,
+		RedirectUri:     "http://api.go.boot:4200/authentication/oauth2/code",
+		Name:            "Branded App",
+		GrantTypes:      "authorization_code",
+		Scope:           "user:read",
+		PrimaryColor:    "#336699",
+		BackgroundColor: "#fafafa",
+	})
+
+	// call api
+	req, _ := http.NewRequest("GET", publicBaseUrl+"/authorize?response_type=code&client_id=apigoboot-branded&state=xyz&redirect_uri=http://api.go.boot:4200/authentication/oauth2/code", nil)
+	req.Header.Set("Accept-Language", "fr-CA,fr;q=0.9,en;q=0.8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// test response
+	page := string(body)
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if !strings.Contains(page, `lang="fr"`) || !strings.Contains(page, "Se connecter") {
+		t.Errorf("Expected %v to be %v, got %v", "login page", "translated in french", page)
+	} else if !strings.Contains(page, "Branded App") || !strings.Contains(page, "#336699") {
+		t.Errorf("Expected %v to be %v, got %v", "login page", "branded with the client name and colors", page)
+	}
+
+	// test the embedded styles are served
+	resp, err = http.Get(publicBaseUrl + "/styles/style.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+}
+
+func TestSecondFactor(t *testing.T) {
+
+	// init test variable
+	requestBody := map[string]string{
+		"grant_type":    "password",
+		"client_id":     "apigoboot",
+		"client_secret": "apigoboot",
+		"username":      "mfa00@example.dev",
+		"password":      "password123",
+	}
+	requestToken := func() (string, string) {
+		access := struct {
+			AccessToken string `json:"access_token"`
+			Error       string `json:"error"`
+		}{}
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method:      "POST",
+			URL:         publicBaseUrl + "/token",
+			ContentType: "application/x-www-form-urlencoded",
+		}, requestBody, &access)
+		resp.Body.Close()
+		return access.AccessToken, access.Error
+	}
+
+	// test the password grant asks for a second factor
+	if token, errorCode := requestToken(); token != "" || errorCode != "mfa_required" {
+		t.Errorf("Expected %v to be %v, got %v", "error", "mfa_required", errorCode)
+	}
+	requestBody["otp"] = "654321"
+	if token, _ := requestToken(); token != "" {
+		t.Error("Access token was issued with a wrong code")
+	}
+	requestBody["otp"] = "123456"
+	if token, errorCode := requestToken(); token == "" {
+		t.Errorf("Expected %v to be %v, got %v", "access token", "issued", errorCode)
+	}
+
+	// test the login page asks for a second factor
+	authorizeUrl := publicBaseUrl + "/authorize?response_type=code&client_id=apigoboot&state=xyz&scope=user:read&redirect_uri=http://api.go.boot:4200/authentication/oauth2/code"
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	postForm := func(form url.Values) (*http.Response, string) {
+		resp, err := browser.PostForm(authorizeUrl, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+	csrfToken := getCsrfToken(t, browser, authorizeUrl)
+	resp, page := postForm(url.Values{"username": {"mfa00@example.dev"}, "password": {"password123"}, "csrf_token": {csrfToken}})
+	if resp.StatusCode != 200 || !strings.Contains(page, `name="mfa_token" value="mfa-token"`) {
+		t.Fatalf("Expected %v to be %v, got %v %s", "response", "the code form", resp.Status, page)
+	}
+
+	// test a wrong code shows the code form again
+	resp, page = postForm(url.Values{"mfa_token": {"mfa-token"}, "code": {"654321"}, "csrf_token": {csrfToken}})
+	if resp.StatusCode != 200 || !strings.Contains(page, `name="mfa_token"`) || !strings.Contains(page, "Invalid code") {
+		t.Errorf("Expected %v to be %v, got %v %s", "response", "the code form with an error", resp.Status, page)
+	}
+
+	// test the right code completes the login
+	resp, _ = postForm(url.Values{"mfa_token": {"mfa-token"}, "code": {"123456"}, "csrf_token": {csrfToken}})
+	if resp.StatusCode != http.StatusFound || !strings.Contains(resp.Header.Get("Location"), "code=") {
+		t.Errorf("Expected %v to be %v, got %v", "status", "302 with a code", resp.Status)
+	}
+}
+
+func TestLoginPageEmailVerification(t *testing.T) {
+
+	// init test variable
+	authorizeUrl := publicBaseUrl + "/authorize?response_type=code&client_id=apigoboot&state=xyz&scope=user:read&redirect_uri=http://api.go.boot:4200/authentication/oauth2/code"
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	postForm := func(form url.Values) (*http.Response, string) {
+		resp, err := browser.PostForm(authorizeUrl, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	// test a user who did not confirm its email is told so and offered a new link
+	csrfToken := getCsrfToken(t, browser, authorizeUrl)
+	resp, page := postForm(url.Values{"username": {"unverified00@example.dev"}, "password": {"password123"}, "csrf_token": {csrfToken}})
+	if resp.StatusCode != 200 || !strings.Contains(page, "Please confirm your email address") ||
+		!strings.Contains(page, `name="resend_verification" value="unverified00@example.dev"`) {
+		t.Fatalf("Expected %v to be %v, got %v %s", "response", "the email verification error", resp.Status, page)
+	}
+
+	// test the new link is asked for to the user service
+	resp, page = postForm(url.Values{"resend_verification": {"unverified00@example.dev"}, "csrf_token": {csrfToken}})
+	if resp.StatusCode != 200 || !strings.Contains(page, "a new link has been sent") {
+		t.Errorf("Expected %v to be %v, got %v %s", "response", "the link to be sent", resp.Status, page)
+	}
+	select {
+	case email := <-verificationEmails:
+		if email != "unverified00@example.dev" {
+			t.Errorf("Expected %v to be %v, got %v", "email", "unverified00@example.dev", email)
+		}
+	case <-time.After(time.Second):
+		t.Error("No new link was asked for")
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+
+	// init test variable
+	authorizeUrl := publicBaseUrl + "/authorize?response_type=code&client_id=apigoboot&state=xyz&scope=user:read&redirect_uri=http://api.go.boot:4200/authentication/oauth2/code"
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// test the login page loads the passkey script
+	resp, err := browser.Get(authorizeUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `src="scripts/webauthn.js"`) || !strings.Contains(resp.Header.Get("Content-Security-Policy"), "script-src 'self'") {
+		t.Errorf("Expected %v to be %v, got %s", "login page", "allowed to run the passkey script", body)
+	}
+	resp, err = http.Get(publicBaseUrl + "/scripts/webauthn.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+
+	// test the options of the user service are given to the browser
+	options := struct {
+		Challenge string `json:"challenge"`
+	}{}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/webauthn/options",
+	}, rest.RequestDTOWebauthnOptions{Username: "test00@example.dev"}, &options)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || options.Challenge != "Y2hhbGxlbmdl" {
+		t.Errorf("Expected %v to be %v, got %v", "challenge", "Y2hhbGxlbmdl", options.Challenge)
+	}
+
+	// test an invalid assertion shows an error
+	csrfToken := getCsrfToken(t, browser, authorizeUrl)
+	resp, err = browser.PostForm(authorizeUrl, url.Values{"webauthn_assertion": {`{"id":"unknown"}`}, "csrf_token": {csrfToken}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || !strings.Contains(string(body), "We could not sign you in with this passkey") {
+		t.Errorf("Expected %v to be %v, got %v %s", "response", "the login form with an error", resp.Status, body)
+	}
+
+	// test a valid assertion completes the login
+	resp, err = browser.PostForm(authorizeUrl, url.Values{"webauthn_assertion": {`{"id":"passkey-1"}`}, "csrf_token": {csrfToken}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || !strings.Contains(resp.Header.Get("Location"), "code=") {
+		t.Errorf("Expected %v to be %v, got %v", "status", "302 with a code", resp.Status)
+	}
+}
+
+func TestPasswordResetPage(t *testing.T) {
+
+	// init test variable
+	resetUrl := publicBaseUrl + "/password-reset"
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar}
+	postForm := func(pageUrl string, form url.Values) string {
+		resp, err := browser.PostForm(pageUrl, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+		}
+		return string(body)
+	}
+
+	// the login page links to the reset page
+	resp, err := browser.Get(publicBaseUrl + "/authorize?response_type=code&client_id=apigoboot&state=xyz&redirect_uri=http://api.go.boot:4200/authentication/oauth2/code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `href="password-reset"`) {
+		t.Error("Login page does not link to the password reset page")
+	}
+
+	// ask for a link
+	body = []byte(postForm(resetUrl, url.Values{"username": {"unknown@example.dev"}, "csrf_token": {getCsrfToken(t, browser, resetUrl)}}))
+	if !strings.Contains(string(body), "a link to reset its password has been sent") {
+		t.Errorf("Expected %v to be %v, got %v", "page", "telling the link has been sent", string(body))
+	}
+
+	// choose a new password with the token of the link
+	confirmUrl := resetUrl + "?token=reset-token"
+	body = []byte(postForm(confirmUrl, url.Values{"new_password": {"password456"}, "new_password_confirmation": {"password789"}, "csrf_token": {getCsrfToken(t, browser, confirmUrl)}}))
+	if !strings.Contains(string(body), "The passwords do not match") {
+		t.Errorf("Expected %v to be %v, got %v", "page", "refusing different passwords", string(body))
+	}
+	badUrl := resetUrl + "?token=expired-token"
+	body = []byte(postForm(badUrl, url.Values{"new_password": {"password456"}, "new_password_confirmation": {"password456"}, "csrf_token": {getCsrfToken(t, browser, badUrl)}}))
+	if !strings.Contains(string(body), "This link is not valid anymore") {
+		t.Errorf("Expected %v to be %v, got %v", "page", "showing the error of the user service", string(body))
+	}
+	body = []byte(postForm(confirmUrl, url.Values{"new_password": {"password456"}, "new_password_confirmation": {"password456"}, "csrf_token": {getCsrfToken(t, browser, confirmUrl)}}))
+	if !strings.Contains(string(body), "Your password has been changed") {
+		t.Errorf("Expected %v to be %v, got %v", "page", "confirming the new password", string(body))
+	}
+
+	// forms without csrf token are refused
+	resp, err = browser.PostForm(resetUrl, url.Values{"username": {"test00@example.dev"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected %s to be %s, got %s", "status", "403", resp.Status)
+	}
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+
+	// init test variable
+	access := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:      "POST",
+		URL:         publicBaseUrl + "/oauth2/password",
+		ContentType: "application/x-www-form-urlencoded",
+	}, map[string]string{
+		"client_id":     "apigoboot",
+		"client_secret": "apigoboot",
+		"method":        "password",
+		"username":      "test00@example.dev",
+		"password":      "password123",
+	}, &access)
+	resp.Body.Close()
+	if access.AccessToken == "" {
+		t.Fatal("Access token is empty")
+	}
+
+	// revoke every token of the user
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "DELETE",
+		URL:    privateBaseUrl + "/user/1/tokens",
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+
+	// the token does not belong to anybody anymore
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/access-token/" + access.AccessToken + "/get-owner",
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Error("Access token is still valid after the revocation")
+	}
+}
+
+func TestUserData(t *testing.T) {
+
+	// init test variable
+	access := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:      "POST",
+		URL:         publicBaseUrl + "/oauth2/password",
+		ContentType: "application/x-www-form-urlencoded",
+	}, map[string]string{
+		"client_id":     "apigoboot",
+		"client_secret": "apigoboot",
+		"method":        "password",
+		"username":      "test00@example.dev",
+		"password":      "password123",
+	}, &access)
+	resp.Body.Close()
+	if access.AccessToken == "" {
+		t.Fatal("Access token is empty")
+	}
+
+	// test the tokens and the clients of the user are exported, without their secrets
+	var userData rest.ResponseDTOUserData
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/user/1/data",
+	}, nil, &userData)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if len(userData.Tokens) == 0 || userData.Tokens[0].ClientId != "apigoboot" {
+		t.Errorf("Expected %s to be %s, got %v", "tokens", "issued to apigoboot", userData.Tokens)
+	} else if len(userData.Clients) != 1 || userData.Clients[0].ClientId != "apigoboot" || userData.Clients[0].ClientSecret != "" {
+		t.Errorf("Expected %s to be %s, got %v", "clients", "apigoboot without its secret", userData.Clients)
+	}
+
+	// erase the user data
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "DELETE",
+		URL:    privateBaseUrl + "/user/1/data",
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+
+	// test the token does not belong to anybody anymore
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/access-token/" + access.AccessToken + "/get-owner",
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Error("Access token is still valid after the erasure")
+	}
+
+	// test nothing is left about the user and its client is kept for the other users
+	userData = rest.ResponseDTOUserData{}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/user/1/data",
+	}, nil, &userData)
+	resp.Body.Close()
+	if len(userData.Tokens) != 0 || len(userData.Clients) != 0 || len(userData.Sessions) != 0 || len(userData.GrantedApps) != 0 {
+		t.Errorf("Expected %s to be %s, got %v", "user data", "empty", userData)
+	}
+	var client service.Client
+	if err := dbconn.DB.Where("id = ?", "apigoboot").First(&client).Error; err != nil {
+		t.Errorf("Expected %s to be %s, got %s", "client apigoboot", "kept", err)
+	} else if client.UserId != 0 {
+		t.Errorf("Expected %s to be %v, got %v", "client owner", 0, client.UserId)
 	}
 }
@@ -2,6 +2,7 @@
 package oauth2
 
 import (
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
 	"github.com/gin-gonic/gin"
 )
 
@@ -10,6 +11,7 @@ type RestInterface interface {
 	AppAuthorize(c *gin.Context)
 	AppToken(c *gin.Context)
 	AppInfo(c *gin.Context)
+	AppAuthStart(c *gin.Context)
 	AppAuthCode(c *gin.Context)
 	AppAuthToken(c *gin.Context)
 	AppAuthPassword(c *gin.Context)
@@ -18,6 +20,27 @@ type RestInterface interface {
 	AppAuthRefresh(c *gin.Context)
 	AppAuthInfo(c *gin.Context)
 	GetAccessTokenOwnerUserId(c *gin.Context)
+	ValidateAccessToken(c *gin.Context)
+	PostClient(c *gin.Context)
+	GetClients(c *gin.Context)
+	GetClient(c *gin.Context)
+	PutClient(c *gin.Context)
+	DeleteClient(c *gin.Context)
+	PostClientSecret(c *gin.Context)
+	PostOwnClientSecret(c *gin.Context)
+	RegisterClient(c *gin.Context)
+	GetGrantedApps(c *gin.Context)
+	DeleteGrantedApp(c *gin.Context)
+	DeviceAuthorization(c *gin.Context)
+	DevicePage(c *gin.Context)
+	Logout(c *gin.Context)
+	Jwks(c *gin.Context)
+	DeleteUserTokens(c *gin.Context)
+	GetUserData(c *gin.Context)
+	DeleteUserData(c *gin.Context)
+	SecurityHeaders(c *gin.Context)
+	WebauthnOptions(c *gin.Context)
+	PasswordResetPage(c *gin.Context)
 }
 
 // Component implement interface component
@@ -32,10 +55,15 @@ func New(rest RestInterface) *Component {
 
 // AttachPublicAPI link the oauth micro-service with its dependencies to the system
 func (component *Component) AttachPublicAPI(group *gin.RouterGroup) {
-	group.GET("/authorize", component.rest.AppAuthorize)
-	group.POST("/authorize", component.rest.AppAuthorize)
+	group.GET("/authorize", component.rest.SecurityHeaders, component.rest.AppAuthorize)
+	group.POST("/authorize", component.rest.SecurityHeaders, component.rest.AppAuthorize)
+	group.POST("/webauthn/options", component.rest.WebauthnOptions)
 	group.POST("/token", component.rest.AppToken)
 	group.POST("/info", component.rest.AppInfo)
+	group.GET("/logout", component.rest.SecurityHeaders, component.rest.Logout)
+	group.POST("/logout", component.rest.SecurityHeaders, component.rest.Logout)
+	group.GET("/jwks", component.rest.Jwks)
+	group.GET("/oauth2/start", component.rest.AppAuthStart)
 	group.GET("/oauth2/code", component.rest.AppAuthCode)
 	group.GET("/oauth2/token", component.rest.AppAuthToken)
 	group.POST("/oauth2/password", component.rest.AppAuthPassword)
@@ -43,9 +71,27 @@ func (component *Component) AttachPublicAPI(group *gin.RouterGroup) {
 	group.GET("/oauth2/assertion", component.rest.AppAuthAssertion)
 	group.GET("/oauth2/refresh", component.rest.AppAuthRefresh)
 	group.GET("/oauth2/info", component.rest.AppAuthInfo)
+	group.POST("/register", component.rest.RegisterClient)
+	group.POST("/device_authorization", component.rest.DeviceAuthorization)
+	group.GET("/device", component.rest.SecurityHeaders, component.rest.DevicePage)
+	group.POST("/device", component.rest.SecurityHeaders, component.rest.DevicePage)
+	group.GET("/password-reset", component.rest.SecurityHeaders, component.rest.PasswordResetPage)
+	group.POST("/password-reset", component.rest.SecurityHeaders, component.rest.PasswordResetPage)
+	group.POST("/client/secret", component.rest.PostOwnClientSecret)
+	group.GET("/apps", component.rest.ValidateAccessToken, apitool.RequireScopes("user:read"), component.rest.GetGrantedApps)
+	group.DELETE("/apps/:clientId", component.rest.ValidateAccessToken, apitool.RequireScopes("user:write"), component.rest.DeleteGrantedApp)
+	group.POST("/admin/clients", component.rest.ValidateAccessToken, apitool.RequireScopes("admin"), component.rest.PostClient)
+	group.GET("/admin/clients", component.rest.ValidateAccessToken, apitool.RequireScopes("admin"), component.rest.GetClients)
+	group.GET("/admin/clients/:clientId", component.rest.ValidateAccessToken, apitool.RequireScopes("admin"), component.rest.GetClient)
+	group.PUT("/admin/clients/:clientId", component.rest.ValidateAccessToken, apitool.RequireScopes("admin"), component.rest.PutClient)
+	group.DELETE("/admin/clients/:clientId", component.rest.ValidateAccessToken, apitool.RequireScopes("admin"), component.rest.DeleteClient)
+	group.POST("/admin/clients/:clientId/secret", component.rest.ValidateAccessToken, apitool.RequireScopes("admin"), component.rest.PostClientSecret)
 }
 
 // AttachPrivateAPI link the oauth micro-service with its dependencies to the system
 func (component *Component) AttachPrivateAPI(group *gin.RouterGroup) {
 	group.GET("/access-token/:accessToken/get-owner", component.rest.GetAccessTokenOwnerUserId)
+	group.DELETE("/user/:userId/tokens", component.rest.DeleteUserTokens)
+	group.GET("/user/:userId/data", component.rest.GetUserData)
+	group.DELETE("/user/:userId/data", component.rest.DeleteUserData)
 }
@@ -3,6 +3,7 @@ package repo
 
 import (
 	"github.com/RangelReale/osin"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/rest"
 	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/service"
 	"github.com/adriendomoison/apigoboot/oauth2-micro-service/database/dbconn"
 	"github.com/go-errors/errors"
@@ -24,8 +25,16 @@ func (s *Storage) SaveAccess(data *osin.AccessData) (err error) {
 		authorizeData = data.AuthorizeData
 	}
 
-	userId, ok := data.UserData.(uint)
-	if !ok {
+	// delegated tokens also carry the clients acting for the user
+	var userId uint
+	actor := ""
+	switch userData := data.UserData.(type) {
+	case uint:
+		userId = userData
+	case rest.ResponseDTOTokenExchangeGrant:
+		userId = userData.UserId
+		actor = userData.Actor
+	default:
 		return errors.New("cannot assert user_id is uint")
 	}
 
@@ -46,6 +55,7 @@ func (s *Storage) SaveAccess(data *osin.AccessData) (err error) {
 	access.UserId = userId
 	access.Previous = prev
 	access.Authorize = authorizeData.Code
+	access.Actor = actor
 
 	if err := tx.Create(&access).Error; err != nil {
 		if rbe := tx.Rollback(); rbe != nil {
@@ -65,16 +75,27 @@ func (s *Storage) SaveAccess(data *osin.AccessData) (err error) {
 // AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
 // Optionally can return error if expired.
 func (s *Storage) LoadAccess(code string) (*osin.AccessData, error) {
-	var userId uint
+	result, err := s.loadAccess(code)
+	if err != nil {
+		return nil, err
+	}
+	if result.IsExpired() {
+		return nil, errors.Errorf("Token expired at %s.", result.ExpireAt().String())
+	}
+	return result, nil
+}
+
+// loadAccess retrieves access data by token whether it has expired or not, refresh tokens outlive their access token
+func (s *Storage) loadAccess(code string) (*osin.AccessData, error) {
 	var result osin.AccessData
 	var access service.Access
 
-	if err := dbconn.DB.Where("code = ?", code).Find(&access).Error; err != nil {
+	if err := dbconn.DB.Where("access_token = ?", code).First(&access).Error; err != nil {
 		return nil, err
 	}
 
 	copier.Copy(&result, &access)
-	result.UserData = userId
+	result.UserData = access.UserId
 
 	client, err := s.GetClient(access.Client)
 	if err != nil {
@@ -82,8 +103,9 @@ func (s *Storage) LoadAccess(code string) (*osin.AccessData, error) {
 	}
 	result.Client = client
 	result.AuthorizeData, _ = s.LoadAuthorize(access.Authorize)
-	prevAccess, _ := s.LoadAccess(access.Previous)
-	result.AccessData = prevAccess
+	if access.Previous != "" {
+		result.AccessData, _ = s.loadAccess(access.Previous)
+	}
 	return &result, nil
 }
 
@@ -46,6 +46,7 @@ func (s *Storage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
 	// Copy Authorize in OSIN AuthorizeData
 	var data osin.AuthorizeData
 	copier.Copy(&data, &authorize)
+	data.UserData = authorize.UserId
 
 	c, err := s.GetClient(authorize.Client)
 	if err != nil {
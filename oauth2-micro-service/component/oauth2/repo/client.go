@@ -6,9 +6,22 @@ import (
 	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/service"
 	"github.com/adriendomoison/apigoboot/oauth2-micro-service/database/dbconn"
 	"github.com/go-errors/errors"
-	"github.com/jinzhu/copier"
 )
 
+// Make sure the interface is implemented correctly
+var _ osin.ClientSecretMatcher = (*secretMatcherClient)(nil)
+
+// secretMatcherClient is an osin client that never exposes the hashed secrets and compares them through the entity
+type secretMatcherClient struct {
+	osin.DefaultClient
+	entity service.Client
+}
+
+// ClientSecretMatches implements interface "github.com/RangelReale/osin".ClientSecretMatcher
+func (c *secretMatcherClient) ClientSecretMatches(secret string) bool {
+	return c.entity.SecretMatches(secret)
+}
+
 // GetClient loads the client by id
 func (s *Storage) GetClient(id string) (osin.Client, error) {
 	var client service.Client
@@ -16,26 +29,37 @@ func (s *Storage) GetClient(id string) (osin.Client, error) {
 		return nil, err
 	}
 
-	var c osin.DefaultClient
-	copier.Copy(&c, &client)
-	c.UserData = client.UserId
-	return &c, nil
+	return &secretMatcherClient{
+		DefaultClient: osin.DefaultClient{
+			Id:          client.Id,
+			RedirectUri: client.RedirectUri,
+			UserData:    client.UserId,
+		},
+		entity: client,
+	}, nil
 }
 
 // UpdateClient updates the client (identified by it's id) and replaces the values with the values of client.
+// The secret is hashed before being stored and is left untouched when empty
 func (s *Storage) UpdateClient(c osin.Client) error {
 	userId, ok := c.GetUserData().(uint)
 	if !ok {
 		return errors.New("cannot assert user_id is uint")
 	}
 
-	var client service.Client
-	client.Id = c.GetId()
-	client.Secret = c.GetSecret()
-	client.RedirectUri = c.GetRedirectUri()
-	client.UserId = userId
+	fields := map[string]interface{}{
+		"redirect_uri": c.GetRedirectUri(),
+		"user_id":      userId,
+	}
+	if c.GetSecret() != "" {
+		hashedSecret, err := service.HashClientSecret(c.GetSecret())
+		if err != nil {
+			return err
+		}
+		fields["secret"] = hashedSecret
+	}
 
-	return dbconn.DB.Save(&client).Error
+	return dbconn.DB.Model(&service.Client{Id: c.GetId()}).Updates(fields).Error
 }
 
 // CreateClient stores the client in the database and returns an error, if something went wrong.
@@ -47,9 +71,17 @@ func (s *Storage) CreateClient(c osin.Client) error {
 
 	var client service.Client
 	client.Id = c.GetId()
-	client.Secret = c.GetSecret()
 	client.RedirectUri = c.GetRedirectUri()
 	client.UserId = userId
+	if c.GetSecret() != "" {
+		hashedSecret, err := service.HashClientSecret(c.GetSecret())
+		if err != nil {
+			return err
+		}
+		client.Secret = hashedSecret
+	} else {
+		client.Public = true
+	}
 
 	if !dbconn.DB.NewRecord(client) {
 		return errors.New("client already exist")
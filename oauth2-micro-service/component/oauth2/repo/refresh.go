@@ -7,6 +7,7 @@ import (
 	"github.com/adriendomoison/apigoboot/oauth2-micro-service/database/dbconn"
 	"github.com/go-errors/errors"
 	"github.com/jinzhu/gorm"
+	"time"
 )
 
 // LoadRefresh retrieves refresh AccessData. Client information MUST be loaded together.
@@ -14,10 +15,13 @@ import (
 // Optionally can return error if expired.
 func (s *Storage) LoadRefresh(code string) (*osin.AccessData, error) {
 	var refresh service.Refresh
-	if err := dbconn.DB.Where("token = ?", code).Find(&refresh).Error; err != nil {
+	if err := dbconn.DB.Where("token = ?", code).First(&refresh).Error; err != nil {
 		return nil, err
 	}
-	return s.LoadAccess(refresh.Access)
+	if refresh.ExpiresAt().Before(time.Now()) {
+		return nil, errors.Errorf("Refresh token expired at %s.", refresh.ExpiresAt().String())
+	}
+	return s.loadAccess(refresh.Access)
 }
 
 // RemoveRefresh revokes or deletes refresh AccessData.
@@ -4,6 +4,8 @@ package repo
 import (
 	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/service"
 	"github.com/adriendomoison/apigoboot/oauth2-micro-service/database/dbconn"
+	"github.com/go-errors/errors"
+	"time"
 )
 
 // Make sure the interface is implemented correctly
@@ -19,10 +21,271 @@ func New() *repo {
 	return &repo{}
 }
 
-// FindByAccessToken find access in Database by access token
+// FindByAccessToken find access in Database by access token, expired tokens are not returned
 func (r *repo) FindByAccessToken(accessToken string) (at service.Access, err error) {
 	if err := dbconn.DB.Where("access_token = ?", accessToken).First(&at).Error; err != nil {
 		return service.Access{}, err
 	}
+	if at.ExpiresAt().Before(time.Now()) {
+		return service.Access{}, errors.New("access token has expired")
+	}
+	return
+}
+
+// CreateClient create a client in Database
+func (r *repo) CreateClient(client service.Client) error {
+	return dbconn.DB.Create(&client).Error
+}
+
+// FindClientById find client in Database by id
+func (r *repo) FindClientById(id string) (client service.Client, err error) {
+	if err := dbconn.DB.Where("id = ?", id).First(&client).Error; err != nil {
+		return service.Client{}, err
+	}
+	return
+}
+
+// FindAllClients find every client in Database
+func (r *repo) FindAllClients() (clients []service.Client, err error) {
+	if err := dbconn.DB.Order("created_at").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	return
+}
+
+// FindClientsByUserId find the clients owned by a user in Database
+func (r *repo) FindClientsByUserId(userId uint) (clients []service.Client, err error) {
+	if err := dbconn.DB.Where("user_id = ?", userId).Order("created_at").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	return
+}
+
+// UpdateClient edit client in Database
+func (r *repo) UpdateClient(client service.Client) error {
+	return dbconn.DB.Save(&client).Error
+}
+
+// DeleteClient remove client from Database
+func (r *repo) DeleteClient(client service.Client) error {
+	return dbconn.DB.Delete(&client).Error
+}
+
+// FindConsent find the consent given by a user to a client in Database
+func (r *repo) FindConsent(userId uint, clientId string) (consent service.Consent, err error) {
+	if err := dbconn.DB.Where("user_id = ? AND client_id = ?", userId, clientId).First(&consent).Error; err != nil {
+		return service.Consent{}, err
+	}
+	return
+}
+
+// FindConsentsByUserId find every consent given by a user in Database
+func (r *repo) FindConsentsByUserId(userId uint) (consents []service.Consent, err error) {
+	if err := dbconn.DB.Where("user_id = ?", userId).Order("created_at").Find(&consents).Error; err != nil {
+		return nil, err
+	}
 	return
 }
+
+// SaveConsent create or update a consent in Database
+func (r *repo) SaveConsent(consent service.Consent) error {
+	return dbconn.DB.Save(&consent).Error
+}
+
+// DeleteConsent remove consent from Database
+func (r *repo) DeleteConsent(consent service.Consent) error {
+	return dbconn.DB.Unscoped().Delete(&consent).Error
+}
+
+// DeleteTokensByUserIdAndClient remove every authorization code, access and refresh token issued to a client for a user
+func (r *repo) DeleteTokensByUserIdAndClient(userId uint, clientId string) error {
+	tx := dbconn.DB.Begin()
+	accessTokens := tx.Model(&service.Access{}).Where("user_id = ? AND client = ?", userId, clientId).Select("access_token").QueryExpr()
+	if err := tx.Where("access IN (?)", accessTokens).Delete(&service.Refresh{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("user_id = ? AND client = ?", userId, clientId).Delete(&service.Access{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("user_id = ? AND client = ?", userId, clientId).Delete(&service.Authorize{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// DeleteTokensByUserId remove every authorization code, access and refresh token issued to any client for a user
+func (r *repo) DeleteTokensByUserId(userId uint) error {
+	tx := dbconn.DB.Begin()
+	accessTokens := tx.Model(&service.Access{}).Where("user_id = ?", userId).Select("access_token").QueryExpr()
+	if err := tx.Where("access IN (?)", accessTokens).Delete(&service.Refresh{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("user_id = ?", userId).Delete(&service.Access{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("user_id = ?", userId).Delete(&service.Authorize{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// FindAccessesByUserId find the access tokens issued to any client for a user in Database, revoked tokens are not returned
+func (r *repo) FindAccessesByUserId(userId uint) (accesses []service.Access, err error) {
+	if err := dbconn.DB.Where("user_id = ?", userId).Order("created_at").Find(&accesses).Error; err != nil {
+		return nil, err
+	}
+	return
+}
+
+// EraseUserData remove for good every authorization code, token, consent, device authorization and session of a user,
+// the clients owned by the user are kept for their other users but are not linked to the user anymore
+func (r *repo) EraseUserData(userId uint) error {
+	tx := dbconn.DB.Begin()
+	accessTokens := tx.Unscoped().Model(&service.Access{}).Where("user_id = ?", userId).Select("access_token").QueryExpr()
+	if err := tx.Unscoped().Where("access IN (?)", accessTokens).Delete(&service.Refresh{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, model := range []interface{}{&service.Access{}, &service.Authorize{}, &service.Consent{}, &service.DeviceAuthorization{}, &service.Session{}} {
+		if err := tx.Unscoped().Where("user_id = ?", userId).Delete(model).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Model(&service.Client{}).Where("user_id = ?", userId).UpdateColumn("user_id", 0).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// CreateDeviceAuthorization create a device authorization in Database
+func (r *repo) CreateDeviceAuthorization(deviceAuthorization service.DeviceAuthorization) error {
+	return dbconn.DB.Create(&deviceAuthorization).Error
+}
+
+// FindDeviceAuthorizationByDeviceCode find device authorization in Database by device code
+func (r *repo) FindDeviceAuthorizationByDeviceCode(deviceCode string) (deviceAuthorization service.DeviceAuthorization, err error) {
+	if err := dbconn.DB.Where("device_code = ?", deviceCode).First(&deviceAuthorization).Error; err != nil {
+		return service.DeviceAuthorization{}, err
+	}
+	return
+}
+
+// FindDeviceAuthorizationByUserCode find device authorization in Database by user code
+func (r *repo) FindDeviceAuthorizationByUserCode(userCode string) (deviceAuthorization service.DeviceAuthorization, err error) {
+	if err := dbconn.DB.Where("user_code = ?", userCode).First(&deviceAuthorization).Error; err != nil {
+		return service.DeviceAuthorization{}, err
+	}
+	return
+}
+
+// UpdateDeviceAuthorization edit device authorization in Database
+func (r *repo) UpdateDeviceAuthorization(deviceAuthorization service.DeviceAuthorization) error {
+	return dbconn.DB.Save(&deviceAuthorization).Error
+}
+
+// DeleteDeviceAuthorization remove device authorization from Database
+func (r *repo) DeleteDeviceAuthorization(deviceAuthorization service.DeviceAuthorization) error {
+	return dbconn.DB.Unscoped().Delete(&deviceAuthorization).Error
+}
+
+// FindUsedAssertion find used assertion in Database by client and jti
+func (r *repo) FindUsedAssertion(clientId string, jti string) (usedAssertion service.UsedAssertion, err error) {
+	if err := dbconn.DB.Where("client_id = ? AND jti = ?", clientId, jti).First(&usedAssertion).Error; err != nil {
+		return service.UsedAssertion{}, err
+	}
+	return
+}
+
+// CreateUsedAssertion create a used assertion in Database
+func (r *repo) CreateUsedAssertion(usedAssertion service.UsedAssertion) error {
+	return dbconn.DB.Create(&usedAssertion).Error
+}
+
+// CreateSession create a session in Database
+func (r *repo) CreateSession(session service.Session) error {
+	return dbconn.DB.Create(&session).Error
+}
+
+// FindSessionById find session in Database by id
+func (r *repo) FindSessionById(id string) (session service.Session, err error) {
+	if err := dbconn.DB.Where("id = ?", id).First(&session).Error; err != nil {
+		return service.Session{}, err
+	}
+	return
+}
+
+// FindSessionsByUserId find the sessions of a user in Database
+func (r *repo) FindSessionsByUserId(userId uint) (sessions []service.Session, err error) {
+	if err := dbconn.DB.Where("user_id = ?", userId).Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return
+}
+
+// UpdateSession edit session in Database
+func (r *repo) UpdateSession(session service.Session) error {
+	return dbconn.DB.Save(&session).Error
+}
+
+// DeleteSession remove session from Database
+func (r *repo) DeleteSession(session service.Session) error {
+	return dbconn.DB.Delete(&session).Error
+}
+
+// purgeInBatches hard delete the rows matching the query, batchSize rows at a time, and return how many have been removed.
+// key is the primary key of the table
+func purgeInBatches(model interface{}, key string, batchSize int, query string, args ...interface{}) (removed int64, err error) {
+	for {
+		batch := dbconn.DB.Unscoped().Model(model).Select(key).Where(query, args...).Limit(batchSize).QueryExpr()
+		result := dbconn.DB.Unscoped().Where("("+key+") IN (?)", batch).Delete(model)
+		if result.Error != nil {
+			return removed, result.Error
+		}
+		removed += result.RowsAffected
+		if result.RowsAffected < int64(batchSize) {
+			return removed, nil
+		}
+	}
+}
+
+// PurgeAuthorizes remove the expired and revoked authorization codes from Database
+func (r *repo) PurgeAuthorizes(now time.Time, batchSize int) (int64, error) {
+	return purgeInBatches(&service.Authorize{}, "code", batchSize,
+		"deleted_at IS NOT NULL OR created_at + expires_in * interval '1 second' < ?", now)
+}
+
+// PurgeRefreshes remove the expired and revoked refresh tokens from Database
+func (r *repo) PurgeRefreshes(now time.Time, batchSize int) (int64, error) {
+	return purgeInBatches(&service.Refresh{}, "token", batchSize,
+		"deleted_at IS NOT NULL OR created_at < ?", now.Add(-service.RefreshTokenLifetime))
+}
+
+// PurgeAccesses remove the expired and revoked access tokens from Database, unless a refresh token still refers to them
+func (r *repo) PurgeAccesses(now time.Time, batchSize int) (int64, error) {
+	refreshedAccesses := dbconn.DB.Model(&service.Refresh{}).Select("access").QueryExpr()
+	return purgeInBatches(&service.Access{}, "access_token", batchSize,
+		"(deleted_at IS NOT NULL OR created_at + expires_in * interval '1 second' < ?) AND access_token NOT IN (?)", now, refreshedAccesses)
+}
+
+// PurgeDeviceAuthorizations remove the expired device authorizations from Database
+func (r *repo) PurgeDeviceAuthorizations(now time.Time, batchSize int) (int64, error) {
+	return purgeInBatches(&service.DeviceAuthorization{}, "id", batchSize, "expires_at < ?", now)
+}
+
+// PurgeUsedAssertions remove the used assertions from Database once they have expired and can't be replayed anymore
+func (r *repo) PurgeUsedAssertions(now time.Time, batchSize int) (int64, error) {
+	return purgeInBatches(&service.UsedAssertion{}, "client_id, jti", batchSize, "expires_at < ?", now)
+}
+
+// PurgeSessions remove the expired sessions from Database
+func (r *repo) PurgeSessions(now time.Time, batchSize int) (int64, error) {
+	return purgeInBatches(&service.Session{}, "id", batchSize, "expires_at < ?", now)
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/RangelReale/osin"
 	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/service"
 	"github.com/adriendomoison/apigoboot/oauth2-micro-service/database/dbconn"
+	"log"
 )
 
 // Make sure the interface is implemented correctly
@@ -22,9 +23,43 @@ func NewStorage(db *sql.DB) *Storage {
 	dbconn.DB.AutoMigrate(&service.Authorize{})
 	dbconn.DB.AutoMigrate(&service.Access{})
 	dbconn.DB.AutoMigrate(&service.Refresh{})
+	dbconn.DB.AutoMigrate(&service.Consent{})
+	dbconn.DB.AutoMigrate(&service.DeviceAuthorization{})
+	dbconn.DB.AutoMigrate(&service.UsedAssertion{})
+	dbconn.DB.AutoMigrate(&service.Session{})
+	hashPlaintextClientSecrets()
+	migrateClientGrantTypes()
 	return &Storage{db}
 }
 
+// migrateClientGrantTypes give the legacy grant types to the clients registered before the grant types were stored per client
+func migrateClientGrantTypes() {
+	if err := dbconn.DB.Model(&service.Client{}).Where("grant_types = '' OR grant_types IS NULL").
+		Update("grant_types", service.LegacyGrantTypes).Error; err != nil {
+		log.Println("could not set the grant types of the legacy clients:", err)
+	}
+}
+
+// hashPlaintextClientSecrets replace the secrets stored in plaintext by previous versions with their hash
+func hashPlaintextClientSecrets() {
+	var clients []service.Client
+	if err := dbconn.DB.Where("secret <> ''").Find(&clients).Error; err != nil {
+		log.Println("could not load clients to hash their secret:", err)
+		return
+	}
+	for _, client := range clients {
+		if service.IsHashedClientSecret(client.Secret) {
+			continue
+		}
+		hashedSecret, err := service.HashClientSecret(client.Secret)
+		if err != nil {
+			log.Println("could not hash secret of client", client.Id, ":", err)
+			continue
+		}
+		dbconn.DB.Model(&client).Update("secret", hashedSecret)
+	}
+}
+
 // Clone the storage if needed. For example, using mgo, you can clone the session with session.Clone
 // to avoid concurrent access problems.
 // This is to avoid cloning the connection at each method access.
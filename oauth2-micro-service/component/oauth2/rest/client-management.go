@@ -0,0 +1,244 @@
+// Package rest implement the callback required by the oauth2 package
+package rest
+
+import (
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// tokenUserIdKey is the key used to store the id of the user owning the access token of a request in the gin context
+const tokenUserIdKey = "token_user_id"
+
+// RequestDTOClient is the object to map JSON request body of a client creation or edition request
+type RequestDTOClient struct {
+	UserId          uint     `json:"user_id"`
+	Name            string   `json:"name" binding:"required"`
+	RedirectUris    []string `json:"redirect_uris" binding:"required,min=1"`
+	GrantTypes      []string `json:"grant_types" binding:"required,min=1"`
+	Scopes          []string `json:"scopes"`
+	Public          bool     `json:"public"`
+	FirstParty      bool     `json:"first_party"`
+	LogoUri         string   `json:"logo_uri" binding:"omitempty,url"`
+	PrimaryColor    string   `json:"primary_color"`
+	BackgroundColor string   `json:"background_color"`
+	LogoutUri       string   `json:"logout_uri" binding:"omitempty,url"`
+	PublicKeys      []string `json:"public_keys"`
+}
+
+// ResponseDTOClient is the object to map JSON response body of a client, the secret is only set on creation and rotation
+type ResponseDTOClient struct {
+	ClientId                string     `json:"client_id"`
+	ClientSecret            string     `json:"client_secret,omitempty"`
+	ClientSecretExpiresAt   *time.Time `json:"client_secret_expires_at,omitempty"`
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+	UserId                  uint       `json:"user_id"`
+	Name                    string     `json:"name"`
+	RedirectUris            []string   `json:"redirect_uris"`
+	GrantTypes              []string   `json:"grant_types"`
+	Scopes                  []string   `json:"scopes"`
+	Public                  bool       `json:"public"`
+	FirstParty              bool       `json:"first_party"`
+	LogoUri                 string     `json:"logo_uri"`
+	PrimaryColor            string     `json:"primary_color"`
+	BackgroundColor         string     `json:"background_color"`
+	LogoutUri               string     `json:"logout_uri"`
+	PublicKeys              []string   `json:"public_keys"`
+	CreatedAt               time.Time  `json:"created_at"`
+}
+
+// RequestDTOSecretRotation is the object to map the optional JSON request body of a secret rotation, durations are in seconds.
+// When previous_secret_expires_in is omitted the previous secret stays valid for a default overlap, 0 revokes it immediately.
+// When expires_in is omitted the new secret never expires
+type RequestDTOSecretRotation struct {
+	PreviousSecretExpiresIn *int `json:"previous_secret_expires_in" binding:"omitempty,min=0"`
+	ExpiresIn               *int `json:"expires_in" binding:"omitempty,min=1"`
+}
+
+// RequestDTOClientRegistration is the object to map JSON request body of a RFC 7591 dynamic client registration
+type RequestDTOClientRegistration struct {
+	ClientName              string   `json:"client_name"`
+	RedirectUris            []string `json:"redirect_uris"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	Scope                   string   `json:"scope"`
+	LogoUri                 string   `json:"logo_uri"`
+	BackchannelLogoutUri    string   `json:"backchannel_logout_uri"`
+}
+
+// ResponseDTOClientRegistration is the object to map JSON response body of a RFC 7591 dynamic client registration
+type ResponseDTOClientRegistration struct {
+	ClientId                string   `json:"client_id"`
+	ClientSecret            string   `json:"client_secret,omitempty"`
+	ClientIdIssuedAt        int64    `json:"client_id_issued_at"`
+	ClientSecretExpiresAt   int64    `json:"client_secret_expires_at"`
+	ClientName              string   `json:"client_name,omitempty"`
+	RedirectUris            []string `json:"redirect_uris"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	Scope                   string   `json:"scope,omitempty"`
+	LogoUri                 string   `json:"logo_uri,omitempty"`
+	BackchannelLogoutUri    string   `json:"backchannel_logout_uri,omitempty"`
+}
+
+// ValidateAccessToken check the bearer access token and store its owner and scopes for apitool.RequireScopes (middleware)
+func (r *rest) ValidateAccessToken(c *gin.Context) {
+	authorizationCode := c.Request.Header.Get("Authorization")
+
+	if authorizationCode == "" || len(authorizationCode) <= 7 {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	tokenOwner, err := r.service.GetResourceOwnerId(authorizationCode[7:])
+	if err != nil {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	c.Set(tokenUserIdKey, tokenOwner.UserId)
+	apitool.SetTokenScopes(c, tokenOwner.Scopes)
+	c.Next()
+}
+
+// PostClient allows to access the service to register a client
+func (r *rest) PostClient(c *gin.Context) {
+	var reqDTO RequestDTOClient
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.AddClient(reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusCreated, resDTO)
+		}
+	}
+}
+
+// GetClients allows to access the service to list the registered clients
+func (r *rest) GetClients(c *gin.Context) {
+	if resDTOs, err := r.service.RetrieveClients(); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTOs)
+	}
+}
+
+// GetClient allows to access the service to retrieve a client when sending its id
+func (r *rest) GetClient(c *gin.Context) {
+	if resDTO, err := r.service.RetrieveClient(c.Param("clientId")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTO)
+	}
+}
+
+// PutClient allows to access the service to update the metadata of a client
+func (r *rest) PutClient(c *gin.Context) {
+	var reqDTO RequestDTOClient
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.EditClient(c.Param("clientId"), reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
+
+// DeleteClient allows to access the service to remove a client from the records
+func (r *rest) DeleteClient(c *gin.Context) {
+	if err := r.service.RemoveClient(c.Param("clientId")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "client has been deleted successfully"})
+	}
+}
+
+// bindSecretRotation bind the optional JSON body of a secret rotation request
+func bindSecretRotation(c *gin.Context) (reqDTO RequestDTOSecretRotation, err error) {
+	if c.Request.ContentLength == 0 {
+		return
+	}
+	err = c.BindJSON(&reqDTO)
+	return
+}
+
+// PostClientSecret allows to access the service to generate a new secret for a client
+func (r *rest) PostClientSecret(c *gin.Context) {
+	if reqDTO, err := bindSecretRotation(c); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.RotateClientSecret(c.Param("clientId"), reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
+
+// PostOwnClientSecret allows a client authenticated with HTTP basic auth to rotate its own secret
+func (r *rest) PostOwnClientSecret(c *gin.Context) {
+	clientId, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	if err := r.service.AuthenticateClient(clientId, secret); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+		return
+	}
+	reqDTO, err := bindSecretRotation(c)
+	if err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+		return
+	}
+	if resDTO, err := r.service.RotateClientSecret(clientId, reqDTO); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTO)
+	}
+}
+
+// RegisterClient implement the RFC 7591 dynamic client registration endpoint, errors follow the RFC format
+func (r *rest) RegisterClient(c *gin.Context) {
+	if !config.GDynamicClientRegistration {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "invalid_request",
+			"error_description": "dynamic client registration is disabled",
+		})
+		return
+	}
+
+	var reqDTO RequestDTOClientRegistration
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_client_metadata",
+			"error_description": err.Error(),
+		})
+		return
+	}
+
+	resDTO, err := r.service.RegisterClient(reqDTO)
+	if err != nil {
+		errorCode := "invalid_client_metadata"
+		if err.Code == servicehelper.UnexpectedError {
+			errorCode = "server_error"
+		} else if err.Param == "redirect_uris" {
+			errorCode = "invalid_redirect_uri"
+		}
+		c.JSON(int(err.Code), gin.H{
+			"error":             errorCode,
+			"error_description": err.Detail.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, resDTO)
+}
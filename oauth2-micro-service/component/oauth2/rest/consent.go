@@ -0,0 +1,102 @@
+// Package rest implement the callback required by the oauth2 package
+package rest
+
+import (
+	"github.com/RangelReale/osin"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// ResponseDTOScope is the object to map a scope and its description
+type ResponseDTOScope struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ResponseDTOConsentPrompt is the object holding the information displayed on the consent screen
+type ResponseDTOConsentPrompt struct {
+	ClientId   string             `json:"client_id"`
+	ClientName string             `json:"client_name"`
+	LogoUri    string             `json:"logo_uri"`
+	Scopes     []ResponseDTOScope `json:"scopes"`
+}
+
+// ResponseDTOGrantedApp is the object to map JSON response body of a client the user has granted access to
+type ResponseDTOGrantedApp struct {
+	ClientId   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	LogoUri    string    `json:"logo_uri"`
+	Scopes     []string  `json:"scopes"`
+	GrantedAt  time.Time `json:"granted_at"`
+}
+
+// handleConsentPage ask the logged in user to grant the requested scopes to third party clients.
+// It returns the decision of the user, and false as second value when the consent page has been rendered instead
+func handleConsentPage(r *rest, ar *osin.AuthorizeRequest, c *gin.Context, userId uint) (bool, bool) {
+	if !r.service.IsConsentRequired(ar.Client.GetId(), userId, ar.Scope) {
+		return true, true
+	}
+
+	// the decision is only read from a posted form, whose csrf token has been checked with the login page
+	switch c.Request.PostForm.Get("consent") {
+	case "allow":
+		if err := r.service.GrantConsent(userId, ar.Client.GetId(), ar.Scope); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+			return false, false
+		}
+		return true, true
+	case "deny":
+		return false, true
+	}
+
+	prompt, err := r.service.GetConsentPrompt(ar.Client.GetId(), ar.Scope)
+	if err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+		return false, false
+	}
+	data := r.newPageData(c, ar.Client.GetId())
+	data["authorize_url"] = c.Request.URL
+	data["consent"] = true
+	data["scopes"] = prompt.Scopes
+	data["consent_ticket"] = r.service.CreateConsentTicket(r.csrfCookie(c), userId, ar.Client.GetId(), ar.Scope)
+	c.HTML(http.StatusOK, "authentication.tmpl", data)
+	return false, false
+}
+
+// getTokenUserId return the id of the user owning the access token, 0 when the token has been issued to a client only
+func getTokenUserId(c *gin.Context) uint {
+	if userId, ok := c.Get(tokenUserIdKey); ok {
+		return userId.(uint)
+	}
+	return 0
+}
+
+// GetGrantedApps allows to access the service to list the clients the user has granted access to
+func (r *rest) GetGrantedApps(c *gin.Context) {
+	userId := getTokenUserId(c)
+	if userId == 0 {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	if resDTOs, err := r.service.RetrieveGrantedApps(userId); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTOs)
+	}
+}
+
+// DeleteGrantedApp allows to access the service to revoke the access the user granted to a client
+func (r *rest) DeleteGrantedApp(c *gin.Context) {
+	userId := getTokenUserId(c)
+	if userId == 0 {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	if err := r.service.RevokeGrantedApp(userId, c.Param("clientId")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "application access has been revoked successfully"})
+	}
+}
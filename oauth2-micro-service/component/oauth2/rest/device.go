@@ -0,0 +1,162 @@
+// Package rest implement the callback required by the oauth2 package
+package rest
+
+import (
+	"github.com/RangelReale/osin"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// DeviceCodeGrantType is the grant type of the RFC 8628 device authorization grant
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// ResponseDTODeviceAuthorization is the object to map JSON response body of a RFC 8628 device authorization request
+type ResponseDTODeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationUri         string `json:"verification_uri"`
+	VerificationUriComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// ResponseDTODeviceGrant is the object holding the user and scopes approved for a device
+type ResponseDTODeviceGrant struct {
+	UserId uint
+	Scope  string
+}
+
+// scopeNames return the space separated list of the scopes displayed on a prompt
+func scopeNames(prompt ResponseDTOConsentPrompt) string {
+	names := make([]string, 0, len(prompt.Scopes))
+	for _, scope := range prompt.Scopes {
+		names = append(names, scope.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+// DeviceAuthorization implement the RFC 8628 device authorization endpoint, errors follow the RFC format
+func (r *rest) DeviceAuthorization(c *gin.Context) {
+	c.Request.ParseForm()
+
+	client, ok := r.getClient(c.Request)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": osin.E_INVALID_CLIENT, "error_description": "client authentication failed"})
+		return
+	}
+	if !r.service.IsGrantTypeAllowed(client.GetId(), DeviceCodeGrantType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": osin.E_UNAUTHORIZED_CLIENT, "error_description": "the client is not allowed to use the device code grant"})
+		return
+	}
+
+	scope := c.Request.Form.Get("scope")
+	if hasScope(scope, "admin") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": osin.E_INVALID_SCOPE, "error_description": "the admin scope can't be delegated by a user"})
+		return
+	}
+	scope, err := r.service.ResolveScopes(client.GetId(), scope)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": osin.E_INVALID_SCOPE, "error_description": err.Detail.Error()})
+		return
+	}
+
+	if resDTO, err := r.service.StartDeviceAuthorization(client.GetId(), scope); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": osin.E_SERVER_ERROR, "error_description": err.Detail.Error()})
+	} else {
+		c.JSON(http.StatusOK, resDTO)
+	}
+}
+
+// handleDeviceAccessRequest exchange an approved device code for an access token, osin does not support this grant type
+func (r *rest) handleDeviceAccessRequest(resp *osin.Response, c *gin.Context) {
+	client, ok := r.getClient(c.Request)
+	if !ok {
+		resp.SetError(osin.E_INVALID_CLIENT, "")
+		return
+	}
+	if !r.service.IsGrantTypeAllowed(client.GetId(), DeviceCodeGrantType) {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, "")
+		return
+	}
+
+	grant, err := r.service.PollDeviceAuthorization(c.Request.Form.Get("device_code"), client.GetId())
+	if err != nil {
+		resp.SetError(err.Detail.Error(), strings.Replace(err.Detail.Error(), "_", " ", -1))
+		return
+	}
+
+	r.server.FinishAccessRequest(resp, c.Request, &osin.AccessRequest{
+		Type:            DeviceCodeGrantType,
+		Client:          client,
+		Scope:           grant.Scope,
+		UserData:        grant.UserId,
+		Authorized:      true,
+		GenerateRefresh: true,
+		Expiration:      r.server.Config.AccessExpiration,
+		RedirectUri:     osin.FirstUri(client.GetRedirectUri(), r.server.Config.RedirectUriSeparator),
+		HttpRequest:     c.Request,
+	})
+}
+
+// DevicePage is the page where a user logs in and enters the user code displayed by a device to approve it
+func (r *rest) DevicePage(c *gin.Context) {
+	c.Request.ParseForm()
+	userCode := c.Request.Form.Get("user_code")
+	data := r.newPageData(c, "")
+	data["device_url"] = c.Request.URL.Path
+	data["user_code"] = userCode
+	translator := data["t"].(translator)
+
+	if c.Request.Method != "POST" {
+		c.HTML(http.StatusOK, "device.tmpl", data)
+		return
+	}
+	if !r.checkCsrfToken(c) {
+		data["error_message"] = translator.T("error.session_expired")
+		c.HTML(http.StatusForbidden, "device.tmpl", data)
+		return
+	}
+
+	prompt, err := r.service.GetDeviceAuthorizationPrompt(userCode)
+	if err != nil {
+		data["error_message"] = err.Message
+		c.HTML(http.StatusOK, "device.tmpl", data)
+		return
+	}
+	data["branding"] = r.clientBranding(prompt.ClientId)
+
+	// answer to the confirmation
+	if ticket := c.Request.Form.Get("consent_ticket"); ticket != "" {
+		userId, ok := r.service.UseConsentTicket(r.csrfCookie(c), ticket, prompt.ClientId, scopeNames(prompt))
+		if !ok {
+			data["error_message"] = translator.T("error.sign_in_again")
+			c.HTML(http.StatusOK, "device.tmpl", data)
+			return
+		}
+		approved := c.Request.Form.Get("consent") == "allow"
+		if err := r.service.DecideDeviceAuthorization(userCode, userId, approved); err != nil {
+			data["error_message"] = err.Message
+			c.HTML(http.StatusOK, "device.tmpl", data)
+			return
+		}
+		if approved && r.service.IsConsentRequired(prompt.ClientId, userId, scopeNames(prompt)) {
+			r.service.GrantConsent(userId, prompt.ClientId, scopeNames(prompt))
+		}
+		data["done"] = true
+		data["approved"] = approved
+		c.HTML(http.StatusOK, "device.tmpl", data)
+		return
+	}
+
+	// login then ask for confirmation
+	userInfo, ok := r.checkLoginForm(c, data)
+	if !ok {
+		c.HTML(http.StatusOK, "device.tmpl", data)
+		return
+	}
+	data["consent"] = true
+	data["scopes"] = prompt.Scopes
+	data["consent_ticket"] = r.service.CreateConsentTicket(r.csrfCookie(c), userInfo.UserId, prompt.ClientId, scopeNames(prompt))
+	c.HTML(http.StatusOK, "device.tmpl", data)
+}
@@ -8,27 +8,211 @@ import (
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strings"
+	"time"
 )
 
-func handleLoginPage(r *rest, ar *osin.AuthorizeRequest, c *gin.Context) (uint, bool) {
-	var errorStatus = false
-	var errorList []apihelper.ApiError
+// lockoutParam is the param of the errors sent by the user service when a login is refused before the password is checked
+const lockoutParam = "lockout"
+
+// mfaTokenParam is the param of the errors sent by the user service when the login waiting for a second factor has expired
+const mfaTokenParam = "mfa_token"
+
+// emailVerificationParam is the param of the errors sent by the user service when a login is refused until the email address is confirmed
+const emailVerificationParam = "email_verification"
+
+// identityLinkParam is the param of the errors sent by the user service when the first login with an identity provider is refused
+// because an account whose email is not confirmed already uses the email of the identity
+const identityLinkParam = "identity_link"
+
+// identityLinkRequiredError is the error of the password grant when the identity must be linked from the settings of the account first
+const identityLinkRequiredError = "identity_link_required"
+
+// mfaRequiredError is the error of the password grant when the user must also send a TOTP code or a recovery code in the otp parameter
+const mfaRequiredError = "mfa_required"
+
+// handleLoginPage identify the user with its single sign-on session, or ask for its credentials and start a session.
+// It returns false as second value when the login page has been rendered instead, prompt=login always ask for the credentials.
+// Posted forms are refused unless they carry a csrf token bound to the browser. A user refused until its email is confirmed can ask for a new link
+func handleLoginPage(r *rest, ar *osin.AuthorizeRequest, c *gin.Context) (ResponseDTOSession, bool) {
+	c.Request.ParseForm()
+	data := r.newPageData(c, ar.Client.GetId())
+	data["authorize_url"] = c.Request.URL
+	translator := data["t"].(translator)
+	if c.Request.Method == "POST" && !r.checkCsrfToken(c) {
+		data["error_message"] = translator.T("error.session_expired")
+		c.HTML(http.StatusForbidden, "authentication.tmpl", data)
+		return ResponseDTOSession{}, false
+	}
+	if c.Request.Form.Get("prompt") != "login" {
+		if session, ok := r.getSession(c); ok {
+			return session, true
+		}
+	}
+
 	if c.Request.Method == "POST" {
-		c.Request.ParseForm()
-		userInfo, err := r.service.AskUserServiceToCheckCredentials(c.Request.Form.Get("username"), c.Request.Form.Get("password"), "password")
-		if err == nil {
-			return userInfo.UserId, true
-		}
-		errorStatus = true
-		errorList = err.Errors
-	}
-	c.HTML(http.StatusOK, "authentication.tmpl", gin.H{
-		"client_id":     ar.Client.GetId(),
-		"authorize_url": c.Request.URL,
-		"error_status":  errorStatus,
-		"errors":        errorList,
-	})
-	return 0, false
+		if ticket := c.Request.Form.Get("consent_ticket"); ticket != "" {
+			if userId, ok := r.service.UseConsentTicket(r.csrfCookie(c), ticket, ar.Client.GetId(), ar.Scope); ok {
+				return ResponseDTOSession{UserId: userId}, true
+			}
+		}
+		if email := c.Request.Form.Get("resend_verification"); email != "" {
+			if err := r.service.AskUserServiceForVerificationEmail(email); err != nil {
+				data["error_message"] = translator.T("error.try_again_later")
+			} else {
+				data["info_message"] = translator.T("login.verification_sent")
+			}
+			c.HTML(http.StatusOK, "authentication.tmpl", data)
+			return ResponseDTOSession{}, false
+		}
+		if userInfo, ok := r.checkLoginForm(c, data); ok {
+			session, sessionErr := r.service.CreateSession(userInfo.UserId)
+			if sessionErr != nil {
+				return ResponseDTOSession{UserId: userInfo.UserId}, true
+			}
+			setSessionCookie(c, session.Cookie, int(time.Until(session.ExpiresAt).Seconds()))
+			return session, true
+		}
+	}
+	c.HTML(http.StatusOK, "authentication.tmpl", data)
+	return ResponseDTOSession{}, false
+}
+
+// checkLoginForm check the credentials posted by a login form, the second factor when the form carries an mfa_token or the passkey assertion posted by the login page.
+// It returns false after setting the error or the mfa_token of the page to render, the code is asked when an mfa_token is set
+func (r *rest) checkLoginForm(c *gin.Context, data gin.H) (ResponseDTOUserInfo, bool) {
+	translator := data["t"].(translator)
+	mfaToken := c.Request.Form.Get("mfa_token")
+	assertion := c.Request.Form.Get("webauthn_assertion")
+	var userInfo ResponseDTOUserInfo
+	var err *apihelper.ApiErrors
+	if mfaToken != "" {
+		userInfo, err = r.service.AskUserServiceToCheckSecondFactor(mfaToken, c.Request.Form.Get("code"), c.ClientIP())
+	} else if assertion != "" {
+		if !json.Valid([]byte(assertion)) {
+			assertion = "null"
+		}
+		userInfo, err = r.service.AskUserServiceToCheckWebauthnAssertion(json.RawMessage(assertion), c.ClientIP())
+	} else {
+		userInfo, err = r.service.AskUserServiceToCheckCredentials(c.Request.Form.Get("username"), c.Request.Form.Get("password"), "password", c.ClientIP())
+	}
+	if err != nil {
+		data["error_status"] = true
+		data["errors"] = err.Errors
+		if assertion != "" {
+			data["error_message"] = loginErrorMessage(translator, err, "error.invalid_passkey")
+		} else if mfaToken == "" && hasErrorParam(err, emailVerificationParam) {
+			data["error_message"] = translator.T("error.email_not_verified")
+			data["resend_verification"] = c.Request.Form.Get("username")
+		} else if mfaToken == "" {
+			data["error_message"] = loginErrorMessage(translator, err, "error.invalid_credentials")
+		} else if hasErrorParam(err, mfaTokenParam) {
+			data["error_message"] = translator.T("error.sign_in_again")
+		} else {
+			data["mfa_token"] = mfaToken
+			data["error_message"] = loginErrorMessage(translator, err, "error.invalid_code")
+		}
+		return ResponseDTOUserInfo{}, false
+	}
+	if userInfo.MfaRequired {
+		data["mfa_token"] = userInfo.MfaToken
+		return ResponseDTOUserInfo{}, false
+	}
+	return userInfo, true
+}
+
+// hasErrorParam check if one of the errors sent by another micro service is about param
+func hasErrorParam(apiErr *apihelper.ApiErrors, param string) bool {
+	for _, e := range apiErr.Errors {
+		if e, ok := e.(map[string]interface{}); ok && e["param"] == param {
+			return true
+		}
+	}
+	return false
+}
+
+// loginErrorMessage return the message to show on a login page after a refused login, or the translation of defaultKey.
+// The user service explains the refusal when the account or the network is locked after too many failed logins
+func loginErrorMessage(translator translator, apiErr *apihelper.ApiErrors, defaultKey string) string {
+	for _, e := range apiErr.Errors {
+		if e, ok := e.(map[string]interface{}); ok && e["param"] == lockoutParam {
+			if message, ok := e["message"].(string); ok && message != "" {
+				return message
+			}
+		}
+	}
+	return translator.T(defaultKey)
+}
+
+// hasScope check if the scope is part of the space separated list of scopes
+func hasScope(scopes string, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAuthorizeRequest check that the client has been registered with the grant type matching the response type
+// and resolve the scopes to grant. The admin scope is never delegated by a user.
+// It returns the osin error code of the refusal or an empty string
+func (r *rest) validateAuthorizeRequest(ar *osin.AuthorizeRequest) string {
+	grantType := "authorization_code"
+	if ar.Type == osin.TOKEN {
+		grantType = "implicit"
+	}
+	if !r.service.IsGrantTypeAllowed(ar.Client.GetId(), grantType) {
+		return osin.E_UNAUTHORIZED_CLIENT
+	}
+	if hasScope(ar.Scope, "admin") {
+		return osin.E_INVALID_SCOPE
+	}
+	scope, err := r.service.ResolveScopes(ar.Client.GetId(), ar.Scope)
+	if err != nil {
+		return osin.E_INVALID_SCOPE
+	}
+	ar.Scope = scope
+	return ""
+}
+
+// validateAccessRequest check that the client has been registered with the grant type and resolve the scopes to grant.
+// Scopes of authorization codes and refresh tokens have been resolved when they were issued.
+// The admin scope can only be obtained with the client credentials grant by clients registered with it.
+// It returns the osin error code of the refusal or an empty string
+func (r *rest) validateAccessRequest(ar *osin.AccessRequest) string {
+	if !r.service.IsGrantTypeAllowed(ar.Client.GetId(), string(ar.Type)) {
+		return osin.E_UNAUTHORIZED_CLIENT
+	}
+	if ar.Type == osin.AUTHORIZATION_CODE || ar.Type == osin.REFRESH_TOKEN {
+		return ""
+	}
+	if ar.Type != osin.CLIENT_CREDENTIALS && hasScope(ar.Scope, "admin") {
+		return osin.E_INVALID_SCOPE
+	}
+	scope, err := r.service.ResolveScopes(ar.Client.GetId(), ar.Scope)
+	if err != nil {
+		return osin.E_INVALID_SCOPE
+	}
+	ar.Scope = scope
+	return ""
+}
+
+// getClient authenticate the client of a request osin does not handle, with HTTP basic auth or the client_id and client_secret parameters.
+// Public clients only send their client_id
+func (r *rest) getClient(req *http.Request) (osin.Client, bool) {
+	auth, err := osin.CheckBasicAuth(req)
+	if err != nil {
+		return nil, false
+	}
+	if auth == nil {
+		auth = &osin.BasicAuth{Username: req.Form.Get("client_id"), Password: req.Form.Get("client_secret")}
+	}
+	client, err := r.server.Storage.GetClient(auth.Username)
+	if err != nil || !osin.CheckClientSecret(client, auth.Password) {
+		return nil, false
+	}
+	return client, true
 }
 
 func downloadAccessToken(url string, auth *osin.BasicAuth, output map[string]interface{}) error {
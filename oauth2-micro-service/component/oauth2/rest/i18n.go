@@ -0,0 +1,116 @@
+// Package rest implement the callback required by the oauth2 package
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is the language of the pages when the browser does not accept any translated language
+const defaultLanguage = "en"
+
+// translations hold the messages of the pages by language then by key, loaded from the embedded statics/locales/<language>.json files
+var translations = loadTranslations()
+
+// loadTranslations read the embedded translation bundles
+func loadTranslations() map[string]map[string]string {
+	files, err := statics.ReadDir("statics/locales")
+	if err != nil {
+		panic(err)
+	}
+	bundles := map[string]map[string]string{}
+	for _, file := range files {
+		content, err := statics.ReadFile("statics/locales/" + file.Name())
+		if err != nil {
+			panic(err)
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(content, &messages); err != nil {
+			panic("invalid translation bundle " + file.Name() + ": " + err.Error())
+		}
+		bundles[strings.TrimSuffix(file.Name(), path.Ext(file.Name()))] = messages
+	}
+	return bundles
+}
+
+// translator translate the messages of a page in the language chosen for the browser
+type translator struct {
+	Lang     string
+	messages map[string]string
+}
+
+// newTranslator choose the translation bundle matching best the Accept-Language header of the browser
+func newTranslator(acceptLanguage string) translator {
+	for _, lang := range acceptedLanguages(acceptLanguage) {
+		if messages, ok := translations[lang]; ok {
+			return translator{lang, messages}
+		}
+		if primary := strings.SplitN(lang, "-", 2)[0]; primary != lang {
+			if messages, ok := translations[primary]; ok {
+				return translator{primary, messages}
+			}
+		}
+	}
+	return translator{defaultLanguage, translations[defaultLanguage]}
+}
+
+// acceptedLanguages return the lower case languages of an Accept-Language header, sorted by decreasing quality
+func acceptedLanguages(acceptLanguage string) []string {
+	type weightedLanguage struct {
+		lang    string
+		quality float64
+	}
+	var weighted []weightedLanguage
+	for _, item := range strings.Split(acceptLanguage, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ";")
+		lang := strings.ToLower(strings.TrimSpace(parts[0]))
+		if lang == "" || lang == "*" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range parts[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			weighted = append(weighted, weightedLanguage{lang, quality})
+		}
+	}
+	sort.SliceStable(weighted, func(i, j int) bool { return weighted[i].quality > weighted[j].quality })
+
+	languages := make([]string, 0, len(weighted))
+	for _, w := range weighted {
+		languages = append(languages, w.lang)
+	}
+	return languages
+}
+
+// T return the message of a key in the chosen language, or in the default language if it has not been translated.
+// The message is formatted with args like fmt.Sprintf
+func (t translator) T(key string, args ...interface{}) string {
+	message, ok := t.messages[key]
+	if !ok {
+		if message, ok = translations[defaultLanguage][key]; !ok {
+			return key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// Scope return the description of a scope in the chosen language, or its registered description if it has not been translated
+func (t translator) Scope(name string, description string) string {
+	if message, ok := t.messages["scope."+name]; ok {
+		return message
+	}
+	return description
+}
@@ -0,0 +1,62 @@
+// Package rest implement the callback required by the oauth2 package
+package rest
+
+import (
+	"github.com/RangelReale/osin"
+	"github.com/gin-gonic/gin"
+)
+
+// JwtBearerGrantType is the grant type of the RFC 7523 JWT bearer assertion grant
+const JwtBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// ResponseDTOAssertionGrant is the object holding the client and user a JWT assertion has been issued for
+type ResponseDTOAssertionGrant struct {
+	ClientId string
+	UserId   uint
+}
+
+// handleJwtBearerAccessRequest exchange a JWT assertion signed by a client for an access token, osin does not support this grant type.
+// The assertion authenticates the client, if client credentials are sent anyway they must match the issuer
+func (r *rest) handleJwtBearerAccessRequest(resp *osin.Response, c *gin.Context) {
+	grant, err := r.service.VerifyJwtBearerAssertion(c.Request.Form.Get("assertion"))
+	if err != nil {
+		resp.SetError(osin.E_INVALID_GRANT, "")
+		resp.InternalError = err.Detail
+		return
+	}
+
+	if auth, _ := osin.CheckBasicAuth(c.Request); (auth != nil && auth.Username != grant.ClientId) ||
+		(c.Request.Form.Get("client_id") != "" && c.Request.Form.Get("client_id") != grant.ClientId) {
+		resp.SetError(osin.E_INVALID_CLIENT, "")
+		return
+	}
+
+	client, clientErr := r.server.Storage.GetClient(grant.ClientId)
+	if clientErr != nil || !r.service.IsGrantTypeAllowed(grant.ClientId, JwtBearerGrantType) {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, "")
+		return
+	}
+
+	scope := c.Request.Form.Get("scope")
+	if grant.UserId != 0 && hasScope(scope, "admin") {
+		resp.SetError(osin.E_INVALID_SCOPE, "")
+		return
+	}
+	scope, err = r.service.ResolveScopes(grant.ClientId, scope)
+	if err != nil {
+		resp.SetError(osin.E_INVALID_SCOPE, "")
+		return
+	}
+
+	r.server.FinishAccessRequest(resp, c.Request, &osin.AccessRequest{
+		Type:            JwtBearerGrantType,
+		Client:          client,
+		Scope:           scope,
+		UserData:        grant.UserId,
+		Authorized:      true,
+		GenerateRefresh: false,
+		Expiration:      r.server.Config.AccessExpiration,
+		RedirectUri:     osin.FirstUri(client.GetRedirectUri(), r.server.Config.RedirectUriSeparator),
+		HttpRequest:     c.Request,
+	})
+}
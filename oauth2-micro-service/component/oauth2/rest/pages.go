@@ -0,0 +1,79 @@
+// Package rest implement the callback required by the oauth2 package
+package rest
+
+import (
+	"embed"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/config"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"io/fs"
+	"net/http"
+)
+
+// statics hold the templates, styles and translations of the pages, so the binary does not depend on the working directory
+//
+//go:embed statics
+var statics embed.FS
+
+// defaultPrimaryColor and defaultBackgroundColor are used when a client has not chosen its colors
+const (
+	defaultPrimaryColor    = "#424242"
+	defaultBackgroundColor = "#e9ebee"
+)
+
+// ResponseDTOClientBranding is the object holding the name, logo and colors displayed on the pages shown to the users of a client
+type ResponseDTOClientBranding struct {
+	ClientId        string
+	DisplayName     string
+	LogoUri         string
+	PrimaryColor    string
+	BackgroundColor string
+}
+
+// LoadPages load the embedded templates of the login, consent, device, password reset and logout pages and serve their styles and scripts
+func LoadPages(router *gin.Engine) {
+	router.SetHTMLTemplate(template.Must(template.ParseFS(statics, "statics/templates/*.tmpl")))
+	for _, dir := range []string{"styles", "scripts"} {
+		files, err := fs.Sub(statics, "statics/"+dir)
+		if err != nil {
+			panic(err)
+		}
+		router.StaticFS("authentication/"+dir, http.FS(files))
+	}
+}
+
+// clientBranding return the branding of a client, completed with the default colors
+func (r *rest) clientBranding(clientId string) ResponseDTOClientBranding {
+	branding, err := r.service.GetClientBranding(clientId)
+	if err != nil {
+		branding = ResponseDTOClientBranding{ClientId: clientId, DisplayName: clientId}
+	}
+	if branding.DisplayName == "" {
+		branding.DisplayName = clientId
+	}
+	if branding.PrimaryColor == "" {
+		branding.PrimaryColor = defaultPrimaryColor
+	}
+	if branding.BackgroundColor == "" {
+		branding.BackgroundColor = defaultBackgroundColor
+	}
+	return branding
+}
+
+// newPageData return the data shared by the pages: the translations matching the languages of the browser,
+// the csrf token of the forms, the nonce of the inline style and the branding of the client, if already known
+func (r *rest) newPageData(c *gin.Context, clientId string) gin.H {
+	translator := newTranslator(c.GetHeader("Accept-Language"))
+	branding := ResponseDTOClientBranding{PrimaryColor: defaultPrimaryColor, BackgroundColor: defaultBackgroundColor}
+	if clientId != "" {
+		branding = r.clientBranding(clientId)
+	}
+	return gin.H{
+		"t":          translator,
+		"lang":       translator.Lang,
+		"app_name":   config.GAppName,
+		"branding":   branding,
+		"csrf_token": r.csrfToken(c),
+		"csp_nonce":  c.GetString(cspNonceKey),
+	}
+}
@@ -0,0 +1,58 @@
+// Package rest implement the callback required by the oauth2 package
+package rest
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// RequestDTOPasswordReset is the object to map JSON request body sent to the user service to ask for a link resetting a password
+type RequestDTOPasswordReset struct {
+	Email string `json:"email"`
+}
+
+// RequestDTOConfirmPasswordReset is the object to map JSON request body sent to the user service to choose a new password with the token of a reset link
+type RequestDTOConfirmPasswordReset struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// PasswordResetPage is the page where a user who forgot its password asks for a reset link, then chooses a new password with the token of the link
+func (r *rest) PasswordResetPage(c *gin.Context) {
+	c.Request.ParseForm()
+	token := c.Request.Form.Get("token")
+	data := r.newPageData(c, "")
+	data["reset_url"] = c.Request.URL.Path
+	data["token"] = token
+	translator := data["t"].(translator)
+
+	if c.Request.Method != "POST" {
+		c.HTML(http.StatusOK, "password-reset.tmpl", data)
+		return
+	}
+	if !r.checkCsrfToken(c) {
+		data["error_message"] = translator.T("error.session_expired")
+		c.HTML(http.StatusForbidden, "password-reset.tmpl", data)
+		return
+	}
+
+	if token == "" {
+		if err := r.service.AskUserServiceForPasswordReset(c.Request.Form.Get("username")); err != nil {
+			data["error_message"] = translator.T("error.try_again_later")
+		} else {
+			data["sent"] = true
+		}
+		c.HTML(http.StatusOK, "password-reset.tmpl", data)
+		return
+	}
+
+	newPassword := c.Request.Form.Get("new_password")
+	if newPassword != c.Request.Form.Get("new_password_confirmation") {
+		data["error_message"] = translator.T("error.password_mismatch")
+	} else if err := r.service.AskUserServiceToResetPassword(token, newPassword); err != nil {
+		data["error_message"] = err.Message
+	} else {
+		data["done"] = true
+	}
+	c.HTML(http.StatusOK, "password-reset.tmpl", data)
+}
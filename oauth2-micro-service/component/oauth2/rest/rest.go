@@ -2,6 +2,7 @@
 package rest
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/RangelReale/osin"
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
@@ -16,8 +17,49 @@ import (
 
 // ServiceInterface is the model for the service package of oauth2
 type ServiceInterface interface {
-	AskUserServiceToCheckCredentials(username string, password string, method string) (ResponseDTOUserInfo, *apihelper.ApiErrors)
+	AskUserServiceToCheckCredentials(username string, password string, method string, ip string) (ResponseDTOUserInfo, *apihelper.ApiErrors)
+	AskUserServiceToCheckSecondFactor(mfaToken string, code string, ip string) (ResponseDTOUserInfo, *apihelper.ApiErrors)
+	AskUserServiceToCheckWebauthnAssertion(assertion json.RawMessage, ip string) (ResponseDTOUserInfo, *apihelper.ApiErrors)
+	AskUserServiceForWebauthnOptions(username string) (json.RawMessage, *servicehelper.Error)
+	AskUserServiceForVerificationEmail(email string) *servicehelper.Error
+	AskUserServiceForPasswordReset(email string) *servicehelper.Error
+	AskUserServiceToResetPassword(token string, newPassword string) *servicehelper.Error
 	GetResourceOwnerId(token string) (ResponseDTOUserInfo, *servicehelper.Error)
+	AddClient(reqDTO RequestDTOClient) (ResponseDTOClient, *servicehelper.Error)
+	RetrieveClient(clientId string) (ResponseDTOClient, *servicehelper.Error)
+	RetrieveClients() ([]ResponseDTOClient, *servicehelper.Error)
+	EditClient(clientId string, reqDTO RequestDTOClient) (ResponseDTOClient, *servicehelper.Error)
+	RemoveClient(clientId string) *servicehelper.Error
+	RotateClientSecret(clientId string, reqDTO RequestDTOSecretRotation) (ResponseDTOClient, *servicehelper.Error)
+	AuthenticateClient(clientId string, secret string) *servicehelper.Error
+	RegisterClient(reqDTO RequestDTOClientRegistration) (ResponseDTOClientRegistration, *servicehelper.Error)
+	IsGrantTypeAllowed(clientId string, grantType string) bool
+	ResolveScopes(clientId string, requestedScope string) (string, *servicehelper.Error)
+	IsConsentRequired(clientId string, userId uint, scope string) bool
+	GetConsentPrompt(clientId string, scope string) (ResponseDTOConsentPrompt, *servicehelper.Error)
+	CreateConsentTicket(csrfCookie string, userId uint, clientId string, scope string) string
+	UseConsentTicket(csrfCookie string, ticket string, clientId string, scope string) (uint, bool)
+	GrantConsent(userId uint, clientId string, scope string) *servicehelper.Error
+	RetrieveGrantedApps(userId uint) ([]ResponseDTOGrantedApp, *servicehelper.Error)
+	RevokeGrantedApp(userId uint, clientId string) *servicehelper.Error
+	StartDeviceAuthorization(clientId string, scope string) (ResponseDTODeviceAuthorization, *servicehelper.Error)
+	GetDeviceAuthorizationPrompt(userCode string) (ResponseDTOConsentPrompt, *servicehelper.Error)
+	DecideDeviceAuthorization(userCode string, userId uint, approved bool) *servicehelper.Error
+	PollDeviceAuthorization(deviceCode string, clientId string) (ResponseDTODeviceGrant, *servicehelper.Error)
+	VerifyJwtBearerAssertion(assertion string) (ResponseDTOAssertionGrant, *servicehelper.Error)
+	ExchangeToken(subjectToken string, clientId string, requestedScope string) (ResponseDTOTokenExchangeGrant, *servicehelper.Error)
+	CreateSession(userId uint) (ResponseDTOSession, *servicehelper.Error)
+	GetSession(cookie string) (ResponseDTOSession, *servicehelper.Error)
+	AddClientToSession(sessionId string, clientId string) *servicehelper.Error
+	EndSession(cookie string) *servicehelper.Error
+	GetSigningKeys() ResponseDTOJwks
+	RevokeUserTokens(userId uint) *servicehelper.Error
+	RetrieveUserData(userId uint) (ResponseDTOUserData, *servicehelper.Error)
+	EraseUserData(userId uint) *servicehelper.Error
+	CreateCsrfCookie() (string, *servicehelper.Error)
+	CreateCsrfToken(cookie string) string
+	CheckCsrfToken(cookie string, token string) bool
+	GetClientBranding(clientId string) (ResponseDTOClientBranding, *servicehelper.Error)
 }
 
 type rest struct {
@@ -32,19 +74,39 @@ type clientCredential struct {
 	Password     string `json:"password"`
 	ClientId     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
+	Scope        string `json:"scope"`
+	Assertion    string `json:"assertion"`
 }
 
 // RequestDTOUserCredentials is the object to map JSON request body of a login request
 type RequestDTOUserCredentials struct {
-	Method   string `json:"method"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	AuthType string          `json:"auth_type"`
+	Username string          `json:"username"`
+	Password string          `json:"password"`
+	Ip       string          `json:"ip"`
+	MfaToken string          `json:"mfa_token,omitempty"`
+	Code     string          `json:"code,omitempty"`
+	Webauthn json.RawMessage `json:"webauthn,omitempty"`
+}
+
+// RequestDTOVerificationEmail is the object to map JSON request body sent to the user service to ask for a new link confirming an email address
+type RequestDTOVerificationEmail struct {
+	Email string `json:"email"`
 }
 
 // ResponseDTOUserInfo is the object to map JSON response body of a request to get user basic info
 type ResponseDTOUserInfo struct {
-	UserId uint   `json:"user_id"`
-	Email  string `json:"email"`
+	UserId uint              `json:"user_id"`
+	Email  string            `json:"email"`
+	Scopes []string          `json:"scopes,omitempty"`
+	Actor  *ResponseDTOActor `json:"act,omitempty"`
+	// Status is the status of the account, Roles are the roles of the user and Permissions the permissions they grant, read from the user service
+	Status      string   `json:"status,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// MfaRequired is set by the user service instead of the user info when the login must be completed with a second factor
+	MfaRequired bool   `json:"mfa_required,omitempty"`
+	MfaToken    string `json:"mfa_token,omitempty"`
 }
 
 // New return a new rest instance
@@ -57,13 +119,30 @@ func (r *rest) AppAuthorize(c *gin.Context) {
 	resp := r.server.NewResponse()
 	defer resp.Close()
 	if ar := r.server.HandleAuthorizeRequest(resp, c.Request); ar != nil {
-		userId, ok := handleLoginPage(r, ar, c)
-		if !ok {
-			return
+		if !r.isRegisteredRedirectUri(ar.Client.GetRedirectUri(), ar.RedirectUri) {
+			// never redirect the user to an unregistered uri, even to report the error
+			resp.SetError(osin.E_INVALID_REQUEST, "redirect_uri must exactly match a registered redirect uri")
+			resp.Type = osin.DATA
+		} else if ar.State == "" {
+			resp.SetError(osin.E_INVALID_REQUEST, "state is required")
+		} else if errorCode := r.validateAuthorizeRequest(ar); errorCode != "" {
+			resp.SetErrorState(errorCode, "", ar.State)
+		} else {
+			session, ok := handleLoginPage(r, ar, c)
+			if !ok {
+				return
+			}
+			consented, ok := handleConsentPage(r, ar, c, session.UserId)
+			if !ok {
+				return
+			}
+			ar.UserData = session.UserId
+			ar.Authorized = consented
+			if consented && session.Id != "" {
+				r.service.AddClientToSession(session.Id, ar.Client.GetId())
+			}
+			r.server.FinishAuthorizeRequest(resp, c.Request, ar)
 		}
-		ar.UserData = userId
-		ar.Authorized = true
-		r.server.FinishAuthorizeRequest(resp, c.Request, ar)
 	}
 	if resp.IsError && resp.InternalError != nil {
 		log.Printf("ERROR: %s\n", resp.InternalError)
@@ -78,26 +157,15 @@ func (r *rest) AppAuthorize(c *gin.Context) {
 func (r *rest) AppToken(c *gin.Context) {
 	resp := r.server.NewResponse()
 	defer resp.Close()
-	if ar := r.server.HandleAccessRequest(resp, c.Request); ar != nil {
-		ar.UserData = uint(0)
-		switch ar.Type {
-		case osin.AUTHORIZATION_CODE:
-			ar.Authorized = true
-		case osin.REFRESH_TOKEN:
-			ar.Authorized = true
-		case osin.PASSWORD:
-			if userInfo, err := r.service.AskUserServiceToCheckCredentials(ar.Username, ar.Password, c.Query("method")); err == nil {
-				ar.Authorized = true
-				ar.UserData = userInfo.UserId
-			}
-		case osin.CLIENT_CREDENTIALS:
-			ar.Authorized = true
-		case osin.ASSERTION:
-			if ar.AssertionType == "urn:osin.example.complete" && ar.Assertion == "osin.data" {
-				ar.Authorized = true
-			}
-		}
-		r.server.FinishAccessRequest(resp, c.Request, ar)
+	switch c.Request.FormValue("grant_type") {
+	case DeviceCodeGrantType:
+		r.handleDeviceAccessRequest(resp, c)
+	case JwtBearerGrantType:
+		r.handleJwtBearerAccessRequest(resp, c)
+	case TokenExchangeGrantType:
+		r.handleTokenExchangeAccessRequest(resp, c)
+	default:
+		r.handleAccessRequest(resp, c)
 	}
 	if resp.IsError && resp.InternalError != nil {
 		log.Printf("ERROR: %s\n", resp.InternalError)
@@ -108,6 +176,48 @@ func (r *rest) AppToken(c *gin.Context) {
 	osin.OutputJSON(resp, c.Writer, c.Request)
 }
 
+// handleAccessRequest handle the grant types supported by osin
+func (r *rest) handleAccessRequest(resp *osin.Response, c *gin.Context) {
+	if ar := r.server.HandleAccessRequest(resp, c.Request); ar != nil {
+		// authorization codes and refresh tokens carry the user they have been issued for
+		if _, ok := ar.UserData.(uint); !ok {
+			ar.UserData = uint(0)
+		}
+		if errorCode := r.validateAccessRequest(ar); errorCode != "" {
+			resp.SetError(errorCode, "")
+		} else {
+			switch ar.Type {
+			case osin.AUTHORIZATION_CODE:
+				ar.Authorized = true
+			case osin.REFRESH_TOKEN:
+				ar.Authorized = true
+			case osin.PASSWORD:
+				userInfo, err := r.service.AskUserServiceToCheckCredentials(ar.Username, ar.Password, c.Query("method"), c.ClientIP())
+				if err != nil && hasErrorParam(err, identityLinkParam) {
+					resp.SetError(identityLinkRequiredError, "sign in with the password of the account using this email and link the identity from its settings")
+					return
+				}
+				if err == nil && userInfo.MfaRequired {
+					// the second factor is sent in the otp parameter, usually after a first request refused with mfa_required
+					otp := c.Request.Form.Get("otp")
+					if otp == "" {
+						resp.SetError(mfaRequiredError, "a TOTP code or a recovery code is required in the otp parameter")
+						return
+					}
+					userInfo, err = r.service.AskUserServiceToCheckSecondFactor(userInfo.MfaToken, otp, c.ClientIP())
+				}
+				if err == nil {
+					ar.Authorized = true
+					ar.UserData = userInfo.UserId
+				}
+			case osin.CLIENT_CREDENTIALS:
+				ar.Authorized = true
+			}
+			r.server.FinishAccessRequest(resp, c.Request, ar)
+		}
+	}
+}
+
 // Information endpoint
 func (r *rest) AppInfo(c *gin.Context) {
 	resp := r.server.NewResponse()
@@ -119,6 +229,23 @@ func (r *rest) AppInfo(c *gin.Context) {
 	osin.OutputJSON(resp, c.Writer, c.Request)
 }
 
+// Application start - CODE, redirect the browser to the authorization endpoint with a new state bound to it
+func (r *rest) AppAuthStart(c *gin.Context) {
+	state := newAuthorizationState(c)
+	if state == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "could not start the authorization request"})
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf(
+		"%s/authentication/authorize?response_type=code&client_id=%s&scope=%s&state=%s&redirect_uri=%s",
+		config.GAppUrl,
+		url.QueryEscape(c.Query("client_id")),
+		url.QueryEscape(c.Query("scope")),
+		url.QueryEscape(state),
+		url.QueryEscape(fmt.Sprintf("%s/authentication/oauth2/code", config.GAppUrl)),
+	))
+}
+
 // Application destination - CODE
 func (r *rest) AppAuthCode(c *gin.Context) {
 
@@ -134,11 +261,17 @@ func (r *rest) AppAuthCode(c *gin.Context) {
 		return
 	}
 
+	// the state sent back by the authorization server must be the one bound to the browser when the request started
+	if !checkAuthorizationState(c) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "state does not match the authorization request"})
+		return
+	}
+
 	jr := make(map[string]interface{})
 
 	// build access code url
 	authURL := fmt.Sprintf(
-		"%s/authentication/token?grant_type=authorization_code&client_id=%s&client_secret=%s&state=xyz&redirect_uri=%s&code=%s",
+		"%s/authentication/token?grant_type=authorization_code&client_id=%s&client_secret=%s&redirect_uri=%s&code=%s",
 		config.GAppUrl,
 		cc.ClientId,
 		cc.ClientSecret,
@@ -202,8 +335,8 @@ func (r *rest) AppAuthPassword(c *gin.Context) {
 
 	// build authentication URL
 	authURL := fmt.Sprintf(
-		"%s/authentication/token?grant_type=password&scope=everything&username=%s&password=%s&method=%s",
-		config.GAppUrl, cc.Username, cc.Password, cc.Method,
+		"%s/authentication/token?grant_type=password&scope=%s&username=%s&password=%s&method=%s",
+		config.GAppUrl, url.QueryEscape(cc.Scope), cc.Username, cc.Password, cc.Method,
 	)
 
 	// build App credentials
@@ -280,17 +413,14 @@ func (r *rest) AppAuthAssertion(c *gin.Context) {
 
 	jr := make(map[string]interface{})
 
-	// build access code url
+	// build access code url, the assertion authenticates the client
 	authURL := fmt.Sprintf(
-		"%s/authentication/token?grant_type=assertion&assertion_type=urn:osin.example.complete&assertion=osin.data",
-		config.GAppUrl,
+		"%s/authentication/token?grant_type=%s&assertion=%s&scope=%s",
+		config.GAppUrl, url.QueryEscape(JwtBearerGrantType), url.QueryEscape(cc.Assertion), url.QueryEscape(cc.Scope),
 	)
 
-	// build app credentials
-	auth := osin.BasicAuth{Username: cc.ClientId, Password: cc.ClientSecret}
-
 	// download token
-	err := downloadAccessToken(authURL, &auth, jr)
+	err := downloadAccessToken(authURL, nil, jr)
 	if err != nil {
 		c.JSON(apihelper.BuildRequestError(err))
 		return
@@ -0,0 +1,140 @@
+// Package rest implement the callback required by the oauth2 package
+package rest
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// csrfCookieName is the name of the cookie the csrf tokens of the forms are bound to
+const csrfCookieName = "apigoboot_csrf"
+
+// csrfFieldName is the name of the hidden form field holding the csrf token
+const csrfFieldName = "csrf_token"
+
+// stateCookieName is the name of the cookie the state of an authorization request started by the application is bound to
+const stateCookieName = "apigoboot_state"
+
+// stateCookiePath restrict the state cookie to the destinations of the application
+const stateCookiePath = "/authentication/oauth2"
+
+// stateLifetime is how long the user has to log in before the state of the authorization request expires
+const stateLifetime = 10 * time.Minute
+
+// csrfCookieKey is the key used to store the value of the csrf cookie sent to the browser during the request in the gin context
+const csrfCookieKey = "csrf_cookie"
+
+// cspNonceKey is the key used to store the nonce allowing the inline style of a page in the gin context
+const cspNonceKey = "csp_nonce"
+
+// contentSecurityPolicy only allow the embedded styles and scripts, the inline style holding the colors of the client and its logo.
+// The scripts can only call the authentication server and the pages can't be framed
+const contentSecurityPolicy = "default-src 'none'; style-src 'self' 'nonce-%s'; script-src 'self'; connect-src 'self'; " +
+	"img-src 'self' https: data:; base-uri 'none'; frame-ancestors 'none'"
+
+// SecurityHeaders add strict security headers and a content security policy to the HTML pages (middleware)
+func (r *rest) SecurityHeaders(c *gin.Context) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	c.Set(cspNonceKey, base64.StdEncoding.EncodeToString(nonce))
+
+	c.Header("Content-Security-Policy", fmt.Sprintf(contentSecurityPolicy, c.GetString(cspNonceKey)))
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	if strings.HasPrefix(config.GAppUrl, "https://") {
+		c.Header("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+	}
+	c.Next()
+}
+
+// csrfCookie return the value of the csrf cookie of the browser, the cookie is sent to the browser if it does not have one yet
+func (r *rest) csrfCookie(c *gin.Context) string {
+	if value := c.GetString(csrfCookieKey); value != "" {
+		return value
+	}
+	cookie, err := c.Request.Cookie(csrfCookieName)
+	if err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	value, cookieErr := r.service.CreateCsrfCookie()
+	if cookieErr != nil {
+		return ""
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    value,
+		Path:     sessionCookiePath,
+		Secure:   strings.HasPrefix(config.GAppUrl, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	c.Set(csrfCookieKey, value)
+	return value
+}
+
+// csrfToken return a new csrf token for the forms of the page, bound to the csrf cookie of the browser
+func (r *rest) csrfToken(c *gin.Context) string {
+	cookie := r.csrfCookie(c)
+	if cookie == "" {
+		return ""
+	}
+	return r.service.CreateCsrfToken(cookie)
+}
+
+// checkCsrfToken verify the csrf token posted by a form has been issued for the csrf cookie of the browser
+func (r *rest) checkCsrfToken(c *gin.Context) bool {
+	cookie, err := c.Request.Cookie(csrfCookieName)
+	if err != nil {
+		return false
+	}
+	return r.service.CheckCsrfToken(cookie.Value, c.Request.PostForm.Get(csrfFieldName))
+}
+
+// newAuthorizationState return a random state for an authorization request and send it to the browser in the state cookie
+func newAuthorizationState(c *gin.Context) string {
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		return ""
+	}
+	state := base64.RawURLEncoding.EncodeToString(value)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    state,
+		Path:     stateCookiePath,
+		MaxAge:   int(stateLifetime.Seconds()),
+		Secure:   strings.HasPrefix(config.GAppUrl, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return state
+}
+
+// checkAuthorizationState verify the state sent back by the authorization server is the one bound to the browser
+func checkAuthorizationState(c *gin.Context) bool {
+	cookie, err := c.Request.Cookie(stateCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(c.Query("state"))) == 1
+}
+
+// isRegisteredRedirectUri check the redirect uri is exactly one of the redirect uris registered by the client,
+// osin also accepts their sub paths and any query
+func (r *rest) isRegisteredRedirectUri(registeredUris string, redirectUri string) bool {
+	for _, uri := range strings.Split(registeredUris, r.server.Config.RedirectUriSeparator) {
+		if uri == redirectUri {
+			return true
+		}
+	}
+	return false
+}
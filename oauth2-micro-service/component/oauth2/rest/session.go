@@ -0,0 +1,117 @@
+// Package rest implement the callback required by the oauth2 package
+package rest
+
+import (
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionCookieName is the name of the cookie holding the single sign-on session
+const sessionCookieName = "apigoboot_session"
+
+// sessionCookiePath restrict the session cookie to the authentication endpoints
+const sessionCookiePath = "/authentication"
+
+// ResponseDTOSession is the object holding a single sign-on session, Cookie is the signed value to send to the browser
+type ResponseDTOSession struct {
+	Id        string
+	UserId    uint
+	Cookie    string
+	ExpiresAt time.Time
+}
+
+// ResponseDTOJwks is the JSON Web Key Set verifying the logout tokens sent to the clients
+type ResponseDTOJwks struct {
+	Keys []ResponseDTOJwk `json:"keys"`
+}
+
+// ResponseDTOJwk is a P-256 public key in the JSON Web Key format
+type ResponseDTOJwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// setSessionCookie send the signed session cookie to the browser, maxAge is in seconds and a negative value remove the cookie
+func setSessionCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     sessionCookiePath,
+		MaxAge:   maxAge,
+		Secure:   strings.HasPrefix(config.GAppUrl, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// getSession return the single sign-on session of the browser, if any
+func (r *rest) getSession(c *gin.Context) (ResponseDTOSession, bool) {
+	cookie, err := c.Request.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return ResponseDTOSession{}, false
+	}
+	session, sessionErr := r.service.GetSession(cookie.Value)
+	if sessionErr != nil {
+		return ResponseDTOSession{}, false
+	}
+	return session, true
+}
+
+// Logout ask the user to confirm the end of the single sign-on session of the browser, the confirmation form carries a csrf token.
+// Once confirmed, the session ends and the tokens of the clients authorized during the session are revoked
+func (r *rest) Logout(c *gin.Context) {
+	c.Request.ParseForm()
+	data := r.newPageData(c, "")
+	data["logout_url"] = c.Request.URL.Path
+	translator := data["t"].(translator)
+
+	if c.Request.Method != "POST" {
+		c.HTML(http.StatusOK, "logout.tmpl", data)
+		return
+	}
+	if !r.checkCsrfToken(c) {
+		data["error_message"] = translator.T("error.session_expired")
+		c.HTML(http.StatusForbidden, "logout.tmpl", data)
+		return
+	}
+	if cookie, err := c.Request.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		if err := r.service.EndSession(cookie.Value); err != nil && err.Code != servicehelper.NotFound {
+			data["error_message"] = translator.T("error.try_again_later")
+			c.HTML(http.StatusInternalServerError, "logout.tmpl", data)
+			return
+		}
+	}
+	setSessionCookie(c, "", -1)
+	data["done"] = true
+	c.HTML(http.StatusOK, "logout.tmpl", data)
+}
+
+// Jwks publish the keys verifying the logout tokens sent to the clients
+func (r *rest) Jwks(c *gin.Context) {
+	c.JSON(http.StatusOK, r.service.GetSigningKeys())
+}
+
+// DeleteUserTokens allows the other micro services to sign a user out everywhere, e.g. once its password has been reset (private API)
+func (r *rest) DeleteUserTokens(c *gin.Context) {
+	userId, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+		return
+	}
+	if err := r.service.RevokeUserTokens(uint(userId)); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "user tokens have been revoked successfully"})
+	}
+}
@@ -0,0 +1,65 @@
+// Sign in with a passkey: fetch the options of the user service, ask the browser for an assertion and post it with the login form
+(function () {
+    var form = document.getElementById("passkey-form");
+    var button = document.getElementById("passkey-button");
+    if (!form || !button || !window.PublicKeyCredential || !navigator.credentials) {
+        return;
+    }
+
+    function toBuffer(value) {
+        var base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+        var binary = atob(base64 + "===".slice((base64.length + 3) % 4));
+        var bytes = new Uint8Array(binary.length);
+        for (var i = 0; i < binary.length; i++) {
+            bytes[i] = binary.charCodeAt(i);
+        }
+        return bytes.buffer;
+    }
+
+    function toBase64Url(buffer) {
+        if (!buffer) {
+            return "";
+        }
+        var bytes = new Uint8Array(buffer);
+        var binary = "";
+        for (var i = 0; i < bytes.length; i++) {
+            binary += String.fromCharCode(bytes[i]);
+        }
+        return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+    }
+
+    button.hidden = false;
+    button.addEventListener("click", function () {
+        var login = document.getElementById("login");
+        fetch(form.getAttribute("data-options-url"), {
+            method: "POST",
+            headers: {"Content-Type": "application/json"},
+            body: JSON.stringify({username: login ? login.value : ""})
+        }).then(function (response) {
+            if (!response.ok) {
+                throw new Error("could not start the passkey login");
+            }
+            return response.json();
+        }).then(function (options) {
+            options.challenge = toBuffer(options.challenge);
+            (options.allowCredentials || []).forEach(function (credential) {
+                credential.id = toBuffer(credential.id);
+            });
+            return navigator.credentials.get({publicKey: options});
+        }).then(function (credential) {
+            form.elements["webauthn_assertion"].value = JSON.stringify({
+                id: credential.id,
+                type: credential.type,
+                response: {
+                    clientDataJSON: toBase64Url(credential.response.clientDataJSON),
+                    authenticatorData: toBase64Url(credential.response.authenticatorData),
+                    signature: toBase64Url(credential.response.signature),
+                    userHandle: toBase64Url(credential.response.userHandle)
+                }
+            });
+            form.submit();
+        }).catch(function () {
+            form.elements["webauthn_assertion"].value = "";
+        });
+    });
+})();
@@ -0,0 +1,71 @@
+// Package rest implement the callback required by the oauth2 package
+package rest
+
+import (
+	"github.com/RangelReale/osin"
+	"github.com/gin-gonic/gin"
+)
+
+// TokenExchangeGrantType is the grant type of the RFC 8693 token exchange grant
+const TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// AccessTokenType is the RFC 8693 identifier of the access tokens issued by this server
+const AccessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+
+// ResponseDTOTokenExchangeGrant is the object holding the user, scopes and actors of a delegated token.
+// Actor is the space separated chain of the clients acting for the user, the most recent one first
+type ResponseDTOTokenExchangeGrant struct {
+	UserId uint
+	Scope  string
+	Actor  string
+}
+
+// ResponseDTOActor is the object to map the RFC 8693 act claim, a nested act is the actor the current one acts for
+type ResponseDTOActor struct {
+	Sub string            `json:"sub"`
+	Act *ResponseDTOActor `json:"act,omitempty"`
+}
+
+// handleTokenExchangeAccessRequest exchange the access token of a user for a downscoped token issued to the calling client,
+// osin does not support this grant type
+func (r *rest) handleTokenExchangeAccessRequest(resp *osin.Response, c *gin.Context) {
+	client, ok := r.getClient(c.Request)
+	if !ok {
+		resp.SetError(osin.E_INVALID_CLIENT, "")
+		return
+	}
+	if !r.service.IsGrantTypeAllowed(client.GetId(), TokenExchangeGrantType) {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, "")
+		return
+	}
+
+	if c.Request.Form.Get("subject_token_type") != AccessTokenType {
+		resp.SetError(osin.E_INVALID_REQUEST, "subject_token_type must be "+AccessTokenType)
+		return
+	}
+	if requestedType := c.Request.Form.Get("requested_token_type"); requestedType != "" && requestedType != AccessTokenType {
+		resp.SetError(osin.E_INVALID_REQUEST, "requested_token_type must be "+AccessTokenType)
+		return
+	}
+
+	grant, err := r.service.ExchangeToken(c.Request.Form.Get("subject_token"), client.GetId(), c.Request.Form.Get("scope"))
+	if err != nil {
+		resp.SetError(err.Detail.Error(), err.Message)
+		return
+	}
+
+	r.server.FinishAccessRequest(resp, c.Request, &osin.AccessRequest{
+		Type:            TokenExchangeGrantType,
+		Client:          client,
+		Scope:           grant.Scope,
+		UserData:        grant,
+		Authorized:      true,
+		GenerateRefresh: false,
+		Expiration:      r.server.Config.AccessExpiration,
+		RedirectUri:     osin.FirstUri(client.GetRedirectUri(), r.server.Config.RedirectUriSeparator),
+		HttpRequest:     c.Request,
+	})
+	if !resp.IsError {
+		resp.Output["issued_token_type"] = AccessTokenType
+	}
+}
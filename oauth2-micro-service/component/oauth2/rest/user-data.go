@@ -0,0 +1,62 @@
+// Package rest implement the callback required by the oauth2 package
+package rest
+
+import (
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ResponseDTOUserData is the object to map JSON response body of the authorizations of a user in the export of its personal data
+type ResponseDTOUserData struct {
+	GrantedApps []ResponseDTOGrantedApp      `json:"granted_apps"`
+	Clients     []ResponseDTOClient          `json:"clients"`
+	Sessions    []ResponseDTOUserDataSession `json:"sessions"`
+	Tokens      []ResponseDTOUserDataToken   `json:"tokens"`
+}
+
+// ResponseDTOUserDataSession is the object to map JSON response body of a single sign-on session of a user
+type ResponseDTOUserDataSession struct {
+	Clients   []string  `json:"clients"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ResponseDTOUserDataToken is the object to map JSON response body of an access token issued for a user, without its value
+type ResponseDTOUserDataToken struct {
+	ClientId    string    `json:"client_id"`
+	Scopes      []string  `json:"scopes"`
+	IssuedAt    time.Time `json:"issued_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Refreshable bool      `json:"refreshable"`
+}
+
+// GetUserData allows the user micro service to export the authorizations of a user (private API)
+func (r *rest) GetUserData(c *gin.Context) {
+	userId, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+		return
+	}
+	if resDTO, err := r.service.RetrieveUserData(uint(userId)); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTO)
+	}
+}
+
+// DeleteUserData allows the user micro service to erase the authorizations of a user (private API)
+func (r *rest) DeleteUserData(c *gin.Context) {
+	userId, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+		return
+	}
+	if err := r.service.EraseUserData(uint(userId)); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "user data have been erased successfully"})
+	}
+}
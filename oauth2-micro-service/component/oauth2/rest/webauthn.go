@@ -0,0 +1,27 @@
+// Package rest implement the callback required by the oauth2 package
+package rest
+
+import (
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// RequestDTOWebauthnOptions is the object to map JSON request body of the start of a passkey login, the username is optional
+type RequestDTOWebauthnOptions struct {
+	Username string `json:"username"`
+}
+
+// WebauthnOptions return the options the login page gives to the browser to sign in with a passkey
+func (r *rest) WebauthnOptions(c *gin.Context) {
+	var reqDTO RequestDTOWebauthnOptions
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if options, err := r.service.AskUserServiceForWebauthnOptions(reqDTO.Username); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.Data(http.StatusOK, "application/json; charset=utf-8", options)
+		}
+	}
+}
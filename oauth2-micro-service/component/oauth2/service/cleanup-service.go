@@ -0,0 +1,58 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"log"
+	"time"
+)
+
+// cleanupBatchSize is the amount of rows deleted per query, to avoid locking the tables for too long
+const cleanupBatchSize = 1000
+
+// CleanupReport hold the amount of expired rows removed from each table
+type CleanupReport struct {
+	Authorizes           int64
+	Accesses             int64
+	Refreshes            int64
+	DeviceAuthorizations int64
+	UsedAssertions       int64
+	Sessions             int64
+}
+
+// PurgeExpired remove the expired authorization codes, tokens, device authorizations, used assertions and sessions.
+// Refresh tokens are purged before access tokens, since an access token is kept as long as a refresh token refers to it
+func (s *service) PurgeExpired() (report CleanupReport, err error) {
+	now := time.Now()
+	if report.Authorizes, err = s.repo.PurgeAuthorizes(now, cleanupBatchSize); err != nil {
+		return
+	}
+	if report.Refreshes, err = s.repo.PurgeRefreshes(now, cleanupBatchSize); err != nil {
+		return
+	}
+	if report.Accesses, err = s.repo.PurgeAccesses(now, cleanupBatchSize); err != nil {
+		return
+	}
+	if report.DeviceAuthorizations, err = s.repo.PurgeDeviceAuthorizations(now, cleanupBatchSize); err != nil {
+		return
+	}
+	if report.UsedAssertions, err = s.repo.PurgeUsedAssertions(now, cleanupBatchSize); err != nil {
+		return
+	}
+	report.Sessions, err = s.repo.PurgeSessions(now, cleanupBatchSize)
+	return
+}
+
+// StartCleanupScheduler purge the expired rows now and then at every interval, in the background
+func (s *service) StartCleanupScheduler(interval time.Duration) {
+	go func() {
+		for {
+			report, err := s.PurgeExpired()
+			if err != nil {
+				log.Println("ERROR: cleanup of expired rows failed:", err)
+			}
+			log.Printf("cleanup removed %d authorization codes, %d access tokens, %d refresh tokens, %d device authorizations, %d used assertions and %d sessions\n",
+				report.Authorizes, report.Accesses, report.Refreshes, report.DeviceAuthorizations, report.UsedAssertions, report.Sessions)
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -0,0 +1,542 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/rest"
+	"github.com/pborman/uuid"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RedirectUriSeparator is the separator used to store multiple redirect uris in Client.RedirectUri
+const RedirectUriSeparator = " "
+
+// AdminScope is the scope required to manage the clients
+const AdminScope = "admin"
+
+// StaffScope is the scope required for the roles of the user to be loaded on its tokens
+const StaffScope = "staff"
+
+// DefaultSecretOverlap is how long the previous secret of a client stays valid after a rotation when no expiry is requested
+const DefaultSecretOverlap = 24 * time.Hour
+
+// supportedGrantTypes list the grant types a client can be registered with
+var supportedGrantTypes = []string{
+	"authorization_code",
+	"implicit",
+	"refresh_token",
+	"password",
+	"client_credentials",
+	rest.JwtBearerGrantType,
+	rest.DeviceCodeGrantType,
+	rest.TokenExchangeGrantType,
+}
+
+// LegacyGrantTypes are the grant types every client was allowed to use before they were registered per client
+const LegacyGrantTypes = "authorization_code implicit refresh_token password client_credentials"
+
+// clientGrantTypes list the grant types of a client, the clients registered before the grant types get the legacy ones
+func clientGrantTypes(entity Client) []string {
+	if strings.TrimSpace(entity.GrantTypes) == "" {
+		return strings.Fields(LegacyGrantTypes)
+	}
+	return strings.Fields(entity.GrantTypes)
+}
+
+// registrationGrantTypes are the grant types a dynamically registered client can use, the others are only given by the administrators
+var registrationGrantTypes = []string{"authorization_code", "refresh_token", rest.DeviceCodeGrantType}
+
+// registrationScopes are the scopes a dynamically registered client can request, the privileged ones are only given by the administrators
+var registrationScopes = []string{"user:read", "user:write", "profile:read", "profile:write"}
+
+// generateClientSecret create a random url safe secret for a confidential client
+func generateClientSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// contains check if value is part of the list
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// createClientDTOFromEntity copy all data from a client entity to a Response DTO, the secret is never copied
+func createClientDTOFromEntity(entity Client) rest.ResponseDTOClient {
+	return rest.ResponseDTOClient{
+		ClientId:                entity.Id,
+		ClientSecretExpiresAt:   entity.SecretExpiresAt,
+		PreviousSecretExpiresAt: entity.PreviousSecretExpiresAt,
+		UserId:                  entity.UserId,
+		Name:                    entity.Name,
+		RedirectUris:            strings.Split(entity.RedirectUri, RedirectUriSeparator),
+		GrantTypes:              clientGrantTypes(entity),
+		Scopes:                  strings.Fields(entity.Scope),
+		Public:                  entity.Public,
+		FirstParty:              entity.FirstParty,
+		LogoUri:                 entity.LogoUri,
+		PrimaryColor:            entity.PrimaryColor,
+		BackgroundColor:         entity.BackgroundColor,
+		LogoutUri:               entity.LogoutUri,
+		PublicKeys:              splitPublicKeys(entity.PublicKeys),
+		CreatedAt:               entity.CreatedAt,
+	}
+}
+
+// splitPublicKeys split the PEM encoded public keys of a client
+func splitPublicKeys(pemKeys string) []string {
+	keys := []string{}
+	remaining := []byte(pemKeys)
+	for {
+		var block *pem.Block
+		if block, remaining = pem.Decode(remaining); block == nil {
+			return keys
+		}
+		keys = append(keys, string(pem.EncodeToMemory(block)))
+	}
+}
+
+// colorPattern match the hexadecimal colors of the branding of a client
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// validateColor check an optional branding color is an hexadecimal color, so it can be safely inserted in the pages
+func validateColor(param string, color string) *servicehelper.Error {
+	if color != "" && !colorPattern.MatchString(color) {
+		return &servicehelper.Error{
+			Detail:  errors.New("invalid color " + color),
+			Message: "Colors must be hexadecimal colors such as #336699",
+			Param:   param,
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	return nil
+}
+
+// validateClientDTO check that the client metadata can be stored and used by the OAuth server
+func validateClientDTO(reqDTO rest.RequestDTOClient) *servicehelper.Error {
+	for _, redirectUri := range reqDTO.RedirectUris {
+		u, err := url.Parse(redirectUri)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Fragment != "" || strings.Contains(redirectUri, RedirectUriSeparator) {
+			return &servicehelper.Error{
+				Detail:  errors.New("invalid redirect uri " + redirectUri),
+				Message: "Redirect uris must be absolute urls without fragment",
+				Param:   "redirect_uris",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+	}
+	if reqDTO.LogoutUri != "" {
+		u, err := url.Parse(reqDTO.LogoutUri)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &servicehelper.Error{
+				Detail:  errors.New("invalid logout uri " + reqDTO.LogoutUri),
+				Message: "The logout uri must be an absolute http or https url",
+				Param:   "logout_uri",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+	}
+	if err := validateColor("primary_color", reqDTO.PrimaryColor); err != nil {
+		return err
+	}
+	if err := validateColor("background_color", reqDTO.BackgroundColor); err != nil {
+		return err
+	}
+	for _, grantType := range reqDTO.GrantTypes {
+		if !contains(supportedGrantTypes, grantType) {
+			return &servicehelper.Error{
+				Detail:  errors.New("unsupported grant type " + grantType),
+				Message: "Grant types must be chosen among " + strings.Join(supportedGrantTypes, ", "),
+				Param:   "grant_types",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+		if reqDTO.Public && (grantType == "client_credentials" || grantType == "password") {
+			return &servicehelper.Error{
+				Detail:  errors.New("grant type " + grantType + " requires a confidential client"),
+				Message: "Public clients can't authenticate themselves and can't use the " + grantType + " grant type",
+				Param:   "grant_types",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+	}
+	if _, err := parsePublicKeys(strings.Join(reqDTO.PublicKeys, "\n")); err != nil {
+		return &servicehelper.Error{
+			Detail:  err,
+			Message: "Public keys must be PEM encoded RSA or P-256 ECDSA public keys",
+			Param:   "public_keys",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	if contains(reqDTO.GrantTypes, rest.JwtBearerGrantType) && len(reqDTO.PublicKeys) == 0 {
+		return &servicehelper.Error{
+			Detail:  errors.New("public keys are required by the jwt-bearer grant type"),
+			Message: "Clients using JWT assertions must register the public keys verifying them",
+			Param:   "public_keys",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	if err := validateScopes(reqDTO.Scopes, "scopes"); err != nil {
+		return err
+	}
+	if reqDTO.Public && contains(reqDTO.Scopes, AdminScope) {
+		return &servicehelper.Error{
+			Detail:  errors.New("admin scope requires a confidential client"),
+			Message: "Only confidential clients can be granted the admin scope",
+			Param:   "scopes",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	return nil
+}
+
+// copyClientDTOToEntity copy the editable metadata of a client from a Request DTO to an entity
+func copyClientDTOToEntity(reqDTO rest.RequestDTOClient, entity *Client) {
+	entity.UserId = reqDTO.UserId
+	entity.Name = reqDTO.Name
+	entity.RedirectUri = strings.Join(reqDTO.RedirectUris, RedirectUriSeparator)
+	entity.GrantTypes = strings.Join(reqDTO.GrantTypes, " ")
+	entity.Scope = strings.Join(reqDTO.Scopes, " ")
+	entity.Public = reqDTO.Public
+	entity.FirstParty = reqDTO.FirstParty
+	entity.LogoUri = reqDTO.LogoUri
+	entity.PrimaryColor = reqDTO.PrimaryColor
+	entity.BackgroundColor = reqDTO.BackgroundColor
+	entity.LogoutUri = reqDTO.LogoutUri
+	entity.PublicKeys = strings.Join(reqDTO.PublicKeys, "\n")
+}
+
+// AddClient register a new client and return it along with its secret
+func (s *service) AddClient(reqDTO rest.RequestDTOClient) (rest.ResponseDTOClient, *servicehelper.Error) {
+	if err := validateClientDTO(reqDTO); err != nil {
+		return rest.ResponseDTOClient{}, err
+	}
+
+	entity := Client{Id: uuid.New(), CreatedAt: time.Now()}
+	copyClientDTOToEntity(reqDTO, &entity)
+
+	var secret string
+	if !entity.Public {
+		var err error
+		if secret, err = generateClientSecret(); err == nil {
+			entity.Secret, err = HashClientSecret(secret)
+		}
+		if err != nil {
+			return rest.ResponseDTOClient{}, &servicehelper.Error{
+				Detail:  errors.New("could not generate client secret"),
+				Message: "We could not create the client, please try again later",
+				Code:    servicehelper.UnexpectedError,
+			}
+		}
+	}
+
+	if err := s.repo.CreateClient(entity); err != nil {
+		return rest.ResponseDTOClient{}, &servicehelper.Error{
+			Detail:  errors.New("client could not be created"),
+			Message: "We could not create the client, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+
+	resDTO := createClientDTOFromEntity(entity)
+	resDTO.ClientSecret = secret
+	return resDTO, nil
+}
+
+// RetrieveClient ask database to retrieve a client from its id
+func (s *service) RetrieveClient(clientId string) (rest.ResponseDTOClient, *servicehelper.Error) {
+	entity, err := s.repo.FindClientById(clientId)
+	if err != nil {
+		return rest.ResponseDTOClient{}, &servicehelper.Error{
+			Detail:  errors.New("client could not be found"),
+			Message: "We could not find any client with the provided id",
+			Param:   "client_id",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	return createClientDTOFromEntity(entity), nil
+}
+
+// GetClientBranding retrieve the name, logo and colors displayed on the pages shown to the users of a client
+func (s *service) GetClientBranding(clientId string) (rest.ResponseDTOClientBranding, *servicehelper.Error) {
+	entity, err := s.repo.FindClientById(clientId)
+	if err != nil {
+		return rest.ResponseDTOClientBranding{}, &servicehelper.Error{
+			Detail:  errors.New("client could not be found"),
+			Message: "We could not find any client with the provided id",
+			Param:   "client_id",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	return rest.ResponseDTOClientBranding{
+		ClientId:        entity.Id,
+		DisplayName:     entity.Name,
+		LogoUri:         entity.LogoUri,
+		PrimaryColor:    entity.PrimaryColor,
+		BackgroundColor: entity.BackgroundColor,
+	}, nil
+}
+
+// RetrieveClients ask database to retrieve every registered client
+func (s *service) RetrieveClients() ([]rest.ResponseDTOClient, *servicehelper.Error) {
+	entities, err := s.repo.FindAllClients()
+	if err != nil {
+		return nil, &servicehelper.Error{
+			Detail:  errors.New("could not retrieve clients"),
+			Message: "We could not retrieve the clients, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	resDTOs := make([]rest.ResponseDTOClient, 0, len(entities))
+	for _, entity := range entities {
+		resDTOs = append(resDTOs, createClientDTOFromEntity(entity))
+	}
+	return resDTOs, nil
+}
+
+// EditClient overwrite the metadata of a client, its id and secret are kept
+func (s *service) EditClient(clientId string, reqDTO rest.RequestDTOClient) (rest.ResponseDTOClient, *servicehelper.Error) {
+	entity, err := s.repo.FindClientById(clientId)
+	if err != nil {
+		return rest.ResponseDTOClient{}, &servicehelper.Error{
+			Detail:  errors.New("client could not be found"),
+			Message: "We could not find any client with the provided id",
+			Param:   "client_id",
+			Code:    servicehelper.NotFound,
+		}
+	}
+
+	if err := validateClientDTO(reqDTO); err != nil {
+		return rest.ResponseDTOClient{}, err
+	}
+
+	if entity.Public != reqDTO.Public {
+		return rest.ResponseDTOClient{}, &servicehelper.Error{
+			Detail:  errors.New("client type can't be changed"),
+			Message: "A public client can't become confidential (and the other way around), please register a new client",
+			Param:   "public",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+
+	copyClientDTOToEntity(reqDTO, &entity)
+	if err := s.repo.UpdateClient(entity); err != nil {
+		return rest.ResponseDTOClient{}, &servicehelper.Error{
+			Detail:  errors.New("could not update client"),
+			Message: "We could not update the client, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return createClientDTOFromEntity(entity), nil
+}
+
+// RemoveClient find a client in the database and delete it
+func (s *service) RemoveClient(clientId string) *servicehelper.Error {
+	if entity, err := s.repo.FindClientById(clientId); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("client could not be found"),
+			Message: "We could not find any client with the provided id",
+			Param:   "client_id",
+			Code:    servicehelper.NotFound,
+		}
+	} else if err := s.repo.DeleteClient(entity); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("failed to delete client"),
+			Message: "We could not delete the client, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// RotateClientSecret generate a new secret for a confidential client.
+// The current secret becomes the previous one and stays valid until the requested expiry so the client can be redeployed without downtime
+func (s *service) RotateClientSecret(clientId string, reqDTO rest.RequestDTOSecretRotation) (rest.ResponseDTOClient, *servicehelper.Error) {
+	entity, err := s.repo.FindClientById(clientId)
+	if err != nil {
+		return rest.ResponseDTOClient{}, &servicehelper.Error{
+			Detail:  errors.New("client could not be found"),
+			Message: "We could not find any client with the provided id",
+			Param:   "client_id",
+			Code:    servicehelper.NotFound,
+		}
+	}
+
+	if entity.Public {
+		return rest.ResponseDTOClient{}, &servicehelper.Error{
+			Detail:  errors.New("public clients have no secret"),
+			Message: "This client is public and has no secret to rotate",
+			Param:   "client_id",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+
+	secret, err := generateClientSecret()
+	if err != nil {
+		return rest.ResponseDTOClient{}, &servicehelper.Error{
+			Detail:  errors.New("could not generate client secret"),
+			Message: "We could not rotate the secret, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	hashedSecret, err := HashClientSecret(secret)
+	if err != nil {
+		return rest.ResponseDTOClient{}, &servicehelper.Error{
+			Detail:  errors.New("could not hash client secret"),
+			Message: "We could not rotate the secret, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+
+	now := time.Now()
+	previousSecretExpiresAt := now.Add(DefaultSecretOverlap)
+	if reqDTO.PreviousSecretExpiresIn != nil {
+		previousSecretExpiresAt = now.Add(time.Duration(*reqDTO.PreviousSecretExpiresIn) * time.Second)
+	}
+	if entity.SecretExpiresAt != nil && entity.SecretExpiresAt.Before(previousSecretExpiresAt) {
+		previousSecretExpiresAt = *entity.SecretExpiresAt
+	}
+	entity.PreviousSecret = entity.Secret
+	entity.PreviousSecretExpiresAt = &previousSecretExpiresAt
+
+	entity.Secret = hashedSecret
+	entity.SecretExpiresAt = nil
+	if reqDTO.ExpiresIn != nil {
+		secretExpiresAt := now.Add(time.Duration(*reqDTO.ExpiresIn) * time.Second)
+		entity.SecretExpiresAt = &secretExpiresAt
+	}
+
+	if err := s.repo.UpdateClient(entity); err != nil {
+		return rest.ResponseDTOClient{}, &servicehelper.Error{
+			Detail:  errors.New("could not update client"),
+			Message: "We could not rotate the secret, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+
+	resDTO := createClientDTOFromEntity(entity)
+	resDTO.ClientSecret = secret
+	return resDTO, nil
+}
+
+// AuthenticateClient check the credentials of a confidential client, the previous secret is accepted until it expires
+func (s *service) AuthenticateClient(clientId string, secret string) *servicehelper.Error {
+	entity, err := s.repo.FindClientById(clientId)
+	if err != nil || entity.Public || !entity.SecretMatches(secret) {
+		return &servicehelper.Error{
+			Detail:  errors.New("invalid client credentials"),
+			Message: "We could not authenticate the client",
+			Code:    servicehelper.Unauthorized,
+		}
+	}
+	return nil
+}
+
+// IsGrantTypeAllowed check if the client has been registered with the grant type
+func (s *service) IsGrantTypeAllowed(clientId string, grantType string) bool {
+	entity, err := s.repo.FindClientById(clientId)
+	if err != nil {
+		return false
+	}
+	return contains(clientGrantTypes(entity), grantType)
+}
+
+// RegisterClient implement the RFC 7591 dynamic client registration. The registration is not authenticated,
+// so the clients are limited to the redirect and device grants and to the scopes the users consent to one by one
+func (s *service) RegisterClient(reqDTO rest.RequestDTOClientRegistration) (rest.ResponseDTOClientRegistration, *servicehelper.Error) {
+	if len(reqDTO.RedirectUris) == 0 {
+		return rest.ResponseDTOClientRegistration{}, &servicehelper.Error{
+			Detail: errors.New("at least one redirect uri is required"),
+			Param:  "redirect_uris",
+			Code:   servicehelper.BadRequest,
+		}
+	}
+
+	switch reqDTO.TokenEndpointAuthMethod {
+	case "":
+		reqDTO.TokenEndpointAuthMethod = "client_secret_basic"
+	case "none", "client_secret_basic", "client_secret_post":
+	default:
+		return rest.ResponseDTOClientRegistration{}, &servicehelper.Error{
+			Detail: errors.New("unsupported token_endpoint_auth_method " + reqDTO.TokenEndpointAuthMethod),
+			Param:  "token_endpoint_auth_method",
+			Code:   servicehelper.BadRequest,
+		}
+	}
+
+	if len(reqDTO.GrantTypes) == 0 {
+		reqDTO.GrantTypes = []string{"authorization_code"}
+	}
+	for _, responseType := range reqDTO.ResponseTypes {
+		if responseType == "token" && !contains(reqDTO.GrantTypes, "implicit") {
+			reqDTO.GrantTypes = append(reqDTO.GrantTypes, "implicit")
+		} else if responseType == "code" && !contains(reqDTO.GrantTypes, "authorization_code") {
+			reqDTO.GrantTypes = append(reqDTO.GrantTypes, "authorization_code")
+		}
+	}
+
+	for _, grantType := range reqDTO.GrantTypes {
+		if !contains(registrationGrantTypes, grantType) {
+			return rest.ResponseDTOClientRegistration{}, &servicehelper.Error{
+				Detail:  errors.New("the grant type " + grantType + " can't be requested through dynamic registration"),
+				Message: "Grant types must be chosen among " + strings.Join(registrationGrantTypes, ", "),
+				Param:   "grant_types",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+	}
+
+	scopes := strings.Fields(reqDTO.Scope)
+	for _, scope := range scopes {
+		if !contains(registrationScopes, scope) {
+			return rest.ResponseDTOClientRegistration{}, &servicehelper.Error{
+				Detail:  errors.New("the scope " + scope + " can't be requested through dynamic registration"),
+				Message: "Scopes must be chosen among " + strings.Join(registrationScopes, ", "),
+				Param:   "scope",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+	}
+
+	resDTO, err := s.AddClient(rest.RequestDTOClient{
+		Name:         reqDTO.ClientName,
+		RedirectUris: reqDTO.RedirectUris,
+		GrantTypes:   reqDTO.GrantTypes,
+		Scopes:       scopes,
+		Public:       reqDTO.TokenEndpointAuthMethod == "none",
+		LogoUri:      reqDTO.LogoUri,
+		LogoutUri:    reqDTO.BackchannelLogoutUri,
+	})
+	if err != nil {
+		return rest.ResponseDTOClientRegistration{}, err
+	}
+
+	return rest.ResponseDTOClientRegistration{
+		ClientId:                resDTO.ClientId,
+		ClientSecret:            resDTO.ClientSecret,
+		ClientIdIssuedAt:        resDTO.CreatedAt.Unix(),
+		ClientSecretExpiresAt:   0,
+		ClientName:              resDTO.Name,
+		RedirectUris:            resDTO.RedirectUris,
+		GrantTypes:              resDTO.GrantTypes,
+		ResponseTypes:           reqDTO.ResponseTypes,
+		TokenEndpointAuthMethod: reqDTO.TokenEndpointAuthMethod,
+		Scope:                   strings.Join(resDTO.Scopes, " "),
+		LogoUri:                 resDTO.LogoUri,
+		BackchannelLogoutUri:    resDTO.LogoutUri,
+	}, nil
+}
@@ -0,0 +1,203 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/rest"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// consentTicketLifetime is how long the user has to answer the consent prompt once logged in
+const consentTicketLifetime = 10 * time.Minute
+
+// signPayload compute the signature of a value handed to the browser
+func signPayload(payload string) string {
+	mac := hmac.New(sha256.New, config.GSecretKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IsConsentRequired check if the user has to be prompted before authorizing the client.
+// First party clients never prompt, third party clients prompt until the user has granted every requested scope
+func (s *service) IsConsentRequired(clientId string, userId uint, scope string) bool {
+	client, err := s.repo.FindClientById(clientId)
+	if err != nil {
+		return true
+	}
+	if client.FirstParty {
+		return false
+	}
+	consent, err := s.repo.FindConsent(userId, clientId)
+	if err != nil {
+		return true
+	}
+	grantedScopes := strings.Fields(consent.Scope)
+	for _, requestedScope := range strings.Fields(scope) {
+		if !contains(grantedScopes, requestedScope) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetConsentPrompt build the information to display to the user on the consent screen
+func (s *service) GetConsentPrompt(clientId string, scope string) (rest.ResponseDTOConsentPrompt, *servicehelper.Error) {
+	client, err := s.repo.FindClientById(clientId)
+	if err != nil {
+		return rest.ResponseDTOConsentPrompt{}, &servicehelper.Error{
+			Detail:  errors.New("client could not be found"),
+			Message: "We could not find any client with the provided id",
+			Param:   "client_id",
+			Code:    servicehelper.NotFound,
+		}
+	}
+
+	prompt := rest.ResponseDTOConsentPrompt{
+		ClientId:   client.Id,
+		ClientName: client.Name,
+		LogoUri:    client.LogoUri,
+	}
+	for _, name := range strings.Fields(scope) {
+		prompt.Scopes = append(prompt.Scopes, rest.ResponseDTOScope{Name: name, Description: Scopes[name]})
+	}
+	return prompt, nil
+}
+
+// consentSignaturePrefix separate the signatures of the consent tickets from the other signed values
+const consentSignaturePrefix = "consent|"
+
+// consentTicketJtiPrefix separate the nonces of the used consent tickets from the jti of the used JWT assertions
+const consentTicketJtiPrefix = "consent-ticket:"
+
+// CreateConsentTicket sign the identity of a logged in user so the consent prompt can be answered without asking the credentials again.
+// The ticket is bound to the csrf cookie of the browser and carries a nonce so it can only be used once
+func (s *service) CreateConsentTicket(csrfCookie string, userId uint, clientId string, scope string) string {
+	nonce, err := generateClientSecret()
+	if err != nil || csrfCookie == "" {
+		return ""
+	}
+	payload := fmt.Sprintf("%d|%s|%s|%d|%s", userId, clientId, scope, time.Now().Add(consentTicketLifetime).Unix(), nonce)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + signPayload(consentSignaturePrefix+csrfCookie+"|"+payload)
+}
+
+// UseConsentTicket verify a consent ticket has been issued to the browser for this client and scope, record it as used and return the id of the user
+func (s *service) UseConsentTicket(csrfCookie string, ticket string, clientId string, scope string) (uint, bool) {
+	parts := strings.Split(ticket, ".")
+	if csrfCookie == "" || len(parts) != 2 {
+		return 0, false
+	}
+	rawPayload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	payload := string(rawPayload)
+	if !hmac.Equal([]byte(parts[1]), []byte(signPayload(consentSignaturePrefix+csrfCookie+"|"+payload))) {
+		return 0, false
+	}
+
+	fields := strings.Split(payload, "|")
+	if len(fields) != 5 || fields[1] != clientId || fields[2] != scope {
+		return 0, false
+	}
+	expiresAt, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return 0, false
+	}
+	userId, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if err := s.repo.CreateUsedAssertion(UsedAssertion{ClientId: clientId, Jti: consentTicketJtiPrefix + fields[4], ExpiresAt: time.Unix(expiresAt, 0)}); err != nil {
+		return 0, false
+	}
+	return uint(userId), true
+}
+
+// GrantConsent remember that the user granted the scopes to the client, previously granted scopes are kept
+func (s *service) GrantConsent(userId uint, clientId string, scope string) *servicehelper.Error {
+	consent, err := s.repo.FindConsent(userId, clientId)
+	if err != nil {
+		consent = Consent{UserId: userId, ClientId: clientId}
+	}
+
+	grantedScopes := strings.Fields(consent.Scope)
+	for _, requestedScope := range strings.Fields(scope) {
+		if !contains(grantedScopes, requestedScope) {
+			grantedScopes = append(grantedScopes, requestedScope)
+		}
+	}
+	consent.Scope = strings.Join(grantedScopes, " ")
+
+	if err := s.repo.SaveConsent(consent); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not save consent"),
+			Message: "We could not save your decision, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// RetrieveGrantedApps ask database to retrieve the clients a user has granted access to
+func (s *service) RetrieveGrantedApps(userId uint) ([]rest.ResponseDTOGrantedApp, *servicehelper.Error) {
+	consents, err := s.repo.FindConsentsByUserId(userId)
+	if err != nil {
+		return nil, &servicehelper.Error{
+			Detail:  errors.New("could not retrieve consents"),
+			Message: "We could not retrieve your applications, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+
+	resDTOs := make([]rest.ResponseDTOGrantedApp, 0, len(consents))
+	for _, consent := range consents {
+		resDTO := rest.ResponseDTOGrantedApp{
+			ClientId:  consent.ClientId,
+			Scopes:    strings.Fields(consent.Scope),
+			GrantedAt: consent.CreatedAt,
+		}
+		if client, err := s.repo.FindClientById(consent.ClientId); err == nil {
+			resDTO.ClientName = client.Name
+			resDTO.LogoUri = client.LogoUri
+		}
+		resDTOs = append(resDTOs, resDTO)
+	}
+	return resDTOs, nil
+}
+
+// RevokeGrantedApp forget the consent given by a user to a client and revoke the tokens issued to the client for this user
+func (s *service) RevokeGrantedApp(userId uint, clientId string) *servicehelper.Error {
+	consent, err := s.repo.FindConsent(userId, clientId)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("consent could not be found"),
+			Message: "You did not grant access to this application",
+			Param:   "client_id",
+			Code:    servicehelper.NotFound,
+		}
+	}
+
+	if err := s.repo.DeleteTokensByUserIdAndClient(userId, clientId); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not revoke tokens"),
+			Message: "We could not revoke the access of this application, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	if err := s.repo.DeleteConsent(consent); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not delete consent"),
+			Message: "We could not revoke the access of this application, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"crypto/hmac"
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"strings"
+)
+
+// csrfSignaturePrefix separate the signatures of the csrf tokens from the other signed values
+const csrfSignaturePrefix = "csrf|"
+
+// CreateCsrfCookie generate the random value of the cookie the csrf tokens of a browser are bound to
+func (s *service) CreateCsrfCookie() (string, *servicehelper.Error) {
+	cookie, err := generateClientSecret()
+	if err != nil {
+		return "", &servicehelper.Error{
+			Detail:  errors.New("could not generate csrf cookie"),
+			Message: "We could not display this page, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return cookie, nil
+}
+
+// CreateCsrfToken generate a new token for the forms of a page, bound to the csrf cookie of the browser
+func (s *service) CreateCsrfToken(cookie string) string {
+	nonce, err := generateClientSecret()
+	if err != nil {
+		return ""
+	}
+	return nonce + "." + signPayload(csrfSignaturePrefix+cookie+"|"+nonce)
+}
+
+// CheckCsrfToken verify a token sent by a form has been issued for the csrf cookie of the browser
+func (s *service) CheckCsrfToken(cookie string, token string) bool {
+	parts := strings.Split(token, ".")
+	if cookie == "" || len(parts) != 2 {
+		return false
+	}
+	return hmac.Equal([]byte(parts[1]), []byte(signPayload(csrfSignaturePrefix+cookie+"|"+parts[0])))
+}
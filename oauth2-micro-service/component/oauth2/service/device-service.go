@@ -0,0 +1,191 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"crypto/rand"
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/rest"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/config"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Status of a device authorization
+const (
+	DeviceAuthorizationPending  = "pending"
+	DeviceAuthorizationApproved = "approved"
+	DeviceAuthorizationDenied   = "denied"
+)
+
+// deviceCodeLifetime is how long the user has to enter the user code
+const deviceCodeLifetime = 10 * time.Minute
+
+// devicePollingInterval is the minimum amount of seconds a device must wait between two token requests
+const devicePollingInterval = 5
+
+// userCodeCharset exclude vowels and look-alike characters so that user codes are easy to type and never form words
+const userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+
+// userCodeLength is the amount of characters in a user code, displayed in two groups separated by a dash
+const userCodeLength = 8
+
+// generateUserCode create a random user code to be typed by the user on the device page
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeCharset)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeCharset[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode remove the formatting of a user code typed by the user
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
+
+// formatUserCode split the user code in two groups to make it easier to read
+func formatUserCode(userCode string) string {
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+// StartDeviceAuthorization create a pending device authorization for the client and the scopes to grant
+func (s *service) StartDeviceAuthorization(clientId string, scope string) (rest.ResponseDTODeviceAuthorization, *servicehelper.Error) {
+	deviceCode, err := generateClientSecret()
+	if err != nil {
+		return rest.ResponseDTODeviceAuthorization{}, &servicehelper.Error{
+			Detail:  errors.New("could not generate device code"),
+			Message: "We could not start the device authorization, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	userCode, err := generateUserCode()
+	if err != nil {
+		return rest.ResponseDTODeviceAuthorization{}, &servicehelper.Error{
+			Detail:  errors.New("could not generate user code"),
+			Message: "We could not start the device authorization, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+
+	deviceAuthorization := DeviceAuthorization{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ClientId:   clientId,
+		Scope:      scope,
+		Status:     DeviceAuthorizationPending,
+		Interval:   devicePollingInterval,
+		ExpiresAt:  time.Now().Add(deviceCodeLifetime),
+	}
+	if err := s.repo.CreateDeviceAuthorization(deviceAuthorization); err != nil {
+		return rest.ResponseDTODeviceAuthorization{}, &servicehelper.Error{
+			Detail:  errors.New("device authorization could not be created"),
+			Message: "We could not start the device authorization, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+
+	verificationUri := config.GAppUrl + "/authentication/device"
+	return rest.ResponseDTODeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationUri:         verificationUri,
+		VerificationUriComplete: verificationUri + "?user_code=" + url.QueryEscape(formatUserCode(userCode)),
+		ExpiresIn:               int(deviceCodeLifetime.Seconds()),
+		Interval:                devicePollingInterval,
+	}, nil
+}
+
+// findPendingDeviceAuthorization ask database to retrieve a device authorization still waiting for the decision of the user
+func (s *service) findPendingDeviceAuthorization(userCode string) (DeviceAuthorization, *servicehelper.Error) {
+	deviceAuthorization, err := s.repo.FindDeviceAuthorizationByUserCode(normalizeUserCode(userCode))
+	if err != nil || deviceAuthorization.Status != DeviceAuthorizationPending || deviceAuthorization.ExpiresAt.Before(time.Now()) {
+		return DeviceAuthorization{}, &servicehelper.Error{
+			Detail:  errors.New("device authorization could not be found"),
+			Message: "This code is invalid or has expired, please start again from your device",
+			Param:   "user_code",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	return deviceAuthorization, nil
+}
+
+// GetDeviceAuthorizationPrompt build the information to display to the user before approving a device
+func (s *service) GetDeviceAuthorizationPrompt(userCode string) (rest.ResponseDTOConsentPrompt, *servicehelper.Error) {
+	deviceAuthorization, err := s.findPendingDeviceAuthorization(userCode)
+	if err != nil {
+		return rest.ResponseDTOConsentPrompt{}, err
+	}
+	return s.GetConsentPrompt(deviceAuthorization.ClientId, deviceAuthorization.Scope)
+}
+
+// DecideDeviceAuthorization store the decision of the user for the device that displayed the user code
+func (s *service) DecideDeviceAuthorization(userCode string, userId uint, approved bool) *servicehelper.Error {
+	deviceAuthorization, err := s.findPendingDeviceAuthorization(userCode)
+	if err != nil {
+		return err
+	}
+
+	deviceAuthorization.UserId = userId
+	deviceAuthorization.Status = DeviceAuthorizationDenied
+	if approved {
+		deviceAuthorization.Status = DeviceAuthorizationApproved
+	}
+	if err := s.repo.UpdateDeviceAuthorization(deviceAuthorization); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not update device authorization"),
+			Message: "We could not save your decision, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// PollDeviceAuthorization check if the user approved the device, the device code can only be exchanged once.
+// On failure the Detail of the error is the RFC 8628 error code to return to the device
+func (s *service) PollDeviceAuthorization(deviceCode string, clientId string) (rest.ResponseDTODeviceGrant, *servicehelper.Error) {
+	deviceAuthorization, err := s.repo.FindDeviceAuthorizationByDeviceCode(deviceCode)
+	if err != nil || deviceAuthorization.ClientId != clientId {
+		return rest.ResponseDTODeviceGrant{}, &servicehelper.Error{Detail: errors.New("invalid_grant"), Param: "device_code", Code: servicehelper.BadRequest}
+	}
+
+	now := time.Now()
+	if deviceAuthorization.ExpiresAt.Before(now) {
+		s.repo.DeleteDeviceAuthorization(deviceAuthorization)
+		return rest.ResponseDTODeviceGrant{}, &servicehelper.Error{Detail: errors.New("expired_token"), Param: "device_code", Code: servicehelper.BadRequest}
+	}
+
+	switch deviceAuthorization.Status {
+	case DeviceAuthorizationApproved:
+		s.repo.DeleteDeviceAuthorization(deviceAuthorization)
+		return rest.ResponseDTODeviceGrant{UserId: deviceAuthorization.UserId, Scope: deviceAuthorization.Scope}, nil
+	case DeviceAuthorizationDenied:
+		s.repo.DeleteDeviceAuthorization(deviceAuthorization)
+		return rest.ResponseDTODeviceGrant{}, &servicehelper.Error{Detail: errors.New("access_denied"), Param: "device_code", Code: servicehelper.BadRequest}
+	}
+
+	tooFast := deviceAuthorization.LastPolledAt != nil &&
+		now.Sub(*deviceAuthorization.LastPolledAt) < time.Duration(deviceAuthorization.Interval)*time.Second
+	deviceAuthorization.LastPolledAt = &now
+	if tooFast {
+		deviceAuthorization.Interval += devicePollingInterval
+	}
+	s.repo.UpdateDeviceAuthorization(deviceAuthorization)
+
+	if tooFast {
+		return rest.ResponseDTODeviceGrant{}, &servicehelper.Error{Detail: errors.New("slow_down"), Param: "device_code", Code: servicehelper.BadRequest}
+	}
+	return rest.ResponseDTODeviceGrant{}, &servicehelper.Error{Detail: errors.New("authorization_pending"), Param: "device_code", Code: servicehelper.BadRequest}
+}
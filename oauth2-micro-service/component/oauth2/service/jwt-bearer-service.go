@@ -0,0 +1,197 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/rest"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/config"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAssertionLifetime is the longest validity accepted for a JWT assertion, it bounds how long used jti are remembered
+const maxAssertionLifetime = time.Hour
+
+// assertionClockSkew is the tolerance applied when comparing the assertion dates with the server clock
+const assertionClockSkew = time.Minute
+
+// jwtHeader is the JOSE header of a JWT assertion
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+// jwtClaims are the claims of a JWT assertion (RFC 7523 section 3), aud can either be a string or a list of strings
+type jwtClaims struct {
+	Iss string          `json:"iss"`
+	Sub string          `json:"sub"`
+	Aud json.RawMessage `json:"aud"`
+	Exp int64           `json:"exp"`
+	Nbf int64           `json:"nbf"`
+	Iat int64           `json:"iat"`
+	Jti string          `json:"jti"`
+}
+
+// audiences return the audiences of the assertion
+func (claims jwtClaims) audiences() []string {
+	var audience string
+	if json.Unmarshal(claims.Aud, &audience) == nil {
+		return []string{audience}
+	}
+	var audiences []string
+	json.Unmarshal(claims.Aud, &audiences)
+	return audiences
+}
+
+// parsePublicKeys parse the PEM encoded RSA and P-256 ECDSA public keys registered by a client
+func parsePublicKeys(pemKeys string) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	remaining := []byte(pemKeys)
+	for {
+		var block *pem.Block
+		if block, remaining = pem.Decode(remaining); block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+		case *ecdsa.PublicKey:
+			if key.Curve != elliptic.P256() {
+				return nil, errors.New("only P-256 ECDSA keys are supported")
+			}
+		default:
+			return nil, errors.New("only RSA and ECDSA public keys are supported")
+		}
+		keys = append(keys, key)
+	}
+	if strings.TrimSpace(string(remaining)) != "" {
+		return nil, errors.New("public keys must be PEM encoded")
+	}
+	return keys, nil
+}
+
+// verifyJwtSignature check the RS256 or ES256 signature of a JWT against the public keys of a client
+func verifyJwtSignature(alg string, signingInput string, signature []byte, keys []crypto.PublicKey) bool {
+	hash := sha256.Sum256([]byte(signingInput))
+	for _, key := range keys {
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			if alg == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			if alg == "ES256" && len(signature) == 64 &&
+				ecdsa.Verify(key, hash[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// invalidAssertionError build the error returned for every invalid assertion, the detail is only meant for the logs
+func invalidAssertionError(detail string) *servicehelper.Error {
+	return &servicehelper.Error{
+		Detail:  errors.New(detail),
+		Message: "The assertion is invalid",
+		Param:   "assertion",
+		Code:    servicehelper.BadRequest,
+	}
+}
+
+// VerifyJwtBearerAssertion verify a RFC 7523 JWT assertion signed by a client and return the client and user it has been issued for.
+// The subject is the id of the user, or the client id when the client acts on its own behalf
+func (s *service) VerifyJwtBearerAssertion(assertion string) (rest.ResponseDTOAssertionGrant, *servicehelper.Error) {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("assertion is not a JWT")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("malformed JWT header")
+	}
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("malformed JWT claims")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("malformed JWT signature")
+	}
+
+	var header jwtHeader
+	var claims jwtClaims
+	if json.Unmarshal(rawHeader, &header) != nil || json.Unmarshal(rawClaims, &claims) != nil {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("malformed JWT")
+	}
+
+	// issuer is the client that signed the assertion
+	client, err := s.repo.FindClientById(claims.Iss)
+	if err != nil {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("unknown issuer")
+	}
+	keys, err := parsePublicKeys(client.PublicKeys)
+	if err != nil || !verifyJwtSignature(header.Alg, parts[0]+"."+parts[1], signature, keys) {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("invalid signature")
+	}
+
+	// audience must identify this server
+	audienceFound := false
+	for _, audience := range claims.audiences() {
+		if audience == config.GAppUrl || audience == config.GAppUrl+"/authentication/token" {
+			audienceFound = true
+		}
+	}
+	if !audienceFound {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("invalid audience")
+	}
+
+	// assertion must be valid now and for a bounded amount of time
+	now := time.Now()
+	expiresAt := time.Unix(claims.Exp, 0)
+	if claims.Exp == 0 || expiresAt.Add(assertionClockSkew).Before(now) || expiresAt.Sub(now) > maxAssertionLifetime {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("invalid expiry")
+	}
+	if claims.Nbf != 0 && time.Unix(claims.Nbf, 0).Add(-assertionClockSkew).After(now) {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("assertion is not valid yet")
+	}
+
+	// assertion can only be used once
+	if claims.Jti == "" {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("missing jti")
+	}
+	if _, err := s.repo.FindUsedAssertion(client.Id, claims.Jti); err == nil {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("assertion has already been used")
+	}
+	if err := s.repo.CreateUsedAssertion(UsedAssertion{ClientId: client.Id, Jti: claims.Jti, ExpiresAt: expiresAt}); err != nil {
+		return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("assertion has already been used")
+	}
+
+	// subject is the user the client acts for
+	grant := rest.ResponseDTOAssertionGrant{ClientId: client.Id}
+	if claims.Sub != client.Id {
+		userId, err := strconv.ParseUint(claims.Sub, 10, 64)
+		if err != nil || userId == 0 {
+			return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("invalid subject")
+		}
+		if owner, err := askUserServiceForUser(uint(userId)); err != nil || owner.Status != userStatusActive {
+			return rest.ResponseDTOAssertionGrant{}, invalidAssertionError("subject is not an active user")
+		}
+		grant.UserId = uint(userId)
+	}
+	return grant, nil
+}
@@ -0,0 +1,78 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/rest"
+	"io/ioutil"
+	"net/http"
+)
+
+// AskUserServiceForPasswordReset call user service to send a link resetting the password of the user with this email, if any
+func (s *service) AskUserServiceForPasswordReset(email string) *servicehelper.Error {
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(rest.RequestDTOPasswordReset{Email: email})
+
+	resp, err := http.Post(privateBaseUrl+"/user/password-reset", "application/json", b)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not send you a link, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	defer resp.Body.Close()
+
+	// the email is checked by the user service, but its existence is never told
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusBadRequest {
+		return &servicehelper.Error{
+			Detail:  errors.New("user service answered " + resp.Status),
+			Message: "We could not send you a link, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// AskUserServiceToResetPassword call user service to choose a new password with the token of a reset link, the message of the errors can be shown to the user
+func (s *service) AskUserServiceToResetPassword(token string, newPassword string) *servicehelper.Error {
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(rest.RequestDTOConfirmPasswordReset{Token: token, NewPassword: newPassword})
+
+	resp, err := http.Post(privateBaseUrl+"/user/password-reset/confirm", "application/json", b)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not reset your password, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	var apiErrors apihelper.ApiErrors
+	json.Unmarshal(body, &apiErrors)
+	if len(apiErrors.Errors) > 0 {
+		if apiError, ok := apiErrors.Errors[0].(map[string]interface{}); ok {
+			if message, ok := apiError["message"].(string); ok && message != "" {
+				return &servicehelper.Error{
+					Detail:  errors.New("user service refused the new password"),
+					Message: message,
+					Code:    servicehelper.Code(resp.StatusCode),
+				}
+			}
+		}
+	}
+	return &servicehelper.Error{
+		Detail:  errors.New("user service answered " + resp.Status),
+		Message: "We could not reset your password, please try again later",
+		Code:    servicehelper.UnexpectedError,
+	}
+}
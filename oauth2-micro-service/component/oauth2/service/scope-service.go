@@ -0,0 +1,84 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"sort"
+	"strings"
+)
+
+// Scopes is the registry of the scopes a client can be allowed to request, with a description to display to the user
+var Scopes = map[string]string{
+	"user:read":     "Read your account information",
+	"user:write":    "Update your email, password or delete your account",
+	"profile:read":  "Read your profile",
+	"profile:write": "Update your profile",
+	AdminScope:      "Manage the OAuth2 clients",
+	StaffScope:      "Act on the accounts of the other users with your roles",
+}
+
+// registeredScopes list the names of the registered scopes in alphabetical order
+func registeredScopes() []string {
+	names := make([]string, 0, len(Scopes))
+	for name := range Scopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateScopes check that every scope is part of the registry
+func validateScopes(scopes []string, param string) *servicehelper.Error {
+	for _, scope := range scopes {
+		if _, ok := Scopes[scope]; !ok {
+			return &servicehelper.Error{
+				Detail:  errors.New("unknown scope " + scope),
+				Message: "Scopes must be chosen among " + strings.Join(registeredScopes(), ", "),
+				Param:   param,
+				Code:    servicehelper.BadRequest,
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveScopes validate the scopes requested by a client and return the scopes to grant.
+// When no scope is requested, every scope allowed to the client except the admin and staff ones is granted
+func (s *service) ResolveScopes(clientId string, requestedScope string) (string, *servicehelper.Error) {
+	entity, err := s.repo.FindClientById(clientId)
+	if err != nil {
+		return "", &servicehelper.Error{
+			Detail:  errors.New("client could not be found"),
+			Message: "We could not find any client with the provided id",
+			Param:   "client_id",
+			Code:    servicehelper.NotFound,
+		}
+	}
+
+	allowedScopes := strings.Fields(entity.Scope)
+	requestedScopes := strings.Fields(requestedScope)
+	if len(requestedScopes) == 0 {
+		for _, scope := range allowedScopes {
+			if scope != AdminScope && scope != StaffScope {
+				requestedScopes = append(requestedScopes, scope)
+			}
+		}
+		return strings.Join(requestedScopes, " "), nil
+	}
+
+	if err := validateScopes(requestedScopes, "scope"); err != nil {
+		return "", err
+	}
+	for _, scope := range requestedScopes {
+		if !contains(allowedScopes, scope) {
+			return "", &servicehelper.Error{
+				Detail:  errors.New("scope " + scope + " is not allowed for this client"),
+				Message: "The client is not allowed to request the scope " + scope,
+				Param:   "scope",
+				Code:    servicehelper.Forbidden,
+			}
+		}
+	}
+	return strings.Join(requestedScopes, " "), nil
+}
@@ -8,18 +8,56 @@ import (
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
 	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/rest"
 	"github.com/adriendomoison/apigoboot/oauth2-micro-service/config"
+	"github.com/elithrar/simple-scrypt"
 	"github.com/go-errors/errors"
 	"github.com/jinzhu/gorm"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // RepoInterface is the model for the repo package of oauth2
 type RepoInterface interface {
 	FindByAccessToken(token string) (Access, error)
+	CreateClient(client Client) error
+	FindClientById(id string) (Client, error)
+	FindAllClients() ([]Client, error)
+	FindClientsByUserId(userId uint) ([]Client, error)
+	UpdateClient(client Client) error
+	DeleteClient(client Client) error
+	FindConsent(userId uint, clientId string) (Consent, error)
+	FindConsentsByUserId(userId uint) ([]Consent, error)
+	SaveConsent(consent Consent) error
+	DeleteConsent(consent Consent) error
+	DeleteTokensByUserIdAndClient(userId uint, clientId string) error
+	DeleteTokensByUserId(userId uint) error
+	FindAccessesByUserId(userId uint) ([]Access, error)
+	EraseUserData(userId uint) error
+	CreateDeviceAuthorization(deviceAuthorization DeviceAuthorization) error
+	FindDeviceAuthorizationByDeviceCode(deviceCode string) (DeviceAuthorization, error)
+	FindDeviceAuthorizationByUserCode(userCode string) (DeviceAuthorization, error)
+	UpdateDeviceAuthorization(deviceAuthorization DeviceAuthorization) error
+	DeleteDeviceAuthorization(deviceAuthorization DeviceAuthorization) error
+	FindUsedAssertion(clientId string, jti string) (UsedAssertion, error)
+	CreateUsedAssertion(usedAssertion UsedAssertion) error
+	CreateSession(session Session) error
+	FindSessionById(id string) (Session, error)
+	FindSessionsByUserId(userId uint) ([]Session, error)
+	UpdateSession(session Session) error
+	DeleteSession(session Session) error
+	PurgeAuthorizes(now time.Time, batchSize int) (int64, error)
+	PurgeRefreshes(now time.Time, batchSize int) (int64, error)
+	PurgeAccesses(now time.Time, batchSize int) (int64, error)
+	PurgeDeviceAuthorizations(now time.Time, batchSize int) (int64, error)
+	PurgeUsedAssertions(now time.Time, batchSize int) (int64, error)
+	PurgeSessions(now time.Time, batchSize int) (int64, error)
 }
 
 // Access database object
+// Actor is the space separated chain of the clients that obtained the token through a token exchange, the most recent one first
 type Access struct {
 	gorm.Model
 	Client       string `gorm:"NOT NULL"`
@@ -31,6 +69,12 @@ type Access struct {
 	ExpiresIn    int32  `gorm:"NOT NULL"`
 	Scope        string `gorm:"NOT NULL"`
 	RedirectUri  string `gorm:"NOT NULL"`
+	Actor        string
+}
+
+// ExpiresAt return the time after which the access token is not valid anymore
+func (a Access) ExpiresAt() time.Time {
+	return a.CreatedAt.Add(time.Duration(a.ExpiresIn) * time.Second)
 }
 
 // Authorize database object
@@ -45,14 +89,112 @@ type Authorize struct {
 	State       string `gorm:"NOT NULL"`
 }
 
+// ExpiresAt return the time after which the authorization code is not valid anymore
+func (a Authorize) ExpiresAt() time.Time {
+	return a.CreatedAt.Add(time.Duration(a.ExpiresIn) * time.Second)
+}
+
 // Client database object
+// RedirectUri hold every redirect uri of the client separated by RedirectUriSeparator,
+// GrantTypes and Scope are space separated lists, PublicKeys are the PEM encoded keys verifying the JWT assertions of the client.
+// Secret and PreviousSecret are scrypt hashes, the previous secret stays valid until its expiry to allow rotations without downtime
 type Client struct {
-	UserId      uint   `gorm:"NOT NULL"`
-	Id          string `gorm:"NOT NULL;PRIMARY KEY"`
-	Secret      string `gorm:"NOT NULL"`
-	RedirectUri string `gorm:"NOT NULL"`
+	UserId                  uint   `gorm:"NOT NULL"`
+	Id                      string `gorm:"NOT NULL;PRIMARY KEY"`
+	Secret                  string `gorm:"NOT NULL"`
+	SecretExpiresAt         *time.Time
+	PreviousSecret          string
+	PreviousSecretExpiresAt *time.Time
+	RedirectUri             string `gorm:"NOT NULL"`
+	Name                    string
+	GrantTypes              string
+	Scope                   string
+	Public                  bool
+	FirstParty              bool
+	LogoUri                 string
+	PrimaryColor            string
+	BackgroundColor         string
+	LogoutUri               string
+	PublicKeys              string `gorm:"type:text"`
+	CreatedAt               time.Time
+	UpdatedAt               time.Time
+}
+
+// Consent database object, Scope is the space separated list of scopes the user granted to the client
+type Consent struct {
+	gorm.Model
+	UserId   uint   `gorm:"NOT NULL;unique_index:idx_consent_user_client"`
+	ClientId string `gorm:"NOT NULL;unique_index:idx_consent_user_client"`
+	Scope    string `gorm:"NOT NULL"`
+}
+
+// SecretMatches check the secret against the active (not expired) secrets of the client.
+// Public clients only match an empty secret
+func (c Client) SecretMatches(secret string) bool {
+	if c.Public {
+		return secret == ""
+	}
+	if secret == "" {
+		return false
+	}
+	now := time.Now()
+	if c.Secret != "" && (c.SecretExpiresAt == nil || c.SecretExpiresAt.After(now)) &&
+		scrypt.CompareHashAndPassword([]byte(c.Secret), []byte(secret)) == nil {
+		return true
+	}
+	return c.PreviousSecret != "" && c.PreviousSecretExpiresAt != nil && c.PreviousSecretExpiresAt.After(now) &&
+		scrypt.CompareHashAndPassword([]byte(c.PreviousSecret), []byte(secret)) == nil
+}
+
+// HashClientSecret hash a client secret to be stored in database
+func HashClientSecret(secret string) (string, error) {
+	hashedSecret, err := scrypt.GenerateFromPassword([]byte(secret), scrypt.DefaultParams)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedSecret), nil
 }
 
+// IsHashedClientSecret check if a stored secret is already a scrypt hash
+func IsHashedClientSecret(secret string) bool {
+	_, err := scrypt.Cost([]byte(secret))
+	return err == nil
+}
+
+// DeviceAuthorization database object of a pending RFC 8628 device authorization.
+// Status is pending until the user approves or denies the request on the device page
+type DeviceAuthorization struct {
+	gorm.Model
+	DeviceCode   string    `gorm:"NOT NULL;unique_index"`
+	UserCode     string    `gorm:"NOT NULL;unique_index"`
+	ClientId     string    `gorm:"NOT NULL"`
+	Scope        string    `gorm:"NOT NULL"`
+	UserId       uint      `gorm:"NOT NULL"`
+	Status       string    `gorm:"NOT NULL"`
+	Interval     int       `gorm:"NOT NULL"`
+	ExpiresAt    time.Time `gorm:"NOT NULL"`
+	LastPolledAt *time.Time
+}
+
+// UsedAssertion database object remembering the jti of the JWT assertions already exchanged and the nonces of the consent tickets already used, until they expire
+type UsedAssertion struct {
+	ClientId  string    `gorm:"NOT NULL;PRIMARY KEY"`
+	Jti       string    `gorm:"NOT NULL;PRIMARY KEY"`
+	ExpiresAt time.Time `gorm:"NOT NULL"`
+}
+
+// Session database object of a single sign-on session, Clients is the space separated list of the clients authorized during the session
+type Session struct {
+	Id        string    `gorm:"NOT NULL;PRIMARY KEY"`
+	UserId    uint      `gorm:"NOT NULL"`
+	Clients   string    `gorm:"NOT NULL"`
+	ExpiresAt time.Time `gorm:"NOT NULL"`
+	CreatedAt time.Time
+}
+
+// RefreshTokenLifetime is how long a refresh token can be used after it has been issued
+const RefreshTokenLifetime = 30 * 24 * time.Hour
+
 // Refresh database object
 type Refresh struct {
 	gorm.Model
@@ -60,6 +202,11 @@ type Refresh struct {
 	Access string `gorm:"NOT NULL"`
 }
 
+// ExpiresAt return the time after which the refresh token is not valid anymore
+func (r Refresh) ExpiresAt() time.Time {
+	return r.CreatedAt.Add(RefreshTokenLifetime)
+}
+
 var privateBaseUrl = config.GAppUrl + "/api/private-v1"
 
 var _ rest.ServiceInterface = (*service)(nil)
@@ -73,15 +220,93 @@ func New(repo RepoInterface) *service {
 	return &service{repo}
 }
 
-// AskUserServiceToCheckCredentials call user service to check if the credentials are correct
-func (s *service) AskUserServiceToCheckCredentials(username string, password string, method string) (rest.ResponseDTOUserInfo, *apihelper.ApiErrors) {
+// AskUserServiceToCheckCredentials call user service to check if the credentials are correct, ip is the address of the user and is used to throttle failed logins
+func (s *service) AskUserServiceToCheckCredentials(username string, password string, method string, ip string) (rest.ResponseDTOUserInfo, *apihelper.ApiErrors) {
 
-	requestBody := rest.RequestDTOUserCredentials{
+	return askUserServiceToCheckCredentials(rest.RequestDTOUserCredentials{
 		Username: username,
 		Password: password,
-		Method:   method,
+		AuthType: method,
+		Ip:       ip,
+	})
+}
+
+// AskUserServiceToCheckSecondFactor call user service to complete a login waiting for a second factor with a TOTP code or a recovery code
+func (s *service) AskUserServiceToCheckSecondFactor(mfaToken string, code string, ip string) (rest.ResponseDTOUserInfo, *apihelper.ApiErrors) {
+	return askUserServiceToCheckCredentials(rest.RequestDTOUserCredentials{
+		AuthType: "mfa",
+		MfaToken: mfaToken,
+		Code:     code,
+		Ip:       ip,
+	})
+}
+
+// AskUserServiceToCheckWebauthnAssertion call user service to check the assertion signed by a passkey, as serialized by the login page
+func (s *service) AskUserServiceToCheckWebauthnAssertion(assertion json.RawMessage, ip string) (rest.ResponseDTOUserInfo, *apihelper.ApiErrors) {
+	return askUserServiceToCheckCredentials(rest.RequestDTOUserCredentials{
+		AuthType: "webauthn",
+		Webauthn: assertion,
+		Ip:       ip,
+	})
+}
+
+// AskUserServiceForWebauthnOptions call user service to start a passkey login, the options are given as they are to the browser
+func (s *service) AskUserServiceForWebauthnOptions(username string) (json.RawMessage, *servicehelper.Error) {
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(rest.RequestDTOWebauthnOptions{Username: username})
+
+	resp, err := http.Post(privateBaseUrl+"/user/webauthn/login-options", "application/json", b)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		if err == nil {
+			resp.Body.Close()
+		}
+		return nil, &servicehelper.Error{
+			Detail:  errors.New("could not retrieve webauthn options from the user service"),
+			Message: "We could not start the login with a passkey, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	defer resp.Body.Close()
+
+	options, err := ioutil.ReadAll(resp.Body)
+	if err != nil || !json.Valid(options) {
+		return nil, &servicehelper.Error{
+			Detail:  errors.New("invalid webauthn options sent by the user service"),
+			Message: "We could not start the login with a passkey, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
 	}
+	return options, nil
+}
 
+// AskUserServiceForVerificationEmail call user service to send a new link confirming the email of a user refused at login until it is confirmed
+func (s *service) AskUserServiceForVerificationEmail(email string) *servicehelper.Error {
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(rest.RequestDTOVerificationEmail{Email: email})
+
+	resp, err := http.Post(privateBaseUrl+"/user/verification-email", "application/json", b)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not send you a new link, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	defer resp.Body.Close()
+
+	// the email is checked by the user service, but whether it needs a link is never told
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusBadRequest {
+		return &servicehelper.Error{
+			Detail:  errors.New("user service answered " + resp.Status),
+			Message: "We could not send you a new link, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// askUserServiceToCheckCredentials send a login request to the user service
+func askUserServiceToCheckCredentials(requestBody rest.RequestDTOUserCredentials) (rest.ResponseDTOUserInfo, *apihelper.ApiErrors) {
 	b := new(bytes.Buffer)
 	json.NewEncoder(b).Encode(requestBody)
 
@@ -119,7 +344,48 @@ func (s *service) GetResourceOwnerId(token string) (rest.ResponseDTOUserInfo, *s
 			Detail: errors.New("failed to retrieve access token"),
 		}
 	}
-	return rest.ResponseDTOUserInfo{
+	resDTO := rest.ResponseDTOUserInfo{
 		UserId: accessToken.UserId,
-	}, nil
+		Scopes: strings.Fields(accessToken.Scope),
+		Actor:  buildActorClaim(strings.Fields(accessToken.Actor)),
+	}
+	if accessToken.UserId != 0 {
+		// the user is read at each check so that a suspension or a change of its roles applies to the tokens already issued
+		owner, err := askUserServiceForUser(accessToken.UserId)
+		if err != nil || owner.Status != userStatusActive {
+			if err != nil {
+				log.Printf("ERROR: could not retrieve user %d: %s\n", accessToken.UserId, err)
+			}
+			return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
+				Param:  "access_token",
+				Detail: errors.New("the owner of the access token is not an active user"),
+			}
+		}
+		// the roles are only loaded on the tokens the user issued for its staff work, so that a third party application can't act with them
+		if contains(resDTO.Scopes, StaffScope) {
+			resDTO.Roles, resDTO.Permissions = owner.Roles, owner.Permissions
+		}
+	}
+	return resDTO, nil
+}
+
+// userStatusActive is the status of the accounts of the user service that can use their tokens
+const userStatusActive = "active"
+
+// askUserServiceForUser call user service to retrieve the status of a user, its roles and the permissions they grant
+func askUserServiceForUser(userId uint) (rest.ResponseDTOUserInfo, error) {
+	resp, err := http.Get(privateBaseUrl + "/user/id/" + strconv.FormatUint(uint64(userId), 10))
+	if err != nil {
+		return rest.ResponseDTOUserInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return rest.ResponseDTOUserInfo{}, errors.New("the user service answered " + resp.Status)
+	}
+
+	userInfo := rest.ResponseDTOUserInfo{}
+	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
+		return rest.ResponseDTOUserInfo{}, err
+	}
+	return userInfo, nil
 }
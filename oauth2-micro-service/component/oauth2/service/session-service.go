@@ -0,0 +1,259 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/rest"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/config"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionLifetime is how long a user stays signed in on the authorization server
+const SessionLifetime = 12 * time.Hour
+
+// logoutNotificationTimeout bound the time spent notifying a client of a logout
+const logoutNotificationTimeout = 5 * time.Second
+
+// logoutTokenLifetime is how long a client accepts a logout token
+const logoutTokenLifetime = 2 * time.Minute
+
+// backchannelLogoutEvent is the event identifying the logout tokens (OpenID Connect Back-Channel Logout section 2.4)
+const backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// sessionSignaturePrefix separate the signatures of the session cookies from the other signed values
+const sessionSignaturePrefix = "session|"
+
+// CreateSession start a single sign-on session for the user and return it along with the signed value of its cookie
+func (s *service) CreateSession(userId uint) (rest.ResponseDTOSession, *servicehelper.Error) {
+	id, err := generateClientSecret()
+	if err != nil {
+		return rest.ResponseDTOSession{}, &servicehelper.Error{
+			Detail:  errors.New("could not generate session id"),
+			Message: "We could not sign you in, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+
+	session := Session{Id: id, UserId: userId, ExpiresAt: time.Now().Add(SessionLifetime)}
+	if err := s.repo.CreateSession(session); err != nil {
+		return rest.ResponseDTOSession{}, &servicehelper.Error{
+			Detail:  errors.New("session could not be created"),
+			Message: "We could not sign you in, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return rest.ResponseDTOSession{
+		Id:        session.Id,
+		UserId:    session.UserId,
+		Cookie:    session.Id + "." + signPayload(sessionSignaturePrefix+session.Id),
+		ExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// findSessionByCookie verify the signature of a session cookie and retrieve the session if it has not expired
+func (s *service) findSessionByCookie(cookie string) (Session, *servicehelper.Error) {
+	notFoundErr := &servicehelper.Error{
+		Detail:  errors.New("session could not be found"),
+		Message: "You are not signed in",
+		Param:   "session",
+		Code:    servicehelper.NotFound,
+	}
+	parts := strings.Split(cookie, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(signPayload(sessionSignaturePrefix+parts[0]))) {
+		return Session{}, notFoundErr
+	}
+	session, err := s.repo.FindSessionById(parts[0])
+	if err != nil {
+		return Session{}, notFoundErr
+	}
+	if session.ExpiresAt.Before(time.Now()) {
+		s.repo.DeleteSession(session)
+		return Session{}, notFoundErr
+	}
+	return session, nil
+}
+
+// GetSession retrieve the single sign-on session of a session cookie
+func (s *service) GetSession(cookie string) (rest.ResponseDTOSession, *servicehelper.Error) {
+	session, err := s.findSessionByCookie(cookie)
+	if err != nil {
+		return rest.ResponseDTOSession{}, err
+	}
+	return rest.ResponseDTOSession{Id: session.Id, UserId: session.UserId, Cookie: cookie, ExpiresAt: session.ExpiresAt}, nil
+}
+
+// AddClientToSession remember that a client has been authorized during the session, so its tokens are revoked on logout
+func (s *service) AddClientToSession(sessionId string, clientId string) *servicehelper.Error {
+	session, err := s.repo.FindSessionById(sessionId)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("session could not be found"),
+			Message: "You are not signed in",
+			Param:   "session",
+			Code:    servicehelper.NotFound,
+		}
+	}
+
+	clients := strings.Fields(session.Clients)
+	if contains(clients, clientId) {
+		return nil
+	}
+	session.Clients = strings.Join(append(clients, clientId), " ")
+	if err := s.repo.UpdateSession(session); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not update session"),
+			Message: "We could not sign you in, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// EndSession sign the user out, revoke the tokens issued to the clients authorized during the session
+// and notify the clients that registered a logout uri
+func (s *service) EndSession(cookie string) *servicehelper.Error {
+	session, err := s.findSessionByCookie(cookie)
+	if err != nil {
+		return err
+	}
+
+	for _, clientId := range strings.Fields(session.Clients) {
+		if err := s.repo.DeleteTokensByUserIdAndClient(session.UserId, clientId); err != nil {
+			return &servicehelper.Error{
+				Detail:  errors.New("could not revoke tokens"),
+				Message: "We could not sign you out, please try again later",
+				Code:    servicehelper.UnexpectedError,
+			}
+		}
+		if client, err := s.repo.FindClientById(clientId); err == nil && client.LogoutUri != "" {
+			go notifyLogout(client.LogoutUri, client.Id, session.UserId)
+		}
+	}
+
+	if err := s.repo.DeleteSession(session); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not delete session"),
+			Message: "We could not sign you out, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// RevokeUserTokens sign a user out everywhere: its sessions are ended, the clients are notified and every token issued for the user is revoked
+func (s *service) RevokeUserTokens(userId uint) *servicehelper.Error {
+	sessions, err := s.repo.FindSessionsByUserId(userId)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not find sessions"),
+			Message: "We could not sign out the user, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	for _, session := range sessions {
+		for _, clientId := range strings.Fields(session.Clients) {
+			if client, err := s.repo.FindClientById(clientId); err == nil && client.LogoutUri != "" {
+				go notifyLogout(client.LogoutUri, client.Id, session.UserId)
+			}
+		}
+		if err := s.repo.DeleteSession(session); err != nil {
+			return &servicehelper.Error{
+				Detail:  errors.New("could not delete session"),
+				Message: "We could not sign out the user, please try again later",
+				Code:    servicehelper.UnexpectedError,
+			}
+		}
+	}
+
+	if err := s.repo.DeleteTokensByUserId(userId); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not revoke tokens"),
+			Message: "We could not sign out the user, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// GetSigningKeys return the public key verifying the logout tokens, as a JSON Web Key Set
+func (s *service) GetSigningKeys() rest.ResponseDTOJwks {
+	return rest.ResponseDTOJwks{Keys: []rest.ResponseDTOJwk{signingJwk(&config.GSigningKey.PublicKey)}}
+}
+
+// signingJwk return the JSON Web Key of a P-256 public key, identified by its thumbprint (RFC 7638)
+func signingJwk(key *ecdsa.PublicKey) rest.ResponseDTOJwk {
+	jwk := rest.ResponseDTOJwk{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		Use: "sig",
+		Alg: "ES256",
+	}
+	thumbprint := sha256.Sum256([]byte(`{"crv":"` + jwk.Crv + `","kty":"` + jwk.Kty + `","x":"` + jwk.X + `","y":"` + jwk.Y + `"}`))
+	jwk.Kid = base64.RawURLEncoding.EncodeToString(thumbprint[:])
+	return jwk
+}
+
+// newLogoutToken sign a logout token telling a client that the user signed out (OpenID Connect Back-Channel Logout section 2.4)
+func newLogoutToken(clientId string, userId uint, now time.Time) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "typ": "logout+jwt", "kid": signingJwk(&config.GSigningKey.PublicKey).Kid})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":    config.GAppUrl,
+		"aud":    clientId,
+		"sub":    strconv.FormatUint(uint64(userId), 10),
+		"iat":    now.Unix(),
+		"exp":    now.Add(logoutTokenLifetime).Unix(),
+		"jti":    base64.RawURLEncoding.EncodeToString(jti),
+		"events": map[string]interface{}{backchannelLogoutEvent: map[string]interface{}{}},
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(signingInput))
+	r, sig, err := ecdsa.Sign(rand.Reader, config.GSigningKey, hash[:])
+	if err != nil {
+		return "", err
+	}
+	signature := append(r.FillBytes(make([]byte, 32)), sig.FillBytes(make([]byte, 32))...)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// notifyLogout post a signed logout token to the back-channel logout uri of a client, failures are only logged
+func notifyLogout(logoutUri string, clientId string, userId uint) {
+	logoutToken, err := newLogoutToken(clientId, userId, time.Now())
+	if err != nil {
+		log.Printf("ERROR: could not sign the logout token of client %s: %s\n", clientId, err)
+		return
+	}
+	client := &http.Client{Timeout: logoutNotificationTimeout}
+	resp, err := client.PostForm(logoutUri, url.Values{"logout_token": {logoutToken}})
+	if err != nil {
+		log.Printf("ERROR: could not notify logout to client %s: %s\n", clientId, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("ERROR: client %s answered %s to the logout notification\n", clientId, resp.Status)
+	}
+}
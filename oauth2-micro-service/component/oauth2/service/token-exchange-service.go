@@ -0,0 +1,86 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/rest"
+	"strings"
+)
+
+// buildActorClaim build the RFC 8693 act claim from the chain of actors of a token, the most recent actor first
+func buildActorClaim(actors []string) *rest.ResponseDTOActor {
+	if len(actors) == 0 {
+		return nil
+	}
+	return &rest.ResponseDTOActor{Sub: actors[0], Act: buildActorClaim(actors[1:])}
+}
+
+// ExchangeToken validate the access token of a user presented by a client and return the downscoped grant to issue to the client.
+// The scopes granted are the requested ones, or every scope of the subject token the client is allowed to request.
+// On failure the Detail of the error is the RFC 6749 error code to return to the client
+func (s *service) ExchangeToken(subjectToken string, clientId string, requestedScope string) (rest.ResponseDTOTokenExchangeGrant, *servicehelper.Error) {
+	subject, err := s.repo.FindByAccessToken(subjectToken)
+	if err != nil || subjectToken == "" {
+		return rest.ResponseDTOTokenExchangeGrant{}, &servicehelper.Error{
+			Detail:  errors.New("invalid_grant"),
+			Message: "The subject token is invalid or has expired",
+			Param:   "subject_token",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	if subject.UserId == 0 {
+		return rest.ResponseDTOTokenExchangeGrant{}, &servicehelper.Error{
+			Detail:  errors.New("invalid_grant"),
+			Message: "The subject token has not been issued to a user",
+			Param:   "subject_token",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+
+	client, err := s.repo.FindClientById(clientId)
+	if err != nil {
+		return rest.ResponseDTOTokenExchangeGrant{}, &servicehelper.Error{
+			Detail:  errors.New("invalid_client"),
+			Message: "We could not find any client with the provided id",
+			Param:   "client_id",
+			Code:    servicehelper.NotFound,
+		}
+	}
+
+	// a delegated token can only narrow the scopes of the subject token
+	subjectScopes := strings.Fields(subject.Scope)
+	allowedScopes := strings.Fields(client.Scope)
+	requestedScopes := strings.Fields(requestedScope)
+	if len(requestedScopes) == 0 {
+		for _, scope := range subjectScopes {
+			if scope != AdminScope && scope != StaffScope && contains(allowedScopes, scope) {
+				requestedScopes = append(requestedScopes, scope)
+			}
+		}
+	}
+	for _, scope := range requestedScopes {
+		if scope == AdminScope || !contains(subjectScopes, scope) || !contains(allowedScopes, scope) {
+			return rest.ResponseDTOTokenExchangeGrant{}, &servicehelper.Error{
+				Detail:  errors.New("invalid_scope"),
+				Message: "The scope " + scope + " can not be delegated to this client",
+				Param:   "scope",
+				Code:    servicehelper.Forbidden,
+			}
+		}
+	}
+	if len(requestedScopes) == 0 {
+		return rest.ResponseDTOTokenExchangeGrant{}, &servicehelper.Error{
+			Detail:  errors.New("invalid_scope"),
+			Message: "None of the scopes of the subject token can be delegated to this client",
+			Param:   "scope",
+			Code:    servicehelper.Forbidden,
+		}
+	}
+
+	return rest.ResponseDTOTokenExchangeGrant{
+		UserId: subject.UserId,
+		Scope:  strings.Join(requestedScopes, " "),
+		Actor:  strings.TrimSpace(clientId + " " + subject.Actor),
+	}, nil
+}
@@ -0,0 +1,86 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/oauth2-micro-service/component/oauth2/rest"
+	"strings"
+	"time"
+)
+
+// RetrieveUserData gather the applications a user granted access to, the clients it owns, its sessions and its valid tokens,
+// for the export of the personal data of the user. The values of the tokens and the secrets of the clients are never exported
+func (s *service) RetrieveUserData(userId uint) (rest.ResponseDTOUserData, *servicehelper.Error) {
+	unexpectedErr := &servicehelper.Error{
+		Detail:  errors.New("could not retrieve user data"),
+		Message: "We could not retrieve the authorizations of the user, please try again later",
+		Code:    servicehelper.UnexpectedError,
+	}
+
+	grantedApps, err := s.RetrieveGrantedApps(userId)
+	if err != nil {
+		return rest.ResponseDTOUserData{}, unexpectedErr
+	}
+	resDTO := rest.ResponseDTOUserData{
+		GrantedApps: grantedApps,
+		Clients:     []rest.ResponseDTOClient{},
+		Sessions:    []rest.ResponseDTOUserDataSession{},
+		Tokens:      []rest.ResponseDTOUserDataToken{},
+	}
+
+	clients, dbErr := s.repo.FindClientsByUserId(userId)
+	if dbErr != nil {
+		return rest.ResponseDTOUserData{}, unexpectedErr
+	}
+	for _, client := range clients {
+		resDTO.Clients = append(resDTO.Clients, createClientDTOFromEntity(client))
+	}
+
+	sessions, dbErr := s.repo.FindSessionsByUserId(userId)
+	if dbErr != nil {
+		return rest.ResponseDTOUserData{}, unexpectedErr
+	}
+	for _, session := range sessions {
+		resDTO.Sessions = append(resDTO.Sessions, rest.ResponseDTOUserDataSession{
+			Clients:   strings.Fields(session.Clients),
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+		})
+	}
+
+	accesses, dbErr := s.repo.FindAccessesByUserId(userId)
+	if dbErr != nil {
+		return rest.ResponseDTOUserData{}, unexpectedErr
+	}
+	now := time.Now()
+	for _, access := range accesses {
+		if access.ExpiresAt().Before(now) && access.RefreshToken == "" {
+			continue
+		}
+		resDTO.Tokens = append(resDTO.Tokens, rest.ResponseDTOUserDataToken{
+			ClientId:    access.Client,
+			Scopes:      strings.Fields(access.Scope),
+			IssuedAt:    access.CreatedAt,
+			ExpiresAt:   access.ExpiresAt(),
+			Refreshable: access.RefreshToken != "",
+		})
+	}
+	return resDTO, nil
+}
+
+// EraseUserData sign a user out everywhere and delete for good everything kept about it.
+// The clients it owns keep working for their other users, they are only unlinked from the user
+func (s *service) EraseUserData(userId uint) *servicehelper.Error {
+	if err := s.RevokeUserTokens(userId); err != nil {
+		return err
+	}
+	if err := s.repo.EraseUserData(userId); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not erase user data"),
+			Message: "We could not delete the authorizations of the user, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
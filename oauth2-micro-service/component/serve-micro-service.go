@@ -30,17 +30,20 @@ func main() {
 	router := gin.Default()
 	router.Use(cors.New(apitool.DefaultCORSConfig()))
 
-	// Init statics
-	if !config.GUnitTestingEnv {
-		router.LoadHTMLGlob("component/oauth2/rest/statics/templates/*")
-		router.Static("authentication/styles", "component/oauth2/rest/statics/styles")
-	}
+	// Init statics, embedded in the binary
+	rest.LoadPages(router)
 
 	// Oauth2 components
-	oauth2Component := oauth2.New(rest.New(initOAuthServer(), service.New(repo.New())))
+	oauth2Service := service.New(repo.New())
+	oauth2Component := oauth2.New(rest.New(initOAuthServer(), oauth2Service))
 	oauth2Component.AttachPublicAPI(router.Group("/authentication"))
 	oauth2Component.AttachPrivateAPI(router.Group("/api/private-v1/authentication"))
 
+	// Purge expired tokens in the background
+	if config.GCleanupInterval > 0 {
+		oauth2Service.StartCleanupScheduler(config.GCleanupInterval)
+	}
+
 	// Start router
 	go log.Println("Service oauth2 started: Navigate to " + config.GAppUrl)
 	router.Run(":" + config.GPort)
@@ -51,8 +54,9 @@ func initOAuthServer() *osin.Server {
 	serverConfig := osin.NewServerConfig()
 	serverConfig.AllowedAuthorizeTypes = osin.AllowedAuthorizeType{osin.CODE, osin.TOKEN}
 	serverConfig.AllowedAccessTypes = osin.AllowedAccessType{osin.AUTHORIZATION_CODE,
-		osin.REFRESH_TOKEN, osin.PASSWORD, osin.CLIENT_CREDENTIALS, osin.ASSERTION}
+		osin.REFRESH_TOKEN, osin.PASSWORD, osin.CLIENT_CREDENTIALS}
 	serverConfig.AllowGetAccessRequest = true
 	serverConfig.AllowClientSecretInParams = true
+	serverConfig.RedirectUriSeparator = service.RedirectUriSeparator
 	return osin.NewServer(serverConfig, repo.NewStorage(dbconn.DB.DB()))
 }
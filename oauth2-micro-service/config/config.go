@@ -2,8 +2,15 @@
 package config
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 // GAppName define the app name
@@ -25,8 +32,30 @@ var GPort string
 // GAppUrl is the application url
 var GAppUrl string
 
+// GDynamicClientRegistration define if clients can register themselves (RFC 7591), set DYNAMIC_CLIENT_REGISTRATION=true to enable it
+var GDynamicClientRegistration bool
+
+// GSecretKey is the key used to sign the values the service hands to the browser, SECRET_KEY is required outside of the dev environment
+var GSecretKey []byte
+
+// GSigningKey is the P-256 key signing the logout tokens sent to the clients, SIGNING_KEY (a PEM encoded EC private key) is required outside of the dev environment
+var GSigningKey *ecdsa.PrivateKey
+
+// GCleanupInterval is the time between two purges of the expired tokens, set CLEANUP_INTERVAL (e.g. 30m) to change it or to 0 to disable the purge
+var GCleanupInterval = time.Hour
+
 // init initialize the default environment
 func init() {
+	if cleanupInterval := os.Getenv("CLEANUP_INTERVAL"); cleanupInterval != "" {
+		interval, err := time.ParseDuration(cleanupInterval)
+		if err != nil {
+			log.Fatal("CLEANUP_INTERVAL is not a valid duration: ", err)
+		}
+		GCleanupInterval = interval
+	}
+
+	GDynamicClientRegistration, _ = strconv.ParseBool(os.Getenv("DYNAMIC_CLIENT_REGISTRATION"))
+
 	GPort = os.Getenv("PORT")
 	if GPort == "" {
 		GDevEnv = true
@@ -38,6 +67,39 @@ func init() {
 		GAppUrl = prodAppUrl
 		log.Println("Heroku Environement detected")
 	}
+
+	if secretKey := os.Getenv("SECRET_KEY"); secretKey != "" {
+		GSecretKey = []byte(secretKey)
+	} else if !GDevEnv {
+		log.Fatal("SECRET_KEY must be set, it signs the csrf tokens, the sessions and the consent tickets of every instance")
+	} else {
+		GSecretKey = make([]byte, 32)
+		if _, err := rand.Read(GSecretKey); err != nil {
+			log.Fatal("could not generate secret key: ", err)
+		}
+		log.Println("SECRET_KEY is not set, a random key has been generated for development and tests")
+	}
+
+	if signingKey := os.Getenv("SIGNING_KEY"); signingKey != "" {
+		block, _ := pem.Decode([]byte(signingKey))
+		if block == nil {
+			log.Fatal("SIGNING_KEY is not a PEM encoded key")
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil || key.Curve != elliptic.P256() {
+			log.Fatal("SIGNING_KEY is not a P-256 EC private key: ", err)
+		}
+		GSigningKey = key
+	} else if !GDevEnv {
+		log.Fatal("SIGNING_KEY must be set, it signs the logout tokens the clients check with the published keys")
+	} else {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			log.Fatal("could not generate signing key: ", err)
+		}
+		GSigningKey = key
+		log.Println("SIGNING_KEY is not set, a random key has been generated for development and tests")
+	}
 }
 
 // SetToTestingEnv set the test environment, this need to be called before testing to prevent the development database to be used
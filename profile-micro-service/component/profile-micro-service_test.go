@@ -23,18 +23,38 @@ var profilePublicId = ""
 func getAccessTokenOwnerUserIdMock(c *gin.Context) {
 	accessToken := c.Param("accessToken")
 	if accessToken == "XXX" {
-		c.JSON(http.StatusOK, gin.H{"user_id": 1})
+		c.JSON(http.StatusOK, gin.H{"user_id": 1, "scopes": []string{"user:read", "user:write", "profile:read", "profile:write"}})
+	} else if accessToken == "DELEGATED" {
+		c.JSON(http.StatusOK, gin.H{"user_id": 1, "scopes": []string{"profile:read"}, "act": gin.H{"sub": "user-micro-service"}})
+	} else if accessToken == "OTHER-APP" {
+		c.JSON(http.StatusOK, gin.H{"user_id": 1, "scopes": []string{"profile:read"}, "act": gin.H{"sub": "other-app"}})
+	} else if accessToken == "ADMIN" {
+		c.JSON(http.StatusOK, gin.H{"user_id": 0, "scopes": []string{"admin"}})
+	} else if accessToken == "SUPPORT" {
+		c.JSON(http.StatusOK, gin.H{"user_id": 2, "scopes": []string{"profile:read", "profile:write", "staff"}, "roles": []string{"support"}, "permissions": []string{"profiles:read"}})
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{})
 	}
 }
 
+// deactivatedUserStatus is the status of the user test01@example.dev, it is not found when it is deleted
+var deactivatedUserStatus = "active"
+
 func getUserById(c *gin.Context) {
 	userId := c.Param("userId")
 	if userId == "1" {
 		c.JSON(http.StatusOK, rest.ResponseDTOUserInfo{
 			Email:  "test00@example.dev",
 			UserId: 1,
+			Status: "active",
+		})
+	} else if userId == "3" && deactivatedUserStatus == "deleted" {
+		c.JSON(http.StatusNotFound, gin.H{"errors": []gin.H{{"detail": "no result found"}}})
+	} else if userId == "3" {
+		c.JSON(http.StatusOK, rest.ResponseDTOUserInfo{
+			Email:  "test01@example.dev",
+			UserId: 3,
+			Status: deactivatedUserStatus,
 		})
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{})
@@ -48,6 +68,11 @@ func getUserByEmail(c *gin.Context) {
 			Email:  "test00@example.dev",
 			UserId: 1,
 		})
+	} else if email == "test01@example.dev" {
+		c.JSON(http.StatusOK, rest.ResponseDTOUserInfo{
+			Email:  "test01@example.dev",
+			UserId: 3,
+		})
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{})
 	}
@@ -235,6 +260,142 @@ func TestPutMissingParam(t *testing.T) {
 	}
 }
 
+func TestSupportRole(t *testing.T) {
+
+	// init test variable
+	publicId := profilePublicId
+
+	// test the support staff can read the profile of another user
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/profiles/" + publicId,
+		Authorization: "Bearer SUPPORT",
+	}, nil, &rest.ResponseDTO{})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+
+	// test the support staff can't edit it
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "PUT",
+		URL:           publicBaseUrl + "/profiles/" + publicId,
+		ContentType:   "application/json",
+		Authorization: "Bearer SUPPORT",
+	}, rest.RequestDTO{PublicId: publicId, FirstName: "Jack", LastName: "Doe", Birthday: "1980-10-20", ProfilePictureUrl: "http://new.picture.dev/pic"}, &rest.ResponseDTO{})
+	resp.Body.Close()
+	if resp.StatusCode != 403 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "403", resp.Status)
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	getUserProfile := func(userId string, authorization string) int {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method:        "GET",
+			URL:           publicBaseUrl + "/users/" + userId + "/profile",
+			Authorization: authorization,
+		}, nil, &rest.ResponseDTO{})
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// test the owner and the user micro service acting on its behalf can read the profile
+	if status := getUserProfile("1", "Bearer XXX"); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+	if status := getUserProfile("1", "Bearer DELEGATED"); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+
+	// test another client acting on behalf of the owner or another user can't
+	if status := getUserProfile("1", "Bearer OTHER-APP"); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+	if status := getUserProfile("3", "Bearer XXX"); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+	if status := getUserProfile("1", ""); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+}
+
+func TestDeactivatedUserProfile(t *testing.T) {
+
+	// init test variable
+	var profileDTO rest.ResponseDTO
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:      "POST",
+		URL:         privateBaseUrl + "/profiles",
+		ContentType: "application/json",
+	}, rest.RequestDTOCreation{FirstName: "Jane", LastName: "Doe", Birthday: "1982-01-02", Email: "test01@example.dev"}, &profileDTO)
+	resp.Body.Close()
+	getProfile := func() int {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method:        "GET",
+			URL:           publicBaseUrl + "/profiles/" + profileDTO.PublicId,
+			Authorization: "Bearer SUPPORT",
+		}, nil, &rest.ResponseDTO{})
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// test the profile of an active user is found
+	if status := getProfile(); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+
+	// test the profile of a deactivated user is hidden
+	deactivatedUserStatus = "deactivated"
+	defer func() { deactivatedUserStatus = "active" }()
+	if status := getProfile(); status != 404 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 404, status)
+	}
+
+	// test the profile of a deleted user is hidden
+	deactivatedUserStatus = "deleted"
+	if status := getProfile(); status != 404 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 404, status)
+	}
+}
+
+func TestUserData(t *testing.T) {
+
+	// test the profile of test01@example.dev created by TestDeactivatedUserProfile is exported
+	var profiles []rest.ResponseDTOUserData
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/users/3/profiles",
+	}, nil, &profiles)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if len(profiles) != 1 || profiles[0].FirstName != "Jane" || profiles[0].Birthday != "1982-01-02" {
+		t.Errorf("Expected %s to be %s, got %v", "profiles", "the profile of Jane", profiles)
+	}
+
+	// test the profiles of the user are erased
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "DELETE",
+		URL:    privateBaseUrl + "/users/3/profiles",
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+	profiles = nil
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/users/3/profiles",
+	}, nil, &profiles)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if len(profiles) != 0 {
+		t.Errorf("Expected %s to be %s, got %v", "profiles", "empty", profiles)
+	}
+}
+
 func TestDelete(t *testing.T) {
 
 	// init test variable
@@ -259,3 +420,51 @@ func TestDelete(t *testing.T) {
 		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
 	}
 }
+
+func TestDeletedProfiles(t *testing.T) {
+
+	// init test variable
+	restore := func(authorization string) int {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method:        "POST",
+			URL:           publicBaseUrl + "/admin/deleted-profiles/" + profilePublicId + "/restore",
+			Authorization: authorization,
+		}, nil, nil)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// test the profile deleted by TestDelete is listed until the end of the retention period
+	var list rest.ResponseDTODeletedProfileList
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/admin/deleted-profiles?user_id=1",
+		Authorization: "Bearer SUPPORT",
+	}, nil, &list)
+	resp.Body.Close()
+	if len(list.Profiles) != 1 || list.Profiles[0].PublicId != profilePublicId {
+		t.Errorf("Expected %s to be %s, got %v", "deleted profiles", profilePublicId, list.Profiles)
+	} else if purgeAt := list.Profiles[0].DeletedAt.Add(config.GProfileRetentionPeriod); !list.Profiles[0].PurgeAt.Equal(purgeAt) {
+		t.Errorf("Expected %s to be %v, got %v", "purge date", purgeAt, list.Profiles[0].PurgeAt)
+	}
+
+	// test only the administrators and the roles allowed to edit the profiles restore them
+	if status := restore("Bearer SUPPORT"); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+	if status := restore("Bearer ADMIN"); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/profiles/" + profilePublicId,
+		Authorization: "Bearer XXX",
+	}, nil, &rest.ResponseDTO{})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, resp.StatusCode)
+	}
+	if status := restore("Bearer ADMIN"); status != 404 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 404, status)
+	}
+}
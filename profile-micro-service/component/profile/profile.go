@@ -2,16 +2,25 @@
 package profile
 
 import (
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
+	"github.com/adriendomoison/apigoboot/profile-micro-service/config"
 	"github.com/gin-gonic/gin"
 )
 
 // RestInterface is the model for the rest package of profile
 type RestInterface interface {
 	ValidateAccessToken(*gin.Context)
+	ValidateUserAccessToken(*gin.Context)
+	ValidateAdminAccessToken(*gin.Context)
 	Post(*gin.Context)
 	Get(*gin.Context)
+	GetUserProfile(*gin.Context)
 	Put(*gin.Context)
 	Delete(*gin.Context)
+	GetUserData(*gin.Context)
+	DeleteUserData(*gin.Context)
+	GetDeletedProfiles(*gin.Context)
+	PostRestore(*gin.Context)
 }
 
 // Component implement interface component
@@ -26,12 +35,17 @@ func New(rest RestInterface) *Component {
 
 // AttachPublicAPI add the profile micro-service public api with its dependencies
 func (ms *Component) AttachPublicAPI(group *gin.RouterGroup) {
-	group.GET("/profiles/:profileId", ms.rest.ValidateAccessToken, ms.rest.Get)
-	group.PUT("/profiles/:profileId", ms.rest.ValidateAccessToken, ms.rest.Put)
+	group.GET("/profiles/:profileId", ms.rest.ValidateAccessToken, apitool.Authorize("owner OR scope:staff AND permission:profiles:read"), apitool.RequireScopes("profile:read"), ms.rest.Get)
+	group.PUT("/profiles/:profileId", ms.rest.ValidateAccessToken, apitool.Authorize("owner OR scope:staff AND permission:profiles:write"), apitool.RequireScopes("profile:write"), ms.rest.Put)
+	group.GET("/users/:userId/profile", ms.rest.ValidateUserAccessToken, apitool.Authorize("owner OR scope:staff AND permission:profiles:read"), apitool.RequireScopes("profile:read"), apitool.AllowActors(config.GUserServiceClientId), ms.rest.GetUserProfile)
+	group.GET("/admin/deleted-profiles", ms.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:profiles:read"), ms.rest.GetDeletedProfiles)
+	group.POST("/admin/deleted-profiles/:profileId/restore", ms.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:profiles:write"), ms.rest.PostRestore)
 }
 
 // AttachPrivateAPI add the profile micro-service private api with its dependencies
 func (ms *Component) AttachPrivateAPI(group *gin.RouterGroup) {
 	group.POST("/profiles", ms.rest.Post)
 	group.DELETE("/profiles/:profileId", ms.rest.Delete)
+	group.GET("/users/:userId/profiles", ms.rest.GetUserData)
+	group.DELETE("/users/:userId/profiles", ms.rest.DeleteUserData)
 }
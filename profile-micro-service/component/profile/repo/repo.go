@@ -2,8 +2,11 @@
 package repo
 
 import (
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
 	"github.com/adriendomoison/apigoboot/profile-micro-service/component/profile/service"
 	"github.com/adriendomoison/apigoboot/profile-micro-service/database/dbconn"
+	"github.com/jinzhu/gorm"
+	"time"
 )
 
 // Make sure the interface is implemented correctly
@@ -44,12 +47,68 @@ func (crud *repo) FindByPublicId(publicId string) (profile service.Entity, err e
 	return profile, nil
 }
 
+// FindByUserId find the profiles of a user in Database
+func (crud *repo) FindByUserId(userId uint) (profiles []service.Entity, err error) {
+	if err = dbconn.DB.Where("user_id = ?", userId).Order("id").Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
 // Update edit profile in Database
 func (crud *repo) Update(profile service.Entity) error {
 	return dbconn.DB.Save(&profile).Error
 }
 
-// Delete remove profile from Database
+// Delete soft delete profile in Database, it is kept until it is purged
 func (crud *repo) Delete(profile service.Entity) error {
 	return dbconn.DB.Delete(&profile).Error
 }
+
+// FindDeleted find the deleted profiles matching a query in Database
+func (crud *repo) FindDeleted(query apitool.Query) (profiles []service.Entity, err error) {
+	db := dbconn.DB.Unscoped().Where("deleted_at IS NOT NULL")
+	if conditions, args := query.Conditions(); conditions != "" {
+		db = db.Where(conditions, args...)
+	}
+	if query.Limit() > 0 {
+		db = db.Limit(query.Limit())
+	}
+	if order := query.Order(); order != "" {
+		db = db.Order(order)
+	}
+	if err = db.Order("id").Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// FindDeletedByPublicId find a deleted profile in Database by public_id
+func (crud *repo) FindDeletedByPublicId(publicId string) (profile service.Entity, err error) {
+	if err = dbconn.DB.Unscoped().Where("public_id = ? AND deleted_at IS NOT NULL", publicId).First(&profile).Error; err != nil {
+		return service.Entity{}, err
+	}
+	return profile, nil
+}
+
+// Restore bring a deleted profile back in Database
+func (crud *repo) Restore(profile service.Entity) error {
+	return dbconn.DB.Unscoped().Model(&profile).UpdateColumn("deleted_at", gorm.Expr("NULL")).Error
+}
+
+// PurgeDeleted remove the profiles deleted before a date from Database for good and return how many have been removed
+func (crud *repo) PurgeDeleted(deletedBefore time.Time) (purged int64, err error) {
+	db := dbconn.DB.Unscoped().Where("deleted_at < ?", deletedBefore).Delete(service.Entity{})
+	return db.RowsAffected, db.Error
+}
+
+// DeleteByUserId remove the profiles of a user from Database for good, including the ones already soft deleted
+func (crud *repo) DeleteByUserId(userId uint) error {
+	return dbconn.DB.Unscoped().Where("user_id = ?", userId).Delete(service.Entity{}).Error
+}
+
+// RunExclusively run a job while holding a database advisory lock, so a single instance runs it at a time.
+// It returns false without running the job when another instance holds the lock
+func (crud *repo) RunExclusively(lock int64, job func()) (ran bool, err error) {
+	return apitool.RunExclusively(dbconn.DB.DB(), lock, job)
+}
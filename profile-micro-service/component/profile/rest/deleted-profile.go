@@ -0,0 +1,57 @@
+// Package rest implement the callback required by the profile package
+package rest
+
+import (
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// RequestDTOListDeletedProfiles is the object to map the query parameters of requests listing the deleted profiles.
+// Sort is deleted_at, prefixed by - for a descending order, and Cursor is the next_cursor of the previous page
+type RequestDTOListDeletedProfiles struct {
+	Limit  int    `form:"limit"`
+	Cursor string `form:"cursor"`
+	Sort   string `form:"sort"`
+	UserId uint   `form:"user_id"`
+}
+
+// ResponseDTODeletedProfile is the object to map JSON response body describing a deleted profile, it can be restored until PurgeAt
+type ResponseDTODeletedProfile struct {
+	PublicId  string    `json:"profile_id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	UserId    uint      `json:"user_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+// ResponseDTODeletedProfileList is the object to map JSON response body of a page of deleted profiles, NextCursor is empty on the last page
+type ResponseDTODeletedProfileList struct {
+	Profiles   []ResponseDTODeletedProfile `json:"profiles"`
+	NextCursor string                      `json:"next_cursor,omitempty"`
+}
+
+// GetDeletedProfiles allows an administrator to list the deleted profiles that can still be restored
+func (r *rest) GetDeletedProfiles(c *gin.Context) {
+	var reqDTO RequestDTOListDeletedProfiles
+	if err := c.Bind(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.ListDeletedProfiles(reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
+
+// PostRestore allows an administrator to bring back a deleted profile before the end of the retention period
+func (r *rest) PostRestore(c *gin.Context) {
+	if err := r.service.Restore(c.Param("profileId")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "profile has been restored successfully"})
+	}
+}
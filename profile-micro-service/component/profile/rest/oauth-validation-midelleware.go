@@ -3,20 +3,23 @@ package rest
 
 import (
 	"encoding/json"
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
 	"github.com/adriendomoison/apigoboot/profile-micro-service/config"
 	"github.com/gin-gonic/gin"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 )
 
 // ResponseDTOUserInfo is the object to map JSON response body when requesting basic user info
 type ResponseDTOUserInfo struct {
 	Email  string `json:"email"`
 	UserId uint   `json:"user_id"`
+	Status string `json:"status"`
 }
 
-func askOauthServiceForTokenOwnerUserId(token string) (uint, int, *apihelper.ApiErrors) {
+func askOauthServiceForTokenOwnerUserId(token string) (apitool.TokenOwner, int, *apihelper.ApiErrors) {
 
 	req, err := http.NewRequest("GET", config.GAppUrl+"/api/private-v1/access-token/"+token+"/get-owner", nil)
 	// TODO add client credential access token
@@ -31,18 +34,17 @@ func askOauthServiceForTokenOwnerUserId(token string) (uint, int, *apihelper.Api
 
 	body, _ := ioutil.ReadAll(resp.Body)
 
-	accessTokenOwner := struct {
-		UserId uint `json:"user_id"`
-	}{}
+	var accessTokenOwner apitool.TokenOwner
 	if json.Unmarshal(body, &accessTokenOwner) != nil {
 		apiErrors := apihelper.ApiErrors{}
 		json.Unmarshal(body, &apiErrors)
-		return 0, resp.StatusCode, &apiErrors
+		return apitool.TokenOwner{}, resp.StatusCode, &apiErrors
 	}
-	return accessTokenOwner.UserId, 0, nil
+	return accessTokenOwner, 0, nil
 }
 
-// ValidateAccessToken check oauth2 access token (middleware)
+// ValidateAccessToken check oauth2 access token and whether its owner is the owner of the profile (middleware).
+// It must be followed by apitool.Authorize to restrict the route to the owner or to the roles allowed to act on other profiles
 func (r *rest) ValidateAccessToken(c *gin.Context) {
 
 	authorizationCode := c.Request.Header.Get("Authorization")
@@ -59,18 +61,60 @@ func (r *rest) ValidateAccessToken(c *gin.Context) {
 		return
 	}
 
-	tokenUserId, _, err := askOauthServiceForTokenOwnerUserId(authorizationCode[7:])
+	tokenOwner, _, err := askOauthServiceForTokenOwnerUserId(authorizationCode[7:])
 	if err != nil {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
 
-	if ok, err := r.service.IsThatTheUserId(publicId, tokenUserId); err != nil {
-		c.JSON(apihelper.BuildResponseError(err))
+	isOwner, svcErr := r.service.IsThatTheUserId(publicId, tokenOwner.UserId)
+	if svcErr != nil {
+		c.JSON(apihelper.BuildResponseError(svcErr))
+		c.Abort()
 		return
-	} else if !ok {
+	}
+	apitool.SetTokenOwner(c, tokenOwner, isOwner)
+	c.Next()
+}
+
+// ValidateUserAccessToken check oauth2 access token and whether its owner is the user of the request (middleware).
+// It must be followed by apitool.Authorize to restrict the route to the owner or to the roles allowed to act on other users
+func (r *rest) ValidateUserAccessToken(c *gin.Context) {
+	token := apitool.BearerToken(c)
+	if token == "" {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	userId, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	tokenOwner, _, apiErrors := askOauthServiceForTokenOwnerUserId(token)
+	if apiErrors != nil {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	apitool.SetTokenOwner(c, tokenOwner, tokenOwner.UserId != 0 && tokenOwner.UserId == uint(userId))
+	c.Next()
+}
+
+// ValidateAdminAccessToken check the access token of a request that does not act on a profile of the token owner (middleware).
+// It must be followed by apitool.Authorize to restrict the route to the tokens granted the admin scope or to the roles allowed
+func (r *rest) ValidateAdminAccessToken(c *gin.Context) {
+	token := apitool.BearerToken(c)
+	if token == "" {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	tokenOwner, _, err := askOauthServiceForTokenOwnerUserId(token)
+	if err != nil {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
+	apitool.SetTokenOwner(c, tokenOwner, false)
 	c.Next()
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/adriendomoison/apigoboot/profile-micro-service/component/profile"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
 )
 
 // ServiceInterface is the model for the service package of profile
@@ -14,9 +15,14 @@ type ServiceInterface interface {
 	GetResourceOwnerId(email string) uint
 	Add(creation RequestDTOCreation) (ResponseDTO, *servicehelper.Error)
 	Retrieve(string) (ResponseDTO, *servicehelper.Error)
+	RetrieveByUserId(userId uint) (ResponseDTO, *servicehelper.Error)
 	Edit(RequestDTO) (ResponseDTO, *servicehelper.Error)
 	Remove(string) *servicehelper.Error
 	IsThatTheUserId(string, uint) (bool, *servicehelper.Error)
+	RetrieveUserData(userId uint) ([]ResponseDTOUserData, *servicehelper.Error)
+	EraseUserData(userId uint) *servicehelper.Error
+	ListDeletedProfiles(reqDTO RequestDTOListDeletedProfiles) (ResponseDTODeletedProfileList, *servicehelper.Error)
+	Restore(publicId string) *servicehelper.Error
 }
 
 // RequestDTOCreation is the object to map JSON request body of a profile creation request
@@ -85,6 +91,20 @@ func (r *rest) Get(c *gin.Context) {
 	}
 }
 
+// GetUserProfile allows to access the service to retrieve the profile of a user from its user id
+func (r *rest) GetUserProfile(c *gin.Context) {
+	userId, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+		return
+	}
+	if resDTO, err := r.service.RetrieveByUserId(uint(userId)); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTO)
+	}
+}
+
 // Put allows to access the service to update the properties of a profile
 func (r *rest) Put(c *gin.Context) {
 	var reqDTO RequestDTO
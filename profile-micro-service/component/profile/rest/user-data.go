@@ -0,0 +1,50 @@
+// Package rest implement the callback required by the profile package
+package rest
+
+import (
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ResponseDTOUserData is the object to map JSON response body of a profile in the export of the personal data of a user
+type ResponseDTOUserData struct {
+	PublicId          string    `json:"profile_id"`
+	FirstName         string    `json:"first_name"`
+	LastName          string    `json:"last_name"`
+	ProfilePictureUrl string    `json:"profile_picture_url"`
+	Birthday          string    `json:"birthday"`
+	OrderAmount       uint      `json:"order_amount"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// GetUserData allows the user micro service to export the profiles of a user (private API)
+func (r *rest) GetUserData(c *gin.Context) {
+	userId, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+		return
+	}
+	if resDTOs, err := r.service.RetrieveUserData(uint(userId)); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTOs)
+	}
+}
+
+// DeleteUserData allows the user micro service to erase the profiles of a user (private API)
+func (r *rest) DeleteUserData(c *gin.Context) {
+	userId, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+		return
+	}
+	if err := r.service.EraseUserData(uint(userId)); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "user profiles have been erased successfully"})
+	}
+}
@@ -0,0 +1,114 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/profile-micro-service/component/profile/rest"
+	"github.com/adriendomoison/apigoboot/profile-micro-service/config"
+	"log"
+	"time"
+)
+
+// deletedProfileSortFields map the fields the deleted profiles can be sorted by to their column
+var deletedProfileSortFields = map[string]string{"deleted_at": "deleted_at"}
+
+// ListDeletedProfiles return a page of the deleted profiles that can still be restored, the last deleted first by default
+func (s *service) ListDeletedProfiles(reqDTO rest.RequestDTOListDeletedProfiles) (resDTO rest.ResponseDTODeletedProfileList, error *servicehelper.Error) {
+	page, err := apitool.NewPage(reqDTO.Limit, reqDTO.Cursor, reqDTO.Sort, "-deleted_at", deletedProfileSortFields)
+	if err != nil {
+		return rest.ResponseDTODeletedProfileList{}, err
+	}
+
+	var query apitool.Query
+	query.Where("deleted_at >= ?", time.Now().Add(-config.GProfileRetentionPeriod))
+	if reqDTO.UserId != 0 {
+		query.Where("user_id = ?", reqDTO.UserId)
+	}
+	query.Paginate(page)
+
+	entities, dbErr := s.repo.FindDeleted(query)
+	if dbErr != nil {
+		return rest.ResponseDTODeletedProfileList{}, &servicehelper.Error{
+			Detail:  dbErr,
+			Message: "We could not list the deleted profiles, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	if len(entities) > page.Limit {
+		entities = entities[:page.Limit]
+		last := entities[len(entities)-1]
+		resDTO.NextCursor = page.Cursor(*last.DeletedAt, last.ID)
+	}
+	resDTO.Profiles = make([]rest.ResponseDTODeletedProfile, 0, len(entities))
+	for _, entity := range entities {
+		resDTO.Profiles = append(resDTO.Profiles, rest.ResponseDTODeletedProfile{
+			PublicId:  entity.PublicId,
+			FirstName: entity.FirstName,
+			LastName:  entity.LastName,
+			UserId:    entity.UserID,
+			DeletedAt: *entity.DeletedAt,
+			PurgeAt:   entity.DeletedAt.Add(config.GProfileRetentionPeriod),
+		})
+	}
+	return resDTO, nil
+}
+
+// Restore bring back a deleted profile until the end of the retention period
+func (s *service) Restore(publicId string) *servicehelper.Error {
+	entity, err := s.repo.FindDeletedByPublicId(publicId)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not find deleted profile"),
+			Message: "We could not find any deleted profile, please check the provided public_id",
+			Param:   "public_id",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if entity.DeletedAt.Before(time.Now().Add(-config.GProfileRetentionPeriod)) {
+		return &servicehelper.Error{
+			Detail:  errors.New("retention period is over"),
+			Message: "This profile has been deleted for too long to be restored, it is being purged",
+			Param:   "public_id",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	if err := s.repo.Restore(entity); err != nil {
+		return &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not restore the profile, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// purgeSchedulerLock is the key of the advisory lock taken by the instance purging the deleted profiles
+const purgeSchedulerLock int64 = 7318003
+
+// PurgeDeletedProfiles delete for good the profiles deleted before the retention period and return how many have been purged
+func (s *service) PurgeDeletedProfiles() (purged int64, err error) {
+	return s.repo.PurgeDeleted(time.Now().Add(-config.GProfileRetentionPeriod))
+}
+
+// StartPurgeScheduler purge the profiles at the end of their retention period now and then at every interval, in the background.
+// The instances share an advisory lock so a single one purges the profiles at a time
+func (s *service) StartPurgeScheduler(interval time.Duration) {
+	go func() {
+		for {
+			var purged int64
+			var err error
+			if _, lockErr := s.repo.RunExclusively(purgeSchedulerLock, func() {
+				purged, err = s.PurgeDeletedProfiles()
+			}); lockErr != nil {
+				log.Println("ERROR: could not lock the purge of the deleted profiles:", lockErr)
+			} else if err != nil {
+				log.Println("ERROR: could not purge the deleted profiles:", err)
+			} else if purged > 0 {
+				log.Printf("%d deleted profiles purged\n", purged)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -3,6 +3,7 @@ package service
 
 import (
 	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
 	"github.com/adriendomoison/apigoboot/api-tool/gentool"
 	"github.com/adriendomoison/apigoboot/profile-micro-service/component/profile/rest"
@@ -16,8 +17,15 @@ type RepoInterface interface {
 	Create(profile Entity) bool
 	FindByID(id uint) (profile Entity, err error)
 	FindByPublicId(publicId string) (profile Entity, err error)
+	FindByUserId(userId uint) (profiles []Entity, err error)
 	Update(profile Entity) error
 	Delete(profile Entity) error
+	FindDeleted(query apitool.Query) (profiles []Entity, err error)
+	FindDeletedByPublicId(publicId string) (profile Entity, err error)
+	Restore(profile Entity) error
+	PurgeDeleted(deletedBefore time.Time) (purged int64, err error)
+	DeleteByUserId(userId uint) error
+	RunExclusively(lock int64, job func()) (ran bool, err error)
 }
 
 // Entity is the model of a profile in the database
@@ -59,18 +67,27 @@ func (s *service) GetResourceOwnerId(publicId string) (userId uint) {
 	return entity.UserID
 }
 
-// createDTOFromEntity copy all data from an entity to a Response DTO
+// hiddenUserStatuses are the statuses of the users whose profile is hidden, as if it did not exist
+var hiddenUserStatuses = map[string]bool{"deactivated": true, "pending_deletion": true}
+
+// createDTOFromEntity copy all data from an entity to a Response DTO, the profiles of the deactivated and deleted users are not found
 func createDTOFromEntity(entity Entity) (resDTO rest.ResponseDTO, error *servicehelper.Error) {
 	copier.Copy(&resDTO, &entity)
 	location, _ := time.LoadLocation("UTC")
 	resDTO.Birthday = entity.Birthday.In(location).Format("2006-01-02")
 	userInfo, err := askUserServiceForUserEmail(entity.UserID)
-	if err != nil {
+	if err != nil && err.Code == servicehelper.NotFound {
+		// the user has been deleted
+		return rest.ResponseDTO{}, &servicehelper.Error{Detail: errors.New("no result found"), Code: servicehelper.NotFound}
+	} else if err != nil {
 		return rest.ResponseDTO{}, &servicehelper.Error{
 			Detail: errors.New("can't retrieve user associated to this profile"),
 			Code:   servicehelper.UnexpectedError,
 		}
 	}
+	if hiddenUserStatuses[userInfo.Status] {
+		return rest.ResponseDTO{}, &servicehelper.Error{Detail: errors.New("no result found"), Code: servicehelper.NotFound}
+	}
 	resDTO.Email = userInfo.Email
 	return resDTO, error
 }
@@ -122,6 +139,15 @@ func (s *service) Retrieve(publicId string) (resDTO rest.ResponseDTO, error *ser
 	return createDTOFromEntity(entity)
 }
 
+// RetrieveByUserId ask database to retrieve the first profile of a user
+func (s *service) RetrieveByUserId(userId uint) (resDTO rest.ResponseDTO, error *servicehelper.Error) {
+	profiles, err := s.repo.FindByUserId(userId)
+	if err != nil || len(profiles) == 0 {
+		return rest.ResponseDTO{}, &servicehelper.Error{Detail: errors.New("no result found"), Code: servicehelper.NotFound}
+	}
+	return createDTOFromEntity(profiles[0])
+}
+
 // Edit edit user profile and ask database to save changes
 func (s *service) Edit(reqDTO rest.RequestDTO) (resDTO rest.ResponseDTO, error *servicehelper.Error) {
 	entity, err := s.repo.FindByPublicId(reqDTO.PublicId)
@@ -147,7 +173,7 @@ func (s *service) Edit(reqDTO rest.RequestDTO) (resDTO rest.ResponseDTO, error *
 	return createDTOFromEntity(entity)
 }
 
-// Remove find a profile in the database and delete it
+// Remove find a profile in the database and soft delete it, an administrator can restore it until the end of the retention period
 func (s *service) Remove(publicId string) (error *servicehelper.Error) {
 	if entity, err := s.repo.FindByPublicId(publicId); err != nil {
 		return &servicehelper.Error{
@@ -14,15 +14,24 @@ import (
 
 var userBaseUrl = "http://api.user.apigoboot:4200/api/private-v1/user"
 
+// createErrorFromApiErrors rebuild the first error returned by the user micro service, the errors are decoded as JSON objects
+func createErrorFromApiErrors(apiErrors apihelper.ApiErrors, statusCode int) *servicehelper.Error {
+	apiError, _ := apiErrors.Errors[0].(map[string]interface{})
+	detail, _ := apiError["detail"].(string)
+	message, _ := apiError["message"].(string)
+	param, _ := apiError["param"].(string)
+	return &servicehelper.Error{
+		Detail:  errors.New(detail),
+		Message: message,
+		Param:   param,
+		Code:    servicehelper.Code(statusCode),
+	}
+}
+
 func askUserServiceForUserId(email string) (rest.ResponseDTOUserInfo, *servicehelper.Error) {
 	resDTO, apiErrors, statusCode := callGetUserIdService(email)
 	if len(apiErrors.Errors) > 0 {
-		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
-			Detail:  errors.New(apiErrors.Errors[0].(apihelper.Error).Detail),
-			Message: apiErrors.Errors[0].(apihelper.Error).Message,
-			Param:   apiErrors.Errors[0].(apihelper.Error).Param,
-			Code:    servicehelper.Code(statusCode),
-		}
+		return rest.ResponseDTOUserInfo{}, createErrorFromApiErrors(apiErrors, statusCode)
 	}
 	return resDTO, nil
 }
@@ -30,12 +39,7 @@ func askUserServiceForUserId(email string) (rest.ResponseDTOUserInfo, *servicehe
 func askUserServiceForUserEmail(userId uint) (rest.ResponseDTOUserInfo, *servicehelper.Error) {
 	resDTO, apiErrors, statusCode := callGetUserEmailService(userId)
 	if len(apiErrors.Errors) > 0 {
-		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
-			Detail:  errors.New(apiErrors.Errors[0].(apihelper.Error).Detail),
-			Message: apiErrors.Errors[0].(apihelper.Error).Message,
-			Param:   apiErrors.Errors[0].(apihelper.Error).Param,
-			Code:    servicehelper.Code(statusCode),
-		}
+		return rest.ResponseDTOUserInfo{}, createErrorFromApiErrors(apiErrors, statusCode)
 	}
 	return resDTO, nil
 }
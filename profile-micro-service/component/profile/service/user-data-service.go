@@ -0,0 +1,50 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/profile-micro-service/component/profile/rest"
+)
+
+// RetrieveUserData list every profile of a user with all the data kept about it, for the export of the personal data of the user.
+// The status of the user is not checked, the profiles of the users being deleted are exported as well
+func (s *service) RetrieveUserData(userId uint) ([]rest.ResponseDTOUserData, *servicehelper.Error) {
+	profiles, err := s.repo.FindByUserId(userId)
+	if err != nil {
+		return nil, &servicehelper.Error{
+			Detail:  errors.New("could not find profiles"),
+			Message: "We could not retrieve the profiles of the user, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	resDTOs := make([]rest.ResponseDTOUserData, 0, len(profiles))
+	for _, profile := range profiles {
+		resDTO := rest.ResponseDTOUserData{
+			PublicId:          profile.PublicId,
+			FirstName:         profile.FirstName,
+			LastName:          profile.LastName,
+			ProfilePictureUrl: profile.ProfilePictureUrl,
+			OrderAmount:       profile.OrderAmount,
+			CreatedAt:         profile.CreatedAt,
+			UpdatedAt:         profile.UpdatedAt,
+		}
+		if profile.Birthday != nil {
+			resDTO.Birthday = profile.Birthday.UTC().Format("2006-01-02")
+		}
+		resDTOs = append(resDTOs, resDTO)
+	}
+	return resDTOs, nil
+}
+
+// EraseUserData delete every profile of a user for good, it succeeds as well when the user has no profile
+func (s *service) EraseUserData(userId uint) *servicehelper.Error {
+	if err := s.repo.DeleteByUserId(userId); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not delete profiles"),
+			Message: "We could not delete the profiles of the user, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
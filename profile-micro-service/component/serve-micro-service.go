@@ -30,10 +30,16 @@ func main() {
 	router.Use(cors.New(apitool.DefaultCORSConfig()))
 
 	// Profile component
-	profileComponent := profile.New(rest.New(service.New(repo.New())))
+	profileService := service.New(repo.New())
+	profileComponent := profile.New(rest.New(profileService))
 	profileComponent.AttachPublicAPI(router.Group("/api/v1"))
 	profileComponent.AttachPrivateAPI(router.Group("/api/private-v1"))
 
+	// Purge the deleted profiles at the end of their retention period in the background
+	if config.GPurgeInterval > 0 {
+		profileService.StartPurgeScheduler(config.GPurgeInterval)
+	}
+
 	// Start router
 	go log.Println("Service profile started: Navigate to " + config.GAppUrl)
 	router.Run(":" + config.GPort)
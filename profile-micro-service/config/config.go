@@ -4,6 +4,7 @@ package config
 import (
 	"log"
 	"os"
+	"time"
 )
 
 // GAppName define the app name
@@ -25,8 +26,35 @@ var GPort string
 // GAppUrl is the application url
 var GAppUrl string
 
+// GUserServiceClientId is the oauth2 client id of the user micro service, the only client a user can delegate the reading of its profile to, set USER_SERVICE_CLIENT_ID to change it
+var GUserServiceClientId = "user-micro-service"
+
+// GProfileRetentionPeriod is how long a deleted profile can be restored before it is purged, set PROFILE_RETENTION_PERIOD (e.g. 720h) to change it
+var GProfileRetentionPeriod = 30 * 24 * time.Hour
+
+// GPurgeInterval is how often the deleted profiles are purged at the end of their retention period, set PURGE_INTERVAL to change it, 0 disables it
+var GPurgeInterval = time.Hour
+
 // init initialize the default environment
 func init() {
+	if clientId := os.Getenv("USER_SERVICE_CLIENT_ID"); clientId != "" {
+		GUserServiceClientId = clientId
+	}
+	if retentionPeriod := os.Getenv("PROFILE_RETENTION_PERIOD"); retentionPeriod != "" {
+		duration, err := time.ParseDuration(retentionPeriod)
+		if err != nil || duration < 0 {
+			log.Fatal("PROFILE_RETENTION_PERIOD is not a valid duration")
+		}
+		GProfileRetentionPeriod = duration
+	}
+	if interval := os.Getenv("PURGE_INTERVAL"); interval != "" {
+		duration, err := time.ParseDuration(interval)
+		if err != nil || duration < 0 {
+			log.Fatal("PURGE_INTERVAL is not a valid duration")
+		}
+		GPurgeInterval = duration
+	}
+
 	GPort = os.Getenv("PORT")
 	if GPort == "" {
 		GDevEnv = true
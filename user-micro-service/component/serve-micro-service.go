@@ -30,10 +30,22 @@ func main() {
 	router.Use(cors.New(apitool.DefaultCORSConfig()))
 
 	// User component
-	userComponent := user.New(rest.New(service.New(repo.New())))
+	userService := service.New(repo.New())
+	userService.CreateDefaultRoles()
+	userComponent := user.New(rest.New(userService))
 	userComponent.AttachPublicAPI(router.Group("/api/v1"))
 	userComponent.AttachPrivateAPI(router.Group("/api/private-v1"))
 
+	// Carry out the erasures at the end of their grace period in the background
+	if config.GErasureInterval > 0 {
+		userService.StartErasureScheduler(config.GErasureInterval)
+	}
+
+	// Purge the deleted users at the end of their retention period in the background
+	if config.GPurgeInterval > 0 {
+		userService.StartPurgeScheduler(config.GPurgeInterval)
+	}
+
 	// Start router
 	go log.Println("Service user started: Navigate to " + config.GAppUrl)
 	router.Run(":" + config.GPort)
@@ -1,36 +1,153 @@
 package main_test
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	"github.com/adriendomoison/apigoboot/api-tool/apitool"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
 	"github.com/adriendomoison/apigoboot/user-micro-service/component/user"
 	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/repo"
 	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
 	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/service"
 	"github.com/adriendomoison/apigoboot/user-micro-service/config"
 	"github.com/adriendomoison/apigoboot/user-micro-service/database/dbconn"
+	"github.com/adriendomoison/apigoboot/user-micro-service/mailer"
+	"github.com/adriendomoison/apigoboot/user-micro-service/passwordhash"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var publicBaseUrl = config.GAppUrl + "/api/v1"
 var privateBaseUrl = config.GAppUrl + "/api/private-v1"
 
+// mails capture the emails sent by the service
+var mails = &mailer.FileMailer{}
+
+// waitForNewMessage wait for an email sent in the background to an address, after the previous one, and return it
+func waitForNewMessage(t *testing.T, to string, previous mailer.Message) mailer.Message {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if mail, err := mails.LastMessage(to); err == nil && mail != previous {
+			return mail
+		}
+	}
+	t.Fatalf("Expected %s to be %s", "email to "+to, "sent")
+	return previous
+}
+
+// verificationToken return the token of the last link confirming an email address sent to an address
+func verificationToken(t *testing.T, to string) string {
+	mail, err := mails.LastMessage(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := regexp.MustCompile(`https?://\S+`).FindString(mail.Body)
+	query, err := url.ParseQuery(link[strings.Index(link, "?")+1:])
+	if err != nil || query.Get("token") == "" || query.Get("email") != to {
+		t.Fatalf("Expected %s to be %s, got %s", "email", "a verification link", mail.Body)
+	}
+	return query.Get("token")
+}
+
+// passwordResetToken return the token of the last link resetting a password sent to an address
+func passwordResetToken(t *testing.T, to string) string {
+	mail, err := mails.LastMessage(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := regexp.MustCompile(`https?://\S+`).FindString(mail.Body)
+	query, err := url.ParseQuery(link[strings.Index(link, "?")+1:])
+	if err != nil || query.Get("token") == "" {
+		t.Fatalf("Expected %s to be %s, got %s", "email", "a password reset link", mail.Body)
+	}
+	return query.Get("token")
+}
+
+// revokedUsers receive the ids of the users whose tokens the service asked to revoke
+var revokedUsers = make(chan string, 1)
+
+func revokeUserTokensMock(c *gin.Context) {
+	revokedUsers <- c.Param("userId")
+	c.JSON(http.StatusOK, gin.H{"message": "user tokens have been revoked successfully"})
+}
+
+// erasedUserData receive the paths of the user data the service asked the other micro services to erase
+var erasedUserData = make(chan string, 10)
+
+func getUserDataMock(c *gin.Context) {
+	if strings.HasSuffix(c.Request.URL.Path, "/profiles") {
+		c.JSON(http.StatusOK, []gin.H{{"profile_id": "12345678", "first_name": "John", "last_name": "Doe"}})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"granted_apps": []gin.H{{"client_id": "apigoboot"}}, "clients": []gin.H{}, "sessions": []gin.H{}, "tokens": []gin.H{}})
+	}
+}
+
+func deleteUserDataMock(c *gin.Context) {
+	erasedUserData <- c.Request.URL.Path
+	c.JSON(http.StatusOK, gin.H{"message": "user data have been erased successfully"})
+}
+
 func getAccessTokenOwnerUserIdMock(c *gin.Context) {
 	accessToken := c.Param("accessToken")
 	if accessToken == "XXX" {
-		c.JSON(http.StatusOK, gin.H{"user_id": 1})
+		c.JSON(http.StatusOK, gin.H{"user_id": 1, "scopes": []string{"user:read", "user:write", "profile:read", "profile:write"}})
+	} else if accessToken == "ZZZ" {
+		c.JSON(http.StatusOK, gin.H{"user_id": 1, "scopes": []string{"user:read"}})
+	} else if accessToken == "ADMIN" {
+		c.JSON(http.StatusOK, gin.H{"user_id": 0, "scopes": []string{"admin"}})
+	} else if accessToken == "SUPPORT" {
+		c.JSON(http.StatusOK, gin.H{"user_id": 999, "scopes": []string{"user:read", "user:write", "staff"}, "roles": []string{"support"}, "permissions": []string{"profiles:read", "users:read", "users:unlock"}})
+	} else if accessToken == "NOSTAFF" {
+		c.JSON(http.StatusOK, gin.H{"user_id": 999, "scopes": []string{"user:read", "user:write"}, "roles": []string{"support"}, "permissions": []string{"profiles:read", "users:read", "users:unlock"}})
+	} else if strings.HasPrefix(accessToken, "USER-") {
+		userId, _ := strconv.Atoi(strings.TrimPrefix(accessToken, "USER-"))
+		c.JSON(http.StatusOK, gin.H{"user_id": userId, "scopes": []string{"user:read", "user:write"}})
 	} else if accessToken == "YYY" {
-		c.JSON(http.StatusOK, gin.H{"user_id": 2})
+		c.JSON(http.StatusOK, gin.H{"user_id": 2, "scopes": []string{"user:read", "user:write", "profile:read", "profile:write"}})
+	} else if accessToken == "YYY-USER-READ" {
+		c.JSON(http.StatusOK, gin.H{"user_id": 2, "scopes": []string{"user:read"}})
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{})
 	}
 }
 
+func exchangeTokenMock(c *gin.Context) {
+	if c.PostForm("grant_type") != apitool.TokenExchangeGrantType || c.PostForm("scope") != "profile:read" {
+		c.JSON(http.StatusOK, gin.H{"error": "invalid_request"})
+		return
+	} else if c.PostForm("subject_token") == "YYY-USER-READ" {
+		// the subject token has not been granted the profile:read scope
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"access_token": "delegated-" + c.PostForm("subject_token"), "issued_token_type": apitool.AccessTokenType})
+}
+
 func getUserProfileMock(c *gin.Context) {
+	if !strings.HasPrefix(c.Request.Header.Get("Authorization"), "Bearer delegated-") {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
 	c.JSON(http.StatusOK, struct {
 		PublicId  string `json:"profile_id"`
 		FirstName string `json:"first_name"`
@@ -41,7 +158,6 @@ func getUserProfileMock(c *gin.Context) {
 		FirstName: "John",
 		LastName:  "Doe",
 		Birthday:  "1980-10-20",
-		Email:     c.Param("email"),
 		PublicId:  "12345678",
 	})
 }
@@ -62,6 +178,77 @@ func postUserProfileMock(c *gin.Context) {
 	})
 }
 
+// fakeIdpKey sign the ID tokens of the fake identity provider
+var fakeIdpKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+var fakeIdpIssuer = config.GAppUrl + "/fake-idp"
+
+func fakeIdpDiscoveryMock(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":            fakeIdpIssuer,
+		"userinfo_endpoint": fakeIdpIssuer + "/userinfo",
+		"jwks_uri":          fakeIdpIssuer + "/jwks",
+	})
+}
+
+func fakeIdpJwksMock(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": []gin.H{{
+		"kid": "fake-key",
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(fakeIdpKey.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(fakeIdpKey.Y.FillBytes(make([]byte, 32))),
+	}}})
+}
+
+// fakeIdpUsers are the claims of the owners of the access tokens of the fake identity provider
+var fakeIdpUsers = map[string]gin.H{
+	"fake-access-token":             {"sub": "fake-subject", "email": "idp00@example.dev", "email_verified": true},
+	"fake-unverified-access-token":  {"sub": "fake-subject", "email": "idp00@example.dev", "email_verified": false},
+	"fake-new-access-token":         {"sub": "fake-new-subject", "email": "idp01@example.dev", "email_verified": true, "name": "idp01"},
+	"fake-denied-access-token":      {"sub": "fake-denied-subject", "email": "idp02@example.dev", "email_verified": true},
+	"fake-test00-access-token":      {"sub": "fake-test00-subject", "email": "another@example.dev", "email_verified": false},
+	"fake-substituted-access-token": {"sub": "fake-subject", "email": "idp00@example.dev", "email_verified": true},
+}
+
+// fakeIdpAudiences are the clients the access tokens of the fake identity provider are issued for, apigoboot by default
+var fakeIdpAudiences = map[string]string{
+	"fake-substituted-access-token": "another-client",
+}
+
+func fakeIdpTokeninfoMock(c *gin.Context) {
+	token := c.Query("access_token")
+	if _, ok := fakeIdpUsers[token]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_token"})
+	} else if audience, ok := fakeIdpAudiences[token]; ok {
+		c.JSON(http.StatusOK, gin.H{"aud": audience})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"aud": "apigoboot"})
+	}
+}
+
+func fakeIdpUserinfoMock(c *gin.Context) {
+	if claims, ok := fakeIdpUsers[strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")]; ok {
+		c.JSON(http.StatusOK, claims)
+	} else {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+	}
+}
+
+// fakeIdpIdToken return an ID token signed by the fake identity provider
+func fakeIdpIdToken(t *testing.T, claims gin.H) string {
+	header, _ := json.Marshal(gin.H{"alg": "ES256", "kid": "fake-key"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, fakeIdpKey, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
 func TestMain(m *testing.M) {
 	// Init Env
 	config.SetToTestingEnv()
@@ -70,19 +257,43 @@ func TestMain(m *testing.M) {
 	dbconn.Connect()
 	defer dbconn.DB.Close()
 
+	// Capture emails
+	mails.Dir, _ = ioutil.TempDir("", "mails")
+	defer os.RemoveAll(mails.Dir)
+	service.Mailer = mails
+
 	// Init router
 	router := gin.Default()
 	router.Use(cors.New(apitool.DefaultCORSConfig()))
 
 	// Append routes to server
-	userComponent := user.New(rest.New(service.New(repo.New())))
+	userService := service.New(repo.New())
+	userService.CreateDefaultRoles()
+	userComponent := user.New(rest.New(userService))
 	userComponent.AttachPublicAPI(router.Group("/api/v1"))
 	userComponent.AttachPrivateAPI(router.Group("/api/private-v1"))
 
 	// Add mocked other micro-services called by this service
 	router.GET("/api/private-v1/access-token/:accessToken/get-owner", getAccessTokenOwnerUserIdMock)
+	router.DELETE("/api/private-v1/authentication/user/:userId/tokens", revokeUserTokensMock)
+	router.POST("/authentication/token", exchangeTokenMock)
 	router.POST("/api/private-v1/profiles", postUserProfileMock)
-	router.GET("/api/private-v1/profiles/:email", getUserProfileMock)
+	router.GET("/api/v1/users/:email/profile", getUserProfileMock)
+	router.GET("/api/private-v1/authentication/user/:userId/data", getUserDataMock)
+	router.DELETE("/api/private-v1/authentication/user/:userId/data", deleteUserDataMock)
+	router.GET("/api/private-v1/users/:userId/profiles", getUserDataMock)
+	router.DELETE("/api/private-v1/users/:userId/profiles", deleteUserDataMock)
+	router.GET("/fake-idp/.well-known/openid-configuration", fakeIdpDiscoveryMock)
+	router.GET("/fake-idp/jwks", fakeIdpJwksMock)
+	router.GET("/fake-idp/userinfo", fakeIdpUserinfoMock)
+	router.GET("/fake-idp/tokeninfo", fakeIdpTokeninfoMock)
+	service.RegisterIdentityProvider(service.NewOidcProvider(config.IdentityProviderConfig{
+		Name:         "fake-idp",
+		Issuer:       fakeIdpIssuer,
+		ClientId:     "apigoboot",
+		Scopes:       []string{"openid", "email"},
+		TokeninfoUrl: fakeIdpIssuer + "/tokeninfo",
+	}))
 
 	// Start service
 	go router.Run(":" + config.GPort)
@@ -94,7 +305,7 @@ func TestMain(m *testing.M) {
 	code := m.Run()
 
 	// Drop test tables
-	dbconn.DB.DropTable(&service.Entity{})
+	dbconn.DB.DropTable(&service.Entity{}, &service.LoginAttempt{}, &service.Credential{}, &service.Identity{}, &service.Role{}, &service.RolePermission{}, &service.UserRole{}, &service.DataRequest{}, &service.UsedToken{})
 
 	// Stop tests
 	os.Exit(code)
@@ -218,12 +429,28 @@ func TestPutEmail(t *testing.T) {
 	if resp.StatusCode != 200 {
 		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
 	}
-	if userDTO.Email != newEmail {
-		t.Errorf("Expected %s to be %s, got %s", "email", newEmail, userDTO.Email)
+	if userDTO.Email != email || userDTO.PendingEmail != newEmail {
+		t.Errorf("Expected %s to be %s, got %s", "pending email", newEmail, userDTO.PendingEmail)
 	}
 	if len(apiError.Errors) == 1 {
 		t.Errorf("Expected %v to be %v, got %v", "len(apiError.Errors)", 0, 1)
 	}
+	if _, err := mails.LastMessage(email); err != nil {
+		t.Errorf("Expected %s to be %s, got %s", "current email", "notified", err)
+	}
+
+	// confirm the new email
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/users/" + newEmail + "/verify",
+	}, rest.RequestDTOVerifyEmail{Token: verificationToken(t, newEmail)}, &userDTO)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+	if userDTO.Email != newEmail || !userDTO.Verified {
+		t.Errorf("Expected %s to be %s, got %s", "email", newEmail, userDTO.Email)
+	}
 }
 
 func TestPutPasswordWithoutKnowingPassword(t *testing.T) {
@@ -231,7 +458,7 @@ func TestPutPasswordWithoutKnowingPassword(t *testing.T) {
 	// init test variable
 	email := "test01@example.dev"
 	password := "WrongPassword"
-	newPassword := "Password123"
+	newPassword := "myNewSecretPassword#456"
 
 	// build JSON request body
 	requestBody := rest.RequestDTOPutPassword{
@@ -263,7 +490,7 @@ func TestPutPassword(t *testing.T) {
 	// init test variable
 	email := "test01@example.dev"
 	password := "mySecretPassword#123"
-	newPassword := "Password123"
+	newPassword := "myNewSecretPassword#456"
 
 	// build JSON request body
 	requestBody := rest.RequestDTOPutPassword{
@@ -310,6 +537,29 @@ func TestDeleteWithWrongAccessToken(t *testing.T) {
 	}
 }
 
+func TestDeleteWithoutWriteScope(t *testing.T) {
+
+	// init test variable
+	email := "test01@example.dev"
+
+	// print test variable for easy debug
+	t.Log("testing with following parameters:")
+	t.Log(email)
+
+	// call api
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "DELETE",
+		URL:           publicBaseUrl + "/users/" + email,
+		Authorization: "Bearer ZZZ",
+	}, nil, &rest.ResponseDTO{})
+	defer resp.Body.Close()
+
+	// test response
+	if resp.StatusCode != 403 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "403", resp.Status)
+	}
+}
+
 func TestDelete(t *testing.T) {
 
 	// init test variable
@@ -331,6 +581,13 @@ func TestDelete(t *testing.T) {
 	if resp.StatusCode != 200 {
 		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
 	}
+
+	// test the tokens of the deleted user are revoked
+	select {
+	case <-revokedUsers:
+	case <-time.After(time.Second):
+		t.Errorf("Expected %s to be %s", "tokens", "revoked")
+	}
 }
 
 func TestPostWithProfileCreation(t *testing.T) {
@@ -398,6 +655,20 @@ func TestProfileWasCreated(t *testing.T) {
 	} else if userWithProfile.PublicId != publicId {
 		t.Errorf("Expected %s to be %s, got %s", "profile ID", publicId, userWithProfile.PublicId)
 	}
+
+	// call api with a token that can't be delegated to read the profile, the user is returned without it
+	userWithProfile = rest.ResponseDTOWithProfile{}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/users/" + email + "?get_profile=true",
+		Authorization: "Bearer YYY-USER-READ",
+	}, nil, &userWithProfile)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if userWithProfile.Email != email || userWithProfile.PublicId != "" {
+		t.Errorf("Expected %s to be %s, got %v", "user", "without profile", userWithProfile)
+	}
 }
 
 func TestCheckCredentials(t *testing.T) {
@@ -479,3 +750,1385 @@ func TestGetByUserId(t *testing.T) {
 		t.Errorf("Expected %s to be %s, got %s", "email", email, userInfo.Email)
 	}
 }
+
+func TestLockoutAfterFailedLogins(t *testing.T) {
+
+	// init test variable
+	email := "test00@example.dev"
+	password := "mySecretPassword#123"
+	maxFailedLogins := config.GMaxFailedLogins
+	config.GMaxFailedLogins = 2
+	defer func() { config.GMaxFailedLogins = maxFailedLogins }()
+
+	checkCredentials := func(password string) int {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    privateBaseUrl + "/user/check-credentials",
+		}, rest.RequestDTOCheckCredentials{Username: email, Password: password, AuthType: "password", Ip: "192.0.2.1"}, &rest.ResponseDTOUserInfo{})
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// fail twice, waiting for the delay following the first failure
+	checkCredentials("wrongPassword")
+	if status := checkCredentials("wrongPassword"); status != http.StatusTooManyRequests {
+		t.Errorf("Expected %s to be %v, got %v", "status", http.StatusTooManyRequests, status)
+	}
+	time.Sleep(time.Second)
+	checkCredentials("wrongPassword")
+
+	// test the account is locked even with the right password
+	if status := checkCredentials(password); status != 423 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 423, status)
+	}
+
+	// test only an administrator can unlock the account
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/admin/users/" + email + "/unlock",
+		Authorization: "Bearer YYY",
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 403 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "403", resp.Status)
+	}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/admin/users/" + email + "/unlock",
+		Authorization: "Bearer ADMIN",
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+
+	// test the account can log in again
+	if status := checkCredentials(password); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+
+	// test concurrent failed logins are all counted, and lock the account once there are enough of them
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	refused := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status := checkCredentials("wrongPassword"); status != http.StatusTooManyRequests && status != 423 {
+				mutex.Lock()
+				refused++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	var entity service.Entity
+	dbconn.DB.Where("email = ?", email).First(&entity)
+	if entity.FailedLogins != refused {
+		t.Errorf("Expected %s to be %v, got %v", "failed logins", refused, entity.FailedLogins)
+	} else if (entity.LockedUntil != nil) != (refused >= config.GMaxFailedLogins) {
+		t.Errorf("Expected %s to be %v, got %v", "locked", refused >= config.GMaxFailedLogins, entity.LockedUntil)
+	}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/admin/users/" + email + "/unlock",
+		Authorization: "Bearer ADMIN",
+	}, nil, nil)
+	resp.Body.Close()
+}
+
+// totpCode compute the code an authenticator app would show for a secret
+func totpCode(t *testing.T, secret string) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestTotpLogin(t *testing.T) {
+
+	// init test variable
+	email := "test00@example.dev"
+	password := "mySecretPassword#123"
+
+	// enroll an authenticator app
+	var enrollment rest.ResponseDTOTotpEnrollment
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/users/" + email + "/mfa/totp",
+		Authorization: "Bearer YYY",
+	}, nil, &enrollment)
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected %s to be %s, got %s", "status", "201", resp.Status)
+	} else if !strings.HasPrefix(enrollment.OtpauthUri, "otpauth://totp/") {
+		t.Errorf("Expected %s to be an otpauth URI, got %s", "otpauth_uri", enrollment.OtpauthUri)
+	}
+
+	// test a wrong first code does not enable TOTP
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/users/" + email + "/mfa/totp/verify",
+		Authorization: "Bearer YYY",
+	}, rest.RequestDTOTotpCode{Code: "000000x"}, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "400", resp.Status)
+	}
+
+	// verify the first code and get the recovery codes
+	code := totpCode(t, enrollment.Secret)
+	var recoveryCodes rest.ResponseDTORecoveryCodes
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/users/" + email + "/mfa/totp/verify",
+		Authorization: "Bearer YYY",
+	}, rest.RequestDTOTotpCode{Code: code}, &recoveryCodes)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if len(recoveryCodes.RecoveryCodes) != 10 {
+		t.Fatalf("Expected %s to be %v, got %v", "recovery codes", 10, len(recoveryCodes.RecoveryCodes))
+	}
+
+	// test the secret is not stored in plaintext
+	var entity service.Entity
+	dbconn.DB.Where("email = ?", email).First(&entity)
+	if entity.TotpSecret == "" || strings.Contains(entity.TotpSecret, enrollment.Secret) {
+		t.Errorf("Expected %s to be %s, got %s", "totp secret", "encrypted", entity.TotpSecret)
+	}
+
+	checkCredentials := func(requestBody rest.RequestDTOCheckCredentials) (int, rest.ResponseDTOUserInfo) {
+		var userInfo rest.ResponseDTOUserInfo
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    privateBaseUrl + "/user/check-credentials",
+		}, requestBody, &userInfo)
+		defer resp.Body.Close()
+		return resp.StatusCode, userInfo
+	}
+
+	// test the password alone returns a challenge instead of the user
+	status, challenge := checkCredentials(rest.RequestDTOCheckCredentials{Username: email, Password: password, AuthType: "password"})
+	if status != 200 {
+		t.Fatalf("Expected %s to be %v, got %v", "status", 200, status)
+	} else if !challenge.MfaRequired || challenge.MfaToken == "" || challenge.UserId != 0 {
+		t.Fatalf("Expected %s to be %s, got %+v", "response", "an mfa challenge", challenge)
+	}
+
+	// test the code used to enroll cannot be used again
+	if status, _ := checkCredentials(rest.RequestDTOCheckCredentials{AuthType: "mfa", MfaToken: challenge.MfaToken, Code: code}); status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+
+	// test a forged token is refused
+	if status, _ := checkCredentials(rest.RequestDTOCheckCredentials{AuthType: "mfa", MfaToken: challenge.MfaToken + "x", Code: recoveryCodes.RecoveryCodes[0]}); status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+
+	// complete the login with a recovery code, it can only be used once
+	time.Sleep(time.Second)
+	status, userInfo := checkCredentials(rest.RequestDTOCheckCredentials{AuthType: "mfa", MfaToken: challenge.MfaToken, Code: recoveryCodes.RecoveryCodes[0]})
+	if status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	} else if userInfo.Email != email {
+		t.Errorf("Expected %s to be %s, got %s", "email", email, userInfo.Email)
+	}
+	if status, _ := checkCredentials(rest.RequestDTOCheckCredentials{AuthType: "mfa", MfaToken: challenge.MfaToken, Code: recoveryCodes.RecoveryCodes[0]}); status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+
+	// test the token of a completed login cannot complete another one
+	if status, _ := checkCredentials(rest.RequestDTOCheckCredentials{AuthType: "mfa", MfaToken: challenge.MfaToken, Code: recoveryCodes.RecoveryCodes[2]}); status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+
+	// disable TOTP with another recovery code
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/users/" + email + "/mfa/totp/disable",
+		Authorization: "Bearer YYY",
+	}, rest.RequestDTOTotpCode{Code: recoveryCodes.RecoveryCodes[1]}, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+}
+
+// cborMap is a CBOR map keeping the order of its keys
+type cborMap [][2]interface{}
+
+// cborEncode encode the values built by a software authenticator in CBOR
+func cborEncode(value interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+		}
+	}
+	switch value := value.(type) {
+	case int:
+		if value < 0 {
+			return head(1, uint64(-1-value))
+		}
+		return head(0, uint64(value))
+	case []byte:
+		return append(head(2, uint64(len(value))), value...)
+	case string:
+		return append(head(3, uint64(len(value))), value...)
+	case cborMap:
+		encoded := head(5, uint64(len(value)))
+		for _, entry := range value {
+			encoded = append(encoded, cborEncode(entry[0])...)
+			encoded = append(encoded, cborEncode(entry[1])...)
+		}
+		return encoded
+	}
+	panic("unsupported cbor value")
+}
+
+// softwareAuthenticator is a WebAuthn authenticator holding an ES256 key, answering like a browser would
+type softwareAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialId []byte
+	signCount    uint32
+	// userNotVerified is set for an authenticator that only checks the presence of the user
+	userNotVerified bool
+}
+
+func newSoftwareAuthenticator(t *testing.T) *softwareAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialId := make([]byte, 16)
+	rand.Read(credentialId)
+	return &softwareAuthenticator{key: key, credentialId: credentialId}
+}
+
+// clientData return the client data of a ceremony, as collected by the browser
+func (a *softwareAuthenticator) clientData(ceremony string, challenge string) []byte {
+	clientData, _ := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": config.GWebauthnOrigins[0]})
+	return clientData
+}
+
+// authenticatorData return the data signed by the authenticator, flags user present and user verified
+func (a *softwareAuthenticator) authenticatorData(flags byte) []byte {
+	flags |= 0x05
+	if a.userNotVerified {
+		flags &^= 0x04
+	}
+	rpIdHash := sha256.Sum256([]byte(config.GWebauthnRpId))
+	authData := append(rpIdHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(authData[33:], a.signCount)
+	return authData
+}
+
+// create answer to the options of a registration with a new credential
+func (a *softwareAuthenticator) create(options rest.ResponseDTOWebauthnCreationOptions) rest.RequestDTOWebauthnCredential {
+	x, y := make([]byte, 32), make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	authData := append(a.authenticatorData(0x40), make([]byte, 16)...)
+	authData = append(authData, byte(len(a.credentialId)>>8), byte(len(a.credentialId)))
+	authData = append(authData, a.credentialId...)
+	authData = append(authData, cborEncode(cborMap{{1, 2}, {3, -7}, {-1, 1}, {-2, x}, {-3, y}})...)
+	attestationObject := cborEncode(cborMap{{"fmt", "none"}, {"attStmt", cborMap{}}, {"authData", authData}})
+	return rest.RequestDTOWebauthnCredential{
+		Id:   base64.RawURLEncoding.EncodeToString(a.credentialId),
+		Type: "public-key",
+		Response: rest.RequestDTOWebauthnResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(a.clientData("webauthn.create", options.Challenge)),
+			AttestationObject: base64.RawURLEncoding.EncodeToString(attestationObject),
+			Transports:        []string{"internal"},
+		},
+		Name: "software authenticator",
+	}
+}
+
+// get answer to the options of a login with an assertion signed by the credential
+func (a *softwareAuthenticator) get(t *testing.T, options rest.ResponseDTOWebauthnRequestOptions) *rest.RequestDTOWebauthnCredential {
+	a.signCount++
+	authData := a.authenticatorData(0)
+	clientData := a.clientData("webauthn.get", options.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	hash := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &rest.RequestDTOWebauthnCredential{
+		Id:   base64.RawURLEncoding.EncodeToString(a.credentialId),
+		Type: "public-key",
+		Response: rest.RequestDTOWebauthnResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientData),
+			AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+			Signature:         base64.RawURLEncoding.EncodeToString(signature),
+		},
+	}
+}
+
+func TestWebauthnLogin(t *testing.T) {
+
+	// init test variable
+	email := "test00@example.dev"
+	authenticator := newSoftwareAuthenticator(t)
+
+	// register a passkey
+	var creationOptions rest.ResponseDTOWebauthnCreationOptions
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/users/" + email + "/webauthn/registration-options",
+		Authorization: "Bearer YYY",
+	}, nil, &creationOptions)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+	credential := authenticator.create(creationOptions)
+	var registered rest.ResponseDTOWebauthnCredential
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/users/" + email + "/webauthn/credentials",
+		Authorization: "Bearer YYY",
+	}, credential, &registered)
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected %s to be %s, got %s", "status", "201", resp.Status)
+	} else if registered.Id != credential.Id {
+		t.Errorf("Expected %s to be %s, got %s", "credential id", credential.Id, registered.Id)
+	}
+
+	// test a credential cannot be registered twice
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/users/" + email + "/webauthn/credentials",
+		Authorization: "Bearer YYY",
+	}, credential, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 409 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "409", resp.Status)
+	}
+
+	// sign in with the passkey
+	var requestOptions rest.ResponseDTOWebauthnRequestOptions
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    privateBaseUrl + "/user/webauthn/login-options",
+	}, rest.RequestDTOWebauthnRequestOptions{Username: email}, &requestOptions)
+	resp.Body.Close()
+	if len(requestOptions.AllowCredentials) != 0 {
+		t.Errorf("Expected %s to be %s, got %+v", "allowed credentials", "empty", requestOptions.AllowCredentials)
+	}
+	assertion := authenticator.get(t, requestOptions)
+	var userInfo rest.ResponseDTOUserInfo
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    privateBaseUrl + "/user/check-credentials",
+	}, rest.RequestDTOCheckCredentials{AuthType: "webauthn", Webauthn: assertion}, &userInfo)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if userInfo.Email != email {
+		t.Errorf("Expected %s to be %s, got %s", "email", email, userInfo.Email)
+	}
+
+	// test an assertion cannot be replayed, nor the challenge be signed again
+	for _, replayed := range []*rest.RequestDTOWebauthnCredential{assertion, authenticator.get(t, requestOptions)} {
+		resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    privateBaseUrl + "/user/check-credentials",
+		}, rest.RequestDTOCheckCredentials{AuthType: "webauthn", Webauthn: replayed}, nil)
+		resp.Body.Close()
+		if resp.StatusCode != 401 {
+			t.Errorf("Expected %s to be %s, got %s", "status", "401", resp.Status)
+		}
+	}
+
+	// test the user must be verified by the authenticator
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    privateBaseUrl + "/user/webauthn/login-options",
+	}, rest.RequestDTOWebauthnRequestOptions{}, &requestOptions)
+	resp.Body.Close()
+	authenticator.userNotVerified = true
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    privateBaseUrl + "/user/check-credentials",
+	}, rest.RequestDTOCheckCredentials{AuthType: "webauthn", Webauthn: authenticator.get(t, requestOptions)}, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 401 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "401", resp.Status)
+	}
+
+	// remove the passkey
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "DELETE",
+		URL:           publicBaseUrl + "/users/" + email + "/webauthn/credentials/" + credential.Id,
+		Authorization: "Bearer YYY",
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+}
+
+func TestIdentityProviderLogin(t *testing.T) {
+
+	// init test variable
+	email := "idp00@example.dev"
+
+	// create the user the identity provider will log in
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/users",
+	}, rest.RequestDTO{Email: email, Password: "mySecretPassword#123"}, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected %s to be %s, got %s", "status", "201", resp.Status)
+	}
+
+	// test the provider is listed
+	var providers []rest.ResponseDTOIdentityProvider
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    publicBaseUrl + "/identity-providers",
+	}, nil, &providers)
+	resp.Body.Close()
+	listed := false
+	for _, provider := range providers {
+		listed = listed || (provider.Name == "fake-idp" && provider.ClientId == "apigoboot")
+	}
+	if resp.StatusCode != 200 || !listed {
+		t.Errorf("Expected %s to be %s, got %+v", "providers", "listing fake-idp", providers)
+	}
+
+	checkCredentials := func(token string) (int, rest.ResponseDTOUserInfo) {
+		var userInfo rest.ResponseDTOUserInfo
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    privateBaseUrl + "/user/check-credentials",
+		}, rest.RequestDTOCheckCredentials{Password: token, AuthType: "fake-idp"}, &userInfo)
+		defer resp.Body.Close()
+		return resp.StatusCode, userInfo
+	}
+
+	// test an access token is checked on the tokeninfo and userinfo urls, the first login needs a verified email
+	if status, _ := checkCredentials("fake-unverified-access-token"); status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+
+	// test the identity is not linked to an account whose email is not confirmed
+	if status, _ := checkCredentials("fake-access-token"); status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+	now := time.Now()
+	dbconn.DB.Model(&service.Entity{}).Where("email = ?", email).Update("verified_at", &now)
+	if status, userInfo := checkCredentials("fake-access-token"); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	} else if userInfo.Email != email {
+		t.Errorf("Expected %s to be %s, got %s", "email", email, userInfo.Email)
+	}
+	if status, _ := checkCredentials("wrong-access-token"); status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+
+	// test an access token issued for another client is refused
+	if status, _ := checkCredentials("fake-substituted-access-token"); status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+
+	// test an ID token is checked with the keys of the provider
+	claims := gin.H{"iss": fakeIdpIssuer, "aud": "apigoboot", "sub": "fake-subject", "email": email, "email_verified": true, "exp": time.Now().Add(time.Hour).Unix()}
+	if status, userInfo := checkCredentials(fakeIdpIdToken(t, claims)); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	} else if userInfo.Email != email {
+		t.Errorf("Expected %s to be %s, got %s", "email", email, userInfo.Email)
+	}
+	claims["aud"] = "another-client"
+	if status, _ := checkCredentials(fakeIdpIdToken(t, claims)); status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+	claims["aud"] = []string{"apigoboot", "another-client"}
+	claims["azp"] = "another-client"
+	if status, _ := checkCredentials(fakeIdpIdToken(t, claims)); status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+	delete(claims, "azp")
+	claims["aud"] = "apigoboot"
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	if status, _ := checkCredentials(fakeIdpIdToken(t, claims)); status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+}
+
+func TestLinkedIdentities(t *testing.T) {
+
+	// init test variable
+	email := "test00@example.dev"
+
+	checkCredentials := func(token string) (int, rest.ResponseDTOUserInfo) {
+		var userInfo rest.ResponseDTOUserInfo
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    privateBaseUrl + "/user/check-credentials",
+		}, rest.RequestDTOCheckCredentials{Password: token, AuthType: "fake-idp"}, &userInfo)
+		defer resp.Body.Close()
+		return resp.StatusCode, userInfo
+	}
+
+	// test the first login of an unknown user creates its account, unless sign up is disabled
+	if status, userInfo := checkCredentials("fake-new-access-token"); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	} else if userInfo.Email != "idp01@example.dev" || userInfo.UserId == 0 {
+		t.Errorf("Expected %s to be %s, got %+v", "user", "a new user", userInfo)
+	}
+	config.GIdentityProviderSignUp = false
+	status, _ := checkCredentials("fake-denied-access-token")
+	config.GIdentityProviderSignUp = true
+	if status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+
+	// link an identity whose email is not the one of the user
+	link := func() *http.Response {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method:        "POST",
+			URL:           publicBaseUrl + "/users/" + email + "/identities",
+			Authorization: "Bearer YYY",
+		}, rest.RequestDTOLinkIdentity{Provider: "fake-idp", Token: "fake-test00-access-token"}, nil)
+		resp.Body.Close()
+		return resp
+	}
+	if resp := link(); resp.StatusCode != 201 {
+		t.Fatalf("Expected %s to be %s, got %s", "status", "201", resp.Status)
+	}
+	if resp := link(); resp.StatusCode != 409 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "409", resp.Status)
+	}
+	var identities []rest.ResponseDTOIdentity
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/users/" + email + "/identities",
+		Authorization: "Bearer YYY",
+	}, nil, &identities)
+	resp.Body.Close()
+	if len(identities) != 1 || identities[0].Subject != "fake-test00-subject" || identities[0].EmailAtLink != "another@example.dev" {
+		t.Errorf("Expected %s to be %s, got %+v", "identities", "the linked identity", identities)
+	}
+
+	// test the linked identity logs in the user
+	if status, userInfo := checkCredentials("fake-test00-access-token"); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	} else if userInfo.Email != email {
+		t.Errorf("Expected %s to be %s, got %s", "email", email, userInfo.Email)
+	}
+
+	// unlink the identity
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "DELETE",
+		URL:           publicBaseUrl + "/users/" + email + "/identities/fake-idp/fake-test00-subject",
+		Authorization: "Bearer YYY",
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+	if status, _ := checkCredentials("fake-test00-access-token"); status != 401 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 401, status)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+
+	// init test variable
+	email := "verify00@example.dev"
+	password := "mySecretPassword#123"
+	config.GEmailVerificationRequired = true
+	defer func() { config.GEmailVerificationRequired = false }()
+
+	var userDTO rest.ResponseDTO
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/users",
+	}, rest.RequestDTO{Email: email, Password: password}, &userDTO)
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected %s to be %s, got %s", "status", "201", resp.Status)
+	} else if userDTO.Verified {
+		t.Errorf("Expected %s to be %v, got %v", "verified", false, userDTO.Verified)
+	}
+
+	checkCredentials := func() int {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    privateBaseUrl + "/user/check-credentials",
+		}, rest.RequestDTOCheckCredentials{Username: email, Password: password, AuthType: "password"}, &rest.ResponseDTOUserInfo{})
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// test the login is refused until the email is confirmed, and a new link can be asked for from the login page
+	if status := checkCredentials(); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+	firstToken := verificationToken(t, email)
+	firstMail, _ := mails.LastMessage(email)
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    privateBaseUrl + "/user/verification-email",
+	}, rest.RequestDTOVerificationEmail{Email: email}, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 202 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 202, resp.StatusCode)
+	} else if waitForNewMessage(t, email, firstMail); verificationToken(t, email) == firstToken {
+		t.Errorf("Expected %s to be %s", "verification link", "sent again")
+	}
+	verify := func(token string) int {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    publicBaseUrl + "/users/" + email + "/verify",
+		}, rest.RequestDTOVerifyEmail{Token: token}, nil)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	token := verificationToken(t, email)
+	if status := verify(token + "x"); status != 400 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 400, status)
+	}
+	if status := verify(token); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+	if status := verify(token); status != 400 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 400, status)
+	}
+	if status := checkCredentials(); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+
+	// init test variable
+	email := "reset00@example.dev"
+	password := "mySecretPassword#123"
+	newPassword := "myNewSecretPassword#456"
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/users",
+	}, rest.RequestDTO{Email: email, Password: password}, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected %s to be %s, got %s", "status", "201", resp.Status)
+	}
+
+	requestReset := func(email string) int {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    publicBaseUrl + "/password-reset",
+		}, rest.RequestDTOPasswordReset{Email: email}, nil)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	confirmReset := func(token string) int {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    publicBaseUrl + "/password-reset/confirm",
+		}, rest.RequestDTOConfirmPasswordReset{Token: token, NewPassword: newPassword}, nil)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	checkCredentials := func(password string) int {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    privateBaseUrl + "/user/check-credentials",
+		}, rest.RequestDTOCheckCredentials{Username: email, Password: password, AuthType: "password"}, &rest.ResponseDTOUserInfo{})
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// test unknown emails get the same answer without any email sent
+	if status := requestReset("nobody@example.dev"); status != 202 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 202, status)
+	}
+
+	// test the reset with the token of the link
+	verificationMail, _ := mails.LastMessage(email)
+	if status := requestReset(email); status != 202 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 202, status)
+	}
+	waitForNewMessage(t, email, verificationMail)
+	if _, err := mails.LastMessage("nobody@example.dev"); err == nil {
+		t.Error("An email has been sent to an unknown address")
+	}
+	token := passwordResetToken(t, email)
+	if status := confirmReset(token + "x"); status != 400 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 400, status)
+	}
+	if status := confirmReset(token); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+	select {
+	case userId := <-revokedUsers:
+		if userId == "" || userId == "0" {
+			t.Errorf("Expected %s to be %s, got %s", "revoked user", "the id of the user", userId)
+		}
+	default:
+		t.Error("Tokens of the user were not revoked")
+	}
+
+	// test the token can only be used once
+	if status := confirmReset(token); status != 400 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 400, status)
+	}
+	if status := checkCredentials(password); status == 200 {
+		t.Error("Old password is still accepted after the reset")
+	}
+	if status := checkCredentials(newPassword); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+
+	// init test variable
+	email := "policy00@example.dev"
+	signUp := func(password string) (int, apihelper.ApiErrors) {
+		resp, apiErrors := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    publicBaseUrl + "/users",
+		}, rest.RequestDTO{Email: email, Password: password}, nil)
+		defer resp.Body.Close()
+		return resp.StatusCode, apiErrors
+	}
+
+	// test every broken rule is reported on the password
+	for password, brokenRules := range map[string]int{"Ab1!": 1, "12345678": 1, "policy00@example.dev!": 1, "123456": 2} {
+		status, apiErrors := signUp(password)
+		if status != 400 {
+			t.Errorf("Expected %s to be %v, got %v", "status", 400, status)
+		} else if len(apiErrors.Errors) != brokenRules {
+			t.Errorf("Expected %s to be %v, got %v", "errors of "+password, brokenRules, len(apiErrors.Errors))
+		}
+		for _, apiError := range apiErrors.Errors {
+			if apiError, ok := apiError.(map[string]interface{}); !ok || apiError["param"] != "password" || apiError["message"] == "" {
+				t.Errorf("Expected %s to be %s, got %v", "error", "a message about the password", apiError)
+			}
+		}
+	}
+
+	// test a password following the policy is accepted
+	if status, _ := signUp("correct horse battery staple"); status != 201 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 201, status)
+	}
+}
+
+func TestImportUsers(t *testing.T) {
+
+	// init test variable
+	email := "import00@example.dev"
+	password := "myLegacyPassword#1"
+	requestBody := rest.RequestDTOImportUsers{Users: []rest.RequestDTOImportUser{
+		{Email: email, PasswordHash: "$2a$10$Rw7cfAr0APRpy4OwTfyZf.wi0zzjqYTt/pHiQVhUMcZuOXkACwpHi", Verified: true},
+		{Email: "import01@example.dev", PasswordHash: "5f4dcc3b5aa765d61d8327deb882cf99"},
+		{Email: "import02@example.dev", PasswordHash: "$argon2id$v=19$m=4194304,t=1000,p=4$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"},
+		{Email: "import03@example.dev", PasswordHash: "$scrypt$ln=30,r=8,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"},
+	}}
+
+	// test only an administrator can import users
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/admin/users",
+		Authorization: "Bearer YYY",
+	}, requestBody, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 403 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "403", resp.Status)
+	}
+
+	// test the users with a supported hash are imported
+	var resDTO rest.ResponseDTOImportUsers
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/admin/users",
+		Authorization: "Bearer ADMIN",
+	}, requestBody, &resDTO)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	} else if resDTO.Imported != 1 {
+		t.Errorf("Expected %s to be %v, got %v", "imported", 1, resDTO.Imported)
+	} else if len(resDTO.Failures) != 3 || resDTO.Failures[0].Email != "import01@example.dev" {
+		t.Errorf("Expected %s to be %s, got %v", "failures", "the unknown hash and the hashes with a too high cost", resDTO.Failures)
+	}
+
+	// test the imported user logs in with its legacy password, which is then hashed again with the current algorithm
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    privateBaseUrl + "/user/check-credentials",
+	}, rest.RequestDTOCheckCredentials{Username: email, Password: password, AuthType: "password"}, &rest.ResponseDTOUserInfo{})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+	var entity service.Entity
+	dbconn.DB.Where("email = ?", email).First(&entity)
+	if algorithm := passwordhash.Identify(entity.Password); algorithm != config.GPasswordHashAlgorithm {
+		t.Errorf("Expected %s to be %s, got %s", "password hash algorithm", config.GPasswordHashAlgorithm, algorithm)
+	}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    privateBaseUrl + "/user/check-credentials",
+	}, rest.RequestDTOCheckCredentials{Username: email, Password: password, AuthType: "password"}, &rest.ResponseDTOUserInfo{})
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %s, got %s", "status", "200", resp.Status)
+	}
+
+	// test an account can't be imported twice
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/admin/users",
+		Authorization: "Bearer ADMIN",
+	}, requestBody, &resDTO)
+	resp.Body.Close()
+	if resDTO.Imported != 0 || len(resDTO.Failures) != 2 {
+		t.Errorf("Expected %s to be %v, got %v", "imported", 0, resDTO.Imported)
+	}
+}
+
+func TestAdminUserList(t *testing.T) {
+
+	// init test variable
+	password := "mySecretPassword#123"
+	emails := []string{"list00@example.dev", "list01@example.dev", "list02@example.dev"}
+	for _, email := range emails {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    publicBaseUrl + "/users",
+		}, rest.RequestDTO{Email: email, Password: password}, nil)
+		resp.Body.Close()
+	}
+	listUsers := func(query url.Values, authorization string) (int, rest.ResponseDTOUserList) {
+		var resDTO rest.ResponseDTOUserList
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method:        "GET",
+			URL:           publicBaseUrl + "/admin/users?" + query.Encode(),
+			Authorization: authorization,
+		}, nil, &resDTO)
+		resp.Body.Close()
+		return resp.StatusCode, resDTO
+	}
+
+	// test only an administrator can list the users
+	if status, _ := listUsers(url.Values{}, "Bearer YYY"); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+
+	// test the users are listed a page at a time, following the cursor
+	query := url.Values{"email": {"list"}, "sort": {"email"}, "limit": {"2"}}
+	status, firstPage := listUsers(query, "Bearer ADMIN")
+	if status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	} else if len(firstPage.Users) != 2 || firstPage.Users[0].Email != emails[0] || firstPage.Users[1].Email != emails[1] {
+		t.Errorf("Expected %s to be %v, got %v", "first page", emails[:2], firstPage.Users)
+	} else if firstPage.NextCursor == "" {
+		t.Errorf("Expected %s to be %s", "next_cursor", "set")
+	}
+	query.Set("cursor", firstPage.NextCursor)
+	if _, secondPage := listUsers(query, "Bearer ADMIN"); len(secondPage.Users) != 1 || secondPage.Users[0].Email != emails[2] {
+		t.Errorf("Expected %s to be %v, got %v", "second page", emails[2:], secondPage.Users)
+	} else if secondPage.NextCursor != "" {
+		t.Errorf("Expected %s to be %s, got %s", "next_cursor", "empty", secondPage.NextCursor)
+	}
+
+	// test the filters
+	if _, page := listUsers(url.Values{"email": {"list"}, "verified": {"true"}}, "Bearer ADMIN"); len(page.Users) != 0 {
+		t.Errorf("Expected %s to be %v, got %v", "verified users", 0, len(page.Users))
+	}
+	if _, page := listUsers(url.Values{"email": {"list"}, "created_before": {"2000-01-01T00:00:00Z"}}, "Bearer ADMIN"); len(page.Users) != 0 {
+		t.Errorf("Expected %s to be %v, got %v", "users created before 2000", 0, len(page.Users))
+	}
+	if status, _ := listUsers(url.Values{"sort": {"password"}}, "Bearer ADMIN"); status != 400 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 400, status)
+	}
+}
+
+func TestAdminSuspendUsers(t *testing.T) {
+
+	// init test variable
+	email := "list00@example.dev"
+	password := "mySecretPassword#123"
+	checkCredentials := func() int {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    privateBaseUrl + "/user/check-credentials",
+		}, rest.RequestDTOCheckCredentials{Username: email, Password: password, AuthType: "password"}, &rest.ResponseDTOUserInfo{})
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	bulkUpdate := func(action string) rest.ResponseDTOBulkUpdate {
+		var resDTO rest.ResponseDTOBulkUpdate
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method:        "POST",
+			URL:           publicBaseUrl + "/admin/" + action,
+			Authorization: "Bearer ADMIN",
+		}, rest.RequestDTOBulkUsers{Emails: []string{email, "nobody@example.dev"}}, &resDTO)
+		resp.Body.Close()
+		return resDTO
+	}
+
+	// test a suspended user can't log in and its tokens are revoked
+	if resDTO := bulkUpdate("suspend"); resDTO.Updated != 1 || len(resDTO.Failures) != 1 {
+		t.Errorf("Expected %s to be %v, got %v", "updated", 1, resDTO)
+	}
+	select {
+	case <-revokedUsers:
+	case <-time.After(time.Second):
+		t.Errorf("Expected %s to be %s", "tokens", "revoked")
+	}
+	if status := checkCredentials(); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+
+	// test an unsuspended user logs in again
+	if resDTO := bulkUpdate("unsuspend"); resDTO.Updated != 1 {
+		t.Errorf("Expected %s to be %v, got %v", "updated", 1, resDTO)
+	}
+	if status := checkCredentials(); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+}
+
+func TestUserStatus(t *testing.T) {
+
+	// init test variable
+	email := "status00@example.dev"
+	password := "mySecretPassword#123"
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/users",
+	}, rest.RequestDTO{Email: email, Password: password}, nil)
+	resp.Body.Close()
+	var userInfo rest.ResponseDTOUserInfo
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/user/email/" + email,
+	}, nil, &userInfo)
+	resp.Body.Close()
+	checkCredentials := func() (int, apihelper.ApiErrors) {
+		resp, apiErrors := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    privateBaseUrl + "/user/check-credentials",
+		}, rest.RequestDTOCheckCredentials{Username: email, Password: password, AuthType: "password"}, &rest.ResponseDTOUserInfo{})
+		resp.Body.Close()
+		return resp.StatusCode, apiErrors
+	}
+	editStatus := func(status string) (int, rest.ResponseDTOStatus) {
+		var resDTO rest.ResponseDTOStatus
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method:        "PUT",
+			URL:           publicBaseUrl + "/admin/users/" + email + "/status",
+			ContentType:   "application/json",
+			Authorization: "Bearer ADMIN",
+		}, rest.RequestDTOStatus{Status: status, Reason: "testing"}, &resDTO)
+		resp.Body.Close()
+		return resp.StatusCode, resDTO
+	}
+	expectRevokedTokens := func() {
+		select {
+		case userId := <-revokedUsers:
+			if userId != strconv.Itoa(int(userInfo.UserId)) {
+				t.Errorf("Expected %s to be %v, got %v", "revoked user", userInfo.UserId, userId)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected %s to be %s", "tokens", "revoked")
+		}
+	}
+
+	// test a user deactivating its account can't log in anymore
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/users/" + email + "/deactivate",
+		ContentType:   "application/json",
+		Authorization: "Bearer USER-" + strconv.Itoa(int(userInfo.UserId)),
+	}, rest.RequestDTODeactivate{Reason: "not using it anymore"}, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, resp.StatusCode)
+	}
+	expectRevokedTokens()
+	if status, apiErrors := checkCredentials(); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	} else if len(apiErrors.Errors) == 0 {
+		t.Errorf("Expected %s to be %s", "errors", "returned")
+	} else if apiError, ok := apiErrors.Errors[0].(map[string]interface{}); !ok || !strings.Contains(fmt.Sprint(apiError["message"]), "deactivated") {
+		t.Errorf("Expected %s to be %s, got %v", "message", "about the deactivation", apiErrors.Errors[0])
+	}
+
+	// test an administrator moves the user through its lifecycle
+	if status, _ := editStatus("archived"); status != 400 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 400, status)
+	}
+	if status, resDTO := editStatus(service.StatusPendingDeletion); status != 200 || resDTO.Status != service.StatusPendingDeletion || resDTO.ChangedAt == nil {
+		t.Errorf("Expected %s to be %v, got %v", "status", service.StatusPendingDeletion, resDTO)
+	}
+	expectRevokedTokens()
+	if status, _ := checkCredentials(); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+	if status, resDTO := editStatus(service.StatusActive); status != 200 || resDTO.Status != service.StatusActive {
+		t.Errorf("Expected %s to be %v, got %v", "status", service.StatusActive, resDTO)
+	}
+	if status, _ := checkCredentials(); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+}
+
+func TestRoles(t *testing.T) {
+
+	// init test variable
+	email := "role00@example.dev"
+	password := "mySecretPassword#123"
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/users",
+	}, rest.RequestDTO{Email: email, Password: password}, nil)
+	resp.Body.Close()
+	request := func(method string, url string, authorization string, body interface{}, resDTO interface{}) int {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method:        method,
+			URL:           url,
+			ContentType:   "application/json",
+			Authorization: authorization,
+		}, body, resDTO)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// test the support staff can read the account of another user but not change it
+	if status := request("GET", publicBaseUrl+"/users/"+email, "Bearer SUPPORT", nil, &rest.ResponseDTO{}); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+	if status := request("PUT", publicBaseUrl+"/users/"+email+"/password", "Bearer SUPPORT", rest.RequestDTOPutPassword{Email: email, Password: password, NewPassword: "myNewSecretPassword#123"}, nil); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+	if status := request("GET", publicBaseUrl+"/admin/users?email=role", "Bearer SUPPORT", nil, &rest.ResponseDTOUserList{}); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+	if status := request("POST", publicBaseUrl+"/admin/suspend", "Bearer SUPPORT", rest.RequestDTOBulkUsers{Emails: []string{email}}, nil); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+
+	// test the permissions are not enough without the staff scope
+	if status := request("GET", publicBaseUrl+"/users/"+email, "Bearer NOSTAFF", nil, nil); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+	if status := request("GET", publicBaseUrl+"/admin/users?email=role", "Bearer NOSTAFF", nil, nil); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+
+	// test only an administrator can manage the roles
+	if status := request("GET", publicBaseUrl+"/admin/roles", "Bearer SUPPORT", nil, nil); status != 403 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 403, status)
+	}
+	var roles []rest.ResponseDTORole
+	if status := request("GET", publicBaseUrl+"/admin/roles", "Bearer ADMIN", nil, &roles); status != 200 || len(roles) != 2 {
+		t.Errorf("Expected %s to be %v, got %v", "default roles", 2, roles)
+	}
+	if status := request("PUT", publicBaseUrl+"/admin/roles/auditor", "Bearer ADMIN", rest.RequestDTORole{Permissions: []string{"unknown:permission"}}, nil); status != 400 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 400, status)
+	}
+	if status := request("PUT", publicBaseUrl+"/admin/roles/auditor", "Bearer ADMIN", rest.RequestDTORole{Description: "Auditors", Permissions: []string{"users:read"}}, &rest.ResponseDTORole{}); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+
+	// test the roles given to a user are exposed to the oauth2 service with the permissions they grant
+	var userRoles rest.ResponseDTOUserRoles
+	if status := request("PUT", publicBaseUrl+"/admin/users/"+email+"/roles", "Bearer ADMIN", rest.RequestDTOUserRoles{Roles: []string{"support", "auditor"}}, &userRoles); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	} else if len(userRoles.Roles) != 2 || userRoles.Roles[0] != "auditor" || len(userRoles.Permissions) != 3 {
+		t.Errorf("Expected %s to be %v, got %v", "roles", []string{"auditor", "support"}, userRoles)
+	}
+	var userInfo rest.ResponseDTOUserInfo
+	request("GET", privateBaseUrl+"/user/email/"+email, "", nil, &userInfo)
+	request("GET", privateBaseUrl+"/user/id/"+strconv.Itoa(int(userInfo.UserId)), "", nil, &userInfo)
+	if len(userInfo.Roles) != 2 || len(userInfo.Permissions) != 3 {
+		t.Errorf("Expected %s to be %v, got %v", "user info roles", userRoles, userInfo)
+	}
+
+	// test a deleted role is removed from the users
+	if status := request("DELETE", publicBaseUrl+"/admin/roles/auditor", "Bearer ADMIN", nil, nil); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+	if request("GET", publicBaseUrl+"/admin/users/"+email+"/roles", "Bearer ADMIN", nil, &userRoles); len(userRoles.Roles) != 1 || userRoles.Roles[0] != "support" {
+		t.Errorf("Expected %s to be %v, got %v", "roles", []string{"support"}, userRoles.Roles)
+	}
+}
+
+func TestDataRequests(t *testing.T) {
+
+	// init test variable
+	email := "gdpr00@example.dev"
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/users",
+	}, rest.RequestDTO{Email: email, Password: "mySecretPassword#123"}, nil)
+	resp.Body.Close()
+	var userInfo rest.ResponseDTOUserInfo
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/user/email/" + email,
+	}, nil, &userInfo)
+	resp.Body.Close()
+	authorization := "Bearer USER-" + strconv.Itoa(int(userInfo.UserId))
+
+	// test a user exports its data kept by every service as JSON
+	var export rest.ResponseDTOExport
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/users/" + email + "/export?format=json",
+		Authorization: authorization,
+	}, nil, &export)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, resp.StatusCode)
+	}
+	if export.User.Email != email {
+		t.Errorf("Expected %s to be %s, got %s", "email", email, export.User.Email)
+	}
+	if !strings.Contains(string(export.Profiles), "John") || !strings.Contains(string(export.Authorizations), "apigoboot") {
+		t.Errorf("Expected %s to be %s, got %s and %s", "export", "complete", export.Profiles, export.Authorizations)
+	}
+	if len(export.DataRequests) != 1 || export.DataRequests[0].Kind != service.DataRequestExport || export.DataRequests[0].Status != service.DataRequestCompleted {
+		t.Errorf("Expected %s to be %s, got %v", "data requests", "the completed export", export.DataRequests)
+	}
+
+	// test the export is downloaded as a ZIP archive by default
+	req, _ := http.NewRequest("GET", publicBaseUrl+"/users/"+email+"/export", nil)
+	req.Header.Set("Authorization", authorization)
+	if resp, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	} else {
+		archive, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.Header.Get("Content-Type") != "application/zip" {
+			t.Errorf("Expected %s to be %s, got %s", "content type", "application/zip", resp.Header.Get("Content-Type"))
+		} else if reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive))); err != nil {
+			t.Errorf("Expected %s to be %s, got %v", "archive", "valid", err)
+		} else if len(reader.File) != 3 || reader.File[0].Name != "user.json" {
+			t.Errorf("Expected %s to be %s, got %v", "archive", "user.json, profiles.json and authorizations.json", reader.File)
+		}
+	}
+
+	// test a user asks for the erasure of its data at the end of the grace period
+	var erasure rest.ResponseDTODataRequest
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "POST",
+		URL:           publicBaseUrl + "/users/" + email + "/erasure",
+		Authorization: authorization,
+	}, nil, &erasure)
+	resp.Body.Close()
+	if resp.StatusCode != 202 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 202, resp.StatusCode)
+	}
+	if erasure.Kind != service.DataRequestErasure || erasure.Status != service.DataRequestPending {
+		t.Errorf("Expected %s to be %s, got %v", "erasure", "pending", erasure)
+	}
+	if erasure.ScheduledAt.Before(time.Now().Add(config.GErasureGracePeriod - time.Minute)) {
+		t.Errorf("Expected %s to be %s, got %v", "erasure", "scheduled at the end of the grace period", erasure.ScheduledAt)
+	}
+	select {
+	case <-revokedUsers:
+	case <-time.After(time.Second):
+		t.Errorf("Expected %s to be %s", "tokens", "revoked")
+	}
+
+	// test an administrator finds the pending erasure
+	var list rest.ResponseDTODataRequestList
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/admin/data-requests?kind=erasure&status=pending",
+		Authorization: "Bearer ADMIN",
+	}, nil, &list)
+	resp.Body.Close()
+	if len(list.DataRequests) != 1 || list.DataRequests[0].Id != erasure.Id {
+		t.Errorf("Expected %s to be %v, got %v", "data requests", erasure, list.DataRequests)
+	}
+
+	// test the erasure is cancelled when the user is reactivated
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "PUT",
+		URL:           publicBaseUrl + "/admin/users/" + email + "/status",
+		ContentType:   "application/json",
+		Authorization: "Bearer ADMIN",
+	}, rest.RequestDTOStatus{Status: service.StatusActive, Reason: "changed their mind"}, nil)
+	resp.Body.Close()
+	var requests []rest.ResponseDTODataRequest
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/users/" + email + "/data-requests",
+		Authorization: authorization,
+	}, nil, &requests)
+	resp.Body.Close()
+	for _, request := range requests {
+		if request.Kind == service.DataRequestErasure && request.Status != service.DataRequestCancelled {
+			t.Errorf("Expected %s to be %s, got %s", "erasure", service.DataRequestCancelled, request.Status)
+		}
+	}
+	if len(requests) != 2 {
+		t.Errorf("Expected %s to be %v, got %v", "data requests", 2, len(requests))
+	}
+}
+
+func TestDeletedUsers(t *testing.T) {
+
+	// init test variable
+	email := "deleted00@example.dev"
+	password := "mySecretPassword#123"
+	resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/users",
+	}, rest.RequestDTO{Email: email, Password: password}, nil)
+	resp.Body.Close()
+	var userInfo rest.ResponseDTOUserInfo
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/user/email/" + email,
+	}, nil, &userInfo)
+	resp.Body.Close()
+	deleteUser := func() {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method:        "DELETE",
+			URL:           publicBaseUrl + "/users/" + email,
+			Authorization: "Bearer USER-" + strconv.Itoa(int(userInfo.UserId)),
+		}, nil, nil)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("Expected %s to be %v, got %v", "status", 200, resp.StatusCode)
+		}
+		select {
+		case <-revokedUsers:
+		case <-time.After(time.Second):
+			t.Errorf("Expected %s to be %s", "tokens", "revoked")
+		}
+	}
+	checkCredentials := func() int {
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method: "POST",
+			URL:    privateBaseUrl + "/user/check-credentials",
+		}, rest.RequestDTOCheckCredentials{Username: email, Password: password, AuthType: "password"}, &rest.ResponseDTOUserInfo{})
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	restore := func() (int, rest.ResponseDTOAdminUser) {
+		var resDTO rest.ResponseDTOAdminUser
+		resp, _ := apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+			Method:        "POST",
+			URL:           publicBaseUrl + "/admin/deleted-users/" + email + "/restore",
+			Authorization: "Bearer ADMIN",
+		}, nil, &resDTO)
+		resp.Body.Close()
+		return resp.StatusCode, resDTO
+	}
+
+	// test a deleted user can't log in anymore and is listed until the end of the retention period
+	deleteUser()
+	if status := checkCredentials(); status == 200 {
+		t.Errorf("Expected %s to be %s, got %v", "status", "an error", status)
+	}
+	var list rest.ResponseDTODeletedUserList
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/admin/deleted-users?email=deleted00",
+		Authorization: "Bearer ADMIN",
+	}, nil, &list)
+	resp.Body.Close()
+	if len(list.Users) != 1 || list.Users[0].Email != email {
+		t.Errorf("Expected %s to be %s, got %v", "deleted users", email, list.Users)
+	} else if purgeAt := list.Users[0].DeletedAt.Add(config.GUserRetentionPeriod); !list.Users[0].PurgeAt.Equal(purgeAt) {
+		t.Errorf("Expected %s to be %v, got %v", "purge date", purgeAt, list.Users[0].PurgeAt)
+	}
+
+	// test an administrator restores the deleted user
+	if status, resDTO := restore(); status != 200 || resDTO.Email != email || resDTO.Status != service.StatusActive {
+		t.Errorf("Expected %s to be %s, got %v %v", "user", "restored", status, resDTO)
+	}
+	if status := checkCredentials(); status != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, status)
+	}
+	if status, _ := restore(); status != 404 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 404, status)
+	}
+
+	// test the email of a deleted user signs up again, and the deleted user can't be restored over the new one
+	deleteUser()
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "POST",
+		URL:    publicBaseUrl + "/users",
+	}, rest.RequestDTO{Email: email, Password: password}, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 201, resp.StatusCode)
+	}
+	if status, _ := restore(); status != 409 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 409, status)
+	}
+
+	// test the users deleted before the retention period are not listed anymore
+	dbconn.DB.Unscoped().Model(&service.Entity{}).Where("email = ? AND deleted_at IS NOT NULL", email).
+		UpdateColumn("deleted_at", time.Now().Add(-config.GUserRetentionPeriod-time.Hour))
+	list = rest.ResponseDTODeletedUserList{}
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "GET",
+		URL:           publicBaseUrl + "/admin/deleted-users?email=deleted00",
+		Authorization: "Bearer ADMIN",
+	}, nil, &list)
+	resp.Body.Close()
+	if len(list.Users) != 0 {
+		t.Errorf("Expected %s to be %s, got %v", "deleted users", "empty", list.Users)
+	}
+	dbconn.DB.Unscoped().Where("email = ? AND deleted_at IS NOT NULL", email).Delete(&service.Entity{})
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method: "GET",
+		URL:    privateBaseUrl + "/user/email/" + email,
+	}, nil, &userInfo)
+	resp.Body.Close()
+
+	// test an administrator purges the deleted user and its data in every service right away
+	deleteUser()
+	resp, _ = apitool.HttpRequestHandlerForUnitTesting(t, apitool.RequestHeader{
+		Method:        "DELETE",
+		URL:           publicBaseUrl + "/admin/deleted-users/" + email,
+		Authorization: "Bearer ADMIN",
+	}, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 200, resp.StatusCode)
+	}
+	for _, suffix := range []string{"/data", "/profiles"} {
+		select {
+		case path := <-erasedUserData:
+			if !strings.HasSuffix(path, suffix) {
+				t.Errorf("Expected %s to be %s, got %s", "erased data", suffix, path)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected %s to be %s", suffix, "erased")
+		}
+	}
+	if status, _ := restore(); status != 404 {
+		t.Errorf("Expected %s to be %v, got %v", "status", 404, status)
+	}
+}
@@ -2,8 +2,12 @@
 package repo
 
 import (
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
 	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/service"
 	"github.com/adriendomoison/apigoboot/user-micro-service/database/dbconn"
+	"github.com/jinzhu/gorm"
+	"log"
+	"time"
 )
 
 // Make sure the interface is implemented correctly
@@ -16,10 +20,48 @@ type repo struct {
 
 // New return a new repo instance
 func New() *repo {
-	dbconn.DB.AutoMigrate(&service.Entity{})
+	addingVerifiedAt := !dbconn.DB.Dialect().HasColumn("user", "verified_at")
+	dbconn.DB.AutoMigrate(&service.Entity{}, &service.LoginAttempt{}, &service.Credential{}, &service.Identity{}, &service.Role{}, &service.RolePermission{}, &service.UserRole{}, &service.DataRequest{}, &service.UsedToken{})
+	migrateEmailIndex()
+	if addingVerifiedAt {
+		migrateVerifiedAt()
+	}
+	encryptPlaintextTotpSecrets()
 	return &repo{}
 }
 
+// encryptPlaintextTotpSecrets encrypt the TOTP secrets stored in plaintext by previous versions
+func encryptPlaintextTotpSecrets() {
+	var users []service.Entity
+	if err := dbconn.DB.Unscoped().Where("totp_secret <> ''").Find(&users).Error; err != nil {
+		log.Println("could not load users to encrypt their totp secret:", err)
+		return
+	}
+	for _, user := range users {
+		if service.IsEncryptedTotpSecret(user.TotpSecret) {
+			continue
+		}
+		encryptedSecret, err := service.EncryptTotpSecret(user.TotpSecret, user.ID)
+		if err != nil {
+			log.Println("could not encrypt totp secret of user", user.ID, ":", err)
+			continue
+		}
+		dbconn.DB.Unscoped().Model(&user).UpdateColumn("totp_secret", encryptedSecret)
+	}
+}
+
+// migrateVerifiedAt consider the users created before the email verification as verified since their sign up,
+// so requiring the verification does not lock them out
+func migrateVerifiedAt() {
+	dbconn.DB.Exec(`UPDATE "user" SET verified_at = created_at WHERE verified_at IS NULL`)
+}
+
+// migrateEmailIndex replace the unique constraint of the emails by a unique index of the users not deleted
+func migrateEmailIndex() {
+	dbconn.DB.Exec(`ALTER TABLE "user" DROP CONSTRAINT IF EXISTS user_email_key`)
+	dbconn.DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_user_email_not_deleted ON "user" (email) WHERE deleted_at IS NULL`)
+}
+
 // Create create a user in Database
 func (repo *repo) Create(user service.Entity) bool {
 	if dbconn.DB.NewRecord(user) {
@@ -49,7 +91,293 @@ func (repo *repo) Update(user service.Entity) error {
 	return dbconn.DB.Save(&user).Error
 }
 
-// Delete remove user from Database
+// Delete soft delete user in Database, it is kept until it is purged
 func (repo *repo) Delete(user service.Entity) error {
 	return dbconn.DB.Delete(&user).Error
 }
+
+// FindDeleted find the deleted users matching a query in Database
+func (repo *repo) FindDeleted(query apitool.Query) (users []service.Entity, err error) {
+	db := dbconn.DB.Unscoped().Where("deleted_at IS NOT NULL")
+	if conditions, args := query.Conditions(); conditions != "" {
+		db = db.Where(conditions, args...)
+	}
+	if query.Limit() > 0 {
+		db = db.Limit(query.Limit())
+	}
+	if order := query.Order(); order != "" {
+		db = db.Order(order)
+	}
+	if err = db.Order("id").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// FindDeletedByEmail find the last deleted user with an email in Database
+func (repo *repo) FindDeletedByEmail(email string) (user service.Entity, err error) {
+	if err = dbconn.DB.Unscoped().Where("email = ? AND deleted_at IS NOT NULL", email).Order("deleted_at DESC").First(&user).Error; err != nil {
+		return service.Entity{}, err
+	}
+	return user, nil
+}
+
+// Restore bring a deleted user back in Database
+func (repo *repo) Restore(user service.Entity) error {
+	return dbconn.DB.Unscoped().Model(&user).UpdateColumn("deleted_at", gorm.Expr("NULL")).Error
+}
+
+// Purge remove user from Database for good, whether it is deleted or not
+func (repo *repo) Purge(user service.Entity) error {
+	return dbconn.DB.Unscoped().Delete(&user).Error
+}
+
+// FindLoginAttemptByIp find the failed logins of an IP address in Database
+func (repo *repo) FindLoginAttemptByIp(ip string) (attempt service.LoginAttempt, err error) {
+	if err = dbconn.DB.Where("ip = ?", ip).First(&attempt).Error; err != nil {
+		return service.LoginAttempt{}, err
+	}
+	return attempt, nil
+}
+
+// RecordFailedIpLogin count a failed login of an IP address in a single statement, so concurrent logins cannot miss each other.
+// The failed logins before forgetBefore are forgotten first, the address is locked until lockedUntil when the count reaches maxFailedLogins
+func (repo *repo) RecordFailedIpLogin(ip string, now time.Time, forgetBefore time.Time, maxFailedLogins int, lockedUntil time.Time) (failedLogins int, err error) {
+	var firstLock *time.Time
+	if maxFailedLogins <= 1 {
+		firstLock = &lockedUntil
+	}
+	err = dbconn.DB.Raw(`INSERT INTO login_attempt (ip, failed_logins, last_failed_login, locked_until) VALUES (?, 1, ?, ?)
+		ON CONFLICT (ip) DO UPDATE SET
+			failed_logins = CASE WHEN login_attempt.last_failed_login IS NULL OR login_attempt.last_failed_login < ? THEN 1 ELSE login_attempt.failed_logins + 1 END,
+			last_failed_login = EXCLUDED.last_failed_login,
+			locked_until = CASE WHEN (CASE WHEN login_attempt.last_failed_login IS NULL OR login_attempt.last_failed_login < ? THEN 1 ELSE login_attempt.failed_logins + 1 END) >= ?
+				THEN ? ELSE login_attempt.locked_until END
+		RETURNING failed_logins`, ip, now, firstLock, forgetBefore, forgetBefore, maxFailedLogins, lockedUntil).Row().Scan(&failedLogins)
+	return failedLogins, err
+}
+
+// RecordFailedLogin count a failed login of a user in a single statement, so concurrent logins cannot miss each other.
+// The failed logins before forgetBefore are forgotten first, the user is locked until lockedUntil when the count reaches maxFailedLogins
+func (repo *repo) RecordFailedLogin(userId uint, now time.Time, forgetBefore time.Time, maxFailedLogins int, lockedUntil time.Time) (failedLogins int, err error) {
+	err = dbconn.DB.Raw(`UPDATE "user" SET
+			failed_logins = CASE WHEN last_failed_login IS NULL OR last_failed_login < ? THEN 1 ELSE failed_logins + 1 END,
+			last_failed_login = ?,
+			locked_until = CASE WHEN (CASE WHEN last_failed_login IS NULL OR last_failed_login < ? THEN 1 ELSE failed_logins + 1 END) >= ?
+				THEN ? ELSE locked_until END
+		WHERE id = ? RETURNING failed_logins`, forgetBefore, now, forgetBefore, maxFailedLogins, lockedUntil, userId).Row().Scan(&failedLogins)
+	return failedLogins, err
+}
+
+// ResetFailedLogins forget the failed logins of a user and unlock it, without writing its other columns
+func (repo *repo) ResetFailedLogins(userId uint) error {
+	return dbconn.DB.Model(&service.Entity{}).Where("id = ?", userId).
+		UpdateColumns(map[string]interface{}{"failed_logins": 0, "last_failed_login": nil, "locked_until": nil}).Error
+}
+
+// UpdateSecondFactor save the last TOTP step and the recovery codes left of a user, without writing its other columns
+func (repo *repo) UpdateSecondFactor(user service.Entity) error {
+	return dbconn.DB.Model(&user).UpdateColumns(map[string]interface{}{"totp_last_step": user.TotpLastStep, "recovery_codes": user.RecoveryCodes}).Error
+}
+
+// CreateCredential create a WebAuthn credential in Database
+func (repo *repo) CreateCredential(credential service.Credential) error {
+	return dbconn.DB.Create(&credential).Error
+}
+
+// FindCredentialsByUserId find the WebAuthn credentials of a user in Database
+func (repo *repo) FindCredentialsByUserId(userId uint) (credentials []service.Credential, err error) {
+	err = dbconn.DB.Where("user_id = ?", userId).Order("created_at").Find(&credentials).Error
+	return credentials, err
+}
+
+// FindCredentialByCredentialId find a WebAuthn credential in Database by the ID chosen by its authenticator
+func (repo *repo) FindCredentialByCredentialId(credentialId string) (credential service.Credential, err error) {
+	if err = dbconn.DB.Where("credential_id = ?", credentialId).First(&credential).Error; err != nil {
+		return service.Credential{}, err
+	}
+	return credential, nil
+}
+
+// UpdateCredential edit a WebAuthn credential in Database
+func (repo *repo) UpdateCredential(credential service.Credential) error {
+	return dbconn.DB.Save(&credential).Error
+}
+
+// DeleteCredential remove a WebAuthn credential from Database
+func (repo *repo) DeleteCredential(credential service.Credential) error {
+	return dbconn.DB.Delete(&credential).Error
+}
+
+// CreateIdentity link an identity of an external provider to a user in Database
+func (repo *repo) CreateIdentity(identity service.Identity) error {
+	return dbconn.DB.Create(&identity).Error
+}
+
+// FindIdentity find a linked identity in Database by its provider and its subject
+func (repo *repo) FindIdentity(provider string, subject string) (identity service.Identity, err error) {
+	if err = dbconn.DB.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return service.Identity{}, err
+	}
+	return identity, nil
+}
+
+// FindIdentitiesByUserId find the identities linked to a user in Database
+func (repo *repo) FindIdentitiesByUserId(userId uint) (identities []service.Identity, err error) {
+	err = dbconn.DB.Where("user_id = ?", userId).Order("linked_at").Find(&identities).Error
+	return identities, err
+}
+
+// DeleteIdentity remove a linked identity from Database
+func (repo *repo) DeleteIdentity(identity service.Identity) error {
+	return dbconn.DB.Delete(&identity).Error
+}
+
+// DeleteIdentitiesByUserId remove the identities linked to a user from Database
+func (repo *repo) DeleteIdentitiesByUserId(userId uint) error {
+	return dbconn.DB.Where("user_id = ?", userId).Delete(service.Identity{}).Error
+}
+
+// FindAll find the users matching a query in Database
+func (repo *repo) FindAll(query apitool.Query) (users []service.Entity, err error) {
+	db := dbconn.DB
+	if conditions, args := query.Conditions(); conditions != "" {
+		db = db.Where(conditions, args...)
+	}
+	if query.Limit() > 0 {
+		db = db.Limit(query.Limit())
+	}
+	if err = db.Order(query.Order()).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// FindRoles find all the roles in Database
+func (repo *repo) FindRoles() (roles []service.Role, err error) {
+	err = dbconn.DB.Order("name").Find(&roles).Error
+	return roles, err
+}
+
+// FindRoleByName find a role in Database by name
+func (repo *repo) FindRoleByName(name string) (role service.Role, err error) {
+	if err = dbconn.DB.Where("name = ?", name).First(&role).Error; err != nil {
+		return service.Role{}, err
+	}
+	return role, nil
+}
+
+// FindRolesByUserId find the roles of a user in Database
+func (repo *repo) FindRolesByUserId(userId uint) (roles []service.Role, err error) {
+	err = dbconn.DB.Where("id IN (SELECT role_id FROM user_role WHERE user_id = ?)", userId).Order("name").Find(&roles).Error
+	return roles, err
+}
+
+// FindRolePermissions find the permissions granted by a role in Database
+func (repo *repo) FindRolePermissions(roleId uint) (permissions []string, err error) {
+	err = dbconn.DB.Model(&service.RolePermission{}).Where("role_id = ?", roleId).Order("permission").Pluck("permission", &permissions).Error
+	return permissions, err
+}
+
+// FindPermissionsByUserId find the permissions granted by all the roles of a user in Database
+func (repo *repo) FindPermissionsByUserId(userId uint) (permissions []string, err error) {
+	err = dbconn.DB.Model(&service.RolePermission{}).Where("role_id IN (SELECT role_id FROM user_role WHERE user_id = ?)", userId).Order("permission").Pluck("DISTINCT permission", &permissions).Error
+	return permissions, err
+}
+
+// SaveRole create or edit a role and replace its permissions in Database
+func (repo *repo) SaveRole(role service.Role, permissions []string) (service.Role, error) {
+	tx := dbconn.DB.Begin()
+	if err := tx.Save(&role).Error; err != nil {
+		tx.Rollback()
+		return service.Role{}, err
+	}
+	if err := tx.Where("role_id = ?", role.ID).Delete(&service.RolePermission{}).Error; err != nil {
+		tx.Rollback()
+		return service.Role{}, err
+	}
+	for _, permission := range permissions {
+		if err := tx.Create(&service.RolePermission{RoleId: role.ID, Permission: permission}).Error; err != nil {
+			tx.Rollback()
+			return service.Role{}, err
+		}
+	}
+	return role, tx.Commit().Error
+}
+
+// DeleteRole remove a role, its permissions and the users it was given to from Database
+func (repo *repo) DeleteRole(role service.Role) error {
+	tx := dbconn.DB.Begin()
+	if err := tx.Where("role_id = ?", role.ID).Delete(&service.UserRole{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("role_id = ?", role.ID).Delete(&service.RolePermission{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Delete(&role).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// SetUserRoles replace the roles of a user in Database
+func (repo *repo) SetUserRoles(userId uint, roleIds []uint) error {
+	tx := dbconn.DB.Begin()
+	if err := tx.Where("user_id = ?", userId).Delete(&service.UserRole{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, roleId := range roleIds {
+		if err := tx.Create(&service.UserRole{UserId: userId, RoleId: roleId}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// CreateDataRequest create a data request in Database
+func (repo *repo) CreateDataRequest(request service.DataRequest) (service.DataRequest, error) {
+	err := dbconn.DB.Create(&request).Error
+	return request, err
+}
+
+// FindDataRequests find the data requests matching a query in Database
+func (repo *repo) FindDataRequests(query apitool.Query) (requests []service.DataRequest, err error) {
+	db := dbconn.DB
+	if conditions, args := query.Conditions(); conditions != "" {
+		db = db.Where(conditions, args...)
+	}
+	if query.Limit() > 0 {
+		db = db.Limit(query.Limit())
+	}
+	if query.Order() != "" {
+		db = db.Order(query.Order())
+	}
+	err = db.Order("id").Find(&requests).Error
+	return requests, err
+}
+
+// UpdateDataRequest edit a data request in Database
+func (repo *repo) UpdateDataRequest(request service.DataRequest) error {
+	return dbconn.DB.Save(&request).Error
+}
+
+// CreateUsedToken record a single-use token as used in Database, it fails when the token has already been used
+func (repo *repo) CreateUsedToken(token service.UsedToken) error {
+	return dbconn.DB.Create(&token).Error
+}
+
+// PurgeUsedTokens delete the used tokens expired before a date from Database
+func (repo *repo) PurgeUsedTokens(expiredBefore time.Time) error {
+	return dbconn.DB.Where("expires_at < ?", expiredBefore).Delete(&service.UsedToken{}).Error
+}
+
+// RunExclusively run a job while holding a database advisory lock, so a single instance runs it at a time.
+// It returns false without running the job when another instance holds the lock
+func (repo *repo) RunExclusively(lock int64, job func()) (ran bool, err error) {
+	return apitool.RunExclusively(dbconn.DB.DB(), lock, job)
+}
@@ -79,3 +79,20 @@ func TestRepository_DeleteUserThatDoesNotExist(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestRepository_RunExclusively(t *testing.T) {
+	var nestedRan bool
+	ran, err := r.RunExclusively(1, func() {
+		nestedRan, _ = r.RunExclusively(1, func() {})
+	})
+	if err != nil || !ran {
+		t.Error("The job did not run while the lock was free", err)
+	}
+	if nestedRan {
+		t.Error("The job ran while another connection held the lock")
+	}
+
+	if ran, err := r.RunExclusively(1, func() {}); err != nil || !ran {
+		t.Error("The lock was not released after the job", err)
+	}
+}
@@ -0,0 +1,167 @@
+// Package rest implement the callback required by the user package
+package rest
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// RequestDTOListDataRequests is the object to map the query parameters of requests listing the data requests.
+// Sort is created_at or scheduled_at, prefixed by - for a descending order, and Cursor is the next_cursor of the previous page
+type RequestDTOListDataRequests struct {
+	Limit  int    `form:"limit"`
+	Cursor string `form:"cursor"`
+	Sort   string `form:"sort"`
+	Kind   string `form:"kind"`
+	Status string `form:"status"`
+}
+
+// ResponseDTODataRequest is the object to map JSON response body describing an export or an erasure of the personal data of a user.
+// Steps are the steps of an erasure already done and Error is the last error that stopped it, it is retried later
+type ResponseDTODataRequest struct {
+	Id          uint       `json:"id"`
+	Kind        string     `json:"kind"`
+	Status      string     `json:"status"`
+	UserId      uint       `json:"user_id"`
+	SubjectHash string     `json:"subject_hash"`
+	RequestedBy uint       `json:"requested_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ScheduledAt time.Time  `json:"scheduled_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	Steps       []string   `json:"steps"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// ResponseDTODataRequestList is the object to map JSON response body of a page of data requests, NextCursor is empty on the last page
+type ResponseDTODataRequestList struct {
+	DataRequests []ResponseDTODataRequest `json:"data_requests"`
+	NextCursor   string                   `json:"next_cursor,omitempty"`
+}
+
+// ResponseDTOExport is the object to map JSON response body of the export of the personal data of a user kept by every service.
+// Profiles and Authorizations are the documents exported by the profile and the oauth2 micro services
+type ResponseDTOExport struct {
+	ExportedAt          time.Time                       `json:"exported_at"`
+	User                ResponseDTOExportUser           `json:"user"`
+	WebauthnCredentials []ResponseDTOWebauthnCredential `json:"webauthn_credentials"`
+	Identities          []ResponseDTOIdentity           `json:"identities"`
+	Roles               []string                        `json:"roles"`
+	DataRequests        []ResponseDTODataRequest        `json:"data_requests"`
+	Profiles            json.RawMessage                 `json:"profiles,omitempty"`
+	Authorizations      json.RawMessage                 `json:"authorizations,omitempty"`
+}
+
+// ResponseDTOExportUser is the object to map JSON response body of the account of a user in the export of its personal data,
+// the password, the secrets and the hashes of the tokens are not exported
+type ResponseDTOExportUser struct {
+	Email           string     `json:"email"`
+	Username        string     `json:"username"`
+	PendingEmail    string     `json:"pending_email"`
+	VerifiedAt      *time.Time `json:"verified_at"`
+	Status          string     `json:"status"`
+	StatusReason    string     `json:"status_reason"`
+	StatusChangedAt *time.Time `json:"status_changed_at"`
+	TotpEnabled     bool       `json:"totp_enabled"`
+	LastFailedLogin *time.Time `json:"last_failed_login"`
+	LockedUntil     *time.Time `json:"locked_until"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// GetExport allows to access the service to download the personal data of a user, as a ZIP archive or as JSON with format=json
+func (r *rest) GetExport(c *gin.Context) {
+	resDTO, err := r.service.ExportUserData(c.Param("email"), apitool.GetTokenUserId(c))
+	if err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+		return
+	}
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, resDTO)
+		return
+	}
+	archive, archiveErr := createExportArchive(resDTO)
+	if archiveErr != nil {
+		c.JSON(apihelper.BuildResponseError(&servicehelper.Error{
+			Detail:  archiveErr,
+			Message: "We could not create the archive of the data, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}))
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=\""+config.GAppName+"-data-export.zip\"")
+	c.Data(http.StatusOK, "application/zip", archive)
+}
+
+// createExportArchive write the export of the personal data of a user in a ZIP archive, with one JSON file per service
+func createExportArchive(resDTO ResponseDTOExport) ([]byte, error) {
+	profiles, authorizations := resDTO.Profiles, resDTO.Authorizations
+	resDTO.Profiles, resDTO.Authorizations = nil, nil
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"user.json", resDTO},
+		{"profiles.json", profiles},
+		{"authorizations.json", authorizations},
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, file := range files {
+		content, err := json.MarshalIndent(file.content, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// PostErasure allows to access the service to ask for the erasure of the personal data of a user at the end of the grace period
+func (r *rest) PostErasure(c *gin.Context) {
+	if resDTO, err := r.service.RequestErasure(c.Param("email"), apitool.GetTokenUserId(c)); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusAccepted, resDTO)
+	}
+}
+
+// GetDataRequests allows to access the service to follow the exports and the erasures of the personal data of a user
+func (r *rest) GetDataRequests(c *gin.Context) {
+	if resDTO, err := r.service.RetrieveDataRequests(c.Param("email")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTO)
+	}
+}
+
+// GetAdminDataRequests allows an administrator to list the exports and the erasures of the personal data of the users
+func (r *rest) GetAdminDataRequests(c *gin.Context) {
+	var reqDTO RequestDTOListDataRequests
+	if err := c.Bind(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.ListDataRequests(reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
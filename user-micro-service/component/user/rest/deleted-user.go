@@ -0,0 +1,68 @@
+// Package rest implement the callback required by the user package
+package rest
+
+import (
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// RequestDTOListDeletedUsers is the object to map the query parameters of requests listing the deleted users.
+// Sort is email or deleted_at, prefixed by - for a descending order, and Cursor is the next_cursor of the previous page
+type RequestDTOListDeletedUsers struct {
+	Limit  int    `form:"limit"`
+	Cursor string `form:"cursor"`
+	Sort   string `form:"sort"`
+	// Email is the beginning of the email addresses
+	Email string `form:"email"`
+}
+
+// ResponseDTODeletedUser is the object to map JSON response body describing a deleted user, it can be restored until PurgeAt
+type ResponseDTODeletedUser struct {
+	Email     string    `json:"email"`
+	Username  string    `json:"username"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+// ResponseDTODeletedUserList is the object to map JSON response body of a page of deleted users, NextCursor is empty on the last page
+type ResponseDTODeletedUserList struct {
+	Users      []ResponseDTODeletedUser `json:"users"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
+
+// GetDeletedUsers allows an administrator to list the deleted users that can still be restored
+func (r *rest) GetDeletedUsers(c *gin.Context) {
+	var reqDTO RequestDTOListDeletedUsers
+	if err := c.Bind(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.ListDeletedUsers(reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
+
+// PostRestore allows an administrator to bring back a deleted user before the end of the retention period
+func (r *rest) PostRestore(c *gin.Context) {
+	if resDTO, err := r.service.Restore(c.Param("email")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTO)
+	}
+}
+
+// DeleteDeletedUser allows an administrator to purge a deleted user and its data in every service without waiting for the end of the retention period
+func (r *rest) DeleteDeletedUser(c *gin.Context) {
+	if err := r.service.Purge(c.Param("email"), apitool.GetTokenUserId(c)); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "user has been purged successfully"})
+	}
+}
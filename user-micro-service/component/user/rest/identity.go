@@ -0,0 +1,56 @@
+// Package rest implement the callback required by the user package
+package rest
+
+import (
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// RequestDTOLinkIdentity is the object to map JSON request body for requests linking an identity provider to a user,
+// the token is an access token or an ID token obtained from the provider
+type RequestDTOLinkIdentity struct {
+	Provider string `json:"provider" binding:"required"`
+	Token    string `json:"token" binding:"required"`
+}
+
+// ResponseDTOIdentity is the object to map JSON response body describing an identity linked to a user
+type ResponseDTOIdentity struct {
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"subject"`
+	EmailAtLink string    `json:"email_at_link"`
+	LinkedAt    time.Time `json:"linked_at"`
+}
+
+// GetIdentities allows to access the service to list the identities linked to a user
+func (r *rest) GetIdentities(c *gin.Context) {
+	if resDTO, err := r.service.RetrieveIdentities(c.Param("email")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTO)
+	}
+}
+
+// PostIdentity allows to access the service to link an identity provider to a user
+func (r *rest) PostIdentity(c *gin.Context) {
+	var reqDTO RequestDTOLinkIdentity
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.LinkIdentity(c.Param("email"), reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusCreated, resDTO)
+		}
+	}
+}
+
+// DeleteIdentity allows to access the service to unlink an identity provider from a user
+func (r *rest) DeleteIdentity(c *gin.Context) {
+	if err := r.service.UnlinkIdentity(c.Param("email"), c.Param("provider"), c.Param("subject")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "identity has been unlinked successfully"})
+	}
+}
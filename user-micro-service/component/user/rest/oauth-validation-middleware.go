@@ -3,6 +3,7 @@ package rest
 
 import (
 	"encoding/json"
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
 	"github.com/adriendomoison/apigoboot/user-micro-service/config"
 	"github.com/gin-gonic/gin"
@@ -10,7 +11,7 @@ import (
 	"net/http"
 )
 
-func askOauthServiceForTokenOwnerUserId(token string) (uint, int, *apihelper.ApiErrors) {
+func askOauthServiceForTokenOwnerUserId(token string) (apitool.TokenOwner, int, *apihelper.ApiErrors) {
 	req, err := http.NewRequest("GET", config.GAppUrl+"/api/private-v1/access-token/"+token+"/get-owner", nil)
 	// TODO add client credential access token
 	//req.Header.Set("Authorization", "Bearer xxx")
@@ -24,17 +25,17 @@ func askOauthServiceForTokenOwnerUserId(token string) (uint, int, *apihelper.Api
 
 	body, _ := ioutil.ReadAll(resp.Body)
 
-	accessTokenOwner := struct {
-		UserId uint `json:"user_id"`
-	}{}
+	var accessTokenOwner apitool.TokenOwner
 	if json.Unmarshal(body, &accessTokenOwner) != nil {
 		apiErrors := apihelper.ApiErrors{}
 		json.Unmarshal(body, &apiErrors)
-		return 0, resp.StatusCode, &apiErrors
+		return apitool.TokenOwner{}, resp.StatusCode, &apiErrors
 	}
-	return accessTokenOwner.UserId, 0, nil
+	return accessTokenOwner, 0, nil
 }
 
+// ValidateAccessToken check the access token of a request acting on a user and whether its owner is this user (middleware).
+// It must be followed by apitool.Authorize to restrict the route to the user or to the roles allowed to act on other accounts
 func (r *rest) ValidateAccessToken(c *gin.Context) {
 
 	authorizationCode := c.Request.Header.Get("Authorization")
@@ -51,18 +52,36 @@ func (r *rest) ValidateAccessToken(c *gin.Context) {
 		return
 	}
 
-	tokenUserId, _, err := askOauthServiceForTokenOwnerUserId(authorizationCode[7:])
+	tokenOwner, _, err := askOauthServiceForTokenOwnerUserId(authorizationCode[7:])
 	if err != nil {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
 
-	if ok, err := r.service.IsThatTheUserId(email, tokenUserId); err != nil {
-		c.JSON(apihelper.BuildResponseError(err))
+	isOwner, svcErr := r.service.IsThatTheUserId(email, tokenOwner.UserId)
+	if svcErr != nil {
+		c.JSON(apihelper.BuildResponseError(svcErr))
+		c.Abort()
 		return
-	} else if !ok {
+	}
+	apitool.SetTokenOwner(c, tokenOwner, isOwner)
+	c.Next()
+}
+
+// ValidateAdminAccessToken check the access token of a request that does not act on behalf of a user (middleware).
+// It must be followed by apitool.Authorize to restrict the route to the tokens granted the admin scope or to the roles allowed
+func (r *rest) ValidateAdminAccessToken(c *gin.Context) {
+	token := apitool.BearerToken(c)
+	if token == "" {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	tokenOwner, _, err := askOauthServiceForTokenOwnerUserId(token)
+	if err != nil {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
+	apitool.SetTokenOwner(c, tokenOwner, false)
 	c.Next()
 }
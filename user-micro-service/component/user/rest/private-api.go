@@ -12,6 +12,14 @@ import (
 type ResponseDTOUserInfo struct {
 	UserId uint   `json:"user_id"`
 	Email  string `json:"email"`
+	// Status is the step of the lifecycle of the user, e.g. active or deactivated
+	Status string `json:"status,omitempty"`
+	// Roles are the roles of the user and Permissions the permissions they grant, exposed to the other services through the access tokens
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// MfaRequired is set by CheckCredentials instead of the user info when the login must be completed with a second factor
+	MfaRequired bool   `json:"mfa_required,omitempty"`
+	MfaToken    string `json:"mfa_token,omitempty"`
 }
 
 // RequestDTOCheckCredentials is the object to map JSON request body for login requests
@@ -19,6 +27,13 @@ type RequestDTOCheckCredentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	AuthType string `json:"auth_type"`
+	// Ip is the address of the user logging in, used to throttle failed password logins
+	Ip string `json:"ip"`
+	// MfaToken and Code complete a login waiting for a second factor, with the "mfa" auth type
+	MfaToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+	// Webauthn is the assertion signed by a passkey or a security key, with the "webauthn" auth type
+	Webauthn *RequestDTOWebauthnCredential `json:"webauthn"`
 }
 
 // GetByEmail allows to access the service to retrieve a user info when sending its email (private API)
@@ -2,11 +2,13 @@
 package rest
 
 import (
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
 	"github.com/adriendomoison/apigoboot/user-micro-service/component/user"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"time"
 )
 
 // ServiceInterface is the model for the service package of user
@@ -19,24 +21,60 @@ type ServiceInterface interface {
 	Remove(string) *servicehelper.Error
 	CheckCredentials(RequestDTOCheckCredentials) (ResponseDTOUserInfo, *servicehelper.Error)
 	AddWithProfile(profile RequestDTOWithProfile) (ResponseDTOWithProfile, *servicehelper.Error)
-	RetrieveWithProfile(email string) (ResponseDTOWithProfile, *servicehelper.Error)
+	RetrieveWithProfile(email string, accessToken string) (ResponseDTOWithProfile, *servicehelper.Error)
 	IsThatTheUserId(email string, userIdToCheck uint) (bool, *servicehelper.Error)
 	RetrieveUserInfoByEmail(email string) (resDTO ResponseDTOUserInfo, error *servicehelper.Error)
 	RetrieveUserInfoByUserId(userId uint) (resDTO ResponseDTOUserInfo, error *servicehelper.Error)
+	Unlock(email string) *servicehelper.Error
+	EnrollTotp(email string) (ResponseDTOTotpEnrollment, *servicehelper.Error)
+	VerifyTotp(email string, reqDTO RequestDTOTotpCode) (ResponseDTORecoveryCodes, *servicehelper.Error)
+	DisableTotp(email string, reqDTO RequestDTOTotpCode) *servicehelper.Error
+	StartWebauthnRegistration(email string) (ResponseDTOWebauthnCreationOptions, *servicehelper.Error)
+	FinishWebauthnRegistration(email string, reqDTO RequestDTOWebauthnCredential) (ResponseDTOWebauthnCredential, *servicehelper.Error)
+	RetrieveWebauthnCredentials(email string) ([]ResponseDTOWebauthnCredential, *servicehelper.Error)
+	RemoveWebauthnCredential(email string, credentialId string) *servicehelper.Error
+	StartWebauthnLogin(reqDTO RequestDTOWebauthnRequestOptions) (ResponseDTOWebauthnRequestOptions, *servicehelper.Error)
+	RetrieveIdentityProviders() []ResponseDTOIdentityProvider
+	RetrieveIdentities(email string) ([]ResponseDTOIdentity, *servicehelper.Error)
+	LinkIdentity(email string, reqDTO RequestDTOLinkIdentity) (ResponseDTOIdentity, *servicehelper.Error)
+	UnlinkIdentity(email string, provider string, subject string) *servicehelper.Error
+	VerifyEmail(email string, reqDTO RequestDTOVerifyEmail) (ResponseDTO, *servicehelper.Error)
+	ResendVerificationEmail(email string) *servicehelper.Error
+	RequestVerificationEmail(reqDTO RequestDTOVerificationEmail)
+	RequestPasswordReset(reqDTO RequestDTOPasswordReset)
+	ResetPassword(reqDTO RequestDTOConfirmPasswordReset) *servicehelper.Error
+	ImportUsers(reqDTO RequestDTOImportUsers) (ResponseDTOImportUsers, *servicehelper.Error)
+	ListUsers(reqDTO RequestDTOListUsers) (ResponseDTOUserList, *servicehelper.Error)
+	SuspendUsers(reqDTO RequestDTOBulkUsers) (ResponseDTOBulkUpdate, *servicehelper.Error)
+	UnsuspendUsers(reqDTO RequestDTOBulkUsers) (ResponseDTOBulkUpdate, *servicehelper.Error)
+	EditStatus(email string, reqDTO RequestDTOStatus) (ResponseDTOStatus, *servicehelper.Error)
+	Deactivate(email string, reqDTO RequestDTODeactivate) *servicehelper.Error
+	RetrieveRoles() ([]ResponseDTORole, *servicehelper.Error)
+	EditRole(name string, reqDTO RequestDTORole) (ResponseDTORole, *servicehelper.Error)
+	RemoveRole(name string) *servicehelper.Error
+	RetrieveUserRoles(email string) (ResponseDTOUserRoles, *servicehelper.Error)
+	EditUserRoles(email string, reqDTO RequestDTOUserRoles) (ResponseDTOUserRoles, *servicehelper.Error)
+	ExportUserData(email string, requestedBy uint) (ResponseDTOExport, *servicehelper.Error)
+	RequestErasure(email string, requestedBy uint) (ResponseDTODataRequest, *servicehelper.Error)
+	RetrieveDataRequests(email string) ([]ResponseDTODataRequest, *servicehelper.Error)
+	ListDataRequests(reqDTO RequestDTOListDataRequests) (ResponseDTODataRequestList, *servicehelper.Error)
+	ListDeletedUsers(reqDTO RequestDTOListDeletedUsers) (ResponseDTODeletedUserList, *servicehelper.Error)
+	Restore(email string) (ResponseDTOAdminUser, *servicehelper.Error)
+	Purge(email string, requestedBy uint) *servicehelper.Error
 }
 
 // RequestDTO is the object to map JSON request body
 type RequestDTO struct {
 	Username string `json:"username"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
+	Password string `json:"password" binding:"required"`
 }
 
 // RequestDTOWithProfile is the object to map JSON request body when a profile is added to the request
 type RequestDTOWithProfile struct {
 	Username  string `json:"username"`
 	Email     string `json:"email" binding:"required,email"`
-	Password  string `json:"password" binding:"required,min=8"`
+	Password  string `json:"password" binding:"required"`
 	FirstName string `json:"first_name" binding:"required,min=2"`
 	LastName  string `json:"last_name" binding:"required,min=2"`
 	Birthday  string `json:"birthday" binding:"required,min=10"`
@@ -46,20 +84,96 @@ type RequestDTOWithProfile struct {
 type RequestDTOPutEmail struct {
 	Email    string `json:"email" binding:"required,email"`
 	NewEmail string `json:"new_email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
+	Password string `json:"password" binding:"required"`
 }
 
 // RequestDTOPutPassword is the object to map JSON request body for requests to edit the email of a user
 type RequestDTOPutPassword struct {
 	Email       string `json:"email" binding:"required,email"`
-	Password    string `json:"password" binding:"required,min=8"`
-	NewPassword string `json:"new_password" binding:"required,min=8"`
+	Password    string `json:"password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// RequestDTOVerifyEmail is the object to map JSON request body for requests confirming an email address with the token sent to it
+type RequestDTOVerifyEmail struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// RequestDTOVerificationEmail is the object to map JSON request body for requests asking for a new link confirming an email address before signing in
+type RequestDTOVerificationEmail struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// RequestDTOPasswordReset is the object to map JSON request body for requests asking for a link resetting a forgotten password
+type RequestDTOPasswordReset struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// RequestDTOConfirmPasswordReset is the object to map JSON request body for requests choosing a new password with the token of a reset link
+type RequestDTOConfirmPasswordReset struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// RequestDTOImportUsers is the object to map JSON request body for requests importing users from another system
+type RequestDTOImportUsers struct {
+	Users []RequestDTOImportUser `json:"users" binding:"required,dive"`
+}
+
+// RequestDTOImportUser is a user imported with the hash of its password, e.g. "$2a$10$..." for bcrypt
+type RequestDTOImportUser struct {
+	Email        string `json:"email" binding:"required,email"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash" binding:"required"`
+	Verified     bool   `json:"verified"`
+}
+
+// RequestDTOListUsers is the object to map the query parameters of requests listing users.
+// Sort is email or created_at, prefixed by - for a descending order, and Cursor is the next_cursor of the previous page
+type RequestDTOListUsers struct {
+	Limit  int    `form:"limit"`
+	Cursor string `form:"cursor"`
+	Sort   string `form:"sort"`
+	// Email is the beginning of the email addresses
+	Email string `form:"email"`
+	// CreatedAfter and CreatedBefore are RFC 3339 dates
+	CreatedAfter  string `form:"created_after"`
+	CreatedBefore string `form:"created_before"`
+	Verified      string `form:"verified"`
+	// Provider is the name of an identity provider linked to the users, or "password" for the users having a password
+	Provider string `form:"provider"`
+	Status   string `form:"status"`
+}
+
+// RequestDTOBulkUsers is the object to map JSON request body for requests updating several users at once
+type RequestDTOBulkUsers struct {
+	Emails []string `json:"emails" binding:"required,dive,email"`
+	Reason string   `json:"reason"`
+}
+
+// RequestDTOStatus is the object to map JSON request body for requests changing the status of a user
+type RequestDTOStatus struct {
+	Status string `json:"status" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+// RequestDTODeactivate is the object to map JSON request body for requests of users deactivating their own account
+type RequestDTODeactivate struct {
+	Reason string `json:"reason"`
+}
+
+// RequestDTOTotpCode is the object to map JSON request body for requests confirming a TOTP code
+type RequestDTOTotpCode struct {
+	Code string `json:"code" binding:"required"`
 }
 
 // ResponseDTO is the object to map JSON response body
 type ResponseDTO struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
+	Verified bool   `json:"verified"`
+	// PendingEmail is the new email address of the user until it is confirmed
+	PendingEmail string `json:"pending_email,omitempty"`
 }
 
 // ResponseDTOWithProfile is the object to map JSON response body when a profile is added to the response
@@ -72,6 +186,65 @@ type ResponseDTOWithProfile struct {
 	Birthday  string `json:"birthday"`
 }
 
+// ResponseDTOTotpEnrollment is the object to map JSON response body of a TOTP enrollment, the otpauth URI can be shown as a QR code
+type ResponseDTOTotpEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauth_uri"`
+}
+
+// ResponseDTORecoveryCodes is the object to map JSON response body listing recovery codes, they are only shown once
+type ResponseDTORecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// ResponseDTOIdentityProvider is the object to map JSON response body describing an external identity provider the users can log in with
+type ResponseDTOIdentityProvider struct {
+	Name     string   `json:"name"`
+	Issuer   string   `json:"issuer,omitempty"`
+	ClientId string   `json:"client_id,omitempty"`
+	Scopes   []string `json:"scopes"`
+}
+
+// ResponseDTOImportUsers is the object to map JSON response body of an import, with the users that could not be imported
+type ResponseDTOImportUsers struct {
+	Imported int                      `json:"imported"`
+	Failures []ResponseDTOUserFailure `json:"failures"`
+}
+
+// ResponseDTOUserFailure is the object to map JSON response body telling why a user could not be imported or updated
+type ResponseDTOUserFailure struct {
+	Email   string `json:"email"`
+	Message string `json:"message"`
+}
+
+// ResponseDTOAdminUser is the object to map JSON response body describing a user to an administrator
+type ResponseDTOAdminUser struct {
+	Email     string    `json:"email"`
+	Username  string    `json:"username"`
+	Verified  bool      `json:"verified"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ResponseDTOStatus is the object to map JSON response body describing the status of a user
+type ResponseDTOStatus struct {
+	Status    string     `json:"status"`
+	Reason    string     `json:"reason"`
+	ChangedAt *time.Time `json:"changed_at"`
+}
+
+// ResponseDTOUserList is the object to map JSON response body of a page of users, NextCursor is empty on the last page
+type ResponseDTOUserList struct {
+	Users      []ResponseDTOAdminUser `json:"users"`
+	NextCursor string                 `json:"next_cursor,omitempty"`
+}
+
+// ResponseDTOBulkUpdate is the object to map JSON response body of a bulk update, with the users that could not be updated
+type ResponseDTOBulkUpdate struct {
+	Updated  int                      `json:"updated"`
+	Failures []ResponseDTOUserFailure `json:"failures"`
+}
+
 // Make sure the interface is implemented correctly
 var _ user.RestInterface = (*rest)(nil)
 
@@ -127,7 +300,13 @@ func (r *rest) Get(c *gin.Context) {
 		return
 	}
 
-	if resDTO, err := r.service.RetrieveWithProfile(c.Param("email")); err != nil {
+	if !getProfile {
+		if resDTO, err := r.service.Retrieve(c.Param("email")); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	} else if resDTO, err := r.service.RetrieveWithProfile(c.Param("email"), apitool.BearerToken(c)); err != nil {
 		c.JSON(apihelper.BuildResponseError(err))
 	} else {
 		c.JSON(http.StatusOK, resDTO)
@@ -148,6 +327,40 @@ func (r *rest) PutEmail(c *gin.Context) {
 	}
 }
 
+// VerifyEmail allows to access the service to confirm an email address, or a new email address waiting for confirmation
+func (r *rest) VerifyEmail(c *gin.Context) {
+	var reqDTO RequestDTOVerifyEmail
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.VerifyEmail(c.Param("email"), reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
+
+// PostVerificationEmail allows to access the service to send again the link confirming the email address of a user
+func (r *rest) PostVerificationEmail(c *gin.Context) {
+	if err := r.service.ResendVerificationEmail(c.Param("email")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusAccepted, gin.H{"message": "verification email has been sent"})
+	}
+}
+
+// PostVerificationEmailRequest allows the other micro services to send a new link to a user who can't sign in until its email is confirmed (private API)
+func (r *rest) PostVerificationEmailRequest(c *gin.Context) {
+	var reqDTO RequestDTOVerificationEmail
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		r.service.RequestVerificationEmail(reqDTO)
+		c.JSON(http.StatusAccepted, gin.H{"message": "if an account needs to confirm this email, a new link has been sent"})
+	}
+}
+
 // PutPassword allows to access the service to update the password of a user
 func (r *rest) PutPassword(c *gin.Context) {
 	var reqDTO RequestDTOPutPassword
@@ -162,6 +375,32 @@ func (r *rest) PutPassword(c *gin.Context) {
 	}
 }
 
+// PostPasswordReset allows to access the service to send a link resetting the password of a user.
+// The answer does not depend on the existence of the user so it can't be used to find the registered emails
+func (r *rest) PostPasswordReset(c *gin.Context) {
+	var reqDTO RequestDTOPasswordReset
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		r.service.RequestPasswordReset(reqDTO)
+		c.JSON(http.StatusAccepted, gin.H{"message": "if an account uses this email, a link to reset its password has been sent"})
+	}
+}
+
+// ConfirmPasswordReset allows to access the service to choose a new password with the token of a reset link
+func (r *rest) ConfirmPasswordReset(c *gin.Context) {
+	var reqDTO RequestDTOConfirmPasswordReset
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if err := r.service.ResetPassword(reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, gin.H{"message": "password has been reset successfully"})
+		}
+	}
+}
+
 // Delete allows to access the service to remove a user from the records
 func (r *rest) Delete(c *gin.Context) {
 	if err := r.service.Remove(c.Param("email")); err != nil {
@@ -170,3 +409,136 @@ func (r *rest) Delete(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "user has been deleted successfully"})
 	}
 }
+
+// Unlock allows an administrator to unlock an account locked after too many failed logins
+func (r *rest) Unlock(c *gin.Context) {
+	if err := r.service.Unlock(c.Param("email")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "user has been unlocked successfully"})
+	}
+}
+
+// ImportUsers allows an administrator to create users from another system with the hashes of their passwords
+func (r *rest) ImportUsers(c *gin.Context) {
+	var reqDTO RequestDTOImportUsers
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.ImportUsers(reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
+
+// GetUsers allows an administrator to list the users, a page at a time
+func (r *rest) GetUsers(c *gin.Context) {
+	var reqDTO RequestDTOListUsers
+	if err := c.Bind(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.ListUsers(reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
+
+// SuspendUsers allows an administrator to prevent several users from logging in
+func (r *rest) SuspendUsers(c *gin.Context) {
+	var reqDTO RequestDTOBulkUsers
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.SuspendUsers(reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
+
+// UnsuspendUsers allows an administrator to let several suspended users log in again
+func (r *rest) UnsuspendUsers(c *gin.Context) {
+	var reqDTO RequestDTOBulkUsers
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.UnsuspendUsers(reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
+
+// PutStatus allows to access the service to change the status of a user
+func (r *rest) PutStatus(c *gin.Context) {
+	var reqDTO RequestDTOStatus
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.EditStatus(c.Param("email"), reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
+
+// PostDeactivation allows to access the service to let a user deactivate its own account
+func (r *rest) PostDeactivation(c *gin.Context) {
+	var reqDTO RequestDTODeactivate
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else if err := r.service.Deactivate(c.Param("email"), reqDTO); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "account has been deactivated successfully"})
+	}
+}
+
+// GetIdentityProviders allows to access the service to list the identity providers the users can log in with
+func (r *rest) GetIdentityProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, r.service.RetrieveIdentityProviders())
+}
+
+// PostTotp allows to access the service to enroll an authenticator app
+func (r *rest) PostTotp(c *gin.Context) {
+	if resDTO, err := r.service.EnrollTotp(c.Param("email")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusCreated, resDTO)
+	}
+}
+
+// VerifyTotp allows to access the service to verify the first code of an authenticator app and get the recovery codes
+func (r *rest) VerifyTotp(c *gin.Context) {
+	var reqDTO RequestDTOTotpCode
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.VerifyTotp(c.Param("email"), reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
+
+// DisableTotp allows to access the service to stop asking for a TOTP code at login
+func (r *rest) DisableTotp(c *gin.Context) {
+	var reqDTO RequestDTOTotpCode
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if err := r.service.DisableTotp(c.Param("email"), reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, gin.H{"message": "two-step verification has been disabled successfully"})
+		}
+	}
+}
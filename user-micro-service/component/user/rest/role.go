@@ -0,0 +1,87 @@
+// Package rest implement the callback required by the user package
+package rest
+
+import (
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// RequestDTORole is the object to map JSON request body for requests creating or editing a role
+type RequestDTORole struct {
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// ResponseDTORole is the object to map JSON response body describing a role
+type ResponseDTORole struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// RequestDTOUserRoles is the object to map JSON request body for requests replacing the roles of a user
+type RequestDTOUserRoles struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+// ResponseDTOUserRoles is the object to map JSON response body describing the roles of a user and the permissions they grant
+type ResponseDTOUserRoles struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// GetRoles allows to access the service to list the roles
+func (r *rest) GetRoles(c *gin.Context) {
+	if resDTO, err := r.service.RetrieveRoles(); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTO)
+	}
+}
+
+// PutRole allows to access the service to create a role or edit its description and permissions
+func (r *rest) PutRole(c *gin.Context) {
+	var reqDTO RequestDTORole
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.EditRole(c.Param("name"), reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
+
+// DeleteRole allows to access the service to remove a role from the users and delete it
+func (r *rest) DeleteRole(c *gin.Context) {
+	if err := r.service.RemoveRole(c.Param("name")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "role has been deleted successfully"})
+	}
+}
+
+// GetUserRoles allows to access the service to list the roles of a user
+func (r *rest) GetUserRoles(c *gin.Context) {
+	if resDTO, err := r.service.RetrieveUserRoles(c.Param("email")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTO)
+	}
+}
+
+// PutUserRoles allows to access the service to replace the roles of a user
+func (r *rest) PutUserRoles(c *gin.Context) {
+	var reqDTO RequestDTOUserRoles
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.EditUserRoles(c.Param("email"), reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package rest implement the callback required by the user package
+package rest
+
+import (
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// DTOWebauthnRelyingParty is the relying party of the WebAuthn options
+type DTOWebauthnRelyingParty struct {
+	Id   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+// DTOWebauthnUser is the user of the WebAuthn creation options, its id is base64url encoded
+type DTOWebauthnUser struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// DTOWebauthnCredentialParameters is a public key algorithm accepted by the server
+type DTOWebauthnCredentialParameters struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// DTOWebauthnCredentialDescriptor designate a registered credential, its id is base64url encoded
+type DTOWebauthnCredentialDescriptor struct {
+	Type       string   `json:"type"`
+	Id         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// DTOWebauthnAuthenticatorSelection is the kind of authenticator expected for a registration
+type DTOWebauthnAuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// ResponseDTOWebauthnCreationOptions is the object to map JSON response body of the start of a WebAuthn registration.
+// It follows the JSON form of the PublicKeyCredentialCreationOptions of the browsers, binary values are base64url encoded
+type ResponseDTOWebauthnCreationOptions struct {
+	Challenge              string                            `json:"challenge"`
+	Rp                     DTOWebauthnRelyingParty           `json:"rp"`
+	User                   DTOWebauthnUser                   `json:"user"`
+	PubKeyCredParams       []DTOWebauthnCredentialParameters `json:"pubKeyCredParams"`
+	Timeout                int64                             `json:"timeout"`
+	Attestation            string                            `json:"attestation"`
+	ExcludeCredentials     []DTOWebauthnCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection DTOWebauthnAuthenticatorSelection `json:"authenticatorSelection"`
+}
+
+// RequestDTOWebauthnRequestOptions is the object to map JSON request body of the start of a WebAuthn login, the username is optional with passkeys
+type RequestDTOWebauthnRequestOptions struct {
+	Username string `json:"username"`
+}
+
+// ResponseDTOWebauthnRequestOptions is the object to map JSON response body of the start of a WebAuthn login.
+// It follows the JSON form of the PublicKeyCredentialRequestOptions of the browsers, binary values are base64url encoded
+type ResponseDTOWebauthnRequestOptions struct {
+	Challenge        string                            `json:"challenge"`
+	RpId             string                            `json:"rpId"`
+	Timeout          int64                             `json:"timeout"`
+	UserVerification string                            `json:"userVerification"`
+	AllowCredentials []DTOWebauthnCredentialDescriptor `json:"allowCredentials"`
+}
+
+// RequestDTOWebauthnResponse is the response of an authenticator, binary values are base64url encoded.
+// The attestation object is sent during a registration, the authenticator data, the signature and the user handle during a login
+type RequestDTOWebauthnResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON" binding:"required"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports"`
+	AuthenticatorData string   `json:"authenticatorData"`
+	Signature         string   `json:"signature"`
+	UserHandle        string   `json:"userHandle"`
+}
+
+// RequestDTOWebauthnCredential is the object to map the JSON form of the PublicKeyCredential created or used by a browser
+type RequestDTOWebauthnCredential struct {
+	Id       string                     `json:"id" binding:"required"`
+	Type     string                     `json:"type"`
+	Response RequestDTOWebauthnResponse `json:"response"`
+	// Name is the label given by the user to a new credential
+	Name string `json:"name"`
+}
+
+// ResponseDTOWebauthnCredential is the object to map JSON response body describing a registered credential
+type ResponseDTOWebauthnCredential struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Transports []string   `json:"transports"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// PostWebauthnRegistrationOptions allows to access the service to start the registration of a passkey or a security key
+func (r *rest) PostWebauthnRegistrationOptions(c *gin.Context) {
+	if resDTO, err := r.service.StartWebauthnRegistration(c.Param("email")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTO)
+	}
+}
+
+// PostWebauthnCredential allows to access the service to register the credential created by the browser
+func (r *rest) PostWebauthnCredential(c *gin.Context) {
+	var reqDTO RequestDTOWebauthnCredential
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.FinishWebauthnRegistration(c.Param("email"), reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusCreated, resDTO)
+		}
+	}
+}
+
+// GetWebauthnCredentials allows to access the service to list the credentials of a user
+func (r *rest) GetWebauthnCredentials(c *gin.Context) {
+	if resDTO, err := r.service.RetrieveWebauthnCredentials(c.Param("email")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, resDTO)
+	}
+}
+
+// DeleteWebauthnCredential allows to access the service to remove a credential of a user
+func (r *rest) DeleteWebauthnCredential(c *gin.Context) {
+	if err := r.service.RemoveWebauthnCredential(c.Param("email"), c.Param("credentialId")); err != nil {
+		c.JSON(apihelper.BuildResponseError(err))
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "credential has been deleted successfully"})
+	}
+}
+
+// PostWebauthnLoginOptions allows to access the service to start a WebAuthn login (private API)
+func (r *rest) PostWebauthnLoginOptions(c *gin.Context) {
+	var reqDTO RequestDTOWebauthnRequestOptions
+	if err := c.BindJSON(&reqDTO); err != nil {
+		c.JSON(apihelper.BuildRequestError(err))
+	} else {
+		if resDTO, err := r.service.StartWebauthnLogin(reqDTO); err != nil {
+			c.JSON(apihelper.BuildResponseError(err))
+		} else {
+			c.JSON(http.StatusOK, resDTO)
+		}
+	}
+}
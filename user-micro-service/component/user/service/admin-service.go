@@ -0,0 +1,179 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userSortFields map the fields the users can be sorted by to their column
+var userSortFields = map[string]string{"email": "email", "created_at": "created_at"}
+
+// likeEscaper escape the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ListUsers return a page of the users matching the filters of an administrator, the newest first by default
+func (s *service) ListUsers(reqDTO rest.RequestDTOListUsers) (resDTO rest.ResponseDTOUserList, error *servicehelper.Error) {
+	page, err := apitool.NewPage(reqDTO.Limit, reqDTO.Cursor, reqDTO.Sort, "-created_at", userSortFields)
+	if err != nil {
+		return rest.ResponseDTOUserList{}, err
+	}
+
+	var query apitool.Query
+	if reqDTO.Email != "" {
+		query.Where("email LIKE ?", likeEscaper.Replace(reqDTO.Email)+"%")
+	}
+	if reqDTO.CreatedAfter != "" {
+		createdAfter, err := parseDateFilter("created_after", reqDTO.CreatedAfter)
+		if err != nil {
+			return rest.ResponseDTOUserList{}, err
+		}
+		query.Where("created_at >= ?", createdAfter)
+	}
+	if reqDTO.CreatedBefore != "" {
+		createdBefore, err := parseDateFilter("created_before", reqDTO.CreatedBefore)
+		if err != nil {
+			return rest.ResponseDTOUserList{}, err
+		}
+		query.Where("created_at < ?", createdBefore)
+	}
+	if reqDTO.Verified != "" {
+		verified, err := strconv.ParseBool(reqDTO.Verified)
+		if err != nil {
+			return rest.ResponseDTOUserList{}, &servicehelper.Error{
+				Detail:  errors.New("invalid verified filter"),
+				Message: "The verified filter must be true or false",
+				Param:   "verified",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+		if verified {
+			query.Where("verified_at IS NOT NULL")
+		} else {
+			query.Where("verified_at IS NULL")
+		}
+	}
+	if reqDTO.Status != "" {
+		if !isStatus(reqDTO.Status) {
+			return rest.ResponseDTOUserList{}, &servicehelper.Error{
+				Detail:  errors.New("invalid status filter"),
+				Message: "The status filter must be active, suspended, deactivated or pending_deletion",
+				Param:   "status",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+		query.Where("status = ?", reqDTO.Status)
+	}
+	if reqDTO.Provider == "password" {
+		query.Where("password <> ''")
+	} else if reqDTO.Provider != "" {
+		query.Where("id IN (SELECT user_id FROM user_identity WHERE provider = ?)", reqDTO.Provider)
+	}
+	query.Paginate(page)
+
+	entities, dbErr := s.repo.FindAll(query)
+	if dbErr != nil {
+		return rest.ResponseDTOUserList{}, &servicehelper.Error{
+			Detail:  dbErr,
+			Message: "We could not list the users, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	if len(entities) > page.Limit {
+		entities = entities[:page.Limit]
+		last := entities[len(entities)-1]
+		if page.Column == "email" {
+			resDTO.NextCursor = page.Cursor(last.Email, last.ID)
+		} else {
+			resDTO.NextCursor = page.Cursor(last.CreatedAt, last.ID)
+		}
+	}
+	resDTO.Users = make([]rest.ResponseDTOAdminUser, 0, len(entities))
+	for _, entity := range entities {
+		resDTO.Users = append(resDTO.Users, rest.ResponseDTOAdminUser{
+			Email:     entity.Email,
+			Username:  entity.Username,
+			Verified:  entity.VerifiedAt != nil,
+			Status:    entity.Status,
+			CreatedAt: entity.CreatedAt,
+		})
+	}
+	return resDTO, nil
+}
+
+// parseDateFilter read a RFC 3339 date filtering a list
+func parseDateFilter(param string, value string) (time.Time, *servicehelper.Error) {
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, &servicehelper.Error{
+			Detail:  err,
+			Message: "Please give a RFC 3339 date, e.g. 2018-03-01T00:00:00Z",
+			Param:   param,
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	return date, nil
+}
+
+// SuspendUsers prevent users from logging in until they are unsuspended, their tokens are revoked
+func (s *service) SuspendUsers(reqDTO rest.RequestDTOBulkUsers) (rest.ResponseDTOBulkUpdate, *servicehelper.Error) {
+	return s.updateUsers(reqDTO, func(entity Entity) *servicehelper.Error {
+		if entity.Status == StatusPendingDeletion {
+			return &servicehelper.Error{
+				Detail:  errors.New("user is pending deletion"),
+				Message: "This user is being deleted",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+		_, err := s.changeStatus(entity, StatusSuspended, reqDTO.Reason)
+		return err
+	})
+}
+
+// UnsuspendUsers let suspended users log in again, the users who are not suspended are left as they are
+func (s *service) UnsuspendUsers(reqDTO rest.RequestDTOBulkUsers) (rest.ResponseDTOBulkUpdate, *servicehelper.Error) {
+	return s.updateUsers(reqDTO, func(entity Entity) *servicehelper.Error {
+		if entity.Status != StatusSuspended {
+			return nil
+		}
+		_, err := s.changeStatus(entity, StatusActive, reqDTO.Reason)
+		return err
+	})
+}
+
+// updateUsers apply an update to several users, the users that can't be updated are reported without stopping the others
+func (s *service) updateUsers(reqDTO rest.RequestDTOBulkUsers, update func(entity Entity) *servicehelper.Error) (resDTO rest.ResponseDTOBulkUpdate, error *servicehelper.Error) {
+	if len(reqDTO.Emails) > maxBulkUsers {
+		return rest.ResponseDTOBulkUpdate{}, &servicehelper.Error{
+			Detail:  errors.New("too many users to update"),
+			Message: "Please update the users by batches of 1000 at most",
+			Param:   "emails",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	resDTO.Failures = []rest.ResponseDTOUserFailure{}
+	for _, email := range reqDTO.Emails {
+		entity, err := s.repo.FindByEmail(email)
+		if err != nil {
+			resDTO.Failures = append(resDTO.Failures, rest.ResponseDTOUserFailure{
+				Email:   email,
+				Message: "We could not find any user with this email address",
+			})
+			continue
+		}
+		if err := update(entity); err != nil {
+			resDTO.Failures = append(resDTO.Failures, rest.ResponseDTOUserFailure{
+				Email:   email,
+				Message: err.Message,
+			})
+			continue
+		}
+		resDTO.Updated++
+	}
+	return resDTO, nil
+}
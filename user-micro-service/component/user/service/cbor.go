@@ -0,0 +1,116 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// cborMaxDepth bound the nesting of the CBOR values sent by the authenticators
+const cborMaxDepth = 16
+
+var errCborMalformed = errors.New("malformed cbor")
+
+// decodeCbor decode the first CBOR value (RFC 8949) of data and return the remaining bytes.
+// Only the types used by WebAuthn are supported: integers are returned as int64, byte strings as []byte,
+// text strings as string, arrays as []interface{}, maps as map[interface{}]interface{} and simple values as bool or nil
+func decodeCbor(data []byte) (interface{}, []byte, error) {
+	return decodeCborValue(data, 0)
+}
+
+// decodeCborHead read the major type and the argument of the next CBOR item
+func decodeCborHead(data []byte) (byte, uint64, []byte, error) {
+	if len(data) < 1 {
+		return 0, 0, nil, errCborMalformed
+	}
+	major, info, data := data[0]>>5, data[0]&0x1f, data[1:]
+	switch {
+	case info < 24:
+		return major, uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return major, uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return major, uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return major, uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return major, binary.BigEndian.Uint64(data), data[8:], nil
+	}
+	// indefinite lengths are not used by authenticators
+	return 0, 0, nil, errCborMalformed
+}
+
+// decodeCborValue decode a CBOR value nested at depth
+func decodeCborValue(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errCborMalformed
+	}
+	major, arg, data, err := decodeCborHead(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, nil, errCborMalformed
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, nil, errCborMalformed
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCborMalformed
+		}
+		if major == 3 {
+			return string(data[:arg]), data[arg:], nil
+		}
+		return append([]byte(nil), data[:arg]...), data[arg:], nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCborMalformed
+		}
+		array := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			if item, data, err = decodeCborValue(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			array = append(array, item)
+		}
+		return array, data, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCborMalformed
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			if key, data, err = decodeCborValue(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errCborMalformed
+			}
+			if value, data, err = decodeCborValue(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, data, nil
+	case 7:
+		switch arg {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		}
+	}
+	return nil, nil, errCborMalformed
+}
@@ -2,113 +2,101 @@
 package service
 
 import (
-	"context"
-	"encoding/json"
 	"errors"
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
 	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
-	"github.com/elithrar/simple-scrypt"
-	"github.com/huandu/facebook"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"io/ioutil"
-	"os"
+	"github.com/adriendomoison/apigoboot/user-micro-service/passwordhash"
+	"log"
+	"time"
 )
 
-// CheckCredentials redirect user authentication to the right method depending of the authType
+// CheckCredentials redirect user authentication to the right method depending of the authType, password being the default.
+// The other auth types are the names of the registered identity providers, the password is then the token obtained from the provider.
+// Users who did not confirm their email address are refused when the verification is required, and the users who are not active are always refused
 func (s *service) CheckCredentials(reqDTO rest.RequestDTOCheckCredentials) (rest.ResponseDTOUserInfo, *servicehelper.Error) {
-	if reqDTO.AuthType == "password" {
-		return checkCredentialsForPasswordAuth(s, reqDTO.Username, reqDTO.Password)
-	} else if reqDTO.AuthType == "facebook" {
-		return checkCredentialsForFacebookAuth(s, reqDTO.Username, reqDTO.Password)
-	} else if reqDTO.AuthType == "google" {
-		return checkCredentialsForGoogleAuth(s, reqDTO.Username, reqDTO.Password)
+	resDTO, err := checkCredentialsByAuthType(s, reqDTO)
+	if err == nil && resDTO.UserId != 0 {
+		if err := s.checkActive(resDTO.UserId); err != nil {
+			return rest.ResponseDTOUserInfo{}, err
+		}
+		if err := s.checkEmailVerified(resDTO.UserId); err != nil {
+			return rest.ResponseDTOUserInfo{}, err
+		}
+	}
+	return resDTO, err
+}
+
+// checkCredentialsByAuthType redirect user authentication to the method of the authType
+func checkCredentialsByAuthType(s *service, reqDTO rest.RequestDTOCheckCredentials) (rest.ResponseDTOUserInfo, *servicehelper.Error) {
+	if reqDTO.AuthType == "password" || reqDTO.AuthType == "" {
+		return checkCredentialsForPasswordAuth(s, reqDTO.Username, reqDTO.Password, reqDTO.Ip)
+	} else if reqDTO.AuthType == "webauthn" {
+		return checkCredentialsForWebauthnAuth(s, reqDTO.Webauthn, reqDTO.Ip)
+	} else if reqDTO.AuthType == "mfa" {
+		return checkCredentialsForMfa(s, reqDTO.MfaToken, reqDTO.Code, reqDTO.Ip)
+	} else if provider, ok := findIdentityProvider(reqDTO.AuthType); ok {
+		return checkCredentialsForIdentityProvider(s, provider, reqDTO.Password)
 	}
 	return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
 		Detail: errors.New("incorrect username or password"),
 	}
 }
 
-// CheckCredentialsForPasswordAuth check user credentials in database
-func checkCredentialsForPasswordAuth(s *service, email string, password string) (rest.ResponseDTOUserInfo, *servicehelper.Error) {
-	if entity, err := s.repo.FindByEmail(email); err != nil {
-		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
-			Detail: errors.New("incorrect username or password"),
+// CheckCredentialsForPasswordAuth check user credentials in database.
+// Consecutive failed logins of the account and of the IP address delay the next attempts and end up locking them.
+// A password is always hashed, even for an unknown email, so the response time does not reveal the registered emails.
+// A hash written with an older algorithm or older parameters is replaced once the password is known to be right.
+// When TOTP is enabled, an mfa_token is returned instead of the user info and the login must be completed with the "mfa" auth type
+func checkCredentialsForPasswordAuth(s *service, email string, password string, ip string) (rest.ResponseDTOUserInfo, *servicehelper.Error) {
+	now := time.Now()
+	attempt := LoginAttempt{Ip: ip}
+	if ip != "" {
+		if savedAttempt, err := s.repo.FindLoginAttemptByIp(ip); err == nil {
+			attempt = savedAttempt
 		}
-	} else if err := scrypt.CompareHashAndPassword([]byte(entity.Password), []byte(password)); err != nil {
-		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
-			Detail: errors.New("incorrect username or password"),
+		if err := checkIpThrottle(attempt, now); err != nil {
+			return rest.ResponseDTOUserInfo{}, err
 		}
-	} else {
-		return rest.ResponseDTOUserInfo{
-			UserId: entity.ID,
-			Email:  entity.Email,
-		}, nil
 	}
-}
 
-// CheckCredentialsForPasswordAuth check user credentials by contacting facebook GraphAPI
-func checkCredentialsForFacebookAuth(s *service, facebookUserId string, accessToken string) (rest.ResponseDTOUserInfo, *servicehelper.Error) {
-	res, err := facebook.Get("/"+facebookUserId, facebook.Params{
-		"fields":       "email",
-		"access_token": accessToken,
-	})
+	entity, err := s.repo.FindByEmail(email)
 	if err != nil {
-		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
-			Detail: errors.New("incorrect username or password"),
+		compareDummyPasswordHash(password)
+		if ip != "" {
+			s.recordFailedIpLogin(ip, now)
 		}
-	}
-	user, err := s.repo.FindByEmail(res["email"].(string))
-	if err != nil {
 		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
 			Detail: errors.New("incorrect username or password"),
 		}
 	}
-	return rest.ResponseDTOUserInfo{
-		UserId: user.ID,
-		Email:  user.Email,
-	}, nil
-}
-
-// CheckCredentialsForGoogleAuth check user credentials by contacting google API
-func checkCredentialsForGoogleAuth(s *service, _ string, accessToken string) (rest.ResponseDTOUserInfo, *servicehelper.Error) {
-	conf := &oauth2.Config{
-		ClientID:     os.Getenv("GOOGLE_LOGIN_API_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_LOGIN_API_SECRET_ID"),
-		Scopes: []string{
-			"https://www.googleapis.com/auth/userinfo.email",
-		},
-		Endpoint: google.Endpoint,
-	}
-
-	tok := oauth2.Token{
-		AccessToken: accessToken,
+	if err := checkAccountThrottle(entity, now); err != nil {
+		return rest.ResponseDTOUserInfo{}, err
 	}
 
-	client := conf.Client(context.Background(), &tok)
-	email, err := client.Get("https://www.googleapis.com/oauth2/v3/userinfo")
-	if err != nil {
-		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
-			Detail: errors.New("error while trying to check credentials"),
-		}
+	if entity.Password == "" {
+		compareDummyPasswordHash(password)
 	}
-	defer email.Body.Close()
-	data, _ := ioutil.ReadAll(email.Body)
-
-	var dat map[string]interface{}
-	if err := json.Unmarshal(data, &dat); err != nil {
-		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
-			Detail: errors.New("error while trying to check credentials"),
+	if err := passwordhash.Compare(entity.Password, password); err != nil {
+		s.recordFailedAccountLogin(entity, now)
+		if ip != "" {
+			s.recordFailedIpLogin(ip, now)
 		}
-	}
-	user, err := s.repo.FindByEmail(dat["email"].(string))
-	if err != nil {
 		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
 			Detail: errors.New("incorrect username or password"),
 		}
 	}
+	entity = s.upgradePasswordHash(entity, password)
+	if err := s.resetFailedLogins(entity); err != nil {
+		log.Printf("ERROR: could not reset failed logins of account %s: %s\n", entity.Email, err)
+	}
+	if entity.TotpEnabled {
+		return rest.ResponseDTOUserInfo{
+			MfaRequired: true,
+			MfaToken:    createSignedToken(mfaTokenPurpose, entity.ID, mfaTokenLifetime, now),
+		}, nil
+	}
 	return rest.ResponseDTOUserInfo{
-		UserId: user.ID,
-		Email:  user.Email,
+		UserId: entity.ID,
+		Email:  entity.Email,
 	}, nil
 }
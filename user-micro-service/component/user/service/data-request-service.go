@@ -0,0 +1,409 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The kinds of the data requests
+const (
+	DataRequestExport  = "export"
+	DataRequestErasure = "erasure"
+)
+
+// The statuses of the data requests, an erasure stays pending until all its steps are done
+const (
+	DataRequestPending   = "pending"
+	DataRequestCompleted = "completed"
+	DataRequestCancelled = "cancelled"
+)
+
+// erasureSchedulerLock is the key of the advisory lock taken by the instance carrying out the erasures
+const erasureSchedulerLock int64 = 7318001
+
+// dataRequestSortFields map the fields the data requests can be sorted by to their column
+var dataRequestSortFields = map[string]string{"created_at": "created_at", "scheduled_at": "scheduled_at"}
+
+// erasureSteps are the steps of an erasure in the order they are carried out. The user is deleted last,
+// so an erasure stopped by a service that could not be reached can be retried from the step that failed
+var erasureSteps = []struct {
+	name  string
+	erase func(s *service, userId uint) error
+}{
+	{"authorizations", func(s *service, userId uint) error {
+		return callDeleteUserDataService(oauth2BaseUrl + "/user/" + strconv.FormatUint(uint64(userId), 10) + "/data")
+	}},
+	{"profiles", func(s *service, userId uint) error {
+		return callDeleteUserDataService(profileUsersBaseUrl + "/" + strconv.FormatUint(uint64(userId), 10) + "/profiles")
+	}},
+	{"user", func(s *service, userId uint) error {
+		return s.purgeUser(userId)
+	}},
+}
+
+// hashSubject hash the email of a user to keep it in the audit records once the user is erased. The hash is keyed
+// by the secret key of the micro service so the emails cannot be found back by hashing a list of known addresses
+func hashSubject(email string) string {
+	mac := hmac.New(sha256.New, config.GSecretKey)
+	mac.Write([]byte("subject|" + strings.ToLower(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// createDataRequestDTO create the response DTO of a data request
+func createDataRequestDTO(request DataRequest) rest.ResponseDTODataRequest {
+	return rest.ResponseDTODataRequest{
+		Id:          request.ID,
+		Kind:        request.Kind,
+		Status:      request.Status,
+		UserId:      request.UserId,
+		SubjectHash: request.SubjectHash,
+		RequestedBy: request.RequestedBy,
+		CreatedAt:   request.CreatedAt,
+		ScheduledAt: request.ScheduledAt,
+		CompletedAt: request.CompletedAt,
+		Steps:       strings.Fields(request.Steps),
+		Error:       request.Error,
+	}
+}
+
+// ExportUserData gather the personal data kept about a user by every service, the export is recorded as a data request
+func (s *service) ExportUserData(email string, requestedBy uint) (rest.ResponseDTOExport, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return rest.ResponseDTOExport{}, &servicehelper.Error{
+			Detail:  errors.New("could not find user"),
+			Message: "We could not find any user, please check the provided email",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	unexpectedErr := func(err error) *servicehelper.Error {
+		return &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not gather the data of the user, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+
+	resDTO := rest.ResponseDTOExport{
+		ExportedAt: time.Now(),
+		User: rest.ResponseDTOExportUser{
+			Email:           entity.Email,
+			Username:        entity.Username,
+			PendingEmail:    entity.PendingEmail,
+			VerifiedAt:      entity.VerifiedAt,
+			Status:          entity.Status,
+			StatusReason:    entity.StatusReason,
+			StatusChangedAt: entity.StatusChangedAt,
+			TotpEnabled:     entity.TotpEnabled,
+			LastFailedLogin: entity.LastFailedLogin,
+			LockedUntil:     entity.LockedUntil,
+			CreatedAt:       entity.CreatedAt,
+			UpdatedAt:       entity.UpdatedAt,
+		},
+		WebauthnCredentials: []rest.ResponseDTOWebauthnCredential{},
+		Identities:          []rest.ResponseDTOIdentity{},
+	}
+	credentials, err := s.repo.FindCredentialsByUserId(entity.ID)
+	if err != nil {
+		return rest.ResponseDTOExport{}, unexpectedErr(err)
+	}
+	for _, credential := range credentials {
+		resDTO.WebauthnCredentials = append(resDTO.WebauthnCredentials, createDTOFromCredential(credential))
+	}
+	identities, err := s.repo.FindIdentitiesByUserId(entity.ID)
+	if err != nil {
+		return rest.ResponseDTOExport{}, unexpectedErr(err)
+	}
+	for _, identity := range identities {
+		resDTO.Identities = append(resDTO.Identities, createDTOFromIdentity(identity))
+	}
+	if resDTO.Roles, _, err = s.userRoleClaims(entity.ID); err != nil {
+		return rest.ResponseDTOExport{}, unexpectedErr(err)
+	}
+
+	userId := strconv.FormatUint(uint64(entity.ID), 10)
+	if resDTO.Profiles, err = callGetUserDataService(profileUsersBaseUrl + "/" + userId + "/profiles"); err != nil {
+		return rest.ResponseDTOExport{}, unexpectedErr(errors.New("could not export the profiles: " + err.Error()))
+	}
+	if resDTO.Authorizations, err = callGetUserDataService(oauth2BaseUrl + "/user/" + userId + "/data"); err != nil {
+		return rest.ResponseDTOExport{}, unexpectedErr(errors.New("could not export the authorizations: " + err.Error()))
+	}
+
+	now := time.Now()
+	if _, err := s.repo.CreateDataRequest(DataRequest{
+		UserId:      entity.ID,
+		SubjectHash: hashSubject(entity.Email),
+		Kind:        DataRequestExport,
+		Status:      DataRequestCompleted,
+		RequestedBy: requestedBy,
+		ScheduledAt: now,
+		CompletedAt: &now,
+	}); err != nil {
+		return rest.ResponseDTOExport{}, unexpectedErr(err)
+	}
+	if resDTO.DataRequests, err = s.findDataRequests(entity.ID, ""); err != nil {
+		return rest.ResponseDTOExport{}, unexpectedErr(err)
+	}
+	return resDTO, nil
+}
+
+// RequestErasure schedule the erasure of a user and of its data in every service at the end of the grace period.
+// The user can't log in anymore, an administrator can cancel the erasure until then by changing its status back
+func (s *service) RequestErasure(email string, requestedBy uint) (rest.ResponseDTODataRequest, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return rest.ResponseDTODataRequest{}, &servicehelper.Error{
+			Detail:  errors.New("could not find user"),
+			Message: "We could not find any user, please check the provided email",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if entity, err := s.changeStatus(entity, StatusPendingDeletion, entity.StatusReason); err != nil {
+		return rest.ResponseDTODataRequest{}, err
+	} else if request, err := s.scheduleErasure(entity, requestedBy, time.Now().Add(config.GErasureGracePeriod)); err != nil {
+		return rest.ResponseDTODataRequest{}, err
+	} else {
+		notify(entity.Email, "Your account is going to be deleted",
+			"The deletion of your "+config.GAppName+" account and of all its data has been requested.\n"+
+				"It will be carried out on "+request.ScheduledAt.UTC().Format("January 2, 2006")+", please contact us before then to cancel it.")
+		return createDataRequestDTO(request), nil
+	}
+}
+
+// scheduleErasure return the pending erasure of a user, brought forward to at if needed, or schedule a new one at this date
+func (s *service) scheduleErasure(entity Entity, requestedBy uint, at time.Time) (DataRequest, *servicehelper.Error) {
+	var query apitool.Query
+	query.Where("user_id = ? AND kind = ? AND status = ?", entity.ID, DataRequestErasure, DataRequestPending)
+	requests, err := s.repo.FindDataRequests(query)
+	if err == nil && len(requests) > 0 {
+		request := requests[0]
+		if at.Before(request.ScheduledAt) {
+			request.ScheduledAt = at
+			err = s.repo.UpdateDataRequest(request)
+		}
+		if err == nil {
+			return request, nil
+		}
+	} else if err == nil {
+		var request DataRequest
+		if request, err = s.repo.CreateDataRequest(DataRequest{
+			UserId:      entity.ID,
+			SubjectHash: hashSubject(entity.Email),
+			Kind:        DataRequestErasure,
+			Status:      DataRequestPending,
+			RequestedBy: requestedBy,
+			ScheduledAt: at,
+		}); err == nil {
+			return request, nil
+		}
+	}
+	return DataRequest{}, &servicehelper.Error{
+		Detail:  err,
+		Message: "We could not schedule the deletion of the user, please try again later",
+		Code:    servicehelper.UnexpectedError,
+	}
+}
+
+// cancelErasures cancel the pending erasures of a user
+func (s *service) cancelErasures(userId uint) error {
+	var query apitool.Query
+	query.Where("user_id = ? AND kind = ? AND status = ?", userId, DataRequestErasure, DataRequestPending)
+	requests, err := s.repo.FindDataRequests(query)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, request := range requests {
+		request.Status = DataRequestCancelled
+		request.CompletedAt = &now
+		if err := s.repo.UpdateDataRequest(request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// erase carry out the steps of an erasure not done yet, the progress is saved after each step
+func (s *service) erase(request DataRequest) error {
+	steps := strings.Fields(request.Steps)
+	for _, step := range erasureSteps {
+		if containsStep(steps, step.name) {
+			continue
+		}
+		if err := step.erase(s, request.UserId); err != nil {
+			request.Error = step.name + ": " + err.Error()
+			if updateErr := s.repo.UpdateDataRequest(request); updateErr != nil {
+				log.Printf("ERROR: could not save the progress of the erasure %d: %s\n", request.ID, updateErr)
+			}
+			return errors.New(request.Error)
+		}
+		steps = append(steps, step.name)
+		request.Steps = strings.Join(steps, " ")
+		request.Error = ""
+		if err := s.repo.UpdateDataRequest(request); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	request.Status = DataRequestCompleted
+	request.CompletedAt = &now
+	return s.repo.UpdateDataRequest(request)
+}
+
+// containsStep check if a step is in a list of steps
+func containsStep(steps []string, step string) bool {
+	for _, s := range steps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessDueErasures carry out the pending erasures whose grace period is over and return how many have been completed
+func (s *service) ProcessDueErasures() (erased int, err error) {
+	var query apitool.Query
+	query.Where("kind = ? AND status = ? AND scheduled_at <= ?", DataRequestErasure, DataRequestPending, time.Now())
+	requests, err := s.repo.FindDataRequests(query)
+	if err != nil {
+		return 0, err
+	}
+	for _, request := range requests {
+		if err := s.erase(request); err != nil {
+			log.Printf("ERROR: erasure %d of user %d stopped: %s\n", request.ID, request.UserId, err)
+			continue
+		}
+		erased++
+	}
+	return erased, nil
+}
+
+// StartErasureScheduler carry out the erasures at the end of their grace period now and then at every interval, in the background.
+// The instances share an advisory lock so a single one carries out the erasures at a time
+func (s *service) StartErasureScheduler(interval time.Duration) {
+	go func() {
+		for {
+			var erased int
+			var err error
+			if _, lockErr := s.repo.RunExclusively(erasureSchedulerLock, func() {
+				erased, err = s.ProcessDueErasures()
+			}); lockErr != nil {
+				log.Println("ERROR: could not lock the erasures:", lockErr)
+			} else if err != nil {
+				log.Println("ERROR: could not find the erasures to carry out:", err)
+			} else if erased > 0 {
+				log.Printf("erasure completed for %d users\n", erased)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// RetrieveDataRequests list the exports and the erasures of the personal data of a user
+func (s *service) RetrieveDataRequests(email string) ([]rest.ResponseDTODataRequest, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return nil, &servicehelper.Error{
+			Detail:  errors.New("could not find user"),
+			Message: "We could not find any user, please check the provided email",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	resDTOs, err := s.findDataRequests(entity.ID, "")
+	if err != nil {
+		return nil, &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not list the requests of the user, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return resDTOs, nil
+}
+
+// findDataRequests list the data requests of a user, of a kind or of every kind when it is empty
+func (s *service) findDataRequests(userId uint, kind string) ([]rest.ResponseDTODataRequest, error) {
+	var query apitool.Query
+	query.Where("user_id = ?", userId)
+	if kind != "" {
+		query.Where("kind = ?", kind)
+	}
+	requests, err := s.repo.FindDataRequests(query)
+	if err != nil {
+		return nil, err
+	}
+	resDTOs := make([]rest.ResponseDTODataRequest, 0, len(requests))
+	for _, request := range requests {
+		resDTOs = append(resDTOs, createDataRequestDTO(request))
+	}
+	return resDTOs, nil
+}
+
+// ListDataRequests return a page of the data requests matching the filters of an administrator, the newest first by default
+func (s *service) ListDataRequests(reqDTO rest.RequestDTOListDataRequests) (resDTO rest.ResponseDTODataRequestList, error *servicehelper.Error) {
+	page, err := apitool.NewPage(reqDTO.Limit, reqDTO.Cursor, reqDTO.Sort, "-created_at", dataRequestSortFields)
+	if err != nil {
+		return rest.ResponseDTODataRequestList{}, err
+	}
+
+	var query apitool.Query
+	if reqDTO.Kind != "" {
+		if reqDTO.Kind != DataRequestExport && reqDTO.Kind != DataRequestErasure {
+			return rest.ResponseDTODataRequestList{}, &servicehelper.Error{
+				Detail:  errors.New("invalid kind filter"),
+				Message: "The kind filter must be export or erasure",
+				Param:   "kind",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+		query.Where("kind = ?", reqDTO.Kind)
+	}
+	if reqDTO.Status != "" {
+		if reqDTO.Status != DataRequestPending && reqDTO.Status != DataRequestCompleted && reqDTO.Status != DataRequestCancelled {
+			return rest.ResponseDTODataRequestList{}, &servicehelper.Error{
+				Detail:  errors.New("invalid status filter"),
+				Message: "The status filter must be pending, completed or cancelled",
+				Param:   "status",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+		query.Where("status = ?", reqDTO.Status)
+	}
+	query.Paginate(page)
+
+	requests, dbErr := s.repo.FindDataRequests(query)
+	if dbErr != nil {
+		return rest.ResponseDTODataRequestList{}, &servicehelper.Error{
+			Detail:  dbErr,
+			Message: "We could not list the requests, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	if len(requests) > page.Limit {
+		requests = requests[:page.Limit]
+		last := requests[len(requests)-1]
+		if page.Column == "scheduled_at" {
+			resDTO.NextCursor = page.Cursor(last.ScheduledAt, last.ID)
+		} else {
+			resDTO.NextCursor = page.Cursor(last.CreatedAt, last.ID)
+		}
+	}
+	resDTO.DataRequests = make([]rest.ResponseDTODataRequest, 0, len(requests))
+	for _, request := range requests {
+		resDTO.DataRequests = append(resDTO.DataRequests, createDataRequestDTO(request))
+	}
+	return resDTO, nil
+}
@@ -0,0 +1,198 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"log"
+	"time"
+)
+
+// purgeSchedulerLock is the key of the advisory lock taken by the instance purging the deleted users
+const purgeSchedulerLock int64 = 7318002
+
+// deletedUserSortFields map the fields the deleted users can be sorted by to their column
+var deletedUserSortFields = map[string]string{"email": "email", "deleted_at": "deleted_at"}
+
+// ListDeletedUsers return a page of the deleted users that can still be restored, the last deleted first by default
+func (s *service) ListDeletedUsers(reqDTO rest.RequestDTOListDeletedUsers) (resDTO rest.ResponseDTODeletedUserList, error *servicehelper.Error) {
+	page, err := apitool.NewPage(reqDTO.Limit, reqDTO.Cursor, reqDTO.Sort, "-deleted_at", deletedUserSortFields)
+	if err != nil {
+		return rest.ResponseDTODeletedUserList{}, err
+	}
+
+	var query apitool.Query
+	query.Where("deleted_at >= ?", time.Now().Add(-config.GUserRetentionPeriod))
+	if reqDTO.Email != "" {
+		query.Where("email LIKE ?", likeEscaper.Replace(reqDTO.Email)+"%")
+	}
+	query.Paginate(page)
+
+	entities, dbErr := s.repo.FindDeleted(query)
+	if dbErr != nil {
+		return rest.ResponseDTODeletedUserList{}, &servicehelper.Error{
+			Detail:  dbErr,
+			Message: "We could not list the deleted users, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	if len(entities) > page.Limit {
+		entities = entities[:page.Limit]
+		last := entities[len(entities)-1]
+		if page.Column == "email" {
+			resDTO.NextCursor = page.Cursor(last.Email, last.ID)
+		} else {
+			resDTO.NextCursor = page.Cursor(*last.DeletedAt, last.ID)
+		}
+	}
+	resDTO.Users = make([]rest.ResponseDTODeletedUser, 0, len(entities))
+	for _, entity := range entities {
+		resDTO.Users = append(resDTO.Users, rest.ResponseDTODeletedUser{
+			Email:     entity.Email,
+			Username:  entity.Username,
+			Status:    entity.Status,
+			CreatedAt: entity.CreatedAt,
+			DeletedAt: *entity.DeletedAt,
+			PurgeAt:   entity.DeletedAt.Add(config.GUserRetentionPeriod),
+		})
+	}
+	return resDTO, nil
+}
+
+// Restore bring back a deleted user until the end of the retention period, it logs in again as before its deletion.
+// Its pending erasures are cancelled, it can't be restored once its data is being erased or another user took its email
+func (s *service) Restore(email string) (rest.ResponseDTOAdminUser, *servicehelper.Error) {
+	entity, err := s.repo.FindDeletedByEmail(email)
+	if err != nil {
+		return rest.ResponseDTOAdminUser{}, &servicehelper.Error{
+			Detail:  errors.New("could not find deleted user"),
+			Message: "We could not find any deleted user, please check the provided email",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if entity.DeletedAt.Before(time.Now().Add(-config.GUserRetentionPeriod)) {
+		return rest.ResponseDTOAdminUser{}, &servicehelper.Error{
+			Detail:  errors.New("retention period is over"),
+			Message: "This user has been deleted for too long to be restored, it is being purged",
+			Param:   "email",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	if _, err := s.repo.FindByEmail(entity.Email); err == nil {
+		return rest.ResponseDTOAdminUser{}, &servicehelper.Error{
+			Detail:  errors.New("email is used by another user"),
+			Message: "Another account has been created with this email since the deletion, the deleted user can't be restored",
+			Param:   "email",
+			Code:    servicehelper.AlreadyExist,
+		}
+	}
+	var query apitool.Query
+	query.Where("user_id = ? AND kind = ? AND status = ? AND steps <> ''", entity.ID, DataRequestErasure, DataRequestPending)
+	requests, err := s.repo.FindDataRequests(query)
+	if err == nil && len(requests) > 0 {
+		return rest.ResponseDTOAdminUser{}, &servicehelper.Error{
+			Detail:  errors.New("erasure has started"),
+			Message: "The data of this user is being erased, it can't be restored",
+			Param:   "email",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	if err == nil {
+		err = s.cancelErasures(entity.ID)
+	}
+	if err != nil {
+		return rest.ResponseDTOAdminUser{}, &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not restore the user, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	if err := s.repo.Restore(entity); err != nil {
+		return rest.ResponseDTOAdminUser{}, &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not restore the user, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return rest.ResponseDTOAdminUser{
+		Email:     entity.Email,
+		Username:  entity.Username,
+		Verified:  entity.VerifiedAt != nil,
+		Status:    entity.Status,
+		CreatedAt: entity.CreatedAt,
+	}, nil
+}
+
+// Purge erase a deleted user and its data in every service right away, without waiting for the end of the retention period.
+// When a service can't be reached the erasure is completed later by the scheduler
+func (s *service) Purge(email string, requestedBy uint) *servicehelper.Error {
+	entity, err := s.repo.FindDeletedByEmail(email)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not find deleted user"),
+			Message: "We could not find any deleted user, please check the provided email",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if err := s.purgeDeletedUser(entity, requestedBy); err != nil {
+		return &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not delete all the data of the user yet, the deletion will be completed later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// purgeDeletedUser erase a deleted user and its data in every service, the purge is recorded as an erasure
+func (s *service) purgeDeletedUser(entity Entity, requestedBy uint) error {
+	request, err := s.scheduleErasure(entity, requestedBy, time.Now())
+	if err != nil {
+		return err.Detail
+	}
+	return s.erase(request)
+}
+
+// PurgeDeletedUsers erase the users deleted before the retention period with their data in every service, and return how many have been purged
+func (s *service) PurgeDeletedUsers() (purged int, err error) {
+	var query apitool.Query
+	query.Where("deleted_at < ?", time.Now().Add(-config.GUserRetentionPeriod))
+	entities, err := s.repo.FindDeleted(query)
+	if err != nil {
+		return 0, err
+	}
+	for _, entity := range entities {
+		if err := s.purgeDeletedUser(entity, 0); err != nil {
+			log.Printf("ERROR: purge of deleted user %d stopped: %s\n", entity.ID, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// StartPurgeScheduler purge the users at the end of their retention period now and then at every interval, in the background.
+// The instances share an advisory lock so a single one purges the users at a time
+func (s *service) StartPurgeScheduler(interval time.Duration) {
+	go func() {
+		for {
+			var purged int
+			var err error
+			if _, lockErr := s.repo.RunExclusively(purgeSchedulerLock, func() {
+				purged, err = s.PurgeDeletedUsers()
+			}); lockErr != nil {
+				log.Println("ERROR: could not lock the purge of the deleted users:", lockErr)
+			} else if err != nil {
+				log.Println("ERROR: could not find the deleted users to purge:", err)
+			} else if purged > 0 {
+				log.Printf("%d deleted users purged\n", purged)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
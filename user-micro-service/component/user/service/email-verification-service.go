@@ -0,0 +1,169 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"crypto/hmac"
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"github.com/adriendomoison/apigoboot/user-micro-service/mailer"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// emailTokenPurpose is the purpose of the signed tokens confirming an email address
+const emailTokenPurpose = "email"
+
+// emailVerificationParam is the param of the errors refusing a login until the email address is confirmed, so the login page can offer a new link
+const emailVerificationParam = "email_verification"
+
+// Mailer send the emails of the service, replace it to use another transport
+var Mailer = mailer.New()
+
+// notify send an email, failures are logged as the emails are sent while handling other requests
+func notify(to string, subject string, body string) {
+	if err := Mailer.Send(mailer.Message{To: to, Subject: subject, Body: body}); err != nil {
+		log.Printf("ERROR: could not send email \"%s\" to %s: %s\n", subject, to, err)
+	}
+}
+
+// startEmailVerification save a new verification token for the pending email of a user, or for its email, and send the link confirming it.
+// Only the last token sent to a user is valid
+func (s *service) startEmailVerification(entity Entity) error {
+	to := entity.Email
+	if entity.PendingEmail != "" {
+		to = entity.PendingEmail
+	}
+	token := createSignedToken(emailTokenPurpose, entity.ID, config.GEmailVerificationLifetime, time.Now())
+	entity.EmailToken = hashSignedToken(token)
+	if err := s.repo.Update(entity); err != nil {
+		log.Printf("ERROR: could not save the verification token of account %s: %s\n", entity.Email, err)
+		return err
+	}
+	link := config.GEmailVerificationUrl + "?" + url.Values{"email": {to}, "token": {token}}.Encode()
+	notify(to, "Confirm your email address",
+		"Please confirm the email address of your "+config.GAppName+" account by opening this link:\n"+link+"\n"+
+			"It expires in "+formatLifetime(config.GEmailVerificationLifetime)+".")
+	return nil
+}
+
+// formatLifetime write a lifetime in hours or in minutes when it is a whole number of them
+func formatLifetime(lifetime time.Duration) string {
+	switch {
+	case lifetime == time.Hour:
+		return "1 hour"
+	case lifetime%time.Hour == 0:
+		return strconv.Itoa(int(lifetime/time.Hour)) + " hours"
+	case lifetime%time.Minute == 0:
+		return strconv.Itoa(int(lifetime/time.Minute)) + " minutes"
+	}
+	return lifetime.String()
+}
+
+// VerifyEmail check a verification token and confirm the email of a user, or switch it to its pending email
+func (s *service) VerifyEmail(email string, reqDTO rest.RequestDTOVerifyEmail) (rest.ResponseDTO, *servicehelper.Error) {
+	now := time.Now()
+	invalidToken := &servicehelper.Error{
+		Detail:  errors.New("invalid or expired verification token"),
+		Message: "This link is not valid anymore, please ask for a new one",
+		Param:   "token",
+		Code:    servicehelper.BadRequest,
+	}
+	userId, ok := parseSignedToken(emailTokenPurpose, reqDTO.Token, now)
+	if !ok {
+		return rest.ResponseDTO{}, invalidToken
+	}
+	entity, err := s.repo.FindByID(userId)
+	if err != nil || entity.EmailToken == "" || !hmac.Equal([]byte(entity.EmailToken), []byte(hashSignedToken(reqDTO.Token))) {
+		return rest.ResponseDTO{}, invalidToken
+	}
+	if entity.PendingEmail != "" {
+		if email != entity.PendingEmail {
+			return rest.ResponseDTO{}, invalidToken
+		}
+		if other, err := s.repo.FindByEmail(entity.PendingEmail); err == nil && other.ID != entity.ID {
+			return rest.ResponseDTO{}, &servicehelper.Error{
+				Detail:  errors.New("this email is already used for another account"),
+				Message: "Email already used, please chose another email",
+				Param:   "email",
+				Code:    servicehelper.AlreadyExist,
+			}
+		}
+		entity.Email = entity.PendingEmail
+		entity.PendingEmail = ""
+	} else if email != entity.Email {
+		return rest.ResponseDTO{}, invalidToken
+	}
+	entity.VerifiedAt = &now
+	entity.EmailToken = ""
+	if err := s.repo.Update(entity); err != nil {
+		return rest.ResponseDTO{}, &servicehelper.Error{
+			Detail:  errors.New("could not update user"),
+			Message: "We could not confirm your email address, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return createDTOFromEntity(entity), nil
+}
+
+// ResendVerificationEmail send a new link confirming the pending email of a user, or its email when it is not confirmed yet
+func (s *service) ResendVerificationEmail(email string) *servicehelper.Error {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if entity.PendingEmail == "" && entity.VerifiedAt != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("email is already verified"),
+			Message: "Your email address is already confirmed",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	if err := s.startEmailVerification(entity); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not update user"),
+			Message: "We could not send you a new link, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// RequestVerificationEmail send a new link confirming the email of a user who did not confirm it yet, e.g. from the login page.
+// Nothing tells whether the email belongs to a user: the answer is the same for unknown or confirmed emails
+// and the link is sent in the background, so the response time does not tell either
+func (s *service) RequestVerificationEmail(reqDTO rest.RequestDTOVerificationEmail) {
+	go func() {
+		entity, err := s.repo.FindByEmail(reqDTO.Email)
+		if err != nil || entity.VerifiedAt != nil {
+			return
+		}
+		if err := s.startEmailVerification(entity); err != nil {
+			log.Printf("ERROR: could not send a new verification link to account %s: %s\n", entity.Email, err)
+		}
+	}()
+}
+
+// checkEmailVerified refuse the login of a user who did not confirm its email address when it is required
+func (s *service) checkEmailVerified(userId uint) *servicehelper.Error {
+	if !config.GEmailVerificationRequired {
+		return nil
+	}
+	if entity, err := s.repo.FindByID(userId); err == nil && entity.VerifiedAt != nil {
+		return nil
+	}
+	return &servicehelper.Error{
+		Detail:  errors.New("email is not verified"),
+		Message: "Please confirm your email address with the link we sent you before signing in",
+		Param:   emailVerificationParam,
+		Code:    servicehelper.Forbidden,
+	}
+}
@@ -0,0 +1,118 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ExternalIdentity is a user as known by an external identity provider
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// IdentityProvider check the tokens the clients obtained from an external identity provider
+type IdentityProvider interface {
+	// Name is the auth_type of the logins with this provider
+	Name() string
+	// Describe return what the clients need to obtain a token from the provider
+	Describe() rest.ResponseDTOIdentityProvider
+	// Authenticate check a token obtained from the provider and return the identity of its owner
+	Authenticate(token string) (ExternalIdentity, error)
+}
+
+var identityProviders = make(map[string]IdentityProvider)
+var identityProvidersMutex sync.RWMutex
+
+// init register the identity providers of the configuration
+func init() {
+	for _, conf := range config.GIdentityProviders {
+		RegisterIdentityProvider(NewOidcProvider(conf))
+	}
+}
+
+// RegisterIdentityProvider make a provider available to the logins, a provider with the same name is replaced
+func RegisterIdentityProvider(provider IdentityProvider) {
+	identityProvidersMutex.Lock()
+	defer identityProvidersMutex.Unlock()
+	identityProviders[provider.Name()] = provider
+}
+
+// findIdentityProvider return the provider registered with name
+func findIdentityProvider(name string) (IdentityProvider, bool) {
+	identityProvidersMutex.RLock()
+	defer identityProvidersMutex.RUnlock()
+	provider, ok := identityProviders[name]
+	return provider, ok
+}
+
+// RetrieveIdentityProviders list the identity providers the users can log in with
+func (s *service) RetrieveIdentityProviders() []rest.ResponseDTOIdentityProvider {
+	identityProvidersMutex.RLock()
+	defer identityProvidersMutex.RUnlock()
+	resDTOs := make([]rest.ResponseDTOIdentityProvider, 0, len(identityProviders))
+	for _, provider := range identityProviders {
+		resDTOs = append(resDTOs, provider.Describe())
+	}
+	sort.Slice(resDTOs, func(i, j int) bool { return resDTOs[i].Name < resDTOs[j].Name })
+	return resDTOs
+}
+
+// checkCredentialsForIdentityProvider check a token obtained from an external identity provider and log in the user the identity is linked to.
+// The first login links the identity to the user with the same confirmed email, or create a new user when sign up is allowed.
+// When TOTP is enabled, the login must be completed with a second factor as for a password login
+func checkCredentialsForIdentityProvider(s *service, provider IdentityProvider, token string) (rest.ResponseDTOUserInfo, *servicehelper.Error) {
+	identity, err := provider.Authenticate(token)
+	if err != nil {
+		log.Printf("WARNING: %s login refused: %s\n", provider.Name(), err)
+		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
+			Detail: errors.New("incorrect username or password"),
+			Code:   servicehelper.Unauthorized,
+		}
+	}
+	identity.Provider = provider.Name()
+
+	var entity Entity
+	if linked, err := s.repo.FindIdentity(identity.Provider, identity.Subject); err == nil {
+		entity, err = s.repo.FindByID(linked.UserId)
+		if err != nil {
+			return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
+				Detail: errors.New("incorrect username or password"),
+				Code:   servicehelper.Unauthorized,
+			}
+		}
+	} else {
+		var linkErr *servicehelper.Error
+		if entity, linkErr = s.linkIdentityOnFirstLogin(identity); linkErr != nil {
+			log.Printf("WARNING: %s login refused: %s\n", provider.Name(), linkErr.Detail)
+			if linkErr.Param == identityLinkParam {
+				return rest.ResponseDTOUserInfo{}, linkErr
+			}
+			return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
+				Detail: errors.New("incorrect username or password"),
+				Code:   servicehelper.Unauthorized,
+			}
+		}
+	}
+
+	if entity.TotpEnabled {
+		return rest.ResponseDTOUserInfo{
+			MfaRequired: true,
+			MfaToken:    createSignedToken(mfaTokenPurpose, entity.ID, mfaTokenLifetime, time.Now()),
+		}, nil
+	}
+	return rest.ResponseDTOUserInfo{
+		UserId: entity.ID,
+		Email:  entity.Email,
+	}, nil
+}
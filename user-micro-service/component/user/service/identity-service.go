@@ -0,0 +1,198 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"log"
+	"time"
+)
+
+// createDTOFromIdentity copy the data of a linked identity to a Response DTO
+func createDTOFromIdentity(identity Identity) rest.ResponseDTOIdentity {
+	return rest.ResponseDTOIdentity{
+		Provider:    identity.Provider,
+		Subject:     identity.Subject,
+		EmailAtLink: identity.EmailAtLink,
+		LinkedAt:    identity.LinkedAt,
+	}
+}
+
+// identityLinkParam is the param of the errors refusing the first login with an identity provider into an account whose email is not confirmed,
+// the identity must then be linked from the settings of the account after a password login
+const identityLinkParam = "identity_link"
+
+// linkIdentityOnFirstLogin link an identity to the user with the same verified email, or to a new user without password when sign up is allowed.
+// An account whose email is not confirmed may have been registered by someone else, so it is never linked this way
+func (s *service) linkIdentityOnFirstLogin(identity ExternalIdentity) (Entity, *servicehelper.Error) {
+	if identity.Email == "" || !identity.EmailVerified {
+		return Entity{}, &servicehelper.Error{
+			Detail: errors.New("the email of the identity is not verified"),
+			Code:   servicehelper.Unauthorized,
+		}
+	}
+	now := time.Now()
+	entity, err := s.repo.FindByEmail(identity.Email)
+	if err != nil {
+		if !config.GIdentityProviderSignUp {
+			return Entity{}, &servicehelper.Error{
+				Detail: errors.New("no user with the email of the identity"),
+				Code:   servicehelper.Unauthorized,
+			}
+		}
+		if !s.repo.Create(Entity{Email: identity.Email, Username: identity.Name, VerifiedAt: &now, Status: StatusActive}) {
+			return Entity{}, &servicehelper.Error{
+				Detail: errors.New("user could not be created"),
+				Code:   servicehelper.Unauthorized,
+			}
+		}
+		if entity, err = s.repo.FindByEmail(identity.Email); err != nil {
+			return Entity{}, &servicehelper.Error{
+				Detail: err,
+				Code:   servicehelper.Unauthorized,
+			}
+		}
+	} else if entity.VerifiedAt == nil {
+		return Entity{}, &servicehelper.Error{
+			Detail:  errors.New("the email of the account is not verified"),
+			Message: "An account already uses this email address, please sign in with its password and link this account from your settings",
+			Param:   identityLinkParam,
+			Code:    servicehelper.Unauthorized,
+		}
+	}
+	if err := s.repo.CreateIdentity(Identity{
+		UserId:      entity.ID,
+		Provider:    identity.Provider,
+		Subject:     identity.Subject,
+		EmailAtLink: identity.Email,
+		LinkedAt:    now,
+	}); err != nil {
+		log.Printf("ERROR: could not link %s identity %s to account %s: %s\n", identity.Provider, identity.Subject, entity.Email, err)
+	}
+	return entity, nil
+}
+
+// RetrieveIdentities list the identities linked to a user
+func (s *service) RetrieveIdentities(email string) ([]rest.ResponseDTOIdentity, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return nil, &servicehelper.Error{
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	identities, err := s.repo.FindIdentitiesByUserId(entity.ID)
+	if err != nil {
+		return nil, &servicehelper.Error{
+			Detail:  errors.New("could not retrieve identities"),
+			Message: "We could not retrieve your linked accounts, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	resDTOs := make([]rest.ResponseDTOIdentity, 0, len(identities))
+	for _, identity := range identities {
+		resDTOs = append(resDTOs, createDTOFromIdentity(identity))
+	}
+	return resDTOs, nil
+}
+
+// LinkIdentity check a token obtained from an identity provider and link the identity it belongs to to a user
+func (s *service) LinkIdentity(email string, reqDTO rest.RequestDTOLinkIdentity) (rest.ResponseDTOIdentity, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return rest.ResponseDTOIdentity{}, &servicehelper.Error{
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	provider, ok := findIdentityProvider(reqDTO.Provider)
+	if !ok {
+		return rest.ResponseDTOIdentity{}, &servicehelper.Error{
+			Detail:  errors.New("unknown identity provider " + reqDTO.Provider),
+			Message: "This provider is not supported",
+			Param:   "provider",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	identity, err := provider.Authenticate(reqDTO.Token)
+	if err != nil {
+		return rest.ResponseDTOIdentity{}, &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not check your account with this provider, please sign in with it again",
+			Param:   "token",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	if linked, err := s.repo.FindIdentity(provider.Name(), identity.Subject); err == nil {
+		message := "This account of " + provider.Name() + " is already linked to another user"
+		if linked.UserId == entity.ID {
+			message = "This account of " + provider.Name() + " is already linked to your account"
+		}
+		return rest.ResponseDTOIdentity{}, &servicehelper.Error{
+			Detail:  errors.New("identity is already linked"),
+			Message: message,
+			Code:    servicehelper.AlreadyExist,
+		}
+	}
+	linked := Identity{
+		UserId:      entity.ID,
+		Provider:    provider.Name(),
+		Subject:     identity.Subject,
+		EmailAtLink: identity.Email,
+		LinkedAt:    time.Now(),
+	}
+	if err := s.repo.CreateIdentity(linked); err != nil {
+		return rest.ResponseDTOIdentity{}, &servicehelper.Error{
+			Detail:  errors.New("could not create identity"),
+			Message: "We could not link this account, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return createDTOFromIdentity(linked), nil
+}
+
+// UnlinkIdentity remove an identity linked to a user, unless it is the only way for a user without password to log in
+func (s *service) UnlinkIdentity(email string, provider string, subject string) *servicehelper.Error {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	identity, err := s.repo.FindIdentity(provider, subject)
+	if err != nil || identity.UserId != entity.ID {
+		return &servicehelper.Error{
+			Detail:  errors.New("identity could not be found"),
+			Message: "This account is not linked to yours",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if entity.Password == "" {
+		identities, errIdentities := s.repo.FindIdentitiesByUserId(entity.ID)
+		credentials, errCredentials := s.repo.FindCredentialsByUserId(entity.ID)
+		if errIdentities != nil || errCredentials != nil || (len(identities) <= 1 && len(credentials) == 0) {
+			return &servicehelper.Error{
+				Detail:  errors.New("identity is the last way to log in"),
+				Message: "You sign in with this account only, link another account or register a passkey before unlinking it",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+	}
+	if err := s.repo.DeleteIdentity(identity); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not delete identity"),
+			Message: "We could not unlink this account, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
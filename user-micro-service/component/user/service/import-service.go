@@ -0,0 +1,56 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"github.com/adriendomoison/apigoboot/user-micro-service/passwordhash"
+	"time"
+)
+
+// maxBulkUsers is the largest amount of users of an import or of a bulk update
+const maxBulkUsers = 1000
+
+// ImportUsers create users whose passwords were hashed by another system (bcrypt, scrypt or argon2id hashes),
+// their hash is replaced by one of the current algorithm at their first login. No email is sent to the imported users.
+// The users that can't be created are reported without stopping the import
+func (s *service) ImportUsers(reqDTO rest.RequestDTOImportUsers) (resDTO rest.ResponseDTOImportUsers, error *servicehelper.Error) {
+	if len(reqDTO.Users) > maxBulkUsers {
+		return rest.ResponseDTOImportUsers{}, &servicehelper.Error{
+			Detail:  errors.New("too many users to import"),
+			Message: "Please import the users by batches of 1000 at most",
+			Param:   "users",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	resDTO.Failures = []rest.ResponseDTOUserFailure{}
+	now := time.Now()
+	for _, user := range reqDTO.Users {
+		if !passwordhash.Valid(user.PasswordHash) {
+			resDTO.Failures = append(resDTO.Failures, rest.ResponseDTOUserFailure{
+				Email:   user.Email,
+				Message: "The password hash is not a supported bcrypt, scrypt or argon2id hash",
+			})
+			continue
+		}
+		entity := Entity{
+			Email:    user.Email,
+			Username: user.Username,
+			Password: user.PasswordHash,
+			Status:   StatusActive,
+		}
+		if user.Verified {
+			entity.VerifiedAt = &now
+		}
+		if !s.repo.Create(entity) {
+			resDTO.Failures = append(resDTO.Failures, rest.ResponseDTOUserFailure{
+				Email:   user.Email,
+				Message: "An account already use this email address",
+			})
+			continue
+		}
+		resDTO.Imported++
+	}
+	return resDTO, nil
+}
@@ -0,0 +1,152 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"log"
+	"strconv"
+	"time"
+)
+
+// LockoutParam is the param of the errors refusing a login before the password is checked, their message can be shown to the user
+const LockoutParam = "lockout"
+
+// loginDelayBase is the time to wait before the next login after a first failure, it doubles at each consecutive failure
+const loginDelayBase = time.Second
+
+// maxLoginDelay bound the time to wait between two logins before the lockout
+const maxLoginDelay = 5 * time.Minute
+
+// AccountLockedHook is called when an account is locked after too many failed logins, replace it to notify the user or the administrators.
+// It is called while the login request is handled and should not block
+var AccountLockedHook = func(userId uint, email string, lockedUntil time.Time) {
+	log.Printf("WARNING: account %s has been locked until %s after too many failed logins\n", email, lockedUntil.Format(time.RFC3339))
+}
+
+// recentFailedLogins return the amount of consecutive failed logins, they are forgotten once the lockout duration has passed since the last one
+func recentFailedLogins(failedLogins int, lastFailedLogin *time.Time, now time.Time) int {
+	if lastFailedLogin == nil || now.Sub(*lastFailedLogin) > config.GLockoutDuration {
+		return 0
+	}
+	return failedLogins
+}
+
+// loginDelay return the time to wait after the last failed login before trying again
+func loginDelay(failedLogins int) time.Duration {
+	if failedLogins < 1 {
+		return 0
+	}
+	delay := loginDelayBase
+	for i := 1; i < failedLogins && delay < maxLoginDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+	return delay
+}
+
+// formatWait describe a waiting time to the user
+func formatWait(wait time.Duration) string {
+	if wait > time.Minute {
+		return strconv.Itoa(int((wait+time.Minute-1)/time.Minute)) + " minutes"
+	}
+	return strconv.Itoa(int((wait+time.Second-1)/time.Second)) + " seconds"
+}
+
+// checkIpThrottle refuse the login if the IP address has been locked after too many failed logins
+func checkIpThrottle(attempt LoginAttempt, now time.Time) *servicehelper.Error {
+	if attempt.LockedUntil != nil && attempt.LockedUntil.After(now) {
+		return &servicehelper.Error{
+			Detail:  errors.New("ip address is locked"),
+			Message: "Too many failed logins from your network, please try again in " + formatWait(attempt.LockedUntil.Sub(now)),
+			Param:   LockoutParam,
+			Code:    servicehelper.TooManyRequests,
+		}
+	}
+	return nil
+}
+
+// checkAccountThrottle refuse the login if the account is locked or if the delay following its last failed login has not passed yet
+func checkAccountThrottle(entity Entity, now time.Time) *servicehelper.Error {
+	if entity.LockedUntil != nil && entity.LockedUntil.After(now) {
+		return &servicehelper.Error{
+			Detail:  errors.New("account is locked"),
+			Message: "Your account has been locked after too many failed logins, please try again in " + formatWait(entity.LockedUntil.Sub(now)) + " or contact an administrator",
+			Param:   LockoutParam,
+			Code:    servicehelper.Locked,
+		}
+	}
+	failedLogins := recentFailedLogins(entity.FailedLogins, entity.LastFailedLogin, now)
+	if failedLogins == 0 {
+		return nil
+	}
+	if retryAt := entity.LastFailedLogin.Add(loginDelay(failedLogins)); retryAt.After(now) {
+		return &servicehelper.Error{
+			Detail:  errors.New("login attempted too soon after a failed login"),
+			Message: "Too many failed logins, please try again in " + formatWait(retryAt.Sub(now)),
+			Param:   LockoutParam,
+			Code:    servicehelper.TooManyRequests,
+		}
+	}
+	return nil
+}
+
+// recordFailedIpLogin count a failed login coming from an IP address and lock it when there are too many.
+// The count is incremented by the database so the concurrent logins all count
+func (s *service) recordFailedIpLogin(ip string, now time.Time) {
+	lockedUntil := now.Add(config.GLockoutDuration)
+	failedLogins, err := s.repo.RecordFailedIpLogin(ip, now, now.Add(-config.GLockoutDuration), config.GMaxFailedLoginsPerIp, lockedUntil)
+	if err != nil {
+		log.Printf("ERROR: could not save failed login of ip address %s: %s\n", ip, err)
+		return
+	}
+	if failedLogins == config.GMaxFailedLoginsPerIp {
+		log.Printf("WARNING: ip address %s has been locked until %s after too many failed logins\n", ip, lockedUntil.Format(time.RFC3339))
+	}
+}
+
+// recordFailedAccountLogin count a failed login of an account and lock it when there are too many.
+// The count is incremented by the database so the concurrent logins all count
+func (s *service) recordFailedAccountLogin(entity Entity, now time.Time) {
+	lockedUntil := now.Add(config.GLockoutDuration)
+	failedLogins, err := s.repo.RecordFailedLogin(entity.ID, now, now.Add(-config.GLockoutDuration), config.GMaxFailedLogins, lockedUntil)
+	if err != nil {
+		log.Printf("ERROR: could not save failed login of account %s: %s\n", entity.Email, err)
+		return
+	}
+	if failedLogins == config.GMaxFailedLogins {
+		AccountLockedHook(entity.ID, entity.Email, lockedUntil)
+	}
+}
+
+// resetFailedLogins forget the failed logins of an account and unlock it
+func (s *service) resetFailedLogins(entity Entity) error {
+	if entity.FailedLogins == 0 && entity.LastFailedLogin == nil && entity.LockedUntil == nil {
+		return nil
+	}
+	return s.repo.ResetFailedLogins(entity.ID)
+}
+
+// Unlock unlock an account locked after too many failed logins and forget its failed logins
+func (s *service) Unlock(email string) *servicehelper.Error {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if err := s.resetFailedLogins(entity); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not unlock user"),
+			Message: "We could not unlock this account, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
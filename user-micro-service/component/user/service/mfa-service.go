@@ -0,0 +1,288 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"log"
+	"strings"
+	"time"
+)
+
+// mfaTokenPurpose is the purpose of the signed tokens proving that the password of a user has been checked
+const mfaTokenPurpose = "mfa"
+
+// mfaTokenLifetime is how long a user has to type its second factor after its password has been checked
+const mfaTokenLifetime = 5 * time.Minute
+
+// recoveryCodeCount is the amount of recovery codes issued when TOTP is enabled
+const recoveryCodeCount = 10
+
+// hashRecoveryCode hash a recovery code after removing the separators the user may have typed
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateRecoveryCodes return new recovery codes and the hashes to save
+func generateRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		random := make([]byte, 7)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(random)[:10])
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// useRecoveryCode remove a recovery code from the unused ones of an entity, return false if it is not one of them
+func useRecoveryCode(entity *Entity, code string) bool {
+	hashed := hashRecoveryCode(code)
+	hashes := strings.Fields(entity.RecoveryCodes)
+	for i, hash := range hashes {
+		if hmac.Equal([]byte(hash), []byte(hashed)) {
+			entity.RecoveryCodes = strings.Join(append(hashes[:i], hashes[i+1:]...), " ")
+			return true
+		}
+	}
+	return false
+}
+
+// checkSecondFactor check a TOTP code or a recovery code and consume it, the entity must be saved afterward
+func checkSecondFactor(entity *Entity, code string, now time.Time) bool {
+	if secret, err := decryptTotpSecret(entity.TotpSecret, entity.ID); err != nil {
+		log.Printf("ERROR: could not decrypt the totp secret of account %s: %s\n", entity.Email, err)
+	} else if step, ok := validateTotp(secret, strings.TrimSpace(code), entity.TotpLastStep, now); ok {
+		entity.TotpLastStep = step
+		return true
+	}
+	return useRecoveryCode(entity, code)
+}
+
+// EnrollTotp generate a new TOTP secret for a user, it is only required at login once a first code has been verified
+func (s *service) EnrollTotp(email string) (rest.ResponseDTOTotpEnrollment, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return rest.ResponseDTOTotpEnrollment{}, &servicehelper.Error{
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if entity.TotpEnabled {
+		return rest.ResponseDTOTotpEnrollment{}, &servicehelper.Error{
+			Detail:  errors.New("totp is already enabled"),
+			Message: "Two-step verification is already enabled on this account, disable it before enrolling a new device",
+			Code:    servicehelper.AlreadyExist,
+		}
+	}
+	secret, err := generateTotpSecret()
+	if err != nil {
+		return rest.ResponseDTOTotpEnrollment{}, &servicehelper.Error{
+			Detail:  errors.New("could not generate totp secret"),
+			Message: "We could not enable two-step verification, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	if entity.TotpSecret, err = EncryptTotpSecret(secret, entity.ID); err != nil {
+		return rest.ResponseDTOTotpEnrollment{}, &servicehelper.Error{
+			Detail:  errors.New("could not encrypt totp secret"),
+			Message: "We could not enable two-step verification, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	entity.TotpLastStep = 0
+	if err := s.repo.Update(entity); err != nil {
+		return rest.ResponseDTOTotpEnrollment{}, &servicehelper.Error{
+			Detail:  errors.New("could not update user"),
+			Message: "We could not enable two-step verification, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return rest.ResponseDTOTotpEnrollment{
+		Secret:     secret,
+		OtpauthUri: totpUri(config.GAppName, entity.Email, secret),
+	}, nil
+}
+
+// VerifyTotp check the first code of a newly enrolled authenticator app, enable TOTP and issue the recovery codes
+func (s *service) VerifyTotp(email string, reqDTO rest.RequestDTOTotpCode) (rest.ResponseDTORecoveryCodes, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return rest.ResponseDTORecoveryCodes{}, &servicehelper.Error{
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if entity.TotpEnabled || entity.TotpSecret == "" {
+		return rest.ResponseDTORecoveryCodes{}, &servicehelper.Error{
+			Detail:  errors.New("no totp enrollment is pending"),
+			Message: "Please enroll an authenticator app before verifying a code",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	secret, err := decryptTotpSecret(entity.TotpSecret, entity.ID)
+	if err != nil {
+		return rest.ResponseDTORecoveryCodes{}, &servicehelper.Error{
+			Detail:  errors.New("could not decrypt totp secret"),
+			Message: "We could not enable two-step verification, please enroll your authenticator app again",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	step, ok := validateTotp(secret, strings.TrimSpace(reqDTO.Code), entity.TotpLastStep, time.Now())
+	if !ok {
+		return rest.ResponseDTORecoveryCodes{}, &servicehelper.Error{
+			Detail:  errors.New("invalid totp code"),
+			Message: "This code is not valid, please type the code currently shown by your authenticator app",
+			Param:   "code",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return rest.ResponseDTORecoveryCodes{}, &servicehelper.Error{
+			Detail:  errors.New("could not generate recovery codes"),
+			Message: "We could not enable two-step verification, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	entity.TotpEnabled = true
+	entity.TotpLastStep = step
+	entity.RecoveryCodes = strings.Join(hashes, " ")
+	if err := s.repo.Update(entity); err != nil {
+		return rest.ResponseDTORecoveryCodes{}, &servicehelper.Error{
+			Detail:  errors.New("could not update user"),
+			Message: "We could not enable two-step verification, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return rest.ResponseDTORecoveryCodes{RecoveryCodes: codes}, nil
+}
+
+// DisableTotp check a last TOTP code or a recovery code and stop asking for a second factor at login
+func (s *service) DisableTotp(email string, reqDTO rest.RequestDTOTotpCode) *servicehelper.Error {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if !entity.TotpEnabled {
+		return &servicehelper.Error{
+			Detail:  errors.New("totp is not enabled"),
+			Message: "Two-step verification is not enabled on this account",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	if !checkSecondFactor(&entity, reqDTO.Code, time.Now()) {
+		return &servicehelper.Error{
+			Detail:  errors.New("invalid totp code"),
+			Message: "This code is not valid, please type the code currently shown by your authenticator app or a recovery code",
+			Param:   "code",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	entity.TotpEnabled = false
+	entity.TotpSecret = ""
+	entity.TotpLastStep = 0
+	entity.RecoveryCodes = ""
+	if err := s.repo.Update(entity); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not update user"),
+			Message: "We could not disable two-step verification, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// checkCredentialsForMfa complete a login waiting for a second factor with a TOTP code or a recovery code.
+// Wrong codes count as failed logins of the account and of the IP address, the mfa token is used up by the login it completes
+func checkCredentialsForMfa(s *service, mfaToken string, code string, ip string) (rest.ResponseDTOUserInfo, *servicehelper.Error) {
+	now := time.Now()
+	userId, ok := parseSignedToken(mfaTokenPurpose, mfaToken, now)
+	if !ok {
+		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
+			Detail:  errors.New("invalid or expired mfa token"),
+			Message: "Your login has expired, please sign in again",
+			Param:   "mfa_token",
+			Code:    servicehelper.Unauthorized,
+		}
+	}
+
+	attempt := LoginAttempt{Ip: ip}
+	if ip != "" {
+		if savedAttempt, err := s.repo.FindLoginAttemptByIp(ip); err == nil {
+			attempt = savedAttempt
+		}
+		if err := checkIpThrottle(attempt, now); err != nil {
+			return rest.ResponseDTOUserInfo{}, err
+		}
+	}
+
+	entity, err := s.repo.FindByID(userId)
+	if err != nil || !entity.TotpEnabled {
+		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
+			Detail:  errors.New("invalid or expired mfa token"),
+			Message: "Your login has expired, please sign in again",
+			Param:   "mfa_token",
+			Code:    servicehelper.Unauthorized,
+		}
+	}
+	if err := checkAccountThrottle(entity, now); err != nil {
+		return rest.ResponseDTOUserInfo{}, err
+	}
+
+	if !checkSecondFactor(&entity, code, now) {
+		s.recordFailedAccountLogin(entity, now)
+		if ip != "" {
+			s.recordFailedIpLogin(ip, now)
+		}
+		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
+			Detail:  errors.New("incorrect code"),
+			Message: "This code is not valid, please try again",
+			Param:   "code",
+			Code:    servicehelper.Unauthorized,
+		}
+	}
+	if _, ok := s.useSignedToken(mfaTokenPurpose, mfaToken, mfaTokenLifetime, now); !ok {
+		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
+			Detail:  errors.New("mfa token already used"),
+			Message: "Your login has expired, please sign in again",
+			Param:   "mfa_token",
+			Code:    servicehelper.Unauthorized,
+		}
+	}
+	if err := s.repo.UpdateSecondFactor(entity); err != nil {
+		log.Printf("ERROR: could not save second factor login of account %s: %s\n", entity.Email, err)
+		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
+			Detail:  errors.New("could not update user"),
+			Message: "We could not sign you in, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	if err := s.resetFailedLogins(entity); err != nil {
+		log.Printf("ERROR: could not reset failed logins of account %s: %s\n", entity.Email, err)
+	}
+	return rest.ResponseDTOUserInfo{
+		UserId: entity.ID,
+		Email:  entity.Email,
+	}, nil
+}
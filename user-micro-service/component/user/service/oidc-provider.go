@@ -0,0 +1,372 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcHttpTimeout bound the calls to the identity providers
+const oidcHttpTimeout = 10 * time.Second
+
+// oidcJwksRefreshDelay is the minimum delay between two downloads of the keys of a provider
+const oidcJwksRefreshDelay = time.Minute
+
+// oidcClockSkew is the tolerance on the expiry of the ID tokens
+const oidcClockSkew = time.Minute
+
+// oidcDefaultClaims are the claims of a standard OpenID Connect provider
+var oidcDefaultClaims = map[string]string{
+	"subject":        "sub",
+	"email":          "email",
+	"email_verified": "email_verified",
+	"name":           "name",
+	"audience":       "aud",
+}
+
+// oidcProvider is a generic OpenID Connect or OAuth2 provider, ID tokens are checked with its keys and access tokens on its tokeninfo and userinfo urls
+type oidcProvider struct {
+	conf   config.IdentityProviderConfig
+	claims map[string]string
+	client *http.Client
+
+	mutex         sync.Mutex
+	discovered    bool
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// NewOidcProvider return an identity provider driven by its configuration
+func NewOidcProvider(conf config.IdentityProviderConfig) IdentityProvider {
+	claims := make(map[string]string)
+	for attribute, claim := range oidcDefaultClaims {
+		claims[attribute] = claim
+	}
+	for attribute, claim := range conf.Claims {
+		claims[attribute] = claim
+	}
+	return &oidcProvider{
+		conf:   conf,
+		claims: claims,
+		client: &http.Client{Timeout: oidcHttpTimeout},
+	}
+}
+
+// Name return the name of the provider
+func (p *oidcProvider) Name() string {
+	return p.conf.Name
+}
+
+// Describe return the issuer, the client id and the scopes of the provider
+func (p *oidcProvider) Describe() rest.ResponseDTOIdentityProvider {
+	return rest.ResponseDTOIdentityProvider{
+		Name:     p.conf.Name,
+		Issuer:   p.conf.Issuer,
+		ClientId: p.conf.ClientId,
+		Scopes:   p.conf.Scopes,
+	}
+}
+
+// Authenticate check an ID token or an access token issued for the client id of the provider, the audience of an access token is read on the tokeninfo url
+func (p *oidcProvider) Authenticate(token string) (ExternalIdentity, error) {
+	if token == "" {
+		return ExternalIdentity{}, errors.New("no token")
+	}
+	if p.conf.ClientId == "" {
+		return ExternalIdentity{}, errors.New("no client id for " + p.conf.Name)
+	}
+	if strings.Count(token, ".") == 2 {
+		claims, err := p.verifyIdToken(token, time.Now())
+		if err == nil {
+			return p.identityFromClaims(claims)
+		}
+		if p.conf.TokeninfoUrl == "" {
+			return ExternalIdentity{}, err
+		}
+		// JWT access tokens are checked on the tokeninfo url
+	}
+	if p.conf.TokeninfoUrl == "" {
+		return ExternalIdentity{}, errors.New(p.conf.Name + " only accepts ID tokens")
+	}
+	if err := p.checkAudience(token); err != nil {
+		return ExternalIdentity{}, err
+	}
+	claims, err := p.fetchUserinfo(token)
+	if err != nil {
+		return ExternalIdentity{}, err
+	}
+	return p.identityFromClaims(claims)
+}
+
+// identityFromClaims map the claims of the provider to an identity
+func (p *oidcProvider) identityFromClaims(claims map[string]interface{}) (ExternalIdentity, error) {
+	identity := ExternalIdentity{
+		Provider:      p.conf.Name,
+		Subject:       claimString(claims, p.claims["subject"]),
+		Email:         claimString(claims, p.claims["email"]),
+		EmailVerified: true,
+		Name:          claimString(claims, p.claims["name"]),
+	}
+	if identity.Subject == "" {
+		return ExternalIdentity{}, errors.New("no subject in the claims of " + p.conf.Name)
+	}
+	if claim := p.claims["email_verified"]; claim != "" {
+		identity.EmailVerified = claimString(claims, claim) == "true"
+	}
+	return identity, nil
+}
+
+// claimString read a claim as a string, numbers and booleans are formatted
+func claimString(claims map[string]interface{}, claim string) string {
+	if claim == "" {
+		return ""
+	}
+	switch value := claims[claim].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// getJSON download a JSON document, with an access token when it is given
+func (p *oidcProvider) getJSON(url string, accessToken string, value interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
+
+// discover read the userinfo and jwks urls missing from the configuration in the discovery document of the issuer
+func (p *oidcProvider) discover() error {
+	if p.discovered || p.conf.Issuer == "" || (p.conf.UserinfoUrl != "" && p.conf.JwksUrl != "") {
+		return nil
+	}
+	var metadata struct {
+		Issuer           string `json:"issuer"`
+		UserinfoEndpoint string `json:"userinfo_endpoint"`
+		JwksUri          string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(strings.TrimSuffix(p.conf.Issuer, "/")+"/.well-known/openid-configuration", "", &metadata); err != nil {
+		return err
+	}
+	if metadata.Issuer != p.conf.Issuer {
+		return errors.New("discovery document of " + p.conf.Issuer + " is for issuer " + metadata.Issuer)
+	}
+	if p.conf.UserinfoUrl == "" {
+		p.conf.UserinfoUrl = metadata.UserinfoEndpoint
+	}
+	if p.conf.JwksUrl == "" {
+		p.conf.JwksUrl = metadata.JwksUri
+	}
+	p.discovered = true
+	return nil
+}
+
+// checkAudience read the audience of an access token on the tokeninfo url, an access token given to another client must not log in its owner
+func (p *oidcProvider) checkAudience(accessToken string) error {
+	tokeninfoUrl, err := url.Parse(p.conf.TokeninfoUrl)
+	if err != nil {
+		return err
+	}
+	query := tokeninfoUrl.Query()
+	query.Set("access_token", accessToken)
+	tokeninfoUrl.RawQuery = query.Encode()
+	claims := make(map[string]interface{})
+	if err := p.getJSON(tokeninfoUrl.String(), "", &claims); err != nil {
+		return err
+	}
+	if !hasAudience(claims, p.claims["audience"], p.conf.ClientId) {
+		return errors.New("access token is not issued for " + p.conf.ClientId)
+	}
+	return nil
+}
+
+// hasAudience tell if the audience claim, a string or an array, contains the client id
+func hasAudience(claims map[string]interface{}, claim string, clientId string) bool {
+	if claim == "" {
+		return false
+	}
+	if claimString(claims, claim) == clientId {
+		return true
+	}
+	if audiences, ok := claims[claim].([]interface{}); ok {
+		for _, audience := range audiences {
+			if audience == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fetchUserinfo read the claims of the owner of an access token
+func (p *oidcProvider) fetchUserinfo(accessToken string) (map[string]interface{}, error) {
+	p.mutex.Lock()
+	err := p.discover()
+	userinfoUrl := p.conf.UserinfoUrl
+	p.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if userinfoUrl == "" {
+		return nil, errors.New("no userinfo url for " + p.conf.Name)
+	}
+	claims := make(map[string]interface{})
+	if err := p.getJSON(userinfoUrl, accessToken, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// publicKey return the key of the provider with the key id, the keys are downloaded again when the key id is unknown
+func (p *oidcProvider) publicKey(keyId string, now time.Time) (crypto.PublicKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if key, ok := p.keys[keyId]; ok {
+		return key, nil
+	}
+	if now.Sub(p.keysFetchedAt) < oidcJwksRefreshDelay {
+		return nil, errors.New("unknown key " + keyId)
+	}
+	if err := p.discover(); err != nil {
+		return nil, err
+	}
+	if p.conf.JwksUrl == "" {
+		return nil, errors.New("no jwks url for " + p.conf.Name)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	p.keysFetchedAt = now
+	if err := p.getJSON(p.conf.JwksUrl, "", &jwks); err != nil {
+		return nil, err
+	}
+	p.keys = make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		switch {
+		case jwk.Kty == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN == nil && errE == nil && len(e) > 0 && len(e) <= 4 {
+				p.keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			}
+		case jwk.Kty == "EC" && jwk.Crv == "P-256":
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if errX == nil && errY == nil && key.Curve.IsOnCurve(key.X, key.Y) {
+				p.keys[jwk.Kid] = key
+			}
+		}
+	}
+	if key, ok := p.keys[keyId]; ok {
+		return key, nil
+	}
+	return nil, errors.New("unknown key " + keyId)
+}
+
+// verifyIdToken check the signature, the issuer, the audience and the expiry of an ID token and return its claims
+func (p *oidcProvider) verifyIdToken(idToken string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(rawHeader, &header) != nil {
+		return nil, errors.New("malformed id token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed id token")
+	}
+	key, err := p.publicKey(header.Kid, now)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) != nil {
+			return nil, errors.New("invalid id token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 ||
+			!ecdsa.Verify(key, hash[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			return nil, errors.New("invalid id token signature")
+		}
+	default:
+		return nil, errors.New("invalid id token signature")
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed id token")
+	}
+	claims := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(rawClaims))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, errors.New("malformed id token")
+	}
+	if p.conf.Issuer != "" && claimString(claims, "iss") != p.conf.Issuer {
+		return nil, errors.New("id token is not issued by " + p.conf.Issuer)
+	}
+	if !hasAudience(claims, "aud", p.conf.ClientId) {
+		return nil, errors.New("id token is not issued for " + p.conf.ClientId)
+	}
+	// the authorized party is required when the ID token has several audiences
+	audiences, _ := claims["aud"].([]interface{})
+	if azp := claimString(claims, "azp"); azp != p.conf.ClientId && (azp != "" || len(audiences) > 1) {
+		return nil, errors.New("id token is not authorized for " + p.conf.ClientId)
+	}
+	exp, _ := claims["exp"].(json.Number)
+	expiry, err := exp.Int64()
+	if err != nil || now.Add(-oidcClockSkew).After(time.Unix(expiry, 0)) {
+		return nil, errors.New("id token is expired")
+	}
+	return claims, nil
+}
@@ -0,0 +1,49 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"github.com/adriendomoison/apigoboot/user-micro-service/passwordhash"
+	"log"
+	"sync"
+)
+
+// PasswordHasher hash the passwords of the users, replace it to change the algorithm or its cost
+var PasswordHasher = passwordhash.New()
+
+// dummyPasswordHash is a hash written by PasswordHasher the first time a login does not find any password to compare with
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+// compareDummyPasswordHash spend the time of a password comparison on a login without any password to compare with,
+// so the response time does not reveal which emails are registered
+func compareDummyPasswordHash(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		var err error
+		if dummyPasswordHash, err = PasswordHasher.Hash("dummy password"); err != nil {
+			log.Println("ERROR: could not hash the dummy password:", err)
+		}
+	})
+	passwordhash.Compare(dummyPasswordHash, password)
+}
+
+// upgradePasswordHash replace the hash of a password checked at login when it is not written with the current algorithm and parameters.
+// The user is returned with the hash saved in database, the old one is kept when the new one can't be saved
+func (s *service) upgradePasswordHash(entity Entity, password string) Entity {
+	if !PasswordHasher.NeedsRehash(entity.Password) {
+		return entity
+	}
+	hashedPassword, err := PasswordHasher.Hash(password)
+	if err != nil {
+		log.Printf("ERROR: could not rehash the password of account %s: %s\n", entity.Email, err)
+		return entity
+	}
+	previousPassword := entity.Password
+	entity.Password = hashedPassword
+	if err := s.repo.Update(entity); err != nil {
+		log.Printf("ERROR: could not save the new password hash of account %s: %s\n", entity.Email, err)
+		entity.Password = previousPassword
+	}
+	return entity
+}
@@ -0,0 +1,40 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/passwordpolicy"
+	"log"
+)
+
+// PasswordPolicy check the passwords chosen by the users, replace it to change the rules
+var PasswordPolicy = passwordpolicy.New()
+
+// checkPasswordPolicy refuse a password breaking the policy, every broken rule is a cause of the error.
+// The password is accepted when only the breached list can't be read
+func checkPasswordPolicy(password string, email string, username string) *servicehelper.Error {
+	violations, err := PasswordPolicy.Check(password, email, username)
+	if err != nil {
+		log.Printf("ERROR: could not check the password of account %s against the breached passwords: %s\n", email, err)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	causes := make([]servicehelper.Error, 0, len(violations))
+	for _, violation := range violations {
+		causes = append(causes, servicehelper.Error{
+			Detail:  errors.New(violation.Detail),
+			Message: violation.Message,
+			Param:   "password",
+			Code:    servicehelper.BadRequest,
+		})
+	}
+	return &servicehelper.Error{
+		Detail:  errors.New("password does not follow the password policy"),
+		Message: violations[0].Message,
+		Param:   "password",
+		Code:    servicehelper.BadRequest,
+		Causes:  causes,
+	}
+}
@@ -0,0 +1,97 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"crypto/hmac"
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"log"
+	"net/url"
+	"time"
+)
+
+// passwordResetTokenPurpose is the purpose of the signed tokens resetting a password
+const passwordResetTokenPurpose = "password-reset"
+
+// RequestPasswordReset send a link resetting the password to a user. Nothing tells whether the email belongs to a user:
+// the answer is the same for unknown emails and the link is saved and sent in the background, so the response time does not tell either.
+// Only the last link sent to a user is valid
+func (s *service) RequestPasswordReset(reqDTO rest.RequestDTOPasswordReset) {
+	go func() {
+		entity, err := s.repo.FindByEmail(reqDTO.Email)
+		if err != nil {
+			return
+		}
+		token := createSignedToken(passwordResetTokenPurpose, entity.ID, config.GPasswordResetLifetime, time.Now())
+		entity.PasswordResetToken = hashSignedToken(token)
+		if err := s.repo.Update(entity); err != nil {
+			log.Printf("ERROR: could not save the password reset token of account %s: %s\n", entity.Email, err)
+			return
+		}
+		link := config.GPasswordResetUrl + "?" + url.Values{"token": {token}}.Encode()
+		notify(entity.Email, "Reset your password",
+			"A new password has been requested for your "+config.GAppName+" account. Please choose it by opening this link:\n"+link+"\n"+
+				"It expires in "+formatLifetime(config.GPasswordResetLifetime)+". If you did not ask for it, you can ignore this email.")
+	}()
+}
+
+// ResetPassword check a password reset token and replace the password of its user.
+// The tokens of the user are revoked first, so a reset always signs out whoever was using the account
+func (s *service) ResetPassword(reqDTO rest.RequestDTOConfirmPasswordReset) *servicehelper.Error {
+	invalidToken := &servicehelper.Error{
+		Detail:  errors.New("invalid or expired password reset token"),
+		Message: "This link is not valid anymore, please ask for a new one",
+		Param:   "token",
+		Code:    servicehelper.BadRequest,
+	}
+	userId, ok := parseSignedToken(passwordResetTokenPurpose, reqDTO.Token, time.Now())
+	if !ok {
+		return invalidToken
+	}
+	entity, err := s.repo.FindByID(userId)
+	if err != nil || entity.PasswordResetToken == "" ||
+		!hmac.Equal([]byte(entity.PasswordResetToken), []byte(hashSignedToken(reqDTO.Token))) {
+		return invalidToken
+	}
+	if err := checkPasswordPolicy(reqDTO.NewPassword, entity.Email, entity.Username); err != nil {
+		return err
+	}
+
+	hashedPassword, err := PasswordHasher.Hash(reqDTO.NewPassword)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not hash the password"),
+			Message: "We could not create your password, please try something different",
+			Param:   "new_password",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+
+	if err := callRevokeTokensService(entity.ID); err != nil {
+		log.Printf("ERROR: could not revoke the tokens of account %s: %s\n", entity.Email, err)
+		return &servicehelper.Error{
+			Detail:  errors.New("could not revoke the tokens of the user"),
+			Message: "We could not reset your password, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+
+	entity.Password = hashedPassword
+	entity.PasswordResetToken = ""
+	entity.FailedLogins = 0
+	entity.LastFailedLogin = nil
+	entity.LockedUntil = nil
+	if err := s.repo.Update(entity); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not update user"),
+			Message: "We could not reset your password, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	notify(entity.Email, "Your password has been changed",
+		"The password of your "+config.GAppName+" account has been reset and you have been signed out of all your devices.\n"+
+			"If you did not do it, please reset your password again and contact us.")
+	return nil
+}
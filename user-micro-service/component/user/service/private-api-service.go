@@ -16,17 +16,25 @@ func (s *service) RetrieveUserInfoByEmail(email string) (resDTO rest.ResponseDTO
 	return rest.ResponseDTOUserInfo{
 		UserId: entity.ID,
 		Email:  entity.Email,
+		Status: entity.Status,
 	}, nil
 }
 
-// RetrieveUserInfoByEmail ask database to retrieve a user from its user id
+// RetrieveUserInfoByUserId ask database to retrieve a user from its user id, with its roles and the permissions they grant
 func (s *service) RetrieveUserInfoByUserId(userId uint) (resDTO rest.ResponseDTOUserInfo, error *servicehelper.Error) {
 	entity, err := s.repo.FindByID(userId)
 	if err != nil {
 		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{Detail: errors.New("no result found"), Code: servicehelper.NotFound}
 	}
+	roles, permissions, err := s.userRoleClaims(entity.ID)
+	if err != nil {
+		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{Detail: err, Code: servicehelper.UnexpectedError}
+	}
 	return rest.ResponseDTOUserInfo{
-		UserId: entity.ID,
-		Email:  entity.Email,
+		UserId:      entity.ID,
+		Email:       entity.Email,
+		Status:      entity.Status,
+		Roles:       roles,
+		Permissions: permissions,
 	}, nil
 }
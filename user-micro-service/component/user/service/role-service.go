@@ -0,0 +1,249 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"log"
+	"regexp"
+	"sort"
+)
+
+// Permissions are the permissions a role can grant, the policies of the routes of the services check them
+var Permissions = map[string]string{
+	"users:read":     "read the accounts of the other users",
+	"users:write":    "edit and delete the accounts of the other users",
+	"users:unlock":   "unlock the accounts locked after failed logins",
+	"users:suspend":  "suspend and unsuspend users",
+	"users:import":   "import users from another system",
+	"roles:manage":   "create roles and give them to users",
+	"profiles:read":  "read the profiles of the other users",
+	"profiles:write": "edit the profiles of the other users",
+}
+
+// DefaultRoles are the roles created by CreateDefaultRoles when they do not exist yet
+var DefaultRoles = map[string]rest.RequestDTORole{
+	"admin": {
+		Description: "Administrators of the users",
+		Permissions: []string{"users:read", "users:write", "users:unlock", "users:suspend", "users:import", "roles:manage", "profiles:read", "profiles:write"},
+	},
+	"support": {
+		Description: "Support staff helping the users with their account",
+		Permissions: []string{"users:read", "users:unlock", "profiles:read"},
+	},
+}
+
+// roleNameFormat is the format of the name of a role
+var roleNameFormat = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// CreateDefaultRoles create the default roles missing from the database, the roles already created are kept as they were edited
+func (s *service) CreateDefaultRoles() {
+	for name, reqDTO := range DefaultRoles {
+		if _, err := s.repo.FindRoleByName(name); err == nil {
+			continue
+		}
+		if _, err := s.repo.SaveRole(Role{Name: name, Description: reqDTO.Description}, reqDTO.Permissions); err != nil {
+			log.Printf("ERROR: could not create the default role %s: %s\n", name, err)
+		}
+	}
+}
+
+// RetrieveRoles list the roles and their permissions
+func (s *service) RetrieveRoles() (resDTO []rest.ResponseDTORole, error *servicehelper.Error) {
+	roles, err := s.repo.FindRoles()
+	if err != nil {
+		return nil, &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not list the roles, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	resDTO = make([]rest.ResponseDTORole, 0, len(roles))
+	for _, role := range roles {
+		permissions, err := s.repo.FindRolePermissions(role.ID)
+		if err != nil {
+			return nil, &servicehelper.Error{
+				Detail:  err,
+				Message: "We could not list the roles, please try again later",
+				Code:    servicehelper.UnexpectedError,
+			}
+		}
+		resDTO = append(resDTO, createRoleDTO(role, permissions))
+	}
+	return resDTO, nil
+}
+
+// EditRole create a role or replace the description and the permissions of an existing one
+func (s *service) EditRole(name string, reqDTO rest.RequestDTORole) (rest.ResponseDTORole, *servicehelper.Error) {
+	if !roleNameFormat.MatchString(name) {
+		return rest.ResponseDTORole{}, &servicehelper.Error{
+			Detail:  errors.New("invalid role name"),
+			Message: "The name of a role must be made of lowercase letters, digits, - and _, starting with a letter",
+			Param:   "name",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	permissions, err := checkPermissions(reqDTO.Permissions)
+	if err != nil {
+		return rest.ResponseDTORole{}, err
+	}
+
+	role, dbErr := s.repo.FindRoleByName(name)
+	if dbErr != nil {
+		role = Role{Name: name}
+	}
+	role.Description = reqDTO.Description
+	if role, dbErr = s.repo.SaveRole(role, permissions); dbErr != nil {
+		return rest.ResponseDTORole{}, &servicehelper.Error{
+			Detail:  dbErr,
+			Message: "We could not save the role, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return createRoleDTO(role, permissions), nil
+}
+
+// checkPermissions check that the permissions of a role are known and return them sorted without duplicates
+func checkPermissions(permissions []string) ([]string, *servicehelper.Error) {
+	checked := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		if _, ok := Permissions[permission]; !ok {
+			return nil, &servicehelper.Error{
+				Detail:  errors.New("unknown permission"),
+				Message: "The permission " + permission + " does not exist",
+				Param:   "permissions",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+		checked = appendMissing(checked, permission)
+	}
+	sort.Strings(checked)
+	return checked, nil
+}
+
+// RemoveRole remove a role from the users who have it and delete it
+func (s *service) RemoveRole(name string) *servicehelper.Error {
+	role, err := s.repo.FindRoleByName(name)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not find role"),
+			Message: "We could not find any role with this name",
+			Param:   "name",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if err := s.repo.DeleteRole(role); err != nil {
+		return &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not delete the role, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// RetrieveUserRoles list the roles of a user and the permissions they grant
+func (s *service) RetrieveUserRoles(email string) (rest.ResponseDTOUserRoles, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return rest.ResponseDTOUserRoles{}, &servicehelper.Error{
+			Detail:  errors.New("could not find user"),
+			Message: "We could not find any user, please check the provided email",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	roles, permissions, err := s.userRoleClaims(entity.ID)
+	if err != nil {
+		return rest.ResponseDTOUserRoles{}, &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not list the roles of the user, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return rest.ResponseDTOUserRoles{Roles: roles, Permissions: permissions}, nil
+}
+
+// EditUserRoles replace the roles of a user, the user gets the new permissions with its next access token check
+func (s *service) EditUserRoles(email string, reqDTO rest.RequestDTOUserRoles) (rest.ResponseDTOUserRoles, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return rest.ResponseDTOUserRoles{}, &servicehelper.Error{
+			Detail:  errors.New("could not find user"),
+			Message: "We could not find any user, please check the provided email",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	var roleIds []uint
+	for _, name := range reqDTO.Roles {
+		role, err := s.repo.FindRoleByName(name)
+		if err != nil {
+			return rest.ResponseDTOUserRoles{}, &servicehelper.Error{
+				Detail:  errors.New("could not find role"),
+				Message: "The role " + name + " does not exist",
+				Param:   "roles",
+				Code:    servicehelper.BadRequest,
+			}
+		}
+		if !containsId(roleIds, role.ID) {
+			roleIds = append(roleIds, role.ID)
+		}
+	}
+	if err := s.repo.SetUserRoles(entity.ID, roleIds); err != nil {
+		return rest.ResponseDTOUserRoles{}, &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not save the roles of the user, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return s.RetrieveUserRoles(email)
+}
+
+// userRoleClaims return the names of the roles of a user and the permissions they grant, sorted
+func (s *service) userRoleClaims(userId uint) (roles []string, permissions []string, err error) {
+	userRoles, err := s.repo.FindRolesByUserId(userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	roles = []string{}
+	for _, role := range userRoles {
+		roles = append(roles, role.Name)
+	}
+	if permissions, err = s.repo.FindPermissionsByUserId(userId); err != nil {
+		return nil, nil, err
+	}
+	if permissions == nil {
+		permissions = []string{}
+	}
+	return roles, permissions, nil
+}
+
+// createRoleDTO create the response DTO of a role
+func createRoleDTO(role Role, permissions []string) rest.ResponseDTORole {
+	if permissions == nil {
+		permissions = []string{}
+	}
+	return rest.ResponseDTORole{Name: role.Name, Description: role.Description, Permissions: permissions}
+}
+
+// appendMissing append a value to a slice unless it is already in it
+func appendMissing(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// containsId check if an ID is in a slice
+func containsId(ids []uint, id uint) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
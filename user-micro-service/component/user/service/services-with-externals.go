@@ -5,15 +5,25 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/apihelper"
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
 	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"strconv"
 )
 
 var profileBaseUrl = "http://api.profile.apigoboot:4200/api/private-v1/profiles"
 
+var profileUsersBaseUrl = "http://api.profile.apigoboot:4200/api/private-v1/users"
+
+var profilePublicUsersBaseUrl = "http://api.profile.apigoboot:4200/api/v1/users"
+
+var oauth2BaseUrl = config.GAppUrl + "/api/private-v1/authentication"
+
 // AddWithProfile set up and create a user with a profile
 func (s *service) AddWithProfile(reqDTO rest.RequestDTOWithProfile) (rest.ResponseDTOWithProfile, *servicehelper.Error) {
 	if _, err := s.Add(rest.RequestDTO{
@@ -43,29 +53,31 @@ func (s *service) AddWithProfile(reqDTO rest.RequestDTOWithProfile) (rest.Respon
 	return resDTO, nil
 }
 
-// RetrieveWithProfile retrieve a user with its profile
-func (s *service) RetrieveWithProfile(email string) (rest.ResponseDTOWithProfile, *servicehelper.Error) {
-	if _, err := s.Retrieve(email); err != nil {
-		return rest.ResponseDTOWithProfile{}, err
-	}
-	resDTO, apiErrors, statusCode := callGetProfileService(email)
-	if statusCode != http.StatusOK {
-		s.Remove(resDTO.Email)
-		if len(apiErrors.Errors) > 0 {
-			return rest.ResponseDTOWithProfile{}, &servicehelper.Error{
-				Detail:  errors.New(apiErrors.Errors[0].(apihelper.Error).Detail),
-				Message: apiErrors.Errors[0].(apihelper.Error).Message,
-				Param:   apiErrors.Errors[0].(apihelper.Error).Param,
-				Code:    servicehelper.Code(statusCode),
-			}
-		}
+// RetrieveWithProfile retrieve a user with its profile, the profile is requested on behalf of the owner of the access token.
+// The user is returned without its profile when the profile can't be read
+func (s *service) RetrieveWithProfile(email string, accessToken string) (rest.ResponseDTOWithProfile, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
 		return rest.ResponseDTOWithProfile{}, &servicehelper.Error{
-			Detail:  errors.New("server unavailable"),
-			Message: "Please try again later",
-			Code:    servicehelper.UnexpectedError,
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
 		}
 	}
-	return resDTO, nil
+	resDTO := rest.ResponseDTOWithProfile{Username: entity.Username, Email: entity.Email}
+	delegatedToken, err := apitool.ExchangeToken(config.GAppUrl+"/authentication/token", config.GClientId, config.GClientSecret, accessToken, "profile:read")
+	if err != nil {
+		log.Printf("ERROR: could not exchange a token to read the profile of user %d: %s\n", entity.ID, err)
+		return resDTO, nil
+	}
+	profile, err := callGetProfileService(entity.ID, delegatedToken)
+	if err != nil {
+		log.Printf("ERROR: could not read the profile of user %d: %s\n", entity.ID, err)
+		return resDTO, nil
+	}
+	profile.Username, profile.Email = entity.Username, entity.Email
+	return profile, nil
 }
 
 // callPostProfileService ask the profile micro service to create a profile for the user
@@ -99,26 +111,88 @@ func callPostProfileService(reqDTO rest.RequestDTOWithProfile) (rest.ResponseDTO
 	return userWithProfile, apiErrors, resp.StatusCode
 }
 
-// callPostProfileService ask the profile micro service to create a profile for the user
-func callGetProfileService(email string) (rest.ResponseDTOWithProfile, apihelper.ApiErrors, int) {
+// callGetProfileService ask the public API of the profile micro service for the profile of the user with a token delegated by the user
+func callGetProfileService(userId uint, delegatedToken string) (rest.ResponseDTOWithProfile, error) {
+	req, err := http.NewRequest("GET", profilePublicUsersBaseUrl+"/"+strconv.FormatUint(uint64(userId), 10)+"/profile", nil)
+	if err != nil {
+		return rest.ResponseDTOWithProfile{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+delegatedToken)
 
-	// call api
-	req, err := http.NewRequest("GET", profileBaseUrl+"/"+email, nil)
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return rest.ResponseDTOWithProfile{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return rest.ResponseDTOWithProfile{}, errors.New("profile service answered " + resp.Status)
+	}
+	userWithProfile := rest.ResponseDTOWithProfile{}
+	if err := json.NewDecoder(resp.Body).Decode(&userWithProfile); err != nil {
+		return rest.ResponseDTOWithProfile{}, err
+	}
+	return userWithProfile, nil
+}
+
+// callRevokeTokensService ask the oauth2 micro service to revoke every token and session of the user
+func callRevokeTokensService(userId uint) error {
+	req, err := http.NewRequest("DELETE", oauth2BaseUrl+"/user/"+strconv.FormatUint(uint64(userId), 10)+"/tokens", nil)
+	if err != nil {
+		return err
+	}
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer resp.Body.Close()
 
-	// read response
-	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("oauth2 service answered " + resp.Status)
+	}
+	return nil
+}
 
-	userWithProfile := rest.ResponseDTOWithProfile{}
-	apiErrors := apihelper.ApiErrors{}
-	json.Unmarshal(body, &userWithProfile)
-	json.Unmarshal(body, &apiErrors)
+// callGetUserDataService ask another micro service for the JSON document of the personal data it keeps about a user
+func callGetUserDataService(url string) (json.RawMessage, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	return userWithProfile, apiErrors, resp.StatusCode
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("service answered " + resp.Status)
+	}
+	if !json.Valid(body) {
+		return nil, errors.New("service answered an invalid JSON document")
+	}
+	return body, nil
+}
+
+// callDeleteUserDataService ask another micro service to erase the personal data it keeps about a user
+func callDeleteUserDataService(url string) error {
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("service answered " + resp.Status)
+	}
+	return nil
 }
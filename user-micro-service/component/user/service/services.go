@@ -3,10 +3,13 @@ package service
 
 import (
 	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
 	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
 	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
-	"github.com/elithrar/simple-scrypt"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"github.com/adriendomoison/apigoboot/user-micro-service/passwordhash"
 	"github.com/jinzhu/copier"
+	"log"
 	"time"
 )
 
@@ -17,6 +20,40 @@ type RepoInterface interface {
 	FindByEmail(email string) (user Entity, err error)
 	Update(user Entity) error
 	Delete(user Entity) error
+	FindDeleted(query apitool.Query) (users []Entity, err error)
+	FindDeletedByEmail(email string) (user Entity, err error)
+	Restore(user Entity) error
+	Purge(user Entity) error
+	FindLoginAttemptByIp(ip string) (attempt LoginAttempt, err error)
+	RecordFailedIpLogin(ip string, now time.Time, forgetBefore time.Time, maxFailedLogins int, lockedUntil time.Time) (failedLogins int, err error)
+	RecordFailedLogin(userId uint, now time.Time, forgetBefore time.Time, maxFailedLogins int, lockedUntil time.Time) (failedLogins int, err error)
+	ResetFailedLogins(userId uint) error
+	UpdateSecondFactor(user Entity) error
+	CreateCredential(credential Credential) error
+	FindCredentialsByUserId(userId uint) (credentials []Credential, err error)
+	FindCredentialByCredentialId(credentialId string) (credential Credential, err error)
+	UpdateCredential(credential Credential) error
+	DeleteCredential(credential Credential) error
+	CreateIdentity(identity Identity) error
+	FindIdentity(provider string, subject string) (identity Identity, err error)
+	FindIdentitiesByUserId(userId uint) (identities []Identity, err error)
+	DeleteIdentity(identity Identity) error
+	DeleteIdentitiesByUserId(userId uint) error
+	FindAll(query apitool.Query) (users []Entity, err error)
+	FindRoles() (roles []Role, err error)
+	FindRoleByName(name string) (role Role, err error)
+	FindRolesByUserId(userId uint) (roles []Role, err error)
+	FindRolePermissions(roleId uint) (permissions []string, err error)
+	FindPermissionsByUserId(userId uint) (permissions []string, err error)
+	SaveRole(role Role, permissions []string) (Role, error)
+	DeleteRole(role Role) error
+	SetUserRoles(userId uint, roleIds []uint) error
+	CreateDataRequest(request DataRequest) (DataRequest, error)
+	FindDataRequests(query apitool.Query) (requests []DataRequest, err error)
+	UpdateDataRequest(request DataRequest) error
+	CreateUsedToken(token UsedToken) error
+	PurgeUsedTokens(expiredBefore time.Time) error
+	RunExclusively(lock int64, job func()) (ran bool, err error)
 }
 
 // Entity is the model of a user in the database
@@ -24,9 +61,33 @@ type Entity struct {
 	ID        uint `gorm:"primary_key"`
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Email     string `gorm:"NOT NULL;UNIQUE"`
-	Username  string
-	Password  string `gorm:"NOT NULL"`
+	// DeletedAt is when the user was deleted, it is not found anymore but can be restored until it is purged
+	DeletedAt *time.Time `sql:"index"`
+	// Email is unique among the users not deleted, so a deleted user does not keep its email from signing up again
+	Email    string `gorm:"NOT NULL"`
+	Username string
+	Password string `gorm:"NOT NULL"`
+	// VerifiedAt is when the user confirmed its email address, PendingEmail is a new address waiting for confirmation
+	VerifiedAt   *time.Time
+	PendingEmail string
+	// EmailToken is the SHA-256 hash of the last verification token sent to the user
+	EmailToken string
+	// PasswordResetToken is the SHA-256 hash of the last password reset token sent to the user, cleared once used
+	PasswordResetToken string
+	// FailedLogins is the amount of consecutive failed password logins, reset on success
+	FailedLogins    int
+	LastFailedLogin *time.Time
+	LockedUntil     *time.Time
+	// TotpSecret is the secret of the authenticator app of the user encrypted by EncryptTotpSecret, it is only asked at login once TotpEnabled is set
+	TotpSecret   string
+	TotpEnabled  bool
+	TotpLastStep int64
+	// RecoveryCodes are the SHA-256 hashes of the unused recovery codes, separated by spaces
+	RecoveryCodes string
+	// Status is the step of the lifecycle of the user, only the active users can log in. StatusReason tells why it was last changed
+	Status          string `gorm:"NOT NULL;DEFAULT:'active'"`
+	StatusReason    string
+	StatusChangedAt *time.Time
 }
 
 // TableName allow to gives a specific name to the user table
@@ -34,6 +95,126 @@ func (Entity) TableName() string {
 	return "user"
 }
 
+// LoginAttempt is the model of the consecutive failed password logins coming from an IP address
+type LoginAttempt struct {
+	Ip              string `gorm:"primary_key"`
+	FailedLogins    int
+	LastFailedLogin *time.Time
+	LockedUntil     *time.Time
+}
+
+// TableName allow to gives a specific name to the login attempt table
+func (LoginAttempt) TableName() string {
+	return "login_attempt"
+}
+
+// Credential is the model of a WebAuthn credential (passkey or security key) registered by a user
+type Credential struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserId    uint `gorm:"NOT NULL;index"`
+	// CredentialId is the base64url encoded ID chosen by the authenticator
+	CredentialId string `gorm:"NOT NULL;UNIQUE"`
+	// PublicKey is the PKIX encoded public key of the credential and Algorithm its COSE algorithm
+	PublicKey  []byte `gorm:"NOT NULL"`
+	Algorithm  int64
+	SignCount  uint32
+	Transports string
+	Name       string
+	LastUsedAt *time.Time
+}
+
+// TableName allow to gives a specific name to the credential table
+func (Credential) TableName() string {
+	return "credential"
+}
+
+// Identity is the model of an account of an external identity provider linked to a user
+type Identity struct {
+	ID       uint   `gorm:"primary_key"`
+	UserId   uint   `gorm:"NOT NULL;index"`
+	Provider string `gorm:"NOT NULL;unique_index:idx_user_identity_provider_subject"`
+	Subject  string `gorm:"NOT NULL;unique_index:idx_user_identity_provider_subject"`
+	// EmailAtLink is the email given by the provider when the identity was linked, the login does not depend on it
+	EmailAtLink string
+	LinkedAt    time.Time
+}
+
+// TableName allow to gives a specific name to the user identity table
+func (Identity) TableName() string {
+	return "user_identity"
+}
+
+// Role is the model of a role given to users, its permissions let them act on the accounts of the other users
+type Role struct {
+	ID          uint   `gorm:"primary_key"`
+	Name        string `gorm:"NOT NULL;UNIQUE"`
+	Description string
+}
+
+// TableName allow to gives a specific name to the role table
+func (Role) TableName() string {
+	return "role"
+}
+
+// RolePermission is the model of a permission granted by a role
+type RolePermission struct {
+	RoleId     uint   `gorm:"primary_key;auto_increment:false"`
+	Permission string `gorm:"primary_key"`
+}
+
+// TableName allow to gives a specific name to the role permission table
+func (RolePermission) TableName() string {
+	return "role_permission"
+}
+
+// UserRole is the model of a role given to a user
+type UserRole struct {
+	UserId uint `gorm:"primary_key;auto_increment:false"`
+	RoleId uint `gorm:"primary_key;auto_increment:false"`
+}
+
+// TableName allow to gives a specific name to the user role table
+func (UserRole) TableName() string {
+	return "user_role"
+}
+
+// DataRequest is the model of a request of a user exercising its rights on its personal data, an export or an erasure.
+// It is kept once done as the audit record of the request, SubjectHash being the HMAC-SHA256 of the email of the user keyed by the secret key of the micro service
+type DataRequest struct {
+	ID          uint `gorm:"primary_key"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	UserId      uint   `gorm:"NOT NULL;index"`
+	SubjectHash string `gorm:"NOT NULL;index"`
+	Kind        string `gorm:"NOT NULL"`
+	Status      string `gorm:"NOT NULL"`
+	// RequestedBy is the ID of the user who made the request, the subject itself or a member of the staff, 0 when unknown
+	RequestedBy uint
+	ScheduledAt time.Time
+	CompletedAt *time.Time
+	// Steps is the space separated list of the steps of an erasure already done, Error is the last error that stopped it
+	Steps string
+	Error string
+}
+
+// TableName allow to gives a specific name to the data request table
+func (DataRequest) TableName() string {
+	return "data_request"
+}
+
+// UsedToken is the model of a single-use signed token that has been used, kept until it expires so it can't be used again
+type UsedToken struct {
+	// Hash is the SHA-256 hash of the token
+	Hash      string    `gorm:"primary_key"`
+	ExpiresAt time.Time `gorm:"NOT NULL;index"`
+}
+
+// TableName allow to gives a specific name to the used token table
+func (UsedToken) TableName() string {
+	return "used_token"
+}
+
 // Make sure the interface is implemented correctly
 var _ rest.ServiceInterface = (*service)(nil)
 
@@ -50,6 +231,7 @@ func New(repo RepoInterface) *service {
 // createDTOFromEntity copy all data from an entity to a Response DTO
 func createDTOFromEntity(entity Entity) (resDTO rest.ResponseDTO) {
 	copier.Copy(&resDTO, &entity)
+	resDTO.Verified = entity.VerifiedAt != nil
 	return resDTO
 }
 
@@ -57,7 +239,8 @@ func createDTOFromEntity(entity Entity) (resDTO rest.ResponseDTO) {
 func createEntityFromDTO(reqDTO rest.RequestDTO, init bool) (entity Entity, error *servicehelper.Error) {
 	copier.Copy(&entity, &reqDTO)
 	if init {
-		hashedPassword, err := scrypt.GenerateFromPassword([]byte(reqDTO.Password), scrypt.DefaultParams)
+		entity.Status = StatusActive
+		hashedPassword, err := PasswordHasher.Hash(reqDTO.Password)
 		if err != nil {
 			return Entity{}, &servicehelper.Error{
 				Detail:  errors.New("could not hash the password"),
@@ -65,7 +248,7 @@ func createEntityFromDTO(reqDTO rest.RequestDTO, init bool) (entity Entity, erro
 				Code:    servicehelper.UnexpectedError,
 			}
 		}
-		entity.Password = string(hashedPassword[:])
+		entity.Password = hashedPassword
 	}
 	return
 }
@@ -81,10 +264,16 @@ func (s *service) GetResourceOwnerId(email string) (userId uint) {
 
 // Add set up and create a user
 func (s *service) Add(reqDTO rest.RequestDTO) (resDTO rest.ResponseDTO, error *servicehelper.Error) {
+	if err := checkPasswordPolicy(reqDTO.Password, reqDTO.Email, reqDTO.Username); err != nil {
+		return rest.ResponseDTO{}, err
+	}
 	entity, Err := createEntityFromDTO(reqDTO, true)
 	if Err != nil {
 		return rest.ResponseDTO{}, Err
 	} else if s.repo.Create(entity) {
+		if entity, err := s.repo.FindByEmail(entity.Email); err == nil {
+			s.startEmailVerification(entity)
+		}
 		return createDTOFromEntity(entity), error
 	}
 	return rest.ResponseDTO{}, &servicehelper.Error{
@@ -109,7 +298,7 @@ func (s *service) Retrieve(email string) (resDTO rest.ResponseDTO, error *servic
 	return createDTOFromEntity(entity), error
 }
 
-// EditEmail check user's password and change its email
+// EditEmail check user's password and hold its new email until it is confirmed with the link sent to it, the current address is notified
 func (s *service) EditEmail(reqDTO rest.RequestDTOPutEmail) (resDTO rest.ResponseDTO, error *servicehelper.Error) {
 	entity, err := s.repo.FindByEmail(reqDTO.Email)
 	if err != nil {
@@ -130,7 +319,7 @@ func (s *service) EditEmail(reqDTO rest.RequestDTOPutEmail) (resDTO rest.Respons
 		}
 	}
 
-	if err := scrypt.CompareHashAndPassword([]byte(entity.Password), []byte(reqDTO.Password)); err != nil {
+	if err := passwordhash.Compare(entity.Password, reqDTO.Password); err != nil {
 		return rest.ResponseDTO{}, &servicehelper.Error{
 			Detail:  errors.New("invalid password"),
 			Message: "We could not authenticate you, please type you password again",
@@ -138,20 +327,23 @@ func (s *service) EditEmail(reqDTO rest.RequestDTOPutEmail) (resDTO rest.Respons
 			Code:    servicehelper.BadRequest,
 		}
 	}
-	entity.Email = reqDTO.NewEmail
+	entity.PendingEmail = reqDTO.NewEmail
 
-	if err := s.repo.Update(entity); err != nil {
+	if err := s.startEmailVerification(entity); err != nil {
 		return rest.ResponseDTO{}, &servicehelper.Error{
 			Detail:  errors.New("could not update user"),
 			Message: "We could not update the email of this account, please contact us or try again later",
 			Code:    servicehelper.UnexpectedError,
 		}
 	}
+	notify(entity.Email, "Your email address is being changed",
+		"A change of the email address of your "+config.GAppName+" account to "+entity.PendingEmail+" has been requested.\n"+
+			"It will be effective once the new address is confirmed. If you did not ask for it, please change your password.")
 
 	return createDTOFromEntity(entity), error
 }
 
-// EditPassword check user password and overwrite it with a new one
+// EditPassword check user password and overwrite it with a new one following the password policy
 func (s *service) EditPassword(reqDTO rest.RequestDTOPutPassword) (resDTO rest.ResponseDTO, error *servicehelper.Error) {
 	entity, err := s.repo.FindByEmail(reqDTO.Email)
 	if err != nil {
@@ -163,7 +355,7 @@ func (s *service) EditPassword(reqDTO rest.RequestDTOPutPassword) (resDTO rest.R
 		}
 	}
 
-	if scrypt.CompareHashAndPassword([]byte(entity.Password), []byte(reqDTO.Password)) != nil {
+	if passwordhash.Compare(entity.Password, reqDTO.Password) != nil {
 		return rest.ResponseDTO{}, &servicehelper.Error{
 			Detail:  errors.New("invalid password"),
 			Message: "We could not authenticate you, please type you password again",
@@ -172,7 +364,11 @@ func (s *service) EditPassword(reqDTO rest.RequestDTOPutPassword) (resDTO rest.R
 		}
 	}
 
-	hashedPassword, err := scrypt.GenerateFromPassword([]byte(reqDTO.NewPassword), scrypt.DefaultParams)
+	if err := checkPasswordPolicy(reqDTO.NewPassword, entity.Email, entity.Username); err != nil {
+		return rest.ResponseDTO{}, err
+	}
+
+	hashedPassword, err := PasswordHasher.Hash(reqDTO.NewPassword)
 	if err != nil {
 		return rest.ResponseDTO{}, &servicehelper.Error{
 			Detail:  errors.New("could not hash the password"),
@@ -181,7 +377,7 @@ func (s *service) EditPassword(reqDTO rest.RequestDTOPutPassword) (resDTO rest.R
 			Code:    servicehelper.UnexpectedError,
 		}
 	}
-	entity.Password = string(hashedPassword[:])
+	entity.Password = hashedPassword
 
 	if err := s.repo.Update(entity); err != nil {
 		return rest.ResponseDTO{}, &servicehelper.Error{
@@ -193,7 +389,8 @@ func (s *service) EditPassword(reqDTO rest.RequestDTOPutPassword) (resDTO rest.R
 	return createDTOFromEntity(entity), error
 }
 
-// Remove find a user in the database and delete it
+// Remove find a user in the database and soft delete it, its tokens are revoked.
+// An administrator can restore it until the end of the retention period, then it is purged with its data in every service
 func (s *service) Remove(email string) (error *servicehelper.Error) {
 	if entity, err := s.repo.FindByEmail(email); err != nil {
 		return &servicehelper.Error{
@@ -204,14 +401,38 @@ func (s *service) Remove(email string) (error *servicehelper.Error) {
 		}
 	} else if err := s.repo.Delete(entity); err != nil {
 		return &servicehelper.Error{
-			Detail:  errors.New("failed to delete user"),
+			Detail:  err,
 			Message: "We could not delete the user, please try again later",
 			Code:    servicehelper.UnexpectedError,
 		}
+	} else if err := callRevokeTokensService(entity.ID); err != nil {
+		// the user can't log in anymore and its tokens are deleted for good when it is purged
+		log.Printf("ERROR: could not revoke the tokens of deleted account %s: %s\n", entity.Email, err)
 	}
 	return
 }
 
+// purgeUser delete a user for good along with its WebAuthn credentials, its linked identities and its roles, whether it is soft deleted or not
+func (s *service) purgeUser(userId uint) error {
+	credentials, err := s.repo.FindCredentialsByUserId(userId)
+	if err != nil {
+		return errors.New("failed to find user credentials")
+	}
+	for _, credential := range credentials {
+		if err := s.repo.DeleteCredential(credential); err != nil {
+			return errors.New("failed to delete user credentials")
+		}
+	}
+	if err := s.repo.DeleteIdentitiesByUserId(userId); err != nil {
+		return errors.New("failed to delete user identities")
+	} else if err := s.repo.SetUserRoles(userId, nil); err != nil {
+		return errors.New("failed to delete user roles")
+	} else if err := s.repo.Purge(Entity{ID: userId}); err != nil {
+		return errors.New("failed to delete user")
+	}
+	return nil
+}
+
 // IsThatTheUserId check if userIdToCheck is the same than the resource
 func (s *service) IsThatTheUserId(email string, userIdToCheck uint) (same bool, error *servicehelper.Error) {
 	entity, err := s.repo.FindByEmail(email)
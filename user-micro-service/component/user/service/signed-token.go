@@ -0,0 +1,78 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signPayload sign a payload with the secret key of the micro service
+func signPayload(payload string) string {
+	mac := hmac.New(sha256.New, config.GSecretKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// createSignedToken return an unpredictable token signed for a purpose, carrying a user ID (or 0) until it expires
+func createSignedToken(purpose string, userId uint, lifetime time.Duration, now time.Time) string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(purpose + "|" + strconv.FormatUint(uint64(userId), 10) + "|" +
+		strconv.FormatInt(now.Add(lifetime).Unix(), 10) + "|" + base64.RawURLEncoding.EncodeToString(nonce)))
+	return payload + "." + signPayload(payload)
+}
+
+// parseSignedToken check the signature, the purpose and the expiration of a token created by createSignedToken and return its user ID
+func parseSignedToken(purpose string, token string, now time.Time) (uint, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(signPayload(parts[0]))) {
+		return 0, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Split(string(payload), "|")
+	if len(fields) != 4 || fields[0] != purpose {
+		return 0, false
+	}
+	userId, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	expiresAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return 0, false
+	}
+	return uint(userId), true
+}
+
+// hashSignedToken hash a single-use token before saving it, so the tokens can't be used by reading the database
+func hashSignedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// useSignedToken check a single-use token created by createSignedToken like parseSignedToken and record it as used,
+// so that it is refused afterwards. lifetime is the lifetime the token was created with
+func (s *service) useSignedToken(purpose string, token string, lifetime time.Duration, now time.Time) (uint, bool) {
+	userId, ok := parseSignedToken(purpose, token, now)
+	if !ok {
+		return 0, false
+	}
+	if err := s.repo.PurgeUsedTokens(now); err != nil {
+		log.Println("ERROR: could not purge the expired used tokens:", err)
+	}
+	if err := s.repo.CreateUsedToken(UsedToken{Hash: hashSignedToken(token), ExpiresAt: now.Add(lifetime)}); err != nil {
+		return 0, false
+	}
+	return userId, true
+}
@@ -0,0 +1,137 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"log"
+	"time"
+)
+
+// The statuses of the lifecycle of a user, only the active users can log in
+const (
+	StatusActive          = "active"
+	StatusSuspended       = "suspended"
+	StatusDeactivated     = "deactivated"
+	StatusPendingDeletion = "pending_deletion"
+)
+
+// inactiveStatusMessages are the messages refusing the login of the users who are not active
+var inactiveStatusMessages = map[string]string{
+	StatusSuspended:       "This account has been suspended, please contact us",
+	StatusDeactivated:     "This account has been deactivated, please contact us to reactivate it",
+	StatusPendingDeletion: "This account is being deleted, please contact us to cancel the deletion",
+}
+
+// EditStatus let an administrator move a user to another step of its lifecycle.
+// The erasure of the user is scheduled when it becomes pending deletion and cancelled when it leaves this status
+func (s *service) EditStatus(email string, reqDTO rest.RequestDTOStatus) (rest.ResponseDTOStatus, *servicehelper.Error) {
+	if !isStatus(reqDTO.Status) {
+		return rest.ResponseDTOStatus{}, &servicehelper.Error{
+			Detail:  errors.New("unknown status"),
+			Message: "The status must be active, suspended, deactivated or pending_deletion",
+			Param:   "status",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+	entity, dbErr := s.repo.FindByEmail(email)
+	if dbErr != nil {
+		return rest.ResponseDTOStatus{}, &servicehelper.Error{
+			Detail:  errors.New("could not find user"),
+			Message: "We could not find any user, please check the provided email",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	previousStatus := entity.Status
+	entity, err := s.changeStatus(entity, reqDTO.Status, reqDTO.Reason)
+	if err != nil {
+		return rest.ResponseDTOStatus{}, err
+	}
+	if entity.Status == StatusPendingDeletion {
+		if _, err := s.scheduleErasure(entity, 0, time.Now().Add(config.GErasureGracePeriod)); err != nil {
+			return rest.ResponseDTOStatus{}, err
+		}
+	} else if previousStatus == StatusPendingDeletion {
+		if err := s.cancelErasures(entity.ID); err != nil {
+			return rest.ResponseDTOStatus{}, &servicehelper.Error{
+				Detail:  err,
+				Message: "The status of the user has been changed but the deletion of its data could not be cancelled, please try again later",
+				Code:    servicehelper.UnexpectedError,
+			}
+		}
+	}
+	return rest.ResponseDTOStatus{Status: entity.Status, Reason: entity.StatusReason, ChangedAt: entity.StatusChangedAt}, nil
+}
+
+// Deactivate let a user deactivate its own account, it can't log in until an administrator reactivates it
+func (s *service) Deactivate(email string, reqDTO rest.RequestDTODeactivate) *servicehelper.Error {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not find user"),
+			Message: "We could not find any user, please check the provided email",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if _, err := s.changeStatus(entity, StatusDeactivated, reqDTO.Reason); err != nil {
+		return err
+	}
+	return nil
+}
+
+// changeStatus save the new status of a user and revoke its tokens when it is not active anymore.
+// The reason replaces the previous one, and the date of the change is kept when the status stays the same
+func (s *service) changeStatus(entity Entity, status string, reason string) (Entity, *servicehelper.Error) {
+	if entity.Status != status {
+		now := time.Now()
+		entity.Status = status
+		entity.StatusChangedAt = &now
+	}
+	entity.StatusReason = reason
+	if err := s.repo.Update(entity); err != nil {
+		return Entity{}, &servicehelper.Error{
+			Detail:  err,
+			Message: "We could not change the status of the user, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	if status != StatusActive {
+		// the user can't log in anymore, its sessions are closed as well
+		if err := callRevokeTokensService(entity.ID); err != nil {
+			log.Printf("ERROR: could not revoke the tokens of account %s: %s\n", entity.Email, err)
+			return Entity{}, &servicehelper.Error{
+				Detail:  errors.New("could not revoke the tokens of the user"),
+				Message: "The status of the user has been changed but its sessions could not be closed, please try again later",
+				Code:    servicehelper.UnexpectedError,
+			}
+		}
+	}
+	return entity, nil
+}
+
+// isStatus check that a status is one of the statuses of the lifecycle of a user
+func isStatus(status string) bool {
+	_, inactive := inactiveStatusMessages[status]
+	return inactive || status == StatusActive
+}
+
+// checkActive refuse the login of a user who is not active
+func (s *service) checkActive(userId uint) *servicehelper.Error {
+	entity, err := s.repo.FindByID(userId)
+	if err == nil && entity.Status == StatusActive {
+		return nil
+	}
+	message, ok := inactiveStatusMessages[entity.Status]
+	if !ok {
+		message = "This account can't be used anymore, please contact us"
+	}
+	return &servicehelper.Error{
+		Detail:  errors.New("user is not active"),
+		Message: message,
+		Code:    servicehelper.Forbidden,
+	}
+}
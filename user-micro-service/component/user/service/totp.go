@@ -0,0 +1,142 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// totpPeriod is the lifetime of a TOTP code (RFC 6238)
+const totpPeriod = 30
+
+// totpDigits is the length of a TOTP code
+const totpDigits = 6
+
+// totpSkew is the amount of periods before and after the current one accepted to tolerate clock drifts
+const totpSkew = 1
+
+// encryptedTotpSecretPrefix mark the TOTP secrets encrypted at rest, it is not part of the base32 alphabet of the plaintext ones
+const encryptedTotpSecretPrefix = "enc:"
+
+// totpEncoding encode the TOTP secrets the way authenticator apps expect them
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTotpSecret return a new random base32 encoded TOTP secret
+func generateTotpSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpUri return the otpauth URI to show as a QR code to enroll an authenticator app
+func totpUri(issuer string, account string, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// totpCode compute the code of a secret for a time step (RFC 4226)
+func totpCode(key []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// validateTotp check a code against a secret and return the time step it matches.
+// Steps up to lastStep are refused so that a code cannot be used twice
+func validateTotp(secret string, code string, lastStep int64, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpSecretCipher return the AES-GCM cipher of the TOTP secrets, its key is derived from the secret key of the micro service
+func totpSecretCipher() (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, config.GSecretKey)
+	mac.Write([]byte("totp-secret"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptTotpSecret encrypt the TOTP secret of a user before saving it, the ciphertext is bound to the user ID
+func EncryptTotpSecret(secret string, userId uint) (string, error) {
+	aead, err := totpSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), []byte(strconv.FormatUint(uint64(userId), 10)))
+	return encryptedTotpSecretPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// IsEncryptedTotpSecret check if a stored TOTP secret is already encrypted
+func IsEncryptedTotpSecret(secret string) bool {
+	return strings.HasPrefix(secret, encryptedTotpSecretPrefix)
+}
+
+// decryptTotpSecret return the TOTP secret of a user saved by EncryptTotpSecret
+func decryptTotpSecret(stored string, userId uint) (string, error) {
+	if !IsEncryptedTotpSecret(stored) {
+		return "", errors.New("totp secret is not encrypted")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedTotpSecretPrefix))
+	if err != nil {
+		return "", err
+	}
+	aead, err := totpSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("totp secret is too short")
+	}
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(strconv.FormatUint(uint64(userId), 10)))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
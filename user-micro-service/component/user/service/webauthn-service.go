@@ -0,0 +1,357 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"errors"
+	"github.com/adriendomoison/apigoboot/api-tool/errorhandling/servicehelper"
+	"github.com/adriendomoison/apigoboot/user-micro-service/component/user/rest"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webauthnTimeout is how long a user has to answer the browser prompt of a WebAuthn ceremony
+const webauthnTimeout = 5 * time.Minute
+
+// purposes of the signed challenges, matching the type of the client data of each ceremony
+const (
+	webauthnCreatePurpose = "webauthn.create"
+	webauthnGetPurpose    = "webauthn.get"
+)
+
+// webauthnTransports are the transports of the authenticators kept with a credential
+var webauthnTransports = map[string]bool{"usb": true, "nfc": true, "ble": true, "internal": true, "hybrid": true, "smart-card": true}
+
+// webauthnUserHandle return the opaque identifier of a user given to the authenticators
+func webauthnUserHandle(userId uint) string {
+	return webauthnEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(userId), 10)))
+}
+
+// createDescriptorsFromCredentials describe registered credentials to the browser
+func createDescriptorsFromCredentials(credentials []Credential) []rest.DTOWebauthnCredentialDescriptor {
+	descriptors := []rest.DTOWebauthnCredentialDescriptor{}
+	for _, credential := range credentials {
+		descriptors = append(descriptors, rest.DTOWebauthnCredentialDescriptor{
+			Type:       "public-key",
+			Id:         credential.CredentialId,
+			Transports: strings.Fields(credential.Transports),
+		})
+	}
+	return descriptors
+}
+
+// createDTOFromCredential copy the data of a credential to a Response DTO
+func createDTOFromCredential(credential Credential) rest.ResponseDTOWebauthnCredential {
+	return rest.ResponseDTOWebauthnCredential{
+		Id:         credential.CredentialId,
+		Name:       credential.Name,
+		Transports: strings.Fields(credential.Transports),
+		CreatedAt:  credential.CreatedAt,
+		LastUsedAt: credential.LastUsedAt,
+	}
+}
+
+// StartWebauthnRegistration return the options to give to the browser to create a credential for a user
+func (s *service) StartWebauthnRegistration(email string) (rest.ResponseDTOWebauthnCreationOptions, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return rest.ResponseDTOWebauthnCreationOptions{}, &servicehelper.Error{
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	credentials, err := s.repo.FindCredentialsByUserId(entity.ID)
+	if err != nil {
+		return rest.ResponseDTOWebauthnCreationOptions{}, &servicehelper.Error{
+			Detail:  errors.New("could not retrieve credentials"),
+			Message: "We could not add a passkey, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	displayName := entity.Username
+	if displayName == "" {
+		displayName = entity.Email
+	}
+	challenge := createSignedToken(webauthnCreatePurpose, entity.ID, webauthnTimeout, time.Now())
+	return rest.ResponseDTOWebauthnCreationOptions{
+		Challenge: webauthnEncoding.EncodeToString([]byte(challenge)),
+		Rp:        rest.DTOWebauthnRelyingParty{Id: config.GWebauthnRpId, Name: config.GAppName},
+		User: rest.DTOWebauthnUser{
+			Id:          webauthnUserHandle(entity.ID),
+			Name:        entity.Email,
+			DisplayName: displayName,
+		},
+		PubKeyCredParams: []rest.DTOWebauthnCredentialParameters{
+			{Type: "public-key", Alg: coseAlgES256},
+			{Type: "public-key", Alg: coseAlgEdDSA},
+			{Type: "public-key", Alg: coseAlgRS256},
+		},
+		Timeout:            webauthnTimeout.Milliseconds(),
+		Attestation:        "none",
+		ExcludeCredentials: createDescriptorsFromCredentials(credentials),
+		AuthenticatorSelection: rest.DTOWebauthnAuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+	}, nil
+}
+
+// FinishWebauthnRegistration check the credential created by the browser and save it.
+// Attestation statements are not verified since the registration asks for none
+func (s *service) FinishWebauthnRegistration(email string, reqDTO rest.RequestDTOWebauthnCredential) (rest.ResponseDTOWebauthnCredential, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return rest.ResponseDTOWebauthnCredential{}, &servicehelper.Error{
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	invalidCredential := func(detail string) (rest.ResponseDTOWebauthnCredential, *servicehelper.Error) {
+		return rest.ResponseDTOWebauthnCredential{}, &servicehelper.Error{
+			Detail:  errors.New(detail),
+			Message: "We could not add this passkey, please try again",
+			Param:   "response",
+			Code:    servicehelper.BadRequest,
+		}
+	}
+
+	clientDataJSON, err := decodeWebauthnBinary(reqDTO.Response.ClientDataJSON)
+	if err != nil {
+		return invalidCredential("malformed client data")
+	}
+	challenge, err := parseClientData(clientDataJSON, webauthnCreatePurpose)
+	if err != nil {
+		return invalidCredential(err.Error())
+	}
+	if userId, ok := s.useSignedToken(webauthnCreatePurpose, challenge, webauthnTimeout, time.Now()); !ok || userId != entity.ID {
+		return invalidCredential("invalid or expired challenge")
+	}
+
+	attestationObject, err := decodeWebauthnBinary(reqDTO.Response.AttestationObject)
+	if err != nil {
+		return invalidCredential("malformed attestation object")
+	}
+	attestation, _, err := decodeCbor(attestationObject)
+	if err != nil {
+		return invalidCredential("malformed attestation object")
+	}
+	attestationMap, _ := attestation.(map[interface{}]interface{})
+	rawAuthData, _ := attestationMap["authData"].([]byte)
+	authData, err := parseAuthenticatorData(rawAuthData, true)
+	if err != nil {
+		return invalidCredential(err.Error())
+	}
+	credentialId := webauthnEncoding.EncodeToString(authData.CredentialId)
+	if credentialId != strings.TrimRight(reqDTO.Id, "=") {
+		return invalidCredential("credential id does not match the authenticator data")
+	}
+	if _, err := s.repo.FindCredentialByCredentialId(credentialId); err == nil {
+		return rest.ResponseDTOWebauthnCredential{}, &servicehelper.Error{
+			Detail:  errors.New("credential is already registered"),
+			Message: "This passkey is already registered",
+			Param:   "id",
+			Code:    servicehelper.AlreadyExist,
+		}
+	}
+
+	var transports []string
+	for _, transport := range reqDTO.Response.Transports {
+		if webauthnTransports[transport] {
+			transports = append(transports, transport)
+		}
+	}
+	credential := Credential{
+		UserId:       entity.ID,
+		CredentialId: credentialId,
+		PublicKey:    authData.PublicKey,
+		Algorithm:    authData.Algorithm,
+		SignCount:    authData.SignCount,
+		Transports:   strings.Join(transports, " "),
+		Name:         reqDTO.Name,
+	}
+	if err := s.repo.CreateCredential(credential); err != nil {
+		return rest.ResponseDTOWebauthnCredential{}, &servicehelper.Error{
+			Detail:  errors.New("could not save credential"),
+			Message: "We could not add this passkey, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	credential.CreatedAt = time.Now()
+	return createDTOFromCredential(credential), nil
+}
+
+// RetrieveWebauthnCredentials return the credentials registered by a user
+func (s *service) RetrieveWebauthnCredentials(email string) ([]rest.ResponseDTOWebauthnCredential, *servicehelper.Error) {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return nil, &servicehelper.Error{
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	credentials, err := s.repo.FindCredentialsByUserId(entity.ID)
+	if err != nil {
+		return nil, &servicehelper.Error{
+			Detail:  errors.New("could not retrieve credentials"),
+			Message: "We could not retrieve your passkeys, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	resDTO := []rest.ResponseDTOWebauthnCredential{}
+	for _, credential := range credentials {
+		resDTO = append(resDTO, createDTOFromCredential(credential))
+	}
+	return resDTO, nil
+}
+
+// RemoveWebauthnCredential delete a credential of a user
+func (s *service) RemoveWebauthnCredential(email string, credentialId string) *servicehelper.Error {
+	entity, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("user could not be found"),
+			Message: "We could not find any user with the provided email address",
+			Param:   "email",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	credential, err := s.repo.FindCredentialByCredentialId(credentialId)
+	if err != nil || credential.UserId != entity.ID {
+		return &servicehelper.Error{
+			Detail:  errors.New("credential could not be found"),
+			Message: "We could not find this passkey",
+			Param:   "credentialId",
+			Code:    servicehelper.NotFound,
+		}
+	}
+	if err := s.repo.DeleteCredential(credential); err != nil {
+		return &servicehelper.Error{
+			Detail:  errors.New("could not delete credential"),
+			Message: "We could not delete this passkey, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	return nil
+}
+
+// StartWebauthnLogin return the options to give to the browser to sign in with a passkey.
+// The credentials of the user are not listed, so that anyone can't learn them from an email address, the passkeys are discoverable.
+// When the username is known, the challenge only accepts a passkey of this user
+func (s *service) StartWebauthnLogin(reqDTO rest.RequestDTOWebauthnRequestOptions) (rest.ResponseDTOWebauthnRequestOptions, *servicehelper.Error) {
+	var userId uint
+	if reqDTO.Username != "" {
+		if entity, err := s.repo.FindByEmail(reqDTO.Username); err == nil {
+			userId = entity.ID
+		}
+	}
+	challenge := createSignedToken(webauthnGetPurpose, userId, webauthnTimeout, time.Now())
+	return rest.ResponseDTOWebauthnRequestOptions{
+		Challenge:        webauthnEncoding.EncodeToString([]byte(challenge)),
+		RpId:             config.GWebauthnRpId,
+		Timeout:          webauthnTimeout.Milliseconds(),
+		UserVerification: "required",
+		AllowCredentials: []rest.DTOWebauthnCredentialDescriptor{},
+	}, nil
+}
+
+// checkCredentialsForWebauthnAuth check the assertion signed by a registered credential.
+// Invalid assertions count as failed logins of the account of the credential and of the IP address
+func checkCredentialsForWebauthnAuth(s *service, assertion *rest.RequestDTOWebauthnCredential, ip string) (rest.ResponseDTOUserInfo, *servicehelper.Error) {
+	refused := &servicehelper.Error{
+		Detail:  errors.New("invalid webauthn assertion"),
+		Message: "We could not sign you in with this passkey",
+		Param:   "webauthn",
+		Code:    servicehelper.Unauthorized,
+	}
+	now := time.Now()
+	attempt := LoginAttempt{Ip: ip}
+	if ip != "" {
+		if savedAttempt, err := s.repo.FindLoginAttemptByIp(ip); err == nil {
+			attempt = savedAttempt
+		}
+		if err := checkIpThrottle(attempt, now); err != nil {
+			return rest.ResponseDTOUserInfo{}, err
+		}
+	}
+	refuseFromIp := func() (rest.ResponseDTOUserInfo, *servicehelper.Error) {
+		if ip != "" {
+			s.recordFailedIpLogin(ip, now)
+		}
+		return rest.ResponseDTOUserInfo{}, refused
+	}
+	if assertion == nil {
+		return rest.ResponseDTOUserInfo{}, refused
+	}
+
+	clientDataJSON, err := decodeWebauthnBinary(assertion.Response.ClientDataJSON)
+	if err != nil {
+		return refuseFromIp()
+	}
+	challenge, err := parseClientData(clientDataJSON, webauthnGetPurpose)
+	if err != nil {
+		return refuseFromIp()
+	}
+	challengeUserId, ok := s.useSignedToken(webauthnGetPurpose, challenge, webauthnTimeout, now)
+	if !ok {
+		return refuseFromIp()
+	}
+	credential, err := s.repo.FindCredentialByCredentialId(strings.TrimRight(assertion.Id, "="))
+	if err != nil || (challengeUserId != 0 && challengeUserId != credential.UserId) {
+		return refuseFromIp()
+	}
+	if assertion.Response.UserHandle != "" && strings.TrimRight(assertion.Response.UserHandle, "=") != webauthnUserHandle(credential.UserId) {
+		return refuseFromIp()
+	}
+	entity, err := s.repo.FindByID(credential.UserId)
+	if err != nil {
+		return refuseFromIp()
+	}
+	if err := checkAccountThrottle(entity, now); err != nil {
+		return rest.ResponseDTOUserInfo{}, err
+	}
+
+	rawAuthData, _ := decodeWebauthnBinary(assertion.Response.AuthenticatorData)
+	signature, _ := decodeWebauthnBinary(assertion.Response.Signature)
+	authData, err := parseAuthenticatorData(rawAuthData, false)
+	if err != nil || !verifyWebauthnSignature(credential.PublicKey, credential.Algorithm, rawAuthData, clientDataJSON, signature) {
+		s.recordFailedAccountLogin(entity, now)
+		return refuseFromIp()
+	}
+	// the passkey replaces both the password and the second factor, the user must have been verified by the authenticator
+	if authData.Flags&authDataUserVerified == 0 {
+		s.recordFailedAccountLogin(entity, now)
+		return refuseFromIp()
+	}
+	// a counter that does not increase reveals a cloned authenticator, unless the authenticator does not count
+	if (authData.SignCount != 0 || credential.SignCount != 0) && authData.SignCount <= credential.SignCount {
+		log.Printf("WARNING: signature counter of credential %s of account %s did not increase, the authenticator may have been cloned\n", credential.CredentialId, entity.Email)
+		s.recordFailedAccountLogin(entity, now)
+		return refuseFromIp()
+	}
+
+	credential.SignCount = authData.SignCount
+	credential.LastUsedAt = &now
+	if err := s.repo.UpdateCredential(credential); err != nil {
+		log.Printf("ERROR: could not save signature counter of credential %s: %s\n", credential.CredentialId, err)
+		return rest.ResponseDTOUserInfo{}, &servicehelper.Error{
+			Detail:  errors.New("could not update credential"),
+			Message: "We could not sign you in, please try again later",
+			Code:    servicehelper.UnexpectedError,
+		}
+	}
+	if err := s.resetFailedLogins(entity); err != nil {
+		log.Printf("ERROR: could not reset failed logins of account %s: %s\n", entity.Email, err)
+	}
+	return rest.ResponseDTOUserInfo{
+		UserId: entity.ID,
+		Email:  entity.Email,
+	}, nil
+}
@@ -0,0 +1,183 @@
+// Package service implement the services required by the rest package
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"math/big"
+)
+
+// COSE algorithms (RFC 8152) of the public keys accepted from the authenticators
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// flags of the authenticator data
+const (
+	authDataUserPresent  = 0x01
+	authDataUserVerified = 0x04
+	authDataAttested     = 0x40
+)
+
+// webauthnEncoding encode the binary values exchanged with the browsers
+var webauthnEncoding = base64.RawURLEncoding
+
+// collectedClientData is the data the browser gives to the authenticator to sign
+type collectedClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authenticatorData is the data signed by an authenticator, the credential is only set during a registration
+type authenticatorData struct {
+	RpIdHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialId []byte
+	PublicKey    []byte
+	Algorithm    int64
+}
+
+// decodeWebauthnBinary decode a base64url value sent by a browser, some libraries keep the padding
+func decodeWebauthnBinary(value string) ([]byte, error) {
+	return webauthnEncoding.DecodeString(string(bytes.TrimRight([]byte(value), "=")))
+}
+
+// parseClientData check the client data of a ceremony and return the challenge it answers
+func parseClientData(raw []byte, ceremony string) (string, error) {
+	var clientData collectedClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return "", errors.New("malformed client data")
+	}
+	if clientData.Type != ceremony {
+		return "", errors.New("client data is not of type " + ceremony)
+	}
+	originAllowed := false
+	for _, origin := range config.GWebauthnOrigins {
+		originAllowed = originAllowed || clientData.Origin == origin
+	}
+	if !originAllowed {
+		return "", errors.New("origin " + clientData.Origin + " is not allowed")
+	}
+	challenge, err := decodeWebauthnBinary(clientData.Challenge)
+	if err != nil {
+		return "", errors.New("malformed challenge")
+	}
+	return string(challenge), nil
+}
+
+// parseAuthenticatorData parse and check the authenticator data, the attested credential is required during a registration
+func parseAuthenticatorData(data []byte, registration bool) (authenticatorData, error) {
+	if len(data) < 37 {
+		return authenticatorData{}, errors.New("authenticator data is too short")
+	}
+	authData := authenticatorData{
+		RpIdHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rpIdHash := sha256.Sum256([]byte(config.GWebauthnRpId))
+	if !bytes.Equal(authData.RpIdHash, rpIdHash[:]) {
+		return authenticatorData{}, errors.New("authenticator data is not for this relying party")
+	}
+	if authData.Flags&authDataUserPresent == 0 {
+		return authenticatorData{}, errors.New("user is not present")
+	}
+	if !registration {
+		return authData, nil
+	}
+
+	// attested credential data: aaguid (16 bytes), credential id length (2 bytes), credential id and COSE public key
+	if authData.Flags&authDataAttested == 0 || len(data) < 55 {
+		return authenticatorData{}, errors.New("authenticator data has no attested credential")
+	}
+	idLength := int(binary.BigEndian.Uint16(data[53:55]))
+	if len(data) < 55+idLength {
+		return authenticatorData{}, errors.New("authenticator data is too short")
+	}
+	authData.CredentialId = data[55 : 55+idLength]
+	coseKey, _, err := decodeCbor(data[55+idLength:])
+	if err != nil {
+		return authenticatorData{}, errors.New("malformed credential public key")
+	}
+	publicKey, algorithm, err := parseCosePublicKey(coseKey)
+	if err != nil {
+		return authenticatorData{}, err
+	}
+	if authData.PublicKey, err = x509.MarshalPKIXPublicKey(publicKey); err != nil {
+		return authenticatorData{}, err
+	}
+	authData.Algorithm = algorithm
+	return authData, nil
+}
+
+// parseCosePublicKey read an ES256, EdDSA or RS256 public key encoded as a COSE key
+func parseCosePublicKey(value interface{}) (crypto.PublicKey, int64, error) {
+	key, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("malformed credential public key")
+	}
+	algorithm, _ := key[int64(3)].(int64)
+	switch algorithm {
+	case coseAlgES256:
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if key[int64(1)] != int64(2) || key[int64(-1)] != int64(1) || len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("malformed ES256 public key")
+		}
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, 0, errors.New("malformed ES256 public key")
+		}
+		return publicKey, algorithm, nil
+	case coseAlgEdDSA:
+		x, _ := key[int64(-2)].([]byte)
+		if key[int64(1)] != int64(1) || key[int64(-1)] != int64(6) || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("malformed EdDSA public key")
+		}
+		return ed25519.PublicKey(x), algorithm, nil
+	case coseAlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if key[int64(1)] != int64(3) || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("malformed RS256 public key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, algorithm, nil
+	}
+	return nil, 0, errors.New("unsupported credential public key algorithm")
+}
+
+// verifyWebauthnSignature check the signature of an assertion, made over the authenticator data and the hash of the client data
+func verifyWebauthnSignature(publicKey []byte, algorithm int64, authData []byte, clientDataJSON []byte, signature []byte) bool {
+	key, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return false
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(signed)
+		return algorithm == coseAlgES256 && ecdsa.VerifyASN1(key, hash[:], signature)
+	case ed25519.PublicKey:
+		return algorithm == coseAlgEdDSA && ed25519.Verify(key, signed, signature)
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(signed)
+		return algorithm == coseAlgRS256 && rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	}
+	return false
+}
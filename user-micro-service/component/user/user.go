@@ -2,6 +2,7 @@
 package user
 
 import (
+	"github.com/adriendomoison/apigoboot/api-tool/apitool"
 	"github.com/gin-gonic/gin"
 )
 
@@ -11,11 +12,48 @@ type RestInterface interface {
 	Get(c *gin.Context)
 	PutEmail(c *gin.Context)
 	PutPassword(c *gin.Context)
+	VerifyEmail(c *gin.Context)
+	PostVerificationEmail(c *gin.Context)
+	PostVerificationEmailRequest(c *gin.Context)
+	PostPasswordReset(c *gin.Context)
+	ConfirmPasswordReset(c *gin.Context)
 	Delete(c *gin.Context)
 	GetByEmail(c *gin.Context)
 	GetById(c *gin.Context)
 	CheckCredentials(c *gin.Context)
 	ValidateAccessToken(c *gin.Context)
+	ValidateAdminAccessToken(c *gin.Context)
+	Unlock(c *gin.Context)
+	ImportUsers(c *gin.Context)
+	GetUsers(c *gin.Context)
+	SuspendUsers(c *gin.Context)
+	UnsuspendUsers(c *gin.Context)
+	PutStatus(c *gin.Context)
+	PostDeactivation(c *gin.Context)
+	GetRoles(c *gin.Context)
+	PutRole(c *gin.Context)
+	DeleteRole(c *gin.Context)
+	GetUserRoles(c *gin.Context)
+	PutUserRoles(c *gin.Context)
+	PostTotp(c *gin.Context)
+	VerifyTotp(c *gin.Context)
+	DisableTotp(c *gin.Context)
+	PostWebauthnRegistrationOptions(c *gin.Context)
+	PostWebauthnCredential(c *gin.Context)
+	GetWebauthnCredentials(c *gin.Context)
+	DeleteWebauthnCredential(c *gin.Context)
+	PostWebauthnLoginOptions(c *gin.Context)
+	GetIdentityProviders(c *gin.Context)
+	GetIdentities(c *gin.Context)
+	PostIdentity(c *gin.Context)
+	DeleteIdentity(c *gin.Context)
+	GetExport(c *gin.Context)
+	PostErasure(c *gin.Context)
+	GetDataRequests(c *gin.Context)
+	GetAdminDataRequests(c *gin.Context)
+	GetDeletedUsers(c *gin.Context)
+	PostRestore(c *gin.Context)
+	DeleteDeletedUser(c *gin.Context)
 }
 
 // Component implement interface component
@@ -31,10 +69,44 @@ func New(rest RestInterface) *Component {
 // AttachPublicAPI add the user micro-service public api with its dependencies
 func (component *Component) AttachPublicAPI(group *gin.RouterGroup) {
 	group.POST("/users", component.rest.Post)
-	group.GET("/users/:email", component.rest.ValidateAccessToken, component.rest.Get)
-	group.PUT("/users/:email/email", component.rest.ValidateAccessToken, component.rest.PutEmail)
-	group.PUT("/users/:email/password", component.rest.ValidateAccessToken, component.rest.PutPassword)
-	group.DELETE("/users/:email", component.rest.ValidateAccessToken, component.rest.Delete)
+	group.GET("/users/:email", component.rest.ValidateAccessToken, apitool.Authorize("owner OR scope:staff AND permission:users:read"), apitool.RequireScopes("user:read"), component.rest.Get)
+	group.PUT("/users/:email/email", component.rest.ValidateAccessToken, apitool.Authorize("owner"), apitool.RequireScopes("user:write"), component.rest.PutEmail)
+	group.POST("/users/:email/verify", component.rest.VerifyEmail)
+	group.POST("/users/:email/verification-email", component.rest.ValidateAccessToken, apitool.Authorize("owner OR scope:staff AND permission:users:write"), apitool.RequireScopes("user:write"), component.rest.PostVerificationEmail)
+	group.PUT("/users/:email/password", component.rest.ValidateAccessToken, apitool.Authorize("owner"), apitool.RequireScopes("user:write"), component.rest.PutPassword)
+	group.POST("/users/:email/deactivate", component.rest.ValidateAccessToken, apitool.Authorize("owner"), apitool.RequireScopes("user:write"), component.rest.PostDeactivation)
+	group.DELETE("/users/:email", component.rest.ValidateAccessToken, apitool.Authorize("owner OR scope:staff AND permission:users:write"), apitool.RequireScopes("user:write"), component.rest.Delete)
+	group.POST("/users/:email/mfa/totp", component.rest.ValidateAccessToken, apitool.Authorize("owner"), apitool.RequireScopes("user:write"), component.rest.PostTotp)
+	group.POST("/users/:email/mfa/totp/verify", component.rest.ValidateAccessToken, apitool.Authorize("owner"), apitool.RequireScopes("user:write"), component.rest.VerifyTotp)
+	group.POST("/users/:email/mfa/totp/disable", component.rest.ValidateAccessToken, apitool.Authorize("owner"), apitool.RequireScopes("user:write"), component.rest.DisableTotp)
+	group.POST("/users/:email/webauthn/registration-options", component.rest.ValidateAccessToken, apitool.Authorize("owner"), apitool.RequireScopes("user:write"), component.rest.PostWebauthnRegistrationOptions)
+	group.POST("/users/:email/webauthn/credentials", component.rest.ValidateAccessToken, apitool.Authorize("owner"), apitool.RequireScopes("user:write"), component.rest.PostWebauthnCredential)
+	group.GET("/users/:email/webauthn/credentials", component.rest.ValidateAccessToken, apitool.Authorize("owner OR scope:staff AND permission:users:read"), apitool.RequireScopes("user:read"), component.rest.GetWebauthnCredentials)
+	group.DELETE("/users/:email/webauthn/credentials/:credentialId", component.rest.ValidateAccessToken, apitool.Authorize("owner OR scope:staff AND permission:users:write"), apitool.RequireScopes("user:write"), component.rest.DeleteWebauthnCredential)
+	group.GET("/users/:email/identities", component.rest.ValidateAccessToken, apitool.Authorize("owner OR scope:staff AND permission:users:read"), apitool.RequireScopes("user:read"), component.rest.GetIdentities)
+	group.POST("/users/:email/identities", component.rest.ValidateAccessToken, apitool.Authorize("owner"), apitool.RequireScopes("user:write"), component.rest.PostIdentity)
+	group.DELETE("/users/:email/identities/:provider/:subject", component.rest.ValidateAccessToken, apitool.Authorize("owner OR scope:staff AND permission:users:write"), apitool.RequireScopes("user:write"), component.rest.DeleteIdentity)
+	group.GET("/users/:email/export", component.rest.ValidateAccessToken, apitool.Authorize("owner OR scope:staff AND permission:users:read"), apitool.RequireScopes("user:read"), component.rest.GetExport)
+	group.POST("/users/:email/erasure", component.rest.ValidateAccessToken, apitool.Authorize("owner OR scope:staff AND permission:users:write"), apitool.RequireScopes("user:write"), component.rest.PostErasure)
+	group.GET("/users/:email/data-requests", component.rest.ValidateAccessToken, apitool.Authorize("owner OR scope:staff AND permission:users:read"), apitool.RequireScopes("user:read"), component.rest.GetDataRequests)
+	group.POST("/password-reset", component.rest.PostPasswordReset)
+	group.POST("/password-reset/confirm", component.rest.ConfirmPasswordReset)
+	group.GET("/identity-providers", component.rest.GetIdentityProviders)
+	group.GET("/admin/users", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:users:read"), component.rest.GetUsers)
+	group.POST("/admin/users", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:users:import"), component.rest.ImportUsers)
+	group.POST("/admin/suspend", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:users:suspend"), component.rest.SuspendUsers)
+	group.POST("/admin/unsuspend", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:users:suspend"), component.rest.UnsuspendUsers)
+	group.POST("/admin/users/:email/unlock", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:users:unlock"), component.rest.Unlock)
+	group.PUT("/admin/users/:email/status", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:users:suspend"), component.rest.PutStatus)
+	group.GET("/admin/users/:email/roles", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:roles:manage OR scope:staff AND permission:users:read"), component.rest.GetUserRoles)
+	group.PUT("/admin/users/:email/roles", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:roles:manage"), component.rest.PutUserRoles)
+	group.GET("/admin/data-requests", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:users:read"), component.rest.GetAdminDataRequests)
+	group.GET("/admin/deleted-users", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:users:read"), component.rest.GetDeletedUsers)
+	group.POST("/admin/deleted-users/:email/restore", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:users:write"), component.rest.PostRestore)
+	group.DELETE("/admin/deleted-users/:email", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:users:write"), component.rest.DeleteDeletedUser)
+	group.GET("/admin/roles", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:roles:manage"), component.rest.GetRoles)
+	group.PUT("/admin/roles/:name", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:roles:manage"), component.rest.PutRole)
+	group.DELETE("/admin/roles/:name", component.rest.ValidateAdminAccessToken, apitool.Authorize("scope:admin OR scope:staff AND permission:roles:manage"), component.rest.DeleteRole)
 }
 
 // AttachPrivateAPI add the user micro-service user api with its dependencies
@@ -42,4 +114,8 @@ func (component *Component) AttachPrivateAPI(group *gin.RouterGroup) {
 	group.GET("/user/email/:email", component.rest.GetByEmail)
 	group.GET("/user/id/:userId", component.rest.GetById)
 	group.POST("/user/check-credentials", component.rest.CheckCredentials)
+	group.POST("/user/webauthn/login-options", component.rest.PostWebauthnLoginOptions)
+	group.POST("/user/verification-email", component.rest.PostVerificationEmailRequest)
+	group.POST("/user/password-reset", component.rest.PostPasswordReset)
+	group.POST("/user/password-reset/confirm", component.rest.ConfirmPasswordReset)
 }
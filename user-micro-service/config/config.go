@@ -2,8 +2,15 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/json"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // GAppName define the app name
@@ -25,8 +32,294 @@ var GPort string
 // GAppUrl is the application url
 var GAppUrl string
 
+// GClientId is the oauth2 client id of the micro service, used to exchange the token of a user before calling another micro service
+var GClientId = "user-micro-service"
+
+// GClientSecret is the oauth2 client secret of the micro service
+var GClientSecret string
+
+// GSecretKey sign the short lived tokens and encrypt the TOTP secrets, SECRET_KEY is required outside of the dev environment
+var GSecretKey []byte
+
+// GWebauthnRpId is the relying party ID of the passkeys, the domain of the login pages, set WEBAUTHN_RP_ID to change it
+var GWebauthnRpId string
+
+// GWebauthnOrigins are the origins of the pages allowed to register and use passkeys, set WEBAUTHN_ORIGINS (comma separated) to change them
+var GWebauthnOrigins []string
+
+// IdentityProviderConfig describe an OpenID Connect or OAuth2 provider the users can log in with.
+// The client gives the access token or the ID token it obtained from the provider as the password of a login whose auth_type is the name of the provider
+type IdentityProviderConfig struct {
+	Name string `json:"name"`
+	// Issuer is the OpenID Connect issuer, its discovery document gives the userinfo and jwks urls when they are not set
+	Issuer string `json:"issuer"`
+	// ClientId is the audience the ID tokens and the access tokens must be issued for
+	ClientId    string   `json:"client_id"`
+	Scopes      []string `json:"scopes"`
+	UserinfoUrl string   `json:"userinfo_url"`
+	JwksUrl     string   `json:"jwks_url"`
+	// TokeninfoUrl give the audience of an access token given as access_token query parameter, access tokens are only accepted when it is set
+	TokeninfoUrl string `json:"tokeninfo_url"`
+	// Claims map the subject, email, email_verified and name of a user and the audience of a token to the claims of the provider, an empty claim is not read
+	Claims map[string]string `json:"claims"`
+}
+
+// GIdentityProviders are the external identity providers, set IDENTITY_PROVIDERS to a JSON array of IdentityProviderConfig to add providers or to replace these ones
+var GIdentityProviders = []IdentityProviderConfig{
+	{
+		Name:         "google",
+		Issuer:       "https://accounts.google.com",
+		ClientId:     os.Getenv("GOOGLE_LOGIN_API_CLIENT_ID"),
+		Scopes:       []string{"openid", "email", "profile"},
+		UserinfoUrl:  "https://openidconnect.googleapis.com/v1/userinfo",
+		JwksUrl:      "https://www.googleapis.com/oauth2/v3/certs",
+		TokeninfoUrl: "https://oauth2.googleapis.com/tokeninfo",
+	},
+	{
+		Name:         "facebook",
+		ClientId:     os.Getenv("FACEBOOK_APP_ID"),
+		Scopes:       []string{"email"},
+		UserinfoUrl:  "https://graph.facebook.com/me?fields=id,email,name",
+		TokeninfoUrl: "https://graph.facebook.com/app",
+		// facebook only gives the confirmed email addresses, and the app of an access token
+		Claims: map[string]string{"subject": "id", "email_verified": "", "audience": "id"},
+	},
+}
+
+// GIdentityProviderSignUp allow the first login with an identity provider to create the account of a new user, set IDENTITY_PROVIDER_SIGN_UP=false to only log in existing users
+var GIdentityProviderSignUp = true
+
+// reservedAuthTypes are the auth types handled by the user micro service itself
+var reservedAuthTypes = []string{"", "password", "mfa", "webauthn"}
+
+// GEmailVerificationRequired refuse the logins of the users who did not confirm their email address, set EMAIL_VERIFICATION to "required" or "optional" to change it
+var GEmailVerificationRequired = false
+
+// GEmailVerificationLifetime is how long the links confirming an email address are valid, set EMAIL_VERIFICATION_LIFETIME (e.g. 24h) to change it
+var GEmailVerificationLifetime = 48 * time.Hour
+
+// GEmailVerificationUrl is the page the links confirming an email address lead to, with the email and token query parameters, set EMAIL_VERIFICATION_URL to change it
+var GEmailVerificationUrl string
+
+// GPasswordResetLifetime is how long the links resetting a password are valid, set PASSWORD_RESET_LIFETIME (e.g. 30m) to change it
+var GPasswordResetLifetime = time.Hour
+
+// GPasswordResetUrl is the page the links resetting a password lead to, with the token query parameter, set PASSWORD_RESET_URL to change it
+var GPasswordResetUrl string
+
+// GMailFrom is the sender of the emails, set MAIL_FROM to change it
+var GMailFrom string
+
+// GSmtpAddr is the host:port of the SMTP server sending the emails, set SMTP_ADDR, SMTP_USERNAME and SMTP_PASSWORD to send them, they are written in GMailDir otherwise
+var GSmtpAddr = os.Getenv("SMTP_ADDR")
+
+// GSmtpUsername is the username of the SMTP server
+var GSmtpUsername = os.Getenv("SMTP_USERNAME")
+
+// GSmtpPassword is the password of the SMTP server
+var GSmtpPassword = os.Getenv("SMTP_PASSWORD")
+
+// GMailDir is the directory where the emails are written when no SMTP server is set, set MAIL_DIR to change it
+var GMailDir = filepath.Join(os.TempDir(), GAppName+"-mails")
+
+// GPasswordMinLength is the minimum amount of characters of a password, set PASSWORD_MIN_LENGTH to change it
+var GPasswordMinLength = 8
+
+// GPasswordMinCharacterClasses is the amount of character classes (lowercase, uppercase, digits, symbols) a password must mix, set PASSWORD_MIN_CHARACTER_CLASSES to change it
+var GPasswordMinCharacterClasses = 1
+
+// GPasswordBreachCheck refuse the passwords found in the breached password list, set PASSWORD_BREACH_CHECK=false to accept them
+var GPasswordBreachCheck = true
+
+// GBreachedPasswordsDir is a directory of Pwned Passwords range files (e.g. 5BAA6.txt) replacing the bundled list, set BREACHED_PASSWORDS_DIR to use it
+var GBreachedPasswordsDir = os.Getenv("BREACHED_PASSWORDS_DIR")
+
+// GPasswordHashAlgorithm is the algorithm of the new password hashes, "argon2id" or "scrypt", set PASSWORD_HASH_ALGORITHM to change it.
+// The hashes of another algorithm or with other parameters are replaced at the next login
+var GPasswordHashAlgorithm = "argon2id"
+
+// GArgon2Memory is the memory in KiB used to hash a password with argon2id, set ARGON2_MEMORY to change it
+var GArgon2Memory uint32 = 64 * 1024
+
+// GArgon2Iterations is the amount of passes over the memory of argon2id, set ARGON2_ITERATIONS to change it
+var GArgon2Iterations uint32 = 3
+
+// GArgon2Parallelism is the amount of threads of argon2id, set ARGON2_PARALLELISM to change it
+var GArgon2Parallelism uint8 = 4
+
+// GMaxFailedLogins is the amount of consecutive failed password logins before an account is locked, set LOGIN_MAX_FAILURES to change it
+var GMaxFailedLogins = 5
+
+// GMaxFailedLoginsPerIp is the amount of consecutive failed password logins before an IP address is locked, set LOGIN_MAX_FAILURES_PER_IP to change it
+var GMaxFailedLoginsPerIp = 20
+
+// GLockoutDuration is how long an account or an IP address stays locked, set LOGIN_LOCKOUT_DURATION (e.g. 30m) to change it
+var GLockoutDuration = 15 * time.Minute
+
+// GErasureGracePeriod is how long a user can cancel the erasure of its data before it is carried out, set ERASURE_GRACE_PERIOD (e.g. 720h) to change it
+var GErasureGracePeriod = 30 * 24 * time.Hour
+
+// GErasureInterval is how often the erasures at the end of their grace period are carried out, set ERASURE_INTERVAL to change it, 0 disables it
+var GErasureInterval = time.Hour
+
+// GUserRetentionPeriod is how long a deleted user can be restored before it is purged, set USER_RETENTION_PERIOD (e.g. 720h) to change it
+var GUserRetentionPeriod = 30 * 24 * time.Hour
+
+// GPurgeInterval is how often the deleted users are purged at the end of their retention period, set PURGE_INTERVAL to change it, 0 disables it
+var GPurgeInterval = time.Hour
+
 // init initialize the default environment
 func init() {
+	if gracePeriod := os.Getenv("ERASURE_GRACE_PERIOD"); gracePeriod != "" {
+		duration, err := time.ParseDuration(gracePeriod)
+		if err != nil || duration < 0 {
+			log.Fatal("ERASURE_GRACE_PERIOD is not a valid duration")
+		}
+		GErasureGracePeriod = duration
+	}
+	if interval := os.Getenv("ERASURE_INTERVAL"); interval != "" {
+		duration, err := time.ParseDuration(interval)
+		if err != nil || duration < 0 {
+			log.Fatal("ERASURE_INTERVAL is not a valid duration")
+		}
+		GErasureInterval = duration
+	}
+	if retentionPeriod := os.Getenv("USER_RETENTION_PERIOD"); retentionPeriod != "" {
+		duration, err := time.ParseDuration(retentionPeriod)
+		if err != nil || duration < 0 {
+			log.Fatal("USER_RETENTION_PERIOD is not a valid duration")
+		}
+		GUserRetentionPeriod = duration
+	}
+	if interval := os.Getenv("PURGE_INTERVAL"); interval != "" {
+		duration, err := time.ParseDuration(interval)
+		if err != nil || duration < 0 {
+			log.Fatal("PURGE_INTERVAL is not a valid duration")
+		}
+		GPurgeInterval = duration
+	}
+
+	if maxFailedLogins := os.Getenv("LOGIN_MAX_FAILURES"); maxFailedLogins != "" {
+		max, err := strconv.Atoi(maxFailedLogins)
+		if err != nil || max < 1 {
+			log.Fatal("LOGIN_MAX_FAILURES is not a valid positive number")
+		}
+		GMaxFailedLogins = max
+	}
+	if maxFailedLoginsPerIp := os.Getenv("LOGIN_MAX_FAILURES_PER_IP"); maxFailedLoginsPerIp != "" {
+		max, err := strconv.Atoi(maxFailedLoginsPerIp)
+		if err != nil || max < 1 {
+			log.Fatal("LOGIN_MAX_FAILURES_PER_IP is not a valid positive number")
+		}
+		GMaxFailedLoginsPerIp = max
+	}
+	if lockoutDuration := os.Getenv("LOGIN_LOCKOUT_DURATION"); lockoutDuration != "" {
+		duration, err := time.ParseDuration(lockoutDuration)
+		if err != nil {
+			log.Fatal("LOGIN_LOCKOUT_DURATION is not a valid duration: ", err)
+		}
+		GLockoutDuration = duration
+	}
+
+	if minLength := os.Getenv("PASSWORD_MIN_LENGTH"); minLength != "" {
+		min, err := strconv.Atoi(minLength)
+		if err != nil || min < 1 {
+			log.Fatal("PASSWORD_MIN_LENGTH is not a valid positive number")
+		}
+		GPasswordMinLength = min
+	}
+	if minClasses := os.Getenv("PASSWORD_MIN_CHARACTER_CLASSES"); minClasses != "" {
+		min, err := strconv.Atoi(minClasses)
+		if err != nil || min < 1 || min > 4 {
+			log.Fatal("PASSWORD_MIN_CHARACTER_CLASSES must be a number between 1 and 4")
+		}
+		GPasswordMinCharacterClasses = min
+	}
+	if breachCheck := os.Getenv("PASSWORD_BREACH_CHECK"); breachCheck != "" {
+		enabled, err := strconv.ParseBool(breachCheck)
+		if err != nil {
+			log.Fatal("PASSWORD_BREACH_CHECK is not a valid boolean")
+		}
+		GPasswordBreachCheck = enabled
+	}
+
+	switch algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM"); algorithm {
+	case "":
+	case "argon2id", "scrypt":
+		GPasswordHashAlgorithm = algorithm
+	default:
+		log.Fatal("PASSWORD_HASH_ALGORITHM must be \"argon2id\" or \"scrypt\"")
+	}
+	if iterations := os.Getenv("ARGON2_ITERATIONS"); iterations != "" {
+		passes, err := strconv.ParseUint(iterations, 10, 32)
+		if err != nil || passes < 1 {
+			log.Fatal("ARGON2_ITERATIONS is not a valid positive number")
+		}
+		GArgon2Iterations = uint32(passes)
+	}
+	if parallelism := os.Getenv("ARGON2_PARALLELISM"); parallelism != "" {
+		threads, err := strconv.ParseUint(parallelism, 10, 8)
+		if err != nil || threads < 1 {
+			log.Fatal("ARGON2_PARALLELISM must be a number between 1 and 255")
+		}
+		GArgon2Parallelism = uint8(threads)
+	}
+	if memory := os.Getenv("ARGON2_MEMORY"); memory != "" {
+		kib, err := strconv.ParseUint(memory, 10, 32)
+		if err != nil || kib < 8*uint64(GArgon2Parallelism) {
+			log.Fatal("ARGON2_MEMORY is not a valid amount of KiB")
+		}
+		GArgon2Memory = uint32(kib)
+	}
+
+	switch os.Getenv("EMAIL_VERIFICATION") {
+	case "", "optional":
+	case "required":
+		GEmailVerificationRequired = true
+	default:
+		log.Fatal("EMAIL_VERIFICATION must be \"optional\" or \"required\"")
+	}
+	if lifetime := os.Getenv("EMAIL_VERIFICATION_LIFETIME"); lifetime != "" {
+		duration, err := time.ParseDuration(lifetime)
+		if err != nil || duration <= 0 {
+			log.Fatal("EMAIL_VERIFICATION_LIFETIME is not a valid duration")
+		}
+		GEmailVerificationLifetime = duration
+	}
+	if lifetime := os.Getenv("PASSWORD_RESET_LIFETIME"); lifetime != "" {
+		duration, err := time.ParseDuration(lifetime)
+		if err != nil || duration <= 0 {
+			log.Fatal("PASSWORD_RESET_LIFETIME is not a valid duration")
+		}
+		GPasswordResetLifetime = duration
+	}
+	if mailDir := os.Getenv("MAIL_DIR"); mailDir != "" {
+		GMailDir = mailDir
+	}
+
+	if identityProviders := os.Getenv("IDENTITY_PROVIDERS"); identityProviders != "" {
+		var providers []IdentityProviderConfig
+		if err := json.Unmarshal([]byte(identityProviders), &providers); err != nil {
+			log.Fatal("IDENTITY_PROVIDERS is not a valid JSON array of identity providers: ", err)
+		}
+		for _, provider := range providers {
+			addIdentityProvider(provider)
+		}
+	}
+
+	if signUp := os.Getenv("IDENTITY_PROVIDER_SIGN_UP"); signUp != "" {
+		enabled, err := strconv.ParseBool(signUp)
+		if err != nil {
+			log.Fatal("IDENTITY_PROVIDER_SIGN_UP is not a valid boolean")
+		}
+		GIdentityProviderSignUp = enabled
+	}
+
+	if clientId := os.Getenv("OAUTH2_CLIENT_ID"); clientId != "" {
+		GClientId = clientId
+	}
+	GClientSecret = os.Getenv("OAUTH2_CLIENT_SECRET")
+
 	GPort = os.Getenv("PORT")
 	if GPort == "" {
 		GDevEnv = true
@@ -38,6 +331,65 @@ func init() {
 		GAppUrl = prodAppUrl
 		log.Println("Heroku Environement detected")
 	}
+
+	if secretKey := os.Getenv("SECRET_KEY"); secretKey != "" {
+		GSecretKey = []byte(secretKey)
+	} else if !GDevEnv {
+		log.Fatal("SECRET_KEY must be set, it encrypts the TOTP secrets and signs the tokens of every instance")
+	} else {
+		GSecretKey = make([]byte, 32)
+		if _, err := rand.Read(GSecretKey); err != nil {
+			log.Fatal("could not generate secret key: ", err)
+		}
+		log.Println("SECRET_KEY is not set, a random key has been generated for development and tests")
+	}
+
+	appUrl, err := url.Parse(GAppUrl)
+	if err != nil {
+		log.Fatal("could not parse the application url: ", err)
+	}
+	GEmailVerificationUrl = GAppUrl + "/verify-email"
+	if verificationUrl := os.Getenv("EMAIL_VERIFICATION_URL"); verificationUrl != "" {
+		GEmailVerificationUrl = verificationUrl
+	}
+	GPasswordResetUrl = GAppUrl + "/authentication/password-reset"
+	if resetUrl := os.Getenv("PASSWORD_RESET_URL"); resetUrl != "" {
+		GPasswordResetUrl = resetUrl
+	}
+	GMailFrom = GAppName + " <no-reply@" + appUrl.Hostname() + ">"
+	if mailFrom := os.Getenv("MAIL_FROM"); mailFrom != "" {
+		GMailFrom = mailFrom
+	}
+	GWebauthnRpId = appUrl.Hostname()
+	if rpId := os.Getenv("WEBAUTHN_RP_ID"); rpId != "" {
+		GWebauthnRpId = rpId
+	}
+	GWebauthnOrigins = []string{appUrl.Scheme + "://" + appUrl.Host}
+	if origins := os.Getenv("WEBAUTHN_ORIGINS"); origins != "" {
+		GWebauthnOrigins = strings.Split(origins, ",")
+	}
+}
+
+// addIdentityProvider add an identity provider to GIdentityProviders, or replace the one with the same name
+func addIdentityProvider(provider IdentityProviderConfig) {
+	for _, authType := range reservedAuthTypes {
+		if provider.Name == authType {
+			log.Fatal("identity provider name \"", provider.Name, "\" is reserved")
+		}
+	}
+	if provider.ClientId == "" {
+		log.Fatal("identity provider ", provider.Name, " needs a client id")
+	}
+	if provider.Issuer == "" && (provider.UserinfoUrl == "" || provider.TokeninfoUrl == "") {
+		log.Fatal("identity provider ", provider.Name, " needs an issuer, or a userinfo url and a tokeninfo url")
+	}
+	for i := range GIdentityProviders {
+		if GIdentityProviders[i].Name == provider.Name {
+			GIdentityProviders[i] = provider
+			return
+		}
+	}
+	GIdentityProviders = append(GIdentityProviders, provider)
 }
 
 // SetToTestingEnv set the test environment, this need to be called before testing to prevent the development database to be used
@@ -0,0 +1,117 @@
+// Package mailer send the emails of the micro service
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message is an email sent to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer send the emails
+type Mailer interface {
+	Send(message Message) error
+}
+
+// New return the mailer of the environment, an SMTP mailer when SMTP_ADDR is set, a file mailer otherwise
+func New() Mailer {
+	if config.GSmtpAddr != "" {
+		return &SmtpMailer{
+			Addr:     config.GSmtpAddr,
+			Username: config.GSmtpUsername,
+			Password: config.GSmtpPassword,
+			From:     config.GMailFrom,
+		}
+	}
+	return &FileMailer{Dir: config.GMailDir}
+}
+
+// format build the RFC 5322 message, the headers are refused when they contain line breaks
+func format(from string, message Message) ([]byte, error) {
+	if strings.ContainsAny(from+message.To+message.Subject, "\r\n") {
+		return nil, errors.New("email headers cannot contain line breaks")
+	}
+	return []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		from, message.To, message.Subject, time.Now().Format(time.RFC1123Z), strings.Replace(message.Body, "\n", "\r\n", -1))), nil
+}
+
+// SmtpMailer send the emails through an SMTP server, with PLAIN authentication when a username is set
+type SmtpMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send send an email through the SMTP server
+func (m *SmtpMailer) Send(message Message) error {
+	data, err := format(m.From, message)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{message.To}, data)
+}
+
+// FileMailer write the emails in a directory instead of sending them, to read them during development and tests
+type FileMailer struct {
+	Dir string
+}
+
+// Send write an email in a .eml file of the directory
+func (m *FileMailer) Send(message Message) error {
+	data, err := format(config.GMailFrom, message)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("/", "_", "\\", "_").Replace(message.To))
+	return ioutil.WriteFile(filepath.Join(m.Dir, name), data, 0600)
+}
+
+// LastMessage read the last email written for a recipient
+func (m *FileMailer) LastMessage(to string) (Message, error) {
+	files, err := filepath.Glob(filepath.Join(m.Dir, "*-"+strings.NewReplacer("/", "_", "\\", "_").Replace(to)+".eml"))
+	if err != nil || len(files) == 0 {
+		return Message{}, errors.New("no email for " + to)
+	}
+	sort.Strings(files)
+	data, err := ioutil.ReadFile(files[len(files)-1])
+	if err != nil {
+		return Message{}, err
+	}
+	parts := strings.SplitN(strings.Replace(string(data), "\r\n", "\n", -1), "\n\n", 2)
+	message := Message{To: to}
+	for _, header := range strings.Split(parts[0], "\n") {
+		if strings.HasPrefix(header, "Subject: ") {
+			message.Subject = strings.TrimPrefix(header, "Subject: ")
+		}
+	}
+	if len(parts) == 2 {
+		message.Body = strings.TrimSuffix(parts[1], "\n")
+	}
+	return message, nil
+}
@@ -0,0 +1,303 @@
+// Package passwordhash hash the passwords in a self-describing format, so the algorithm and its cost can change without invalidating the stored hashes
+package passwordhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"github.com/elithrar/simple-scrypt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	scryptkdf "golang.org/x/crypto/scrypt"
+	"log"
+	"strings"
+)
+
+// Algorithms of the hashes
+const (
+	Argon2id = "argon2id"
+	Scrypt   = "scrypt"
+	Bcrypt   = "bcrypt"
+	// LegacyScrypt is the N$r$p$salt$key format the passwords were hashed with before, it is read but never written
+	LegacyScrypt = "legacy-scrypt"
+)
+
+const saltLength = 16
+const keyLength = 32
+
+// The costs accepted in a hash are bounded, so an imported or tampered hash cannot make a login hash a password for minutes.
+// The memory of argon2id is in KiB, scrypt uses 128 * r * N bytes
+const (
+	maxArgon2Memory      = 1 << 20
+	maxArgon2Iterations  = 16
+	maxArgon2Parallelism = 16
+	maxScryptLogN        = 20
+	maxScryptR           = 32
+	maxScryptP           = 16
+	maxScryptMemory      = 1 << 30
+	maxBcryptCost        = 16
+	maxSaltLength        = 64
+	maxKeyLength         = 64
+)
+
+// ErrMismatchedHashAndPassword is returned when a password does not match its hash
+var ErrMismatchedHashAndPassword = errors.New("passwordhash: hash does not match the password")
+
+// ErrUnknownFormat is returned when a hash is not written in one of the supported formats
+var ErrUnknownFormat = errors.New("passwordhash: unknown hash format")
+
+// Argon2Params are the cost of the argon2id hashes, Memory is in KiB
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// ScryptParams are the cost of the scrypt hashes, N is 2^LogN
+type ScryptParams struct {
+	LogN uint8
+	R    int
+	P    int
+}
+
+// Hasher hash the new passwords with Algorithm, the hashes of the other algorithms or with other parameters need a rehash
+type Hasher struct {
+	Algorithm string
+	Argon2    Argon2Params
+	Scrypt    ScryptParams
+}
+
+// New return the hasher of the environment, the process stops when its argon2id cost is out of the accepted bounds
+func New() *Hasher {
+	hasher := &Hasher{
+		Algorithm: config.GPasswordHashAlgorithm,
+		Argon2: Argon2Params{
+			Memory:      config.GArgon2Memory,
+			Iterations:  config.GArgon2Iterations,
+			Parallelism: config.GArgon2Parallelism,
+		},
+		// same cost as scrypt.DefaultParams, the parameters of the legacy hashes
+		Scrypt: ScryptParams{LogN: 14, R: 8, P: 1},
+	}
+	if !argon2ParamsInRange(hasher.Argon2) {
+		log.Fatalf("ARGON2_MEMORY must be at most %d KiB, ARGON2_ITERATIONS at most %d and ARGON2_PARALLELISM at most %d",
+			maxArgon2Memory, maxArgon2Iterations, maxArgon2Parallelism)
+	}
+	return hasher
+}
+
+// Hash hash a password with the algorithm and the parameters of the hasher
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	switch h.Algorithm {
+	case Argon2id:
+		key := argon2.IDKey([]byte(password), salt, h.Argon2.Iterations, h.Argon2.Memory, h.Argon2.Parallelism, keyLength)
+		return encodeArgon2(h.Argon2, salt, key), nil
+	case Scrypt:
+		key, err := scryptkdf.Key([]byte(password), salt, 1<<h.Scrypt.LogN, h.Scrypt.R, h.Scrypt.P, keyLength)
+		if err != nil {
+			return "", err
+		}
+		return encodeScrypt(h.Scrypt, salt, key), nil
+	}
+	return "", fmt.Errorf("passwordhash: unsupported algorithm %q", h.Algorithm)
+}
+
+// NeedsRehash check if a hash was not written with the algorithm and the parameters of the hasher
+func (h *Hasher) NeedsRehash(hash string) bool {
+	switch Identify(hash) {
+	case Argon2id:
+		params, _, _, err := decodeArgon2(hash)
+		return err != nil || h.Algorithm != Argon2id || params != h.Argon2
+	case Scrypt:
+		params, _, _, err := decodeScrypt(hash)
+		return err != nil || h.Algorithm != Scrypt || params != h.Scrypt
+	}
+	return true
+}
+
+// Identify return the algorithm of a hash, or an empty string when the format is unknown
+func Identify(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return Argon2id
+	case strings.HasPrefix(hash, "$scrypt$"):
+		return Scrypt
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return Bcrypt
+	case !strings.HasPrefix(hash, "$") && strings.Count(hash, "$") == 4:
+		return LegacyScrypt
+	}
+	return ""
+}
+
+// Valid check if a hash can be compared to a password and if its cost is within bounds, to accept the hashes imported from another system
+func Valid(hash string) bool {
+	var err error
+	switch Identify(hash) {
+	case Argon2id:
+		_, _, _, err = decodeArgon2(hash)
+	case Scrypt:
+		_, _, _, err = decodeScrypt(hash)
+	case Bcrypt:
+		err = checkBcryptCost(hash)
+	case LegacyScrypt:
+		err = checkLegacyScryptCost(hash)
+	default:
+		err = ErrUnknownFormat
+	}
+	return err == nil
+}
+
+// argon2ParamsInRange check the cost of an argon2id hash is within bounds
+func argon2ParamsInRange(params Argon2Params) bool {
+	return params.Iterations >= 1 && params.Iterations <= maxArgon2Iterations &&
+		params.Parallelism >= 1 && params.Parallelism <= maxArgon2Parallelism &&
+		params.Memory >= 8*uint32(params.Parallelism) && params.Memory <= maxArgon2Memory
+}
+
+// scryptCostInRange check the cost of a scrypt hash is within bounds, n must be a power of 2
+func scryptCostInRange(n int, r int, p int) bool {
+	return n > 1 && n <= 1<<maxScryptLogN && n&(n-1) == 0 &&
+		r >= 1 && r <= maxScryptR && p >= 1 && p <= maxScryptP && 128*r*n <= maxScryptMemory
+}
+
+// checkBcryptCost check a bcrypt hash can be read and its cost is within bounds
+func checkBcryptCost(hash string) error {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return err
+	}
+	if cost > maxBcryptCost {
+		return fmt.Errorf("passwordhash: bcrypt cost %d is above %d", cost, maxBcryptCost)
+	}
+	return nil
+}
+
+// checkLegacyScryptCost check a hash of the legacy format can be read and its cost is within bounds
+func checkLegacyScryptCost(hash string) error {
+	params, err := scrypt.Cost([]byte(hash))
+	if err != nil {
+		return err
+	}
+	if !scryptCostInRange(params.N, params.R, params.P) || params.DKLen > maxKeyLength || params.SaltLen > maxSaltLength {
+		return fmt.Errorf("passwordhash: invalid scrypt parameters N=%d,r=%d,p=%d", params.N, params.R, params.P)
+	}
+	return nil
+}
+
+// Compare check a password against a hash of any supported algorithm, nil means they match
+func Compare(hash string, password string) error {
+	switch Identify(hash) {
+	case Argon2id:
+		params, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return err
+		}
+		return compareKeys(key, argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key))))
+	case Scrypt:
+		params, salt, key, err := decodeScrypt(hash)
+		if err != nil {
+			return err
+		}
+		other, err := scryptkdf.Key([]byte(password), salt, 1<<params.LogN, params.R, params.P, len(key))
+		if err != nil {
+			return err
+		}
+		return compareKeys(key, other)
+	case Bcrypt:
+		if err := checkBcryptCost(hash); err != nil {
+			return err
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err == bcrypt.ErrMismatchedHashAndPassword {
+			return ErrMismatchedHashAndPassword
+		} else if err != nil {
+			return err
+		}
+		return nil
+	case LegacyScrypt:
+		if err := checkLegacyScryptCost(hash); err != nil {
+			return err
+		}
+		if err := scrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err == scrypt.ErrMismatchedHashAndPassword {
+			return ErrMismatchedHashAndPassword
+		} else if err != nil {
+			return err
+		}
+		return nil
+	}
+	return ErrUnknownFormat
+}
+
+// compareKeys compare two derived keys in constant time
+func compareKeys(key []byte, other []byte) error {
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatchedHashAndPassword
+	}
+	return nil
+}
+
+// encodeArgon2 write an argon2id hash in the PHC string format, e.g. $argon2id$v=19$m=65536,t=3,p=2$salt$key
+func encodeArgon2(params Argon2Params, salt []byte, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// decodeArgon2 read the parameters, the salt and the key of an argon2id hash
+func decodeArgon2(hash string) (params Argon2Params, salt []byte, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnknownFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("passwordhash: unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrUnknownFormat
+	}
+	if !argon2ParamsInRange(params) {
+		return params, nil, nil, fmt.Errorf("passwordhash: invalid argon2 parameters %q", parts[3])
+	}
+	salt, key, err = decodeSaltAndKey(parts[4], parts[5])
+	return params, salt, key, err
+}
+
+// encodeScrypt write a scrypt hash in the PHC string format, e.g. $scrypt$ln=14,r=8,p=1$salt$key
+func encodeScrypt(params ScryptParams, salt []byte, key []byte) string {
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", params.LogN, params.R, params.P,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// decodeScrypt read the parameters, the salt and the key of a scrypt hash
+func decodeScrypt(hash string) (params ScryptParams, salt []byte, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return params, nil, nil, ErrUnknownFormat
+	}
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &params.LogN, &params.R, &params.P); err != nil {
+		return params, nil, nil, ErrUnknownFormat
+	}
+	if params.LogN < 1 || params.LogN > maxScryptLogN || !scryptCostInRange(1<<params.LogN, params.R, params.P) {
+		return params, nil, nil, fmt.Errorf("passwordhash: invalid scrypt parameters %q", parts[2])
+	}
+	salt, key, err = decodeSaltAndKey(parts[3], parts[4])
+	return params, salt, key, err
+}
+
+// decodeSaltAndKey decode the base64 salt and key of a PHC string
+func decodeSaltAndKey(encodedSalt string, encodedKey string) (salt []byte, key []byte, err error) {
+	if salt, err = base64.RawStdEncoding.DecodeString(encodedSalt); err != nil || len(salt) > maxSaltLength {
+		return nil, nil, ErrUnknownFormat
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(encodedKey); err != nil || len(key) == 0 || len(key) > maxKeyLength {
+		return nil, nil, ErrUnknownFormat
+	}
+	return salt, key, nil
+}
@@ -0,0 +1,109 @@
+// Package passwordpolicy check the passwords chosen by the users
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// rangePrefixLength is the length of the hash prefixes of the k-anonymity ranges, as in the Pwned Passwords API
+const rangePrefixLength = 5
+
+// bundledPasswords are the SHA-1 hashes of the most common breached passwords, shipped with the binary
+//
+//go:embed breached-passwords.txt
+var bundledPasswords string
+
+// BreachedList give the breached password hashes sharing a prefix (k-anonymity), so the list never needs the whole hash of a password
+type BreachedList interface {
+	// Range return the uppercase hex suffixes of the SHA-1 hashes starting with the 5 characters prefix
+	Range(prefix string) ([]string, error)
+}
+
+// IsBreached check if a password is in a breached list
+func IsBreached(list BreachedList, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes, err := list.Range(hash[:rangePrefixLength])
+	if err != nil {
+		return false, err
+	}
+	for _, suffix := range suffixes {
+		if suffix == hash[rangePrefixLength:] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MemoryList is a breached list held in memory, indexed by prefix
+type MemoryList map[string][]string
+
+var bundledList MemoryList
+var bundledListOnce sync.Once
+
+// BundledList return the list shipped with the binary
+func BundledList() MemoryList {
+	bundledListOnce.Do(func() {
+		bundledList, _ = ReadList(strings.NewReader(bundledPasswords))
+	})
+	return bundledList
+}
+
+// ReadList read a list of SHA-1 hashes in the format of the Pwned Passwords downloads (HASH or HASH:COUNT lines), lines starting with # are ignored
+func ReadList(reader io.Reader) (MemoryList, error) {
+	list := make(MemoryList)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash := strings.ToUpper(strings.SplitN(line, ":", 2)[0])
+		if len(hash) != 2*sha1.Size {
+			continue
+		}
+		prefix := hash[:rangePrefixLength]
+		list[prefix] = append(list[prefix], hash[rangePrefixLength:])
+	}
+	return list, scanner.Err()
+}
+
+// Range return the suffixes of the hashes of the list starting with prefix
+func (l MemoryList) Range(prefix string) ([]string, error) {
+	return l[prefix], nil
+}
+
+// RangeDir is a directory of range files as written by the Pwned Passwords downloader, e.g. 5BAA6.txt holding SUFFIX:COUNT lines.
+// Only the file of the prefix is read, so the full list does not need to fit in memory
+type RangeDir struct {
+	Dir string
+}
+
+// Range read the suffixes of the range file of the prefix, a missing file is an empty range
+func (d *RangeDir) Range(prefix string) ([]string, error) {
+	file, err := os.Open(filepath.Join(d.Dir, strings.ToUpper(prefix)+".txt"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var suffixes []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		suffix := strings.ToUpper(strings.TrimSpace(strings.SplitN(scanner.Text(), ":", 2)[0]))
+		if suffix != "" {
+			suffixes = append(suffixes, suffix)
+		}
+	}
+	return suffixes, scanner.Err()
+}
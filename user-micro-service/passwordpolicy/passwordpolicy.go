@@ -0,0 +1,126 @@
+// Package passwordpolicy check the passwords chosen by the users
+package passwordpolicy
+
+import (
+	"fmt"
+	"github.com/adriendomoison/apigoboot/user-micro-service/config"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// minPersonalInfoLength is the length under which the parts of the email and the username are not searched in the passwords
+const minPersonalInfoLength = 3
+
+// Violation is a rule of the policy a password breaks, Message can be shown to the user
+type Violation struct {
+	Rule    string
+	Detail  string
+	Message string
+}
+
+// Rules of the policy
+const (
+	RuleMinLength        = "min_length"
+	RuleCharacterClasses = "character_classes"
+	RulePersonalInfo     = "personal_info"
+	RuleBreachedPassword = "breached_password"
+)
+
+// characterClassesNames describe the character classes to the user
+const characterClassesNames = "lowercase letters, uppercase letters, digits and symbols"
+
+// Policy is the set of rules a password must follow, a nil Breached list disables the breach check
+type Policy struct {
+	MinLength           int
+	MinCharacterClasses int
+	Breached            BreachedList
+}
+
+// New return the policy of the environment
+func New() *Policy {
+	policy := &Policy{
+		MinLength:           config.GPasswordMinLength,
+		MinCharacterClasses: config.GPasswordMinCharacterClasses,
+	}
+	if config.GPasswordBreachCheck {
+		if config.GBreachedPasswordsDir != "" {
+			policy.Breached = &RangeDir{Dir: config.GBreachedPasswordsDir}
+		} else {
+			policy.Breached = BundledList()
+		}
+	}
+	return policy
+}
+
+// Check return every rule the password breaks, the email and the username of the user must not be part of it.
+// The error tells the breached list could not be read, the other rules are still checked
+func (p *Policy) Check(password string, email string, username string) ([]Violation, error) {
+	var violations []Violation
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMinLength,
+			Detail:  fmt.Sprintf("password is shorter than %d characters", p.MinLength),
+			Message: fmt.Sprintf("Your password must contain at least %d characters", p.MinLength),
+		})
+	}
+	if classes := characterClasses(password); classes < p.MinCharacterClasses {
+		violations = append(violations, Violation{
+			Rule:    RuleCharacterClasses,
+			Detail:  fmt.Sprintf("password mixes %d character classes instead of %d", classes, p.MinCharacterClasses),
+			Message: fmt.Sprintf("Your password must mix at least %d of %s", p.MinCharacterClasses, characterClassesNames),
+		})
+	}
+	if containsPersonalInfo(password, email, username) {
+		violations = append(violations, Violation{
+			Rule:    RulePersonalInfo,
+			Detail:  "password contains the email or the username",
+			Message: "Your password must not contain your email address or your username",
+		})
+	}
+	if p.Breached == nil {
+		return violations, nil
+	}
+	breached, err := IsBreached(p.Breached, password)
+	if breached {
+		violations = append(violations, Violation{
+			Rule:    RuleBreachedPassword,
+			Detail:  "password is in the breached password list",
+			Message: "This password has appeared in a data breach and can't be used, please choose another one",
+		})
+	}
+	return violations, err
+}
+
+// characterClasses count the classes of the characters of a password among lowercase letters, uppercase letters, digits and symbols
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// containsPersonalInfo check if the password contains the email, its local part or the username, whatever the case
+func containsPersonalInfo(password string, email string, username string) bool {
+	password = strings.ToLower(password)
+	parts := []string{strings.ToLower(email), strings.ToLower(username)}
+	if at := strings.LastIndex(email, "@"); at > 0 {
+		parts = append(parts, strings.ToLower(email[:at]))
+	}
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) >= minPersonalInfoLength && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}